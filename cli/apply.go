@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/core/service"
+	"gatekeeper/util"
+)
+
+// Apply 执行 gatekeeper apply -f config.yaml
+// 先输出变更计划，确认后在事务中写入DB，写入前校验计划未被并发修改
+func Apply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	configPath := fs.String("config", "./etc/", "input config file like ./conf/dev/")
+	file := fs.String("f", "", "declarative config file, format by extension: .yaml/.yml/.toml/.json")
+	autoApprove := fs.Bool("y", false, "apply without confirmation")
+	dryRun := fs.Bool("dry-run", false, "only show the plan")
+	author := fs.String("author", defaultAuthor(), "author recorded in config history")
+	reload := fs.Bool("reload", false, "notify cluster to reload after apply")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-f is required")
+	}
+
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	target, err := service.ImportConfig(data, service.ConfigFormatByPath(*file))
+	if err != nil {
+		return errors.Wrap(err, "parse "+*file)
+	}
+	if err := initConfig(*configPath); err != nil {
+		return err
+	}
+	defer config.Destroy()
+
+	current, err := service.Storage.Load()
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+	changes := service.PlanImport(current, target)
+	PrintPlan(os.Stdout, changes)
+	if len(changes) == 0 || *dryRun {
+		return nil
+	}
+	if !*autoApprove && !confirm(os.Stdin, os.Stdout) {
+		fmt.Fprintln(os.Stdout, "Apply cancelled.")
+		return nil
+	}
+
+	_, err = service.Storage.Apply(func(current *service.ConfigSnapshot) ([]*service.ConfigChange, error) {
+		latest := service.PlanImport(current, target)
+		if !samePlan(changes, latest) {
+			return nil, errors.New("config changed since plan was made, please run apply again")
+		}
+		return latest, nil
+	}, *author, service.ConfigActionImport)
+	if err != nil {
+		return errors.Wrap(err, "apply")
+	}
+	fmt.Fprintf(os.Stdout, "Apply complete! %d changes applied.\n", len(changes))
+
+	if *reload {
+		if err := service.ClusterReload(); err != nil {
+			return errors.Wrap(err, "cluster reload")
+		}
+		fmt.Fprintln(os.Stdout, "Cluster reloaded.")
+	} else {
+		fmt.Fprintf(os.Stdout, "Gateways will pick up the changes within %dms.\n", config.BaseConf.Interval)
+	}
+	return nil
+}
+
+// Export 执行 gatekeeper export -format yaml，将DB中的配置输出到标准输出或文件
+func Export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	configPath := fs.String("config", "./etc/", "input config file like ./conf/dev/")
+	format := fs.String("format", "", "yaml/toml/json, default by -o extension or yaml")
+	output := fs.String("o", "", "output file, default stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = service.ConfigFormatYAML
+		if *output != "" {
+			*format = service.ConfigFormatByPath(*output)
+		}
+	}
+	if err := initConfig(*configPath); err != nil {
+		return err
+	}
+	defer config.Destroy()
+
+	snapshot, err := service.Storage.Load()
+	if err != nil {
+		return errors.Wrap(err, "load config")
+	}
+	data, err := service.ExportConfig(snapshot, *format)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(*output, data, 0644)
+}
+
+// PrintPlan 输出变更计划，更新项附带字段差异
+func PrintPlan(w io.Writer, changes []*service.ConfigChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes. Config is up-to-date.")
+		return
+	}
+	counts := map[string]int{}
+	for _, change := range changes {
+		counts[change.Action]++
+		switch change.Action {
+		case service.ConfigActionCreate:
+			fmt.Fprintf(w, "  + %s %s\n", change.Type, change.Name)
+		case service.ConfigActionDelete:
+			fmt.Fprintf(w, "  - %s %s\n", change.Type, change.Name)
+		default:
+			fmt.Fprintf(w, "  ~ %s %s\n", change.Type, change.Name)
+			for _, line := range util.DiffLines(service.ChangeContent(change.Before), service.ChangeContent(change.After)) {
+				if line.Type != " " {
+					fmt.Fprintf(w, "      %s %s\n", line.Type, strings.TrimSpace(line.Text))
+				}
+			}
+		}
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete.\n",
+		counts[service.ConfigActionCreate], counts[service.ConfigActionUpdate], counts[service.ConfigActionDelete])
+}
+
+// samePlan 比较两次计划的变更项是否一致
+func samePlan(a, b []*service.ConfigChange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].Name != b[i].Name || a[i].Action != b[i].Action {
+			return false
+		}
+	}
+	return true
+}
+
+func confirm(r io.Reader, w io.Writer) bool {
+	fmt.Fprint(w, "\nDo you want to apply these changes? Only 'yes' will be accepted: ")
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+func defaultAuthor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// initConfig 初始化配置及配置存储，路径不以/结尾时自动补全
+func initConfig(configPath string) error {
+	if !strings.HasSuffix(configPath, "/") {
+		configPath = configPath + "/"
+	}
+	config.Conf = &configPath
+	if err := config.Init(configPath); err != nil {
+		return err
+	}
+	return service.InitStorage()
+}
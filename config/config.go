@@ -1,6 +1,8 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -12,6 +14,7 @@ import (
 	"git.baijiahulian.com/plt/go-common/util/redis"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
 
 	"gatekeeper/util"
 )
@@ -39,38 +42,6 @@ func Init(configPath string) error {
 		return err
 	}
 
-	// 加载redis配置并且初始化redis
-	redisConf := &RedisConfig{}
-	if err = parseConfig(configPath+"redis.json", redisConf); err != nil {
-		return err
-	}
-	Redis, err = redis.NewRedisClusterByConfig(&config.RedisConfig{
-		Addr:        []string{fmt.Sprintf("%s:%d", redisConf.Host, redisConf.Port)},
-		IdleMax:     redisConf.MaxIdle,
-		ActiveMax:   redisConf.MaxActive,
-		IdleTimeout: redisConf.IdleTimeout,
-		Password:    redisConf.Password,
-	}, nil)
-	if err != nil {
-		return err
-	}
-
-	// 加载mysql配置,并且初始化db
-	mysqlConf := &MySQLConfig{}
-	if err := parseConfig(configPath+"mysql.json", mysqlConf); err != nil {
-		return err
-	}
-	DB, err = gorm.Open("mysql", mysqlConf.DataSourceName)
-	if err != nil {
-		return err
-	}
-	if err := DB.DB().Ping(); err != nil {
-		return err
-	}
-	DB.DB().SetMaxOpenConns(mysqlConf.MaxOpenConn)
-	DB.DB().SetMaxIdleConns(mysqlConf.MaxIdleConn)
-	DB.DB().SetConnMaxLifetime(time.Second * time.Duration(mysqlConf.MaxConnLifeTime))
-
 	// 设置时区
 	if location, err := time.LoadLocation(BaseConf.TimeLocation); err != nil {
 		return err
@@ -86,12 +57,109 @@ func Init(configPath string) error {
 	AccessLog = log.NewDefaultLogrus()
 	AccessLog.SetOutput(BaseConf.AccessLog)
 	AccessLog.SetLevel(BaseConf.AccessLog.LogLevel)
+
+	// 加载redis配置并且初始化redis，未配置时计数使用内存
+	if err = initRedis(configPath + "redis.json"); err != nil {
+		return err
+	}
+
+	// 按存储类型初始化db，file模式不依赖数据库
+	if err = initDB(configPath); err != nil {
+		return err
+	}
+
+	// 未配置登录态密钥时随机生成，重启后登录态失效
+	if AuthConf.SessionSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		AuthConf.SessionSecret = hex.EncodeToString(secret)
+		SysLog.Warn("[session secret not configured] admin sessions will not survive restart or be shared across cluster")
+	}
+	return nil
+}
+
+// 初始化redis，配置文件不存在或未配置host时不启用
+func initRedis(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		SysLog.Warn("[redis not configured] counters are kept in memory of this node")
+		return nil
+	}
+	redisConf := &RedisConfig{}
+	if err := parseConfig(path, redisConf); err != nil {
+		return err
+	}
+	if redisConf.Host == "" {
+		SysLog.Warn("[redis not configured] counters are kept in memory of this node")
+		return nil
+	}
+	client, err := redis.NewRedisClusterByConfig(&config.RedisConfig{
+		Addr:        []string{fmt.Sprintf("%s:%d", redisConf.Host, redisConf.Port)},
+		IdleMax:     redisConf.MaxIdle,
+		ActiveMax:   redisConf.MaxActive,
+		IdleTimeout: redisConf.IdleTimeout,
+		Password:    redisConf.Password,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	Redis = client
+	return nil
+}
+
+// 按存储类型初始化db
+func initDB(configPath string) error {
+	var err error
+	switch driver := BaseConf.StorageDriver(); driver {
+	case StorageMySQL:
+		mysqlConf := &MySQLConfig{}
+		if err := parseConfig(configPath+"mysql.json", mysqlConf); err != nil {
+			return err
+		}
+		DB, err = gorm.Open("mysql", mysqlConf.DataSourceName)
+		if err != nil {
+			return err
+		}
+		if err := DB.DB().Ping(); err != nil {
+			return err
+		}
+		DB.DB().SetMaxOpenConns(mysqlConf.MaxOpenConn)
+		DB.DB().SetMaxIdleConns(mysqlConf.MaxIdleConn)
+		DB.DB().SetConnMaxLifetime(time.Second * time.Duration(mysqlConf.MaxConnLifeTime))
+	case StorageSQLite:
+		path := BaseConf.Storage.SQLitePath
+		if path == "" {
+			path = configPath + "gatekeeper.db"
+		}
+		// WAL模式下读写互不阻塞，写事务开始时即加锁，并发写等待而非报database is locked
+		DB, err = gorm.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+		if err != nil {
+			return err
+		}
+	case StorageFile:
+		SysLog.Warn("[storage driver file] config is read from module.json/app.json, admin is disabled")
+	default:
+		return fmt.Errorf("unknown storage driver %v", driver)
+	}
 	return nil
 }
 
+// 是否启用了数据库
+func DBEnabled() bool {
+	return DB != nil
+}
+
+// 是否启用了redis
+func RedisEnabled() bool {
+	return Redis != nil
+}
+
 //公共销毁函数
 func Destroy() {
-	DB.Close()
+	if DB != nil {
+		DB.Close()
+	}
 }
 
 func parseConfig(path string, conf interface{}) error {
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"net"
 	"time"
 
@@ -27,7 +28,13 @@ var (
 	Conf *string
 )
 
+// redis未配置
+var ErrRedisNotConfigured = errors.New("redis not configured")
+
 func RedisPipeline(pip ...func(c redis.Conn)) error {
+	if Redis == nil {
+		return ErrRedisNotConfigured
+	}
 	c := Redis.GetConnPool().Get()
 	defer c.Close()
 	for _, f := range pip {
@@ -38,6 +45,9 @@ func RedisPipeline(pip ...func(c redis.Conn)) error {
 }
 
 func RedisDo(commandName string, args ...interface{}) (interface{}, error) {
+	if Redis == nil {
+		return nil, ErrRedisNotConfigured
+	}
 	c := Redis.GetConnPool().Get()
 	defer c.Close()
 	reply, err := c.Do(commandName, args...)
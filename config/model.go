@@ -3,9 +3,13 @@ package config
 import "git.baijiahulian.com/plt/go-common/model/config"
 
 // 验证结构体
+// admin_username/admin_passport 仅用于用户表为空时初始化首个管理员
 type AuthConfig struct {
-	AdminName     string `json:"admin_username"`
-	AdminPassport string `json:"admin_passport"`
+	AdminName      string `json:"admin_username"`
+	AdminPassport  string `json:"admin_passport"`
+	SessionSecret  string `json:"session_secret"`   //登录态签名密钥，集群内各节点需一致
+	CookieSecure   bool   `json:"cookie_secure"`    //仅https下发送cookie
+	CookieSameSite string `json:"cookie_same_site"` //lax(默认)/strict/none
 }
 
 type MySQLConfig struct {
@@ -33,6 +37,39 @@ type BaseConfig struct {
 	Interval     int               `json:"interval"`
 	Http         *HttpConfig       `json:"http"`
 	Cluster      *ClusterConfig    `json:"cluster"`
+	Storage      *StorageConfig    `json:"storage"`
+	// 访问日志格式 json(默认)/logfmt/text
+	AccessLogFormat string `json:"access_log_format"`
+	// 访问日志输出的字段及顺序，为空时输出全部字段
+	AccessLogFields []string `json:"access_log_fields"`
+	// 链路追踪，未配置时只透传traceparent不导出span
+	Trace *TraceConfig `json:"trace"`
+	// 流量统计时间序列，未配置时使用默认保留天数
+	Stats *StatsConfig `json:"stats"`
+	// 告警规则及通知webhook，未配置规则时不检查
+	Alert *AlertConfig `json:"alert"`
+}
+
+// 存储类型
+const (
+	StorageMySQL  = "mysql"
+	StorageSQLite = "sqlite"
+	StorageFile   = "file"
+)
+
+// 存储配置
+// driver为file时不依赖数据库，模块及租户配置直接读写module.json/app.json，管理后台不可用
+type StorageConfig struct {
+	Driver     string `json:"driver"`      //mysql(默认)/sqlite/file
+	SQLitePath string `json:"sqlite_path"` //sqlite数据库文件路径
+}
+
+// 存储类型，未配置时为mysql
+func (c *BaseConfig) StorageDriver() string {
+	if c.Storage == nil || c.Storage.Driver == "" {
+		return StorageMySQL
+	}
+	return c.Storage.Driver
 }
 
 type HttpConfig struct {
@@ -42,6 +79,80 @@ type HttpConfig struct {
 	ReadTimeout    int    `json:"read_timeout"`
 	WriteTimeout   int    `json:"write_timeout"`
 	MaxHeaderBytes int    `json:"max_header_bytes"`
+	// 需要缓存请求体时内存中保留的最大字节数，超出部分写入临时文件
+	BodyBufferSize int64 `json:"body_buffer_size"`
+	// 管理后台请求体最大字节数
+	AdminMaxBodySize int64 `json:"admin_max_body_size"`
+}
+
+// 链路追踪配置，span以OTLP/JSON格式导出
+type TraceConfig struct {
+	Exporter      string  `json:"exporter"`       //file/otlp，为空时不导出span
+	File          string  `json:"file"`           //exporter为file时写入的文件，每行一个ExportTraceServiceRequest
+	Endpoint      string  `json:"endpoint"`       //exporter为otlp时collector地址，如http://127.0.0.1:4318/v1/traces
+	ServiceName   string  `json:"service_name"`   //默认gatekeeper
+	SampleRate    float64 `json:"sample_rate"`    //请求未携带traceparent时的采样率，0为全部采样
+	BatchSize     int     `json:"batch_size"`     //每批导出的最大span数
+	FlushInterval int     `json:"flush_interval"` //导出间隔，单位ms
+}
+
+// 流量统计时间序列配置，配置redis时集群共享，否则仅保存在本节点内存
+type StatsConfig struct {
+	MinuteRetention int `json:"minute_retention"` //分钟粒度保留天数，默认2
+	HourRetention   int `json:"hour_retention"`   //小时粒度保留天数，默认31
+	DayRetention    int `json:"day_retention"`    //天粒度保留天数，默认400
+}
+
+// 流量统计各粒度的保留天数，未配置或不大于0时使用默认值
+func (c *BaseConfig) StatsRetention() (minute, hour, day int) {
+	minute, hour, day = 2, 31, 400
+	if c.Stats == nil {
+		return
+	}
+	if c.Stats.MinuteRetention > 0 {
+		minute = c.Stats.MinuteRetention
+	}
+	if c.Stats.HourRetention > 0 {
+		hour = c.Stats.HourRetention
+	}
+	if c.Stats.DayRetention > 0 {
+		day = c.Stats.DayRetention
+	}
+	return
+}
+
+// 告警配置
+// 各节点按interval检查规则，条件持续for毫秒后触发，触发及恢复时各通知一次
+type AlertConfig struct {
+	Interval       int             `json:"interval"`        //检查间隔，单位ms，默认10000
+	RepeatInterval int             `json:"repeat_interval"` //告警持续时重复通知的间隔，单位ms，0为不重复
+	Rules          []*AlertRule    `json:"rules"`
+	Webhooks       []*AlertWebhook `json:"webhooks"`
+}
+
+// 告警规则
+// type为upstream_down、no_available_ip、error_rate、latency时targets为模块名，app_quota时为app_id，为空时检查全部
+type AlertRule struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`         //upstream_down/no_available_ip/error_rate/latency/app_quota/reload_failed
+	Targets     []string `json:"targets"`      //检查的模块或租户
+	Threshold   float64  `json:"threshold"`    //error_rate为百分比，latency为ms，app_quota为已用日配额的百分比
+	Window      string   `json:"window"`       //error_rate、latency的统计窗口：1m/5m/15m，默认1m
+	Percentile  string   `json:"percentile"`   //latency比较的耗时：avg/p50/p95/p99，默认p95
+	MinRequests int64    `json:"min_requests"` //error_rate、latency统计窗口内请求数不足时不告警
+	For         int      `json:"for"`          //条件持续时间，单位ms，0为立即触发
+	Severity    string   `json:"severity"`     //critical/warning/info，默认warning
+	Webhooks    []string `json:"webhooks"`     //通知的webhook名称，为空时通知全部
+}
+
+// 告警通知webhook，以POST方式发送
+// template为空时发送json，可选内置模板dingtalk/feishu/wecom/slack，或自定义text/template
+type AlertWebhook struct {
+	Name     string            `json:"name"`
+	URL      string            `json:"url"`
+	Template string            `json:"template"`
+	Headers  map[string]string `json:"headers"`
+	Timeout  int               `json:"timeout"` //单位ms，默认5000
 }
 
 type ClusterConfig struct {
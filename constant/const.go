@@ -16,14 +16,56 @@ const (
 	RequestModuleCounterPrefix = "gatekeeper_module_counter_"
 	//RequestModuleHourCounterPrefix 模块小时前缀
 	RequestModuleHourCounterPrefix = "gatekeeper_module_hour_counter_"
+	//RequestGroupCounterPrefix 上游分组天前缀
+	RequestGroupCounterPrefix = "gatekeeper_group_counter_"
+	//RequestGroupHourCounterPrefix 上游分组小时前缀
+	RequestGroupHourCounterPrefix = "gatekeeper_group_hour_counter_"
 
 	//AdminCookiePrefix admin相关
 	AdminCookiePrefix = "admin_"
-	//AdminCookieSecrit aes密钥
-	AdminCookieSecrit = "1122334455667788"
 	//AdminExpired 管理员登陆超时时间
 	AdminExpired = 14400
 
 	//IPDefaultWeight 默认ip权重
 	IPDefaultWeight = 50
+
+	//DiscoveryRedisPrefix 后端自注册redis key前缀
+	DiscoveryRedisPrefix = "gatekeeper_discovery_"
+	//DiscoveryEmptyThreshold 服务发现连续返回空节点列表的次数达到该值才清空节点，避免瞬时异常导致服务不可用
+	DiscoveryEmptyThreshold = 3
+
+	//MirrorDefaultConcurrency 镜像默认最大并发数
+	MirrorDefaultConcurrency = 20
+	//BulkheadDefaultQueueTimeout 模块并发排队默认超时时间，单位ms
+	BulkheadDefaultQueueTimeout = 1000
+	//MirrorHeader 镜像请求标识header
+	MirrorHeader = "X-Gatekeeper-Mirror"
+
+	//CachePrefix 响应缓存key前缀
+	CachePrefix = "gatekeeper_cache_"
+	//CacheStatusHeader 缓存命中状态header
+	CacheStatusHeader = "X-Gatekeeper-Cache"
+	//CacheMemoryMaxBytes 内存缓存最大字节数
+	CacheMemoryMaxBytes = 256 << 20
+	//CacheDefaultMaxBodySize 默认可缓存的最大响应字节数
+	CacheDefaultMaxBodySize = 1 << 20
+
+	//BodyDefaultBufferSize 请求体默认内存缓存字节数，超出写入临时文件
+	BodyDefaultBufferSize = 1 << 20
+	//AdminDefaultMaxBodySize 管理后台默认请求体上限
+	AdminDefaultMaxBodySize = 10 << 20
+
+	//QuotaPrefix 租户请求配额计数key前缀
+	QuotaPrefix = "gatekeeper_quota_"
+	//TimeSeriesPrefix 流量统计时间序列key前缀
+	TimeSeriesPrefix = "gatekeeper_ts_"
+
+	//TraceDefaultServiceName 链路追踪默认服务名
+	TraceDefaultServiceName = "gatekeeper"
+	//TraceDefaultBatchSize 每批导出的默认span数
+	TraceDefaultBatchSize = 512
+	//TraceDefaultFlushInterval 默认导出间隔，单位ms
+	TraceDefaultFlushInterval = 1000
+	//TraceQueueSize 待导出span队列长度，队列满时丢弃
+	TraceQueueSize = 8192
 )
@@ -2,6 +2,7 @@ package constant
 
 //中间件常量
 const (
-	MiddlewareServiceKey     = "gateway_service"
-	MiddlewareRequestBodyKey = "request_body"
+	MiddlewareServiceKey   = "gateway_service"
+	MiddlewareAdminUserKey = "admin_user"
+	MiddlewareRejectKey    = "reject_reason"
 )
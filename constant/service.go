@@ -8,6 +8,10 @@ const (
 	DLTagAccessControlSuccess = " access_control_success"
 	DLTagLoadBalanceFailure   = " load_balance_failure"
 	DLTagLoadBalanceSuccess   = " load_balance_suceess"
+	DLTagHTTPLimitFailure     = " http_limit_failure"
+	DLTagBodyLimitFailure     = " body_limit_failure"
+	DLTagRateLimitFailure     = " rate_limit_failure"
+	DLTagBulkheadFailure      = " bulkhead_failure"
 	DLTagSsoHandlerFailure    = " sso_handler_failure"
 	DLTagSsoHandlerSuccess    = " sso_handler_success"
 
@@ -2,11 +2,9 @@ package controller
 
 import (
 	"crypto/md5"
-	"encoding/base64"
 	"fmt"
 	"github.com/e421083458/golang_common/lib"
 	"html/template"
-	"io"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -18,6 +16,7 @@ import (
 
 	"gatekeeper/config"
 	"gatekeeper/constant"
+	"gatekeeper/core/discovery"
 	"gatekeeper/core/resource"
 	"gatekeeper/core/service"
 	"gatekeeper/model/entity"
@@ -26,26 +25,71 @@ import (
 )
 
 //AdminRegister admin路口注册
+//Audit需注册在Auth之前，以便记录未通过验证的操作
+//修改类操作仅支持POST，登录之外的POST请求均需校验csrf token
 func AdminRegister(router *gin.RouterGroup) {
 	admin := Admin{}
-	router.GET("/index", admin.Index)
 	router.GET("/login", admin.Login)
 	router.POST("/login", admin.Login)
-	router.GET("/loginout", admin.LoginOut)
-	router.GET("/open", admin.Open)
-	router.GET("/close", admin.Close)
-	router.GET("/delete", admin.Delete)
-	router.GET("/add_http", admin.AddHTTP)
-	router.GET("/service_list", admin.ServiceList)
-	router.GET("/app_list", admin.AppList)
-	router.GET("/add_app", admin.AddAPP)
-	router.GET("/edit_app", admin.EditAPP)
-	router.POST("/save_app", admin.SaveAPP)
-	router.GET("/del_app", admin.DelAPP)
-	router.GET("/app_detail", admin.APPDetail)
-	router.GET("/service_detail", admin.ServiceDetail)
-	router.POST("/save_service", admin.SaveService)
-	router.GET("/edit_service", admin.EditService)
+
+	router.Use(admin.CSRF())
+	router.POST("/loginout", admin.LoginOut)
+
+	router.GET("/index", admin.Auth(RoleViewer, nil), admin.Index)
+	router.GET("/service_list", admin.Auth(RoleViewer, nil), admin.ServiceList)
+	router.GET("/service_detail", admin.Auth(RoleViewer, targetQuery(service.ConfigTypeModule, "module_name")), admin.ServiceDetail)
+	router.GET("/upstream_stat", admin.Auth(RoleViewer, targetQuery(service.ConfigTypeModule, "module_name")), admin.UpstreamStat)
+	router.GET("/stat_range", admin.Auth(RoleViewer, targetStat), admin.StatRange)
+	router.GET("/stat_chart", admin.Auth(RoleViewer, targetStat), admin.StatChart)
+	router.GET("/app_list", admin.Auth(RoleViewer, nil), admin.AppList)
+	router.GET("/app_detail", admin.Auth(RoleViewer, nil), admin.APPDetail)
+	router.GET("/validate", admin.Auth(RoleViewer, nil), admin.Validate)
+	router.POST("/validate", admin.Auth(RoleViewer, nil), admin.Validate)
+	router.GET("/history", admin.Auth(RoleViewer, nil), admin.History)
+	router.GET("/history_diff", admin.Auth(RoleViewer, nil), admin.HistoryDiff)
+	router.GET("/alert", admin.Auth(RoleViewer, nil), admin.AlertList)
+	router.GET("/events", admin.Auth(RoleViewer, nil), admin.Events)
+	router.GET("/password", admin.Auth(RoleViewer, nil), admin.Password)
+	router.POST("/password", admin.Audit(AuditActionPassword, admin.targetLoginUser), admin.Auth(RoleViewer, nil), admin.Password)
+
+	router.GET("/add_http", admin.Auth(RoleOperator, nil), admin.AddHTTP)
+	router.GET("/edit_service", admin.Auth(RoleOperator, targetQuery(service.ConfigTypeModule, "module_name")), admin.EditService)
+	router.POST("/save_service", admin.Audit(AuditActionSaveService, targetPostForm(service.ConfigTypeModule, "base.name")),
+		admin.Auth(RoleOperator, targetPostForm(service.ConfigTypeModule, "base.name")), admin.SaveService)
+	router.POST("/open", admin.Audit(AuditActionOpen, targetPostForm(service.ConfigTypeModule, "name")),
+		admin.Auth(RoleOperator, targetPostForm(service.ConfigTypeModule, "name")), admin.Open)
+	router.POST("/close", admin.Audit(AuditActionClose, targetPostForm(service.ConfigTypeModule, "name")),
+		admin.Auth(RoleOperator, targetPostForm(service.ConfigTypeModule, "name")), admin.Close)
+	router.POST("/drain", admin.Audit(AuditActionDrain, targetPostForm(service.ConfigTypeModule, "name")),
+		admin.Auth(RoleOperator, targetPostForm(service.ConfigTypeModule, "name")), admin.Drain)
+	router.POST("/cache_purge", admin.Audit(AuditActionCachePurge, targetPostForm(service.ConfigTypeModule, "module_name")),
+		admin.Auth(RoleOperator, targetPostForm(service.ConfigTypeModule, "module_name")), admin.CachePurge)
+
+	router.POST("/delete", admin.Audit(AuditActionDeleteService, targetPostForm(service.ConfigTypeModule, "module_name")),
+		admin.Auth(RoleAdmin, nil), admin.Delete)
+	router.GET("/add_app", admin.Auth(RoleAdmin, nil), admin.AddAPP)
+	router.GET("/edit_app", admin.Auth(RoleAdmin, nil), admin.EditAPP)
+	router.POST("/save_app", admin.Audit(AuditActionSaveAPP, targetPostForm(service.ConfigTypeApp, "app_id")),
+		admin.Auth(RoleAdmin, nil), admin.SaveAPP)
+	router.POST("/del_app", admin.Audit(AuditActionDeleteAPP, targetPostForm(service.ConfigTypeApp, "app_id")),
+		admin.Auth(RoleAdmin, nil), admin.DelAPP)
+	router.POST("/rollback", admin.Audit(AuditActionRollback, targetRollback), admin.Auth(RoleAdmin, nil), admin.Rollback)
+	router.POST("/alert_test", admin.Audit(AuditActionAlertTest, targetPostForm(auditTargetAlert, "")),
+		admin.Auth(RoleAdmin, nil), admin.AlertTest)
+	router.GET("/audit", admin.Auth(RoleAdmin, nil), admin.AuditList)
+	router.GET("/audit_export", admin.Auth(RoleAdmin, nil), admin.AuditExport)
+	router.GET("/user_list", admin.Auth(RoleAdmin, nil), admin.UserList)
+	router.GET("/add_user", admin.Auth(RoleAdmin, nil), admin.AddUser)
+	router.GET("/edit_user", admin.Auth(RoleAdmin, nil), admin.EditUser)
+	router.POST("/save_user", admin.Audit(AuditActionSaveUser, targetPostForm(auditTargetUser, "user_name")),
+		admin.Auth(RoleAdmin, nil), admin.SaveUser)
+	router.POST("/del_user", admin.Audit(AuditActionDeleteUser, targetPostForm(auditTargetUser, "user_name")),
+		admin.Auth(RoleAdmin, nil), admin.DelUser)
+	router.GET("/config_io", admin.Auth(RoleAdmin, nil), admin.ConfigIO)
+	router.GET("/export", admin.Auth(RoleAdmin, nil), admin.ExportConfig)
+	router.POST("/import_plan", admin.Auth(RoleAdmin, nil), admin.ImportPlan)
+	router.POST("/import", admin.Audit(AuditActionImport, targetPostForm(service.ConfigTypeConfig, "")),
+		admin.Auth(RoleAdmin, nil), admin.ImportConfig)
 }
 
 //Index 首页action
@@ -56,111 +100,41 @@ func (admin *Admin) Index(c *gin.Context) {
 
 //LoginOut 退出action
 func (admin *Admin) LoginOut(c *gin.Context) {
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     constant.AdminCookiePrefix + "user_name",
-		Value:    "",
-		Expires:  time.Now().Add(-31500000 * time.Second),
-		Path:     "/",
-		Domain:   "",
-		Secure:   false,
-		HttpOnly: true,
-	})
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     constant.AdminCookiePrefix + "token",
-		Value:    "",
-		Expires:  time.Now().Add(-31500000 * time.Second),
-		Path:     "/",
-		Domain:   "",
-		Secure:   false,
-		HttpOnly: true,
-	})
+	http.SetCookie(c.Writer, admin.sessionCookie("", time.Now().Add(-31500000*time.Second)))
 	c.Redirect(302, "/admin/login")
 	return
 }
 
-//LoginAuth 登陆验证action
-func (admin *Admin) LoginAuth(c *gin.Context) error {
-	userName, err := c.Cookie(constant.AdminCookiePrefix + "user_name")
-	if err != nil {
-		return err
-	}
-	token, err := c.Cookie(constant.AdminCookiePrefix + "token")
-	if err != nil {
-		return err
-	}
-
-	//base64解密
-	bytesPass, err := base64.StdEncoding.DecodeString(token)
-	if err != nil {
-		return err
-	}
-
-	//aes解密
-	tokenStr, err := util.AesDecrypt(c.Request.Context(), bytesPass, []byte(constant.AdminCookieSecrit))
-	if err != nil {
-		return err
-	}
-	if string(tokenStr) != userName {
-		return errors.New("login error")
-	}
-	return nil
-}
-
 //Login 登陆action
 func (admin *Admin) Login(c *gin.Context) {
-	userName := c.PostForm("user_name")
-	passport := c.PostForm("passport")
-	if config.AuthConf.AdminName == userName && config.AuthConf.AdminPassport == passport {
-		http.SetCookie(c.Writer, &http.Cookie{
-			Name:     constant.AdminCookiePrefix + "user_name",
-			Value:    userName,
-			Expires:  time.Now().Add(time.Second * constant.AdminExpired),
-			Path:     "/",
-			Domain:   "",
-			Secure:   false,
-			HttpOnly: true,
-		})
-
-		var aeskey = []byte(constant.AdminCookieSecrit)
-		pass := []byte(userName)
-		xpass, err := util.AesEncrypt(c.Request.Context(), pass, aeskey)
-		if err != nil {
-			util.ResponseError(c, 500, err)
+	errMsg := ""
+	if c.Request.Method == http.MethodPost {
+		user, err := admin.checkLogin(c.PostForm("user_name"), c.PostForm("passport"))
+		if err == nil {
+			admin.setSession(c, user)
+			c.Redirect(302, "/admin/index")
 			return
 		}
-		pass64 := base64.StdEncoding.EncodeToString(xpass) //base64
-		http.SetCookie(c.Writer, &http.Cookie{
-			Name:     constant.AdminCookiePrefix + "token",
-			Value:    pass64,
-			Expires:  time.Now().Add(time.Second * constant.AdminExpired),
-			Path:     "/",
-			Domain:   "",
-			Secure:   false,
-			HttpOnly: true,
-		})
-		c.Redirect(302, "/admin/index")
-		return
+		config.SysLog.Warn("[admin login failed] user:%s ip:%s err:%v", c.PostForm("user_name"), c.ClientIP(), err)
+		errMsg = errLoginFailed.Error()
 	}
 	t := template.New("login.html")
 	t, err := t.ParseFiles("./tmpl/green/login.html")
 	if err != nil {
 		util.ResponseError(c, 500, err)
+		return
 	}
-	err2 := t.Execute(c.Writer, "")
+	err2 := t.Execute(c.Writer, errMsg)
 	if err2 != nil {
-		util.ResponseError(c, 500, err)
+		util.ResponseError(c, 500, err2)
 	}
 	return
 }
 
 //Open 打开流量action
 func (admin *Admin) Open(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
-	moduleName := c.Query("name")
-	addr := c.Query("addr")
+	moduleName := c.PostForm("name")
+	addr := c.PostForm("addr")
 	tx := config.DB.Begin()
 	base := &entity.GatewayModuleBase{Name: moduleName}
 	baseInfo, err := base.FindByName(tx, moduleName)
@@ -176,35 +150,29 @@ func (admin *Admin) Open(c *gin.Context) {
 		util.ResponseError(c, 500, errors.New("load.GetByModule:"+err.Error()))
 		return
 	}
-	forbidList := []string{}
-	for _, item := range strings.Split(load.ForbidList, ",") {
-		if item != "" && item != addr {
-			forbidList = append(forbidList, item)
-		}
-	}
-	load.ForbidList = strings.Join(forbidList, ",")
+	load.ForbidList = removeAddr(load.ForbidList, addr)
+	load.DrainList = removeAddr(load.DrainList, addr)
 	lerr := load.Save(tx)
 	if lerr != nil {
 		tx.Rollback()
 		util.ResponseError(c, 500, errors.New("load.Save:"+lerr.Error()))
 		return
 	}
+	if !admin.recordHistory(c, tx, service.ConfigTypeModule, moduleName, service.ConfigActionOpen) {
+		return
+	}
 	tx.Commit()
 	if err := admin.ClusterReloadModule(); err != nil {
 		util.ResponseError(c, 500, errors.New("ClusterReloadModule:"+err.Error()))
 		return
 	}
-	c.Redirect(302, "/admin/service_detail?module_name="+moduleName)
+	util.ResponseSuccess(c, "")
 }
 
 //Close 关闭流量action
 func (admin *Admin) Close(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
-	moduleName := c.Query("name")
-	addr := c.Query("addr")
+	moduleName := c.PostForm("name")
+	addr := c.PostForm("addr")
 
 	tx := config.DB.Begin()
 	base := &entity.GatewayModuleBase{Name: moduleName}
@@ -231,6 +199,7 @@ func (admin *Admin) Close(c *gin.Context) {
 		forbidList = append(forbidList, addr)
 	}
 	load.ForbidList = strings.Join(forbidList, ",")
+	load.DrainList = removeAddr(load.DrainList, addr)
 	//fmt.Println(load.ForbidList)
 	lerr := load.Save(tx)
 	if lerr != nil {
@@ -238,52 +207,108 @@ func (admin *Admin) Close(c *gin.Context) {
 		util.ResponseError(c, 500, errors.New("load.Save:"+err.Error()))
 		return
 	}
+	if !admin.recordHistory(c, tx, service.ConfigTypeModule, moduleName, service.ConfigActionClose) {
+		return
+	}
 	tx.Commit()
 
 	if err := admin.ClusterReloadModule(); err != nil {
 		util.ResponseError(c, 500, errors.New("ClusterReloadModule:"+err.Error()))
 		return
 	}
-	c.Redirect(302, "/admin/service_detail?module_name="+moduleName)
+	util.ResponseSuccess(c, "")
 	return
 }
 
-//ClusterReloadModule 集群配置更新action
-func (admin *Admin) ClusterReloadModule() error {
-	clusterList := config.BaseConf.Cluster.ClusterList
-	clusterAddr := config.BaseConf.Cluster.ClusterAddr
-
-	//集群配置更新
-	for _, item := range strings.Split(clusterList, ",") {
-		resp, bts, _ := util.HttpGET(fmt.Sprintf(
-			"http://%s%s/reload",
-			item, clusterAddr), nil, 5000, nil)
-		if resp.StatusCode != 200 {
-			return errors.New("clusterList.update:" + string(bts))
+//Drain 排空流量action，节点不再接收新请求，进行中的请求继续完成
+func (admin *Admin) Drain(c *gin.Context) {
+	moduleName := c.PostForm("name")
+	addr := c.PostForm("addr")
+
+	tx := config.DB.Begin()
+	base := &entity.GatewayModuleBase{Name: moduleName}
+	baseInfo, err := base.FindByName(tx, moduleName)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("base.FindByName:"+err.Error()))
+		return
+	}
+	load := &entity.GatewayLoadBalance{}
+	load, err = load.GetByModule(tx, baseInfo.ID)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("load.GetByModule:"+err.Error()))
+		return
+	}
+	if util.InStringList(addr, strings.Split(load.ForbidList, ",")) {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("节点流量已关闭！"))
+		return
+	}
+	drainList := []string{}
+	for _, item := range strings.Split(load.DrainList, ",") {
+		if item != "" {
+			drainList = append(drainList, item)
+		}
+	}
+	if !util.InStringList(addr, drainList) {
+		drainList = append(drainList, addr)
+	}
+	load.DrainList = strings.Join(drainList, ",")
+	if lerr := load.Save(tx); lerr != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("load.Save:"+lerr.Error()))
+		return
+	}
+	if !admin.recordHistory(c, tx, service.ConfigTypeModule, moduleName, service.ConfigActionDrain) {
+		return
+	}
+	tx.Commit()
+
+	if err := admin.ClusterReloadModule(); err != nil {
+		util.ResponseError(c, 500, errors.New("ClusterReloadModule:"+err.Error()))
+		return
+	}
+	util.ResponseSuccess(c, "")
+}
+
+// 从逗号分隔的ip列表中移除指定ip
+func removeAddr(list, addr string) string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item != "" && item != addr {
+			items = append(items, item)
 		}
 	}
-	return nil
+	return strings.Join(items, ",")
+}
+
+//ClusterReloadModule 集群配置更新action
+func (admin *Admin) ClusterReloadModule() error {
+	return service.ClusterReload()
 }
 
 //Delete 删除服务action
 func (admin *Admin) Delete(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
-	moduleName := c.Query("module_name")
+	moduleName := c.PostForm("module_name")
 	if moduleName == "" {
 		util.ResponseError(c, 500, errors.New("module name 必传！"))
 		return
 	}
+	if err := checkConfirm(c, moduleName); err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
 	tx := config.DB.Begin()
 	base := &entity.GatewayModuleBase{}
 	baseInfo, err := base.FindByName(tx, moduleName)
 	if err != nil {
+		tx.Rollback()
 		util.ResponseError(c, 500, errors.New("base.FindByName:"+err.Error()))
 		return
 	}
 	if baseInfo.Name != moduleName {
+		tx.Rollback()
 		util.ResponseError(c, 500, errors.New("module name 不存在"))
 		return
 	}
@@ -294,12 +319,19 @@ func (admin *Admin) Delete(c *gin.Context) {
 	load.Del(tx)
 	match := &entity.GatewayMatchRule{ModuleID: baseInfo.ID}
 	match.Del(tx)
+	group := &entity.GatewayUpstreamGroup{ModuleID: baseInfo.ID}
+	group.Del(tx)
+	cache := &entity.GatewayCache{ModuleID: baseInfo.ID}
+	cache.Del(tx)
+	if !admin.recordHistory(c, tx, service.ConfigTypeModule, moduleName, service.ConfigActionDelete) {
+		return
+	}
 	tx.Commit()
 	if err := admin.ClusterReloadModule(); err != nil {
 		util.ResponseError(c, 500, errors.New("ClusterReloadModule:"+err.Error()))
 		return
 	}
-	c.Redirect(302, "/admin/service_list")
+	util.ResponseSuccess(c, "")
 }
 
 func (admin *Admin) getDBAPPConf() (*running.Apps, error) {
@@ -321,10 +353,6 @@ func (admin *Admin) getDBAPPConf() (*running.Apps, error) {
 
 //AppList app列表action
 func (admin *Admin) AppList(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
 	appConf, errm := admin.getDBAPPConf()
 	if errm != nil {
 		util.ResponseError(c, 500, errm)
@@ -333,8 +361,7 @@ func (admin *Admin) AppList(c *gin.Context) {
 	appListObj := &APPListObj{ActiveURL: "/admin/app_list"}
 	for _, app := range appConf.Apps {
 		counter := resource.FlowCounters.GetAPPCounter(app.AppID)
-		DateFormat := "2006-01-02"
-		qdp, _ := counter.GetDayCount(time.Now().Format(DateFormat))
+		qdp, _ := counter.GetDayCount(time.Now().In(config.TimeLocation).Format(constant.DateFormat))
 		appListObj.List = append(appListObj.List, APPItemObj{
 			GatewayAPP: app,
 			QPS:        counter.QPS,
@@ -345,7 +372,7 @@ func (admin *Admin) AppList(c *gin.Context) {
 	if err != nil {
 		util.ResponseError(c, 500, err)
 	}
-	err2 := admin.executeTemplate(t, c.Writer, appListObj, "/admin/app_list")
+	err2 := admin.executeTemplate(t, c, appListObj, "/admin/app_list")
 	if err2 != nil {
 		util.ResponseError(c, 500, err2)
 	}
@@ -354,10 +381,6 @@ func (admin *Admin) AppList(c *gin.Context) {
 
 //ServiceList 服务列表action
 func (admin *Admin) ServiceList(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
 	moduleConf, err := admin.getDBModuleConf()
 	if err != nil {
 		util.ResponseError(c, 500, err)
@@ -375,16 +398,20 @@ func (admin *Admin) ServiceList(c *gin.Context) {
 	clusterIP := config.BaseConf.Cluster.ClusterIP
 	httpAddr := config.BaseConf.Cluster.ClusterAddr
 
+	user := admin.currentUser(c)
 	detailList := []*ServiceDetailInfo{}
 	for _, module := range moduleConf.Module {
-		ipList := strings.Split(module.LoadBalance.IPList, ",")
-		weightList := strings.Split(module.LoadBalance.WeightList, ",")
+		if user != nil && !canAccessModule(user, module.Base.Name) {
+			continue
+		}
+		ipList, weightList := admin.moduleNodes(module)
 		detailInfo := &ServiceDetailInfo{}
 		detailInfo.Module = module
 		detailInfo.ModuleIPList = ipList
 		detailInfo.WeightList = weightList
 		detailInfo.ActiveIPList = service.SysConfMgr.GetActiveIPList(module.Base.Name)
 		detailInfo.ForbidIPList = service.SysConfMgr.GetForbidIPList(module.Base.Name)
+		detailInfo.DrainIPList = service.SysConfMgr.GetDrainIPList(module.Base.Name)
 		detailInfo.AvaliableIPList = service.SysConfMgr.GetAvailableIPList(module.Base.Name)
 
 		detailInfo.ModuleIPCount = len(detailInfo.ModuleIPList)
@@ -402,7 +429,7 @@ func (admin *Admin) ServiceList(c *gin.Context) {
 		detailInfo.DayRequest = fmt.Sprint(dayCount)
 		detailList = append(detailList, detailInfo)
 	}
-	err2 := admin.executeTemplate(t, c.Writer, detailList, "/admin/service_list")
+	err2 := admin.executeTemplate(t, c, detailList, "/admin/service_list")
 	if err2 != nil {
 		util.ResponseError(c, 500, err2)
 	}
@@ -431,6 +458,14 @@ func (admin *Admin) getDBModuleConf() (*running.Modules, error) {
 	if err != nil {
 		return nil, err
 	}
+	upstreamGroupArr, err := (&entity.GatewayUpstreamGroup{}).GetAll(config.DB)
+	if err != nil {
+		return nil, err
+	}
+	cacheArr, err := (&entity.GatewayCache{}).GetAll(config.DB)
+	if err != nil {
+		return nil, err
+	}
 	for _, base := range bases {
 		matchRules := &entity.GatewayMatchRule{}
 		for _, x := range matchRuleArr {
@@ -450,24 +485,48 @@ func (admin *Admin) getDBModuleConf() (*running.Modules, error) {
 				loadBalance = x
 			}
 		}
+		var upstreamGroups []*entity.GatewayUpstreamGroup
+		for _, x := range upstreamGroupArr {
+			if x.ModuleID == base.ID {
+				upstreamGroups = append(upstreamGroups, x)
+			}
+		}
+		var cache *entity.GatewayCache
+		for _, x := range cacheArr {
+			if x.ModuleID == base.ID {
+				cache = x
+			}
+		}
 		if base != nil && loadBalance != nil {
 			moduleConf.Module[base.Name] = &running.GatewayModule{
-				Base:          base,
-				MatchRule:     matchRules,
-				AccessControl: accessControl,
-				LoadBalance:   loadBalance,
+				Base:           base,
+				MatchRule:      matchRules,
+				AccessControl:  accessControl,
+				LoadBalance:    loadBalance,
+				UpstreamGroups: upstreamGroups,
+				Cache:          cache,
 			}
 		}
 	}
 	return moduleConf, nil
 }
 
+//moduleNodes 模块节点及权重，使用服务发现时为当前发现的节点
+func (admin *Admin) moduleNodes(module *running.GatewayModule) ([]string, []string) {
+	if discovery.IsStatic(module.LoadBalance.DiscoveryType) {
+		return strings.Split(module.LoadBalance.IPList, ","), strings.Split(module.LoadBalance.WeightList, ",")
+	}
+	ipList := []string{}
+	weightList := []string{}
+	for _, node := range service.SysConfMgr.GetModuleNodes(module.Base.Name) {
+		ipList = append(ipList, node.Addr)
+		weightList = append(weightList, strconv.FormatInt(node.Weight, 10))
+	}
+	return ipList, weightList
+}
+
 //ServiceDetail 服务详情action
 func (admin *Admin) ServiceDetail(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
 	moduleName := c.Query("module_name")
 	moduleConf, err := admin.getDBModuleConf()
 	if err != nil {
@@ -492,8 +551,7 @@ func (admin *Admin) ServiceDetail(c *gin.Context) {
 		util.ResponseError(c, 500, errors.New("module_name not found"))
 		return
 	}
-	ipList := strings.Split(module.LoadBalance.IPList, ",")
-	weightList := strings.Split(module.LoadBalance.WeightList, ",")
+	ipList, weightList := admin.moduleNodes(module)
 
 	detailInfo := &ServiceDetailInfo{}
 	detailInfo.Module = module
@@ -501,7 +559,11 @@ func (admin *Admin) ServiceDetail(c *gin.Context) {
 	detailInfo.WeightList = weightList
 	detailInfo.ActiveIPList = service.SysConfMgr.GetActiveIPList(moduleName)
 	detailInfo.ForbidIPList = service.SysConfMgr.GetForbidIPList(moduleName)
+	detailInfo.DrainIPList = service.SysConfMgr.GetDrainIPList(moduleName)
 	detailInfo.AvaliableIPList = service.SysConfMgr.GetAvailableIPList(moduleName)
+	detailInfo.Bulkhead = service.SysConfMgr.GetBulkheadStat(moduleName)
+	detailInfo.StatWindow = upstreamStatWindow(c)
+	detailInfo.UpstreamGroups = admin.upstreamGroupStats(module)
 
 	counter := resource.FlowCounters.GetRequestCounter(module.Base.Name)
 	for i := 0; i <= time.Now().In(config.TimeLocation).Hour(); i++ {
@@ -533,7 +595,7 @@ func (admin *Admin) ServiceDetail(c *gin.Context) {
 		detailInfo.DailyStatMax = int64(float64(detailInfo.DailyStatMax) * 1.2)
 		detailInfo.DailyHourStat = detailInfo.DailyHourStat[0 : len(detailInfo.DailyHourStat)-1]
 	}
-	err = admin.executeTemplate(t, c.Writer, detailInfo, "/admin/service_list")
+	err = admin.executeTemplate(t, c, detailInfo, "/admin/service_list")
 	if err != nil {
 		util.ResponseError(c, 500, err)
 	}
@@ -542,18 +604,33 @@ func (admin *Admin) ServiceDetail(c *gin.Context) {
 
 //SaveService 保存服务action
 func (admin *Admin) SaveService(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
 	baseID := c.PostForm("base.id")
 	moduleName := c.PostForm("base.name")
 	serviceName := c.PostForm("base.service_name")
 	loadType := c.PostForm("base.load_type")
 	frontendPort := c.PostForm("base.frontend_addr")
+	accessLogFile := strings.TrimSpace(c.PostForm("base.access_log_file"))
+	errorPages := strings.TrimSpace(strings.Replace(c.PostForm("base.error_pages"), "\r\n", "\n", -1))
 	matchRule := c.PostForm("match.rule")
 	urlRewrite := c.PostForm("match.url_rewrite")
-	ipWeight := c.PostForm("load.ip_weight_list")
+	ipWeight := strings.TrimSpace(c.PostForm("load.ip_weight_list"))
+	discoveryType := c.PostForm("load.discovery_type")
+	discoveryTarget := strings.TrimSpace(c.PostForm("load.discovery_target"))
+	upstreamGroups := c.PostForm("load.upstream_groups")
+	mirrorGroup := strings.TrimSpace(c.PostForm("load.mirror_group"))
+	mirrorPercent := c.DefaultPostForm("load.mirror_percent", "0")
+	mirrorMaxConcurrency := c.DefaultPostForm("load.mirror_max_concurrency", "0")
+	maxBodySize := c.DefaultPostForm("load.max_body_size", "0")
+	slowStart := c.DefaultPostForm("load.slow_start", "0")
+	maxConnsPerHost := c.DefaultPostForm("load.max_conns_per_host", "0")
+	maxConcurrency := c.DefaultPostForm("load.max_concurrency", "0")
+	maxConcurrencyPerNode := c.DefaultPostForm("load.max_concurrency_per_node", "0")
+	queueSize := c.DefaultPostForm("load.queue_size", "0")
+	queueTimeout := c.DefaultPostForm("load.queue_timeout", "0")
+	hostMode := c.PostForm("load.host_mode")
+	fixedHost := strings.TrimSpace(c.PostForm("load.fixed_host"))
+	requestHeaderRules := strings.TrimSpace(strings.Replace(c.PostForm("load.request_header_rules"), "\r\n", "\n", -1))
+	responseHeaderRules := strings.TrimSpace(strings.Replace(c.PostForm("load.response_header_rules"), "\r\n", "\n", -1))
 	checkURL := c.PostForm("load.check_url")
 	whiteList := c.PostForm("access.white_list")
 	whiteHostName := c.PostForm("access.white_host_name")
@@ -608,21 +685,75 @@ func (admin *Admin) SaveService(c *gin.Context) {
 		util.ResponseError(c, 500, errors.New("链接最大空闲时间 格式化错误:"+err.Error()))
 		return
 	}
+	mirrorPercentInt, err := strconv.ParseInt(mirrorPercent, 10, 64)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("镜像比例 格式化错误:"+err.Error()))
+		return
+	}
+	mirrorMaxConcurrencyInt, err := strconv.ParseInt(mirrorMaxConcurrency, 10, 64)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("镜像最大并发 格式化错误:"+err.Error()))
+		return
+	}
+	maxBodySizeInt, err := strconv.ParseInt(maxBodySize, 10, 64)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("请求体最大字节数 格式化错误:"+err.Error()))
+		return
+	}
+	slowStartInt, err := strconv.ParseInt(slowStart, 10, 64)
+	if err != nil || slowStartInt < 0 {
+		util.ResponseError(c, 500, errors.New("慢启动时长 格式化错误"))
+		return
+	}
+	maxConnsPerHostInt, err := strconv.ParseInt(maxConnsPerHost, 10, 64)
+	if err != nil || maxConnsPerHostInt < 0 {
+		util.ResponseError(c, 500, errors.New("单节点最大连接数 格式化错误"))
+		return
+	}
+	maxConcurrencyInt, err := strconv.ParseInt(maxConcurrency, 10, 64)
+	if err != nil || maxConcurrencyInt < 0 {
+		util.ResponseError(c, 500, errors.New("模块最大并发 格式化错误"))
+		return
+	}
+	maxConcurrencyPerNodeInt, err := strconv.ParseInt(maxConcurrencyPerNode, 10, 64)
+	if err != nil || maxConcurrencyPerNodeInt < 0 {
+		util.ResponseError(c, 500, errors.New("单节点最大并发 格式化错误"))
+		return
+	}
+	queueSizeInt, err := strconv.ParseInt(queueSize, 10, 64)
+	if err != nil || queueSizeInt < 0 {
+		util.ResponseError(c, 500, errors.New("排队请求数 格式化错误"))
+		return
+	}
+	queueTimeoutInt, err := strconv.ParseInt(queueTimeout, 10, 64)
+	if err != nil || queueTimeoutInt < 0 {
+		util.ResponseError(c, 500, errors.New("排队超时时间 格式化错误"))
+		return
+	}
 	if strings.HasSuffix(matchRule, "/") {
 		matchRule = util.Substr(matchRule, 0, int64(len(matchRule)-1))
 	}
 
 	if loadType == "http" {
-		if moduleName == "" || serviceName == "" || matchRule == "" || ipWeight == "" || checkURL == "" {
+		if moduleName == "" || serviceName == "" || matchRule == "" || checkURL == "" {
 			util.ResponseError(c, 500, errors.New("*字段，必须填写！"))
 			return
 		}
 	} else if loadType == "tcp" {
-		if moduleName == "" || serviceName == "" || frontendPort == "" || ipWeight == "" {
+		if moduleName == "" || serviceName == "" || frontendPort == "" {
 			util.ResponseError(c, 500, errors.New("*字段，必须填写！"))
 			return
 		}
 	}
+	//使用服务发现时无需手工填写服务器ip
+	if discovery.IsStatic(discoveryType) && ipWeight == "" {
+		util.ResponseError(c, 500, errors.New("服务器ip和权重，必须填写！"))
+		return
+	}
+	if !discovery.IsStatic(discoveryType) && discoveryTarget == "" {
+		util.ResponseError(c, 500, errors.New("服务发现目标，必须填写！"))
+		return
+	}
 
 	//开启事务
 	tx := config.DB.Begin()
@@ -632,6 +763,7 @@ func (admin *Admin) SaveService(c *gin.Context) {
 	if baseID != "0" {
 		tID, err := strconv.ParseInt(baseID, 10, 64)
 		if err != nil {
+			tx.Rollback()
 			util.ResponseError(c, 500, errors.New("base.id，必须为数字！"))
 			return
 		}
@@ -643,6 +775,10 @@ func (admin *Admin) SaveService(c *gin.Context) {
 		model2.Del(tx)
 		model3 := &entity.GatewayAccessControl{ModuleID: tID}
 		model3.Del(tx)
+		model5 := &entity.GatewayUpstreamGroup{ModuleID: tID}
+		model5.Del(tx)
+		model6 := &entity.GatewayCache{ModuleID: tID}
+		model6.Del(tx)
 		//model4 := &entity.GatewayDataFilter{ModuleID: tID}
 		//model4.Del(tx)
 		moduleID = tID
@@ -655,6 +791,8 @@ func (admin *Admin) SaveService(c *gin.Context) {
 	base.ServiceName = serviceName
 	base.LoadType = loadType
 	base.PassAuthType = 2
+	base.AccessLogFile = accessLogFile
+	base.ErrorPages = errorPages
 	//服务标识验证
 	if ok, _ := regexp.Match("^[0-9a-zA-Z_-]+$", []byte(moduleName)); !ok {
 		tx.Rollback()
@@ -708,15 +846,16 @@ func (admin *Admin) SaveService(c *gin.Context) {
 	}
 
 	//构造 gateway_match_rule
+	matchModel := &entity.GatewayMatchRule{}
 	matchRules := strings.Split(matchRule, ",")
-	urlRewrites := strings.Split(urlRewrite, "\n")
-	if baseID == "0" && (urlRewrite == "\n" || urlRewrite == "") {
+	urlRewrites := service.URLRewriteLines(urlRewrite)
+	if baseID == "0" && len(urlRewrites) == 0 {
 		//urlRewrites为空时，自动填充
 		for _, rule := range matchRules {
 			urlRewrites = append(urlRewrites, fmt.Sprintf("^%s(.*) $1", rule))
 		}
 	}
-	urlRewrite = strings.Join(urlRewrites, ",")
+	urlRewrite = strings.Join(urlRewrites, "\n")
 	for _, rule := range matchRules {
 		model := &entity.GatewayMatchRule{}
 		model.ModuleID = base.ID
@@ -746,6 +885,7 @@ func (admin *Admin) SaveService(c *gin.Context) {
 			util.ResponseError(c, 500, errors.New("GatewayMatchRule.Save:"+err.Error()))
 			return
 		}
+		matchModel = model
 	}
 
 	//构造 gateway_load_balance
@@ -753,6 +893,9 @@ func (admin *Admin) SaveService(c *gin.Context) {
 	ipList := []string{}
 	weightList := []string{}
 	for _, ipItem := range ipWeights {
+		if ipItem = strings.TrimSpace(ipItem); ipItem == "" {
+			continue
+		}
 		ipItems := strings.Split(ipItem, " ")
 		if util.InStringList(ipItems[0], ipList) {
 			tx.Rollback()
@@ -782,6 +925,8 @@ func (admin *Admin) SaveService(c *gin.Context) {
 	model.CheckTimeout = 2000
 	model.CheckInterval = int(checkIntervalInt)
 	model.Type = "round-robin"
+	model.DiscoveryType = discoveryType
+	model.DiscoveryTarget = discoveryTarget
 	model.IPList = strings.Join(ipList, ",")
 	model.WeightList = strings.Join(weightList, ",")
 	model.ProxyConnectTimeout = int(proxyConnectTimeoutInt)
@@ -790,6 +935,20 @@ func (admin *Admin) SaveService(c *gin.Context) {
 	model.IdleConnTimeout = int(idleConnTimeoutInt)
 	//fmt.Println("save value",maxIdleConnInt)
 	model.MaxIdleConn = int(maxIdleConnInt)
+	model.MirrorGroup = mirrorGroup
+	model.MirrorPercent = mirrorPercentInt
+	model.MirrorMaxConcurrency = int(mirrorMaxConcurrencyInt)
+	model.MaxBodySize = maxBodySizeInt
+	model.SlowStart = int(slowStartInt)
+	model.MaxConnsPerHost = int(maxConnsPerHostInt)
+	model.MaxConcurrency = int(maxConcurrencyInt)
+	model.MaxConcurrencyPerNode = int(maxConcurrencyPerNodeInt)
+	model.QueueSize = int(queueSizeInt)
+	model.QueueTimeout = int(queueTimeoutInt)
+	model.HostMode = hostMode
+	model.FixedHost = fixedHost
+	model.RequestHeaderRules = requestHeaderRules
+	model.ResponseHeaderRules = responseHeaderRules
 	if err := model.Save(tx); err != nil {
 		tx.Rollback()
 		util.ResponseError(c, 500, errors.New("GatewayLoadBalance.Save:"+err.Error()))
@@ -822,6 +981,65 @@ func (admin *Admin) SaveService(c *gin.Context) {
 		//c.Error(500, "access.Save:"+err.Error())
 		return
 	}
+
+	//构造 gateway_upstream_group
+	groups, err := parseUpstreamGroups(upstreamGroups)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("上游分组格式错误:"+err.Error()))
+		return
+	}
+	for _, group := range groups {
+		group.ModuleID = base.ID
+		if err := group.Save(tx); err != nil {
+			tx.Rollback()
+			util.ResponseError(c, 500, errors.New("GatewayUpstreamGroup.Save:"+err.Error()))
+			return
+		}
+	}
+
+	//构造 gateway_cache
+	cache, err := parseCacheForm(c)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, err)
+		return
+	}
+	if cache != nil {
+		cache.ModuleID = base.ID
+		if err := cache.Save(tx); err != nil {
+			tx.Rollback()
+			util.ResponseError(c, 500, errors.New("GatewayCache.Save:"+err.Error()))
+			return
+		}
+	}
+
+	//校验保存后的完整配置
+	result, err := admin.validateChange(&running.GatewayModule{
+		Base:           base,
+		MatchRule:      matchModel,
+		LoadBalance:    model,
+		AccessControl:  access,
+		UpstreamGroups: groups,
+		Cache:          cache,
+	}, nil)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, err)
+		return
+	}
+	if err := result.Err(); err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("配置校验失败:"+err.Error()))
+		return
+	}
+	historyAction := service.ConfigActionUpdate
+	if baseID == "0" {
+		historyAction = service.ConfigActionCreate
+	}
+	if !admin.recordHistory(c, tx, service.ConfigTypeModule, moduleName, historyAction) {
+		return
+	}
 	tx.Commit()
 	if err := admin.ClusterReloadModule(); err != nil {
 		util.ResponseError(c, 500, errors.New("ClusterReloadModule:"+err.Error()))
@@ -833,15 +1051,14 @@ func (admin *Admin) SaveService(c *gin.Context) {
 
 //SaveAPP 保存租户action
 func (admin *Admin) SaveAPP(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
 	id := c.PostForm("id")
 	appID := c.PostForm("app_id")
 	name := c.PostForm("name")
 	secret := c.PostForm("secret")
 	totalQueryDaily := c.PostForm("total_query_daily")
+	totalQueryHourly := c.DefaultPostForm("total_query_hourly", "0")
+	totalQueryMonthly := c.DefaultPostForm("total_query_monthly", "0")
+	moduleQuota := c.PostForm("module_quota")
 	qps := c.PostForm("qps")
 	openAPI := c.PostForm("open_api")
 	whiteIps := c.PostForm("white_ips")
@@ -869,6 +1086,21 @@ func (admin *Admin) SaveAPP(c *gin.Context) {
 		return
 	}
 
+	totalQueryHourlyInt, err := strconv.ParseInt(totalQueryHourly, 10, 64)
+	if err != nil || totalQueryHourlyInt < 0 {
+		util.ResponseError(c, 500, errors.New("小时请求总量 必须为非负整数！"))
+		return
+	}
+	totalQueryMonthlyInt, err := strconv.ParseInt(totalQueryMonthly, 10, 64)
+	if err != nil || totalQueryMonthlyInt < 0 {
+		util.ResponseError(c, 500, errors.New("月请求总量 必须为非负整数！"))
+		return
+	}
+	if _, err := service.ParseModuleQuota(moduleQuota); err != nil {
+		util.ResponseError(c, 500, errors.New("模块配额 格式错误:"+err.Error()))
+		return
+	}
+
 	//构造 gateway_module_base
 	tx := config.DB.Begin()
 
@@ -878,6 +1110,7 @@ func (admin *Admin) SaveAPP(c *gin.Context) {
 		idInt, _ := strconv.ParseInt(id, 10, 64)
 		appInfo, err := app.FindByID(tx, idInt)
 		if err != nil {
+			tx.Rollback()
 			util.ResponseError(c, 500, errors.New("FindByID:"+err.Error()))
 			return
 		}
@@ -891,6 +1124,7 @@ func (admin *Admin) SaveAPP(c *gin.Context) {
 		}
 		//fmt.Println("appInfo",appInfo)
 		if appInfo.AppID != "" {
+			tx.Rollback()
 			util.ResponseError(c, 500, errors.New("app_id 已经存在！"))
 			return
 		}
@@ -908,6 +1142,9 @@ func (admin *Admin) SaveAPP(c *gin.Context) {
 	app.WhiteIps = whiteIps
 	app.CityIDs = cityIds
 	app.TotalQueryDaily = totalQueryDailyInt
+	app.TotalQueryHourly = totalQueryHourlyInt
+	app.TotalQueryMonthly = totalQueryMonthlyInt
+	app.ModuleQuota = moduleQuota
 	app.QPS = qpsInt
 	app.GroupID = groupIDInt
 	app.Timeout = timeoutInt
@@ -917,6 +1154,26 @@ func (admin *Admin) SaveAPP(c *gin.Context) {
 		util.ResponseError(c, 500, errors.New("GatewayModuleBase.Save:"+err.Error()))
 		return
 	}
+
+	//校验保存后的完整配置
+	result, err := admin.validateChange(nil, app)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, err)
+		return
+	}
+	if err := result.Err(); err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("配置校验失败:"+err.Error()))
+		return
+	}
+	historyAction := service.ConfigActionUpdate
+	if id == "0" {
+		historyAction = service.ConfigActionCreate
+	}
+	if !admin.recordHistory(c, tx, service.ConfigTypeApp, appID, historyAction) {
+		return
+	}
 	tx.Commit()
 	if err := admin.ClusterReloadModule(); err != nil {
 		util.ResponseError(c, 500, errors.New("ClusterReloadModule:"+err.Error()))
@@ -926,13 +1183,71 @@ func (admin *Admin) SaveAPP(c *gin.Context) {
 	return
 }
 
-//EditAPP 修改app action
-func (admin *Admin) EditAPP(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
+//Validate 配置校验action，不保存
+//请求体为空时校验当前DB配置，否则将请求中的模块、租户合并到DB配置后校验
+func (admin *Admin) Validate(c *gin.Context) {
+	input := &ValidateInput{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(input); err != nil {
+			util.ResponseError(c, 500, errors.New("参数格式错误:"+err.Error()))
+			return
+		}
+	}
+	moduleConf, appConf, err := admin.getDBConf()
+	if err != nil {
+		util.ResponseError(c, 500, err)
 		return
 	}
+	for name, module := range input.Module {
+		moduleConf.Module[name] = module
+	}
+	for name, app := range input.Apps {
+		appConf.Apps[name] = app
+	}
+	util.ResponseSuccess(c, service.ValidateConfig(moduleConf, appConf))
+}
+
+//validateChange 将待保存的模块或租户合并到当前DB配置后校验
+func (admin *Admin) validateChange(module *running.GatewayModule, app *entity.GatewayAPP) (*service.ValidateResult, error) {
+	moduleConf, appConf, err := admin.getDBConf()
+	if err != nil {
+		return nil, err
+	}
+	if module != nil {
+		moduleConf.Module[module.Base.Name] = module
+	}
+	if app != nil {
+		for name, item := range appConf.Apps {
+			if app.ID != 0 && item.ID == app.ID {
+				delete(appConf.Apps, name)
+			}
+		}
+		appConf.Apps[app.Name] = app
+	}
+	return service.ValidateConfig(moduleConf, appConf), nil
+}
+
+//getDBConf 获取DB中的模块及租户配置
+func (admin *Admin) getDBConf() (*running.Modules, *running.Apps, error) {
+	moduleConf, err := admin.getDBModuleConf()
+	if err != nil {
+		return nil, nil, err
+	}
+	if moduleConf == nil {
+		return nil, nil, errors.New("获取模块配置错误")
+	}
+	appConf, err := admin.getDBAPPConf()
+	if err != nil {
+		return nil, nil, err
+	}
+	if appConf == nil {
+		return nil, nil, errors.New("获取租户配置错误")
+	}
+	return moduleConf, appConf, nil
+}
 
+//EditAPP 修改app action
+func (admin *Admin) EditAPP(c *gin.Context) {
 	appID := c.Query("app_id")
 	tx := config.DB
 	app := &entity.GatewayAPP{}
@@ -953,7 +1268,7 @@ func (admin *Admin) EditAPP(c *gin.Context) {
 		util.ResponseError(c, 500, err)
 		return
 	}
-	err2 := admin.executeTemplate(t, c.Writer, app, "/admin/app_list")
+	err2 := admin.executeTemplate(t, c, app, "/admin/app_list")
 	if err2 != nil {
 		util.ResponseError(c, 500, err2)
 		return
@@ -963,11 +1278,6 @@ func (admin *Admin) EditAPP(c *gin.Context) {
 
 //APPDetail 租户详情action
 func (admin *Admin) APPDetail(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
-
 	appID := c.Query("app_id")
 	tx := config.DB
 	app := &entity.GatewayAPP{}
@@ -1024,7 +1334,7 @@ func (admin *Admin) APPDetail(c *gin.Context) {
 		util.ResponseError(c, 500, err)
 		return
 	}
-	err2 := admin.executeTemplate(t, c.Writer, detailInfo, "/admin/app_list")
+	err2 := admin.executeTemplate(t, c, detailInfo, "/admin/app_list")
 	if err2 != nil {
 		util.ResponseError(c, 500, err2)
 	}
@@ -1033,14 +1343,13 @@ func (admin *Admin) APPDetail(c *gin.Context) {
 
 //DelAPP 删除租户action
 func (admin *Admin) DelAPP(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
+	appID := c.PostForm("app_id")
+	if err := checkConfirm(c, appID); err != nil {
+		util.ResponseError(c, 500, err)
 		return
 	}
-
 	tx := config.DB
 	tx = tx.Begin() //这里一定要赋值
-	appID := c.Query("app_id")
 	app := &entity.GatewayAPP{}
 	appInfo, err := app.FindByAppID(tx, appID)
 	if err != nil {
@@ -1055,20 +1364,19 @@ func (admin *Admin) DelAPP(c *gin.Context) {
 	}
 	app = appInfo
 	app.Del(tx)
+	if !admin.recordHistory(c, tx, service.ConfigTypeApp, appID, service.ConfigActionDelete) {
+		return
+	}
 	tx.Commit()
 	if err := admin.ClusterReloadModule(); err != nil {
 		util.ResponseError(c, 500, errors.New("ClusterReloadModule:"+err.Error()))
 		return
 	}
-	c.Redirect(302, "/admin/app_list")
+	util.ResponseSuccess(c, "")
 }
 
 //EditService 编辑服务action
 func (admin *Admin) EditService(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
 	moduleName := c.Query("module_name")
 	moduleConf, errm := admin.getDBModuleConf()
 	if errm != nil {
@@ -1098,16 +1406,18 @@ func (admin *Admin) EditService(c *gin.Context) {
 	detailInfo.WeightList = weightList
 	detailInfo.ActiveIPList = service.SysConfMgr.GetActiveIPList(moduleName)
 	detailInfo.ForbidIPList = service.SysConfMgr.GetForbidIPList(moduleName)
+	detailInfo.DrainIPList = service.SysConfMgr.GetDrainIPList(moduleName)
 	detailInfo.AvaliableIPList = service.SysConfMgr.GetAvailableIPList(moduleName)
 
 	matchRules := []string{}
-	matchURLRules := []string{}
 	matchRules = append(matchRules, module.MatchRule.Rule)
-	matchURLRules = strings.Split(module.MatchRule.URLRewrite, ",")
-	detailInfo.URLRewrite = strings.Join(matchURLRules, "\r")
+	detailInfo.URLRewrite = strings.Join(service.URLRewriteLines(module.MatchRule.URLRewrite), "\n")
 	detailInfo.MatchRule = strings.Join(matchRules, ",")
 	ipWeigths := []string{}
 	for index, item := range ipList {
+		if item == "" {
+			continue
+		}
 		if len(weightList)-1 >= index {
 			item = item + " " + weightList[index]
 		} else {
@@ -1116,6 +1426,11 @@ func (admin *Admin) EditService(c *gin.Context) {
 		ipWeigths = append(ipWeigths, item)
 	}
 	detailInfo.IPWeightList = strings.Join(ipWeigths, "\r")
+	detailInfo.GroupList = formatUpstreamGroups(module.UpstreamGroups)
+	detailInfo.Cache = module.Cache
+	if detailInfo.Cache == nil {
+		detailInfo.Cache = &entity.GatewayCache{}
+	}
 	if strings.Contains(module.AccessControl.AuthType, "passport") {
 		detailInfo.Passport = "1"
 	}
@@ -1143,7 +1458,7 @@ func (admin *Admin) EditService(c *gin.Context) {
 		}
 		t = tg
 	}
-	err2 := admin.executeTemplate(t, c.Writer, detailInfo, "/admin/service_list")
+	err2 := admin.executeTemplate(t, c, detailInfo, "/admin/service_list")
 	if err2 != nil {
 		util.ResponseError(c, 500, err2)
 		return
@@ -1153,11 +1468,6 @@ func (admin *Admin) EditService(c *gin.Context) {
 
 //AddHTTP 添加http服务action
 func (admin *Admin) AddHTTP(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
-
 	t, err := admin.getTemplateByURL("/admin/add_http")
 	if err != nil {
 		util.ResponseError(c, 500, err)
@@ -1169,8 +1479,10 @@ func (admin *Admin) AddHTTP(c *gin.Context) {
 			MatchRule:     &entity.GatewayMatchRule{},
 			LoadBalance:   &entity.GatewayLoadBalance{},
 			AccessControl: &entity.GatewayAccessControl{},
-		}}
-	err = admin.executeTemplate(t, c.Writer, detailInfo, "/admin/service_list")
+		},
+		Cache: &entity.GatewayCache{},
+	}
+	err = admin.executeTemplate(t, c, detailInfo, "/admin/service_list")
 	if err != nil {
 		util.ResponseError(c, 500, err)
 	}
@@ -1179,10 +1491,6 @@ func (admin *Admin) AddHTTP(c *gin.Context) {
 
 //AddAPP 添加app的action
 func (admin *Admin) AddAPP(c *gin.Context) {
-	if err := admin.LoginAuth(c); err != nil {
-		c.Redirect(302, "/admin/login")
-		return
-	}
 	t, err := admin.getTemplateByURL("/admin/add_app")
 	if err != nil {
 		util.ResponseError(c, 500, err)
@@ -1190,7 +1498,7 @@ func (admin *Admin) AddAPP(c *gin.Context) {
 
 	app := &entity.GatewayAPP{}
 	app.Secret = fmt.Sprintf("%x", md5.Sum([]byte(time.Now().String())))
-	err2 := admin.executeTemplate(t, c.Writer, app, "/admin/app_list")
+	err2 := admin.executeTemplate(t, c, app, "/admin/app_list")
 	if err2 != nil {
 		util.ResponseError(c, 500, err2)
 	}
@@ -1207,11 +1515,13 @@ func (admin *Admin) parseTemplate(tempFile string) (*template.Template, error) {
 		"./tmpl/green/layout/sidebar.html")
 }
 
-func (admin *Admin) executeTemplate(t *template.Template, wr io.Writer, data interface{}, activeURL string) error {
+func (admin *Admin) executeTemplate(t *template.Template, c *gin.Context, data interface{}, activeURL string) error {
 	m := make(map[string]interface{})
 	m["data"] = data
 	m["active_uri"] = activeURL
-	return t.Execute(wr, m)
+	m["user"] = admin.currentUser(c)
+	m["csrf_token"] = admin.csrfToken(c)
+	return t.Execute(c.Writer, m)
 }
 
 func (admin *Admin) getTemplateByURL(action string) (*template.Template, error) {
@@ -1234,8 +1544,28 @@ func (admin *Admin) getTemplateByURL(action string) (*template.Template, error)
 		return admin.parseTemplate("./tmpl/green/add_app.html")
 	case "/admin/edit_app":
 		return admin.parseTemplate("./tmpl/green/add_app.html")
+	case "/admin/alert":
+		return admin.parseTemplate("./tmpl/green/alert.html")
 	case "/admin/app_detail":
 		return admin.parseTemplate("./tmpl/green/app_detail.html")
+	case "/admin/history":
+		return admin.parseTemplate("./tmpl/green/history.html")
+	case "/admin/history_diff":
+		return admin.parseTemplate("./tmpl/green/history_diff.html")
+	case "/admin/audit":
+		return admin.parseTemplate("./tmpl/green/audit.html")
+	case "/admin/user_list":
+		return admin.parseTemplate("./tmpl/green/user_list.html")
+	case "/admin/add_user":
+		return admin.parseTemplate("./tmpl/green/add_user.html")
+	case "/admin/edit_user":
+		return admin.parseTemplate("./tmpl/green/add_user.html")
+	case "/admin/password":
+		return admin.parseTemplate("./tmpl/green/password.html")
+	case "/admin/config_io":
+		return admin.parseTemplate("./tmpl/green/config_io.html")
+	case "/admin/stat_chart":
+		return admin.parseTemplate("./tmpl/green/stat_chart.html")
 	}
 	return nil, errors.New("not found match action")
 }
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"html/template"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/core/service"
+	"gatekeeper/model/entity"
+	"gatekeeper/util"
+)
+
+//alertPageSize 告警历史每页条数
+const alertPageSize = 50
+
+//AlertListInfo 告警页面结构体
+type AlertListInfo struct {
+	Configured bool                          `json:"configured"` //是否配置了告警规则
+	Rules      []*config.AlertRule           `json:"rules"`
+	Webhooks   []string                      `json:"webhooks"`
+	Active     []*service.Alert              `json:"active"` //本节点当前的告警
+	Rule       string                        `json:"rule"`
+	Severity   string                        `json:"severity"`
+	Status     string                        `json:"status"`
+	Target     string                        `json:"target"`
+	StartTime  string                        `json:"start_time"`
+	EndTime    string                        `json:"end_time"`
+	History    []*entity.GatewayAlertHistory `json:"history"` //file存储模式下为空
+	CanTest    bool                          `json:"-"`       //管理员可发送测试通知
+	Query      template.URL                  `json:"-"`       //分页链接参数
+	Page       int                           `json:"page"`
+	PrevPage   int                           `json:"-"`
+	NextPage   int                           `json:"-"`
+	HasMore    bool                          `json:"has_more"`
+}
+
+//AlertList 告警规则、当前告警及告警历史action，format=json时输出json
+func (admin *Admin) AlertList(c *gin.Context) {
+	filter := &entity.GatewayAlertHistoryFilter{
+		Rule:     c.Query("rule"),
+		Severity: c.Query("severity"),
+		Status:   c.Query("status"),
+		Target:   c.Query("target"),
+	}
+	var err error
+	if filter.StartTime, err = parseAuditTime(c.Query("start_time"), false); err != nil {
+		util.ResponseError(c, 500, errors.New("start_time 格式错误:"+err.Error()))
+		return
+	}
+	if filter.EndTime, err = parseAuditTime(c.Query("end_time"), true); err != nil {
+		util.ResponseError(c, 500, errors.New("end_time 格式错误:"+err.Error()))
+		return
+	}
+	page, _ := strconv.Atoi(c.Query("page"))
+	if page < 1 {
+		page = 1
+	}
+	listInfo := &AlertListInfo{
+		Configured: service.Alerts != nil,
+		Rules:      service.Alerts.Rules(),
+		Webhooks:   service.Alerts.WebhookNames(),
+		Active:     service.Alerts.Active(),
+		Rule:       filter.Rule,
+		Severity:   filter.Severity,
+		Status:     filter.Status,
+		Target:     filter.Target,
+		StartTime:  c.Query("start_time"),
+		EndTime:    c.Query("end_time"),
+		CanTest:    admin.currentUser(c).Role == RoleAdmin,
+		Query:      auditExportQuery(c),
+		Page:       page,
+		PrevPage:   page - 1,
+		NextPage:   page + 1,
+	}
+	if config.DBEnabled() {
+		listInfo.History, err = (&entity.GatewayAlertHistory{}).GetList(config.DB, filter, (page-1)*alertPageSize, alertPageSize)
+		if err != nil {
+			util.ResponseError(c, 500, errors.New("GetList:"+err.Error()))
+			return
+		}
+		listInfo.HasMore = len(listInfo.History) == alertPageSize
+	}
+	if c.Query("format") == "json" {
+		util.ResponseSuccess(c, listInfo)
+		return
+	}
+	t, err := admin.getTemplateByURL("/admin/alert")
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	if err := admin.executeTemplate(t, c, listInfo, "/admin/alert"); err != nil {
+		util.ResponseError(c, 500, err)
+	}
+}
+
+//AlertTest 向webhook发送测试告警
+func (admin *Admin) AlertTest(c *gin.Context) {
+	if err := service.Alerts.TestWebhook(c.PostForm("webhook")); err != nil {
+		util.ResponseError(c, 500, errors.New("TestWebhook:"+err.Error()))
+		return
+	}
+	util.ResponseSuccess(c, "")
+}
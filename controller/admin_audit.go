@@ -0,0 +1,339 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/core/service"
+	"gatekeeper/model/entity"
+	"gatekeeper/util"
+)
+
+// 审计操作
+const (
+	AuditActionOpen          = "open"
+	AuditActionClose         = "close"
+	AuditActionDrain         = "drain"
+	AuditActionDeleteService = "delete_service"
+	AuditActionSaveService   = "save_service"
+	AuditActionSaveAPP       = "save_app"
+	AuditActionDeleteAPP     = "delete_app"
+	AuditActionRollback      = "rollback"
+	AuditActionSaveUser      = "save_user"
+	AuditActionDeleteUser    = "delete_user"
+	AuditActionImport        = "import"
+	AuditActionCachePurge    = "cache_purge"
+	AuditActionPassword      = "password"
+	AuditActionAlertTest     = "alert_test"
+)
+
+// 审计目标类型
+const (
+	auditTargetUser  = "user"  //管理员用户
+	auditTargetAlert = "alert" //告警配置，不记录变更前后内容
+)
+
+//auditContextKey 请求中待记录的审计信息，Auth通过后读取变更前的配置
+const auditContextKey = "audit"
+
+//auditState 单次请求的审计信息
+type auditState struct {
+	log        *entity.GatewayAuditLog
+	authorized bool
+}
+
+// 审计结果
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+	AuditResultDenied  = "denied" //未登录或权限不足
+)
+
+const (
+	//auditPageSize 审计列表每页条数
+	auditPageSize = 50
+	//auditExportLimit 单次导出最大条数
+	auditExportLimit = 10000
+	//auditTimeFormat 审计查询时间格式
+	auditTimeFormat = "2006-01-02 15:04:05"
+)
+
+//routeTarget 从请求中解析操作目标类型及名称，用于审计及模块权限校验
+type routeTarget func(c *gin.Context) (targetType string, targetName string)
+
+//targetQuery 从query参数中读取目标名称
+func targetQuery(targetType, key string) routeTarget {
+	return func(c *gin.Context) (string, string) {
+		return targetType, c.Query(key)
+	}
+}
+
+//targetPostForm 从表单参数中读取目标名称
+func targetPostForm(targetType, key string) routeTarget {
+	return func(c *gin.Context) (string, string) {
+		return targetType, c.PostForm(key)
+	}
+}
+
+//targetRollback 回滚的审计目标为版本对应的模块或租户，scope=config时为全量配置
+func targetRollback(c *gin.Context) (string, string) {
+	if c.PostForm("scope") == "config" {
+		return service.ConfigTypeConfig, ""
+	}
+	id, _ := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	history, err := (&entity.GatewayConfigHistory{}).FindByID(config.DB, id)
+	if err != nil || history.ID == 0 {
+		return service.ConfigTypeConfig, ""
+	}
+	return history.Type, history.Name
+}
+
+//Audit 审计中间件，记录操作人、来源ip、目标变更前后的配置及操作结果
+//需注册在Auth中间件之前，以便记录未通过验证的请求，变更前的配置在Auth通过后读取
+func (admin *Admin) Audit(action string, target routeTarget) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := &auditState{
+			log: &entity.GatewayAuditLog{
+				SourceIP: c.ClientIP(),
+				Action:   action,
+			},
+		}
+		auditLog := state.log
+		auditLog.TargetType, auditLog.TargetName = target(c)
+		c.Set(auditContextKey, state)
+		c.Next()
+		auditLog.Actor = admin.loginUser(c)
+		switch {
+		case !state.authorized || c.Writer.Status() == 403:
+			auditLog.Result = AuditResultDenied
+			auditLog.Message = auditMessage(c)
+			auditLog.Before = ""
+		case c.IsAborted() || c.Writer.Status() >= 400:
+			auditLog.Result = AuditResultFailure
+			auditLog.Message = auditMessage(c)
+			auditLog.After = admin.auditSnapshot(auditLog.TargetType, auditLog.TargetName)
+		default:
+			auditLog.Result = AuditResultSuccess
+			auditLog.After = admin.auditSnapshot(auditLog.TargetType, auditLog.TargetName)
+		}
+		admin.saveAuditLog(auditLog)
+	}
+}
+
+//auditAuthorized 权限校验通过后读取审计目标变更前的配置，未注册Audit的路由直接跳过
+func (admin *Admin) auditAuthorized(c *gin.Context) {
+	value, ok := c.Get(auditContextKey)
+	if !ok {
+		return
+	}
+	state, ok := value.(*auditState)
+	if !ok || state.authorized {
+		return
+	}
+	state.authorized = true
+	state.log.Before = admin.auditSnapshot(state.log.TargetType, state.log.TargetName)
+}
+
+//targetLoginUser 审计目标为当前登录的管理员，未登录时名称为空
+func (admin *Admin) targetLoginUser(c *gin.Context) (string, string) {
+	user, err := admin.sessionUser(c)
+	if err != nil {
+		return auditTargetUser, ""
+	}
+	return auditTargetUser, user.UserName
+}
+
+//auditSnapshot 仅读取目标当前的DB配置，失败时仅记录日志
+func (admin *Admin) auditSnapshot(targetType, name string) string {
+	switch targetType {
+	case auditTargetUser:
+		return admin.auditUserSnapshot(name)
+	case auditTargetAlert:
+		return ""
+	}
+	content, err := service.LoadDBTarget(config.DB, targetType, name)
+	if err != nil {
+		config.SysLog.Error("[audit snapshot failed] type:%s name:%s err:%v", targetType, name, err)
+		return ""
+	}
+	return content
+}
+
+//auditUserSnapshot 读取管理员用户信息，不含密码
+func (admin *Admin) auditUserSnapshot(userName string) string {
+	user, err := (&entity.GatewayAdminUser{}).FindByName(config.DB, userName)
+	if err != nil {
+		config.SysLog.Error("[audit snapshot failed] type:%s name:%s err:%v", auditTargetUser, userName, err)
+		return ""
+	}
+	if user.ID == 0 {
+		return ""
+	}
+	bts, err := json.MarshalIndent(user, "", "\t")
+	if err != nil {
+		return ""
+	}
+	return string(bts)
+}
+
+//saveAuditLog 保存审计日志，写入失败不影响操作结果
+func (admin *Admin) saveAuditLog(auditLog *entity.GatewayAuditLog) {
+	if err := auditLog.Save(config.DB); err != nil {
+		config.SysLog.Error("[audit save failed] actor:%s action:%s target:%s err:%v", auditLog.Actor, auditLog.Action, auditLog.TargetName, err)
+	}
+}
+
+//auditMessage 从错误输出中提取失败原因
+func auditMessage(c *gin.Context) string {
+	response, ok := c.Get("response")
+	if !ok {
+		return ""
+	}
+	str, _ := response.(string)
+	resp := &util.Response{}
+	if err := json.Unmarshal([]byte(str), resp); err != nil {
+		return str
+	}
+	return resp.ErrorMsg
+}
+
+//AuditList 审计日志列表action，format=json时输出json
+func (admin *Admin) AuditList(c *gin.Context) {
+	filter, err := auditFilter(c)
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	page, _ := strconv.Atoi(c.Query("page"))
+	if page < 1 {
+		page = 1
+	}
+	list, err := (&entity.GatewayAuditLog{}).GetList(config.DB, filter, (page-1)*auditPageSize, auditPageSize)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("GetList:"+err.Error()))
+		return
+	}
+	listInfo := &AuditListInfo{
+		Actor:      filter.Actor,
+		Action:     filter.Action,
+		TargetType: filter.TargetType,
+		TargetName: filter.TargetName,
+		Result:     filter.Result,
+		StartTime:  c.Query("start_time"),
+		EndTime:    c.Query("end_time"),
+		Query:      auditExportQuery(c),
+		List:       list,
+		Page:       page,
+		PrevPage:   page - 1,
+		NextPage:   page + 1,
+		HasMore:    len(list) == auditPageSize,
+	}
+	if c.Query("format") == "json" {
+		util.ResponseSuccess(c, listInfo)
+		return
+	}
+	t, err := admin.getTemplateByURL("/admin/audit")
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	if err := admin.executeTemplate(t, c, listInfo, "/admin/audit"); err != nil {
+		util.ResponseError(c, 500, err)
+	}
+}
+
+//AuditExport 审计日志导出action，format支持csv、json
+func (admin *Admin) AuditExport(c *gin.Context) {
+	filter, err := auditFilter(c)
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	list, err := (&entity.GatewayAuditLog{}).GetList(config.DB, filter, 0, auditExportLimit)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("GetList:"+err.Error()))
+		return
+	}
+	fileName := "audit_" + time.Now().Format("20060102150405")
+	switch c.DefaultQuery("format", "csv") {
+	case "json":
+		c.Header("Content-Disposition", "attachment; filename="+fileName+".json")
+		c.JSON(200, list)
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", "attachment; filename="+fileName+".csv")
+		c.Status(200)
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "created_at", "actor", "source_ip", "action", "target_type", "target_name", "result", "message", "before", "after"})
+		for _, item := range list {
+			w.Write([]string{
+				strconv.FormatInt(item.ID, 10),
+				item.CreatedAt.Format(auditTimeFormat),
+				item.Actor,
+				item.SourceIP,
+				item.Action,
+				item.TargetType,
+				item.TargetName,
+				item.Result,
+				item.Message,
+				item.Before,
+				item.After,
+			})
+		}
+		w.Flush()
+	default:
+		util.ResponseError(c, 500, errors.New("不支持的导出格式:"+c.Query("format")))
+	}
+}
+
+//auditExportQuery 当前筛选条件对应的分页及导出参数
+func auditExportQuery(c *gin.Context) template.URL {
+	query := c.Request.URL.Query()
+	query.Del("page")
+	query.Del("format")
+	return template.URL(query.Encode())
+}
+
+//auditFilter 解析审计查询条件，时间格式为 2006-01-02 15:04:05 或 2006-01-02
+func auditFilter(c *gin.Context) (*entity.GatewayAuditLogFilter, error) {
+	filter := &entity.GatewayAuditLogFilter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetName: c.Query("target_name"),
+		Result:     c.Query("result"),
+	}
+	var err error
+	if filter.StartTime, err = parseAuditTime(c.Query("start_time"), false); err != nil {
+		return nil, errors.New("start_time 格式错误:" + err.Error())
+	}
+	if filter.EndTime, err = parseAuditTime(c.Query("end_time"), true); err != nil {
+		return nil, errors.New("end_time 格式错误:" + err.Error())
+	}
+	return filter, nil
+}
+
+//parseAuditTime 解析查询时间，仅有日期的结束时间包含当天
+func parseAuditTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(auditTimeFormat, value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
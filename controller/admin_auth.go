@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"gatekeeper/config"
+	"gatekeeper/constant"
+	"gatekeeper/core/service"
+	"gatekeeper/model/entity"
+	"gatekeeper/util"
+)
+
+// 管理员角色，权限依次递增
+const (
+	RoleViewer   = "viewer"   //只读
+	RoleOperator = "operator" //可修改服务、开关流量
+	RoleAdmin    = "admin"    //全部权限，含租户、回滚、审计及用户管理
+)
+
+//roleLevels 角色权限等级
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+//csrfHeader ajax请求携带csrf token的header
+const csrfHeader = "X-CSRF-Token"
+
+var errLoginFailed = errors.New("用户名或密码错误")
+
+//Auth 登录及权限验证中间件，role为访问所需的最低角色
+//target不为nil且指向模块时，限定了模块的用户只能访问授权的模块
+func (admin *Admin) Auth(role string, target routeTarget) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := admin.sessionUser(c)
+		if err != nil {
+			c.Redirect(302, "/admin/login")
+			c.Abort()
+			return
+		}
+		c.Set(constant.MiddlewareAdminUserKey, user)
+		if roleLevels[user.Role] < roleLevels[role] {
+			util.ResponseError(c, 403, errors.New("权限不足，需要"+role+"角色"))
+			return
+		}
+		if target != nil {
+			targetType, name := target(c)
+			if targetType == service.ConfigTypeModule && !canAccessModule(user, name) {
+				util.ResponseError(c, 403, errors.New("无权操作模块:"+name))
+				return
+			}
+		}
+		admin.auditAuthorized(c)
+		c.Next()
+	}
+}
+
+//sessionUser 校验登录态token并返回对应用户
+func (admin *Admin) sessionUser(c *gin.Context) (*entity.GatewayAdminUser, error) {
+	token, err := c.Cookie(constant.AdminCookiePrefix + "token")
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid token")
+	}
+	nameBts, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	expire, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || expire < time.Now().Unix() {
+		return nil, errors.New("token expired")
+	}
+	user, fallback, err := findUser(string(nameBts))
+	if err != nil {
+		return nil, err
+	}
+	if !fallback && user.ID == 0 {
+		return nil, errors.New("user not found")
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sessionSign(user, expire))) {
+		return nil, errors.New("invalid token")
+	}
+	return user, nil
+}
+
+//setSession 下发登录态cookie
+func (admin *Admin) setSession(c *gin.Context, user *entity.GatewayAdminUser) {
+	expire := time.Now().Add(time.Second * constant.AdminExpired)
+	http.SetCookie(c.Writer, admin.sessionCookie(sessionToken(user, expire.Unix()), expire))
+}
+
+//sessionCookie 登录态cookie，Secure及SameSite由admin.json配置
+func (admin *Admin) sessionCookie(value string, expire time.Time) *http.Cookie {
+	sameSite := http.SameSiteLaxMode
+	switch strings.ToLower(config.AuthConf.CookieSameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+	return &http.Cookie{
+		Name:     constant.AdminCookiePrefix + "token",
+		Value:    value,
+		Expires:  expire,
+		Path:     "/",
+		Domain:   "",
+		Secure:   config.AuthConf.CookieSecure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+}
+
+//CSRF 校验POST等修改类请求的csrf token，token由登录态派生，随登录态失效
+//token可通过X-CSRF-Token header或csrf_token表单参数传递
+func (admin *Admin) CSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		token := c.GetHeader(csrfHeader)
+		if token == "" {
+			token = c.PostForm("csrf_token")
+		}
+		expected := admin.csrfToken(c)
+		if expected == "" || !hmac.Equal([]byte(token), []byte(expected)) {
+			config.SysLog.Warn("[admin csrf failed] uri:%s ip:%s", c.Request.RequestURI, c.ClientIP())
+			util.ResponseError(c, 403, errors.New("csrf token 校验失败，请刷新页面后重试"))
+			return
+		}
+		c.Next()
+	}
+}
+
+//csrfToken 当前登录态对应的csrf token，未登录时为空
+func (admin *Admin) csrfToken(c *gin.Context) string {
+	session, err := c.Cookie(constant.AdminCookiePrefix + "token")
+	if err != nil || session == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(config.AuthConf.SessionSecret))
+	mac.Write([]byte("csrf\n" + session))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//checkConfirm 危险操作需在confirm参数中输入目标名称进行确认
+func checkConfirm(c *gin.Context, name string) error {
+	if c.PostForm("confirm") != name {
+		return errors.New("请输入 " + name + " 确认操作")
+	}
+	return nil
+}
+
+//sessionToken 生成登录态token：用户名.过期时间.签名
+func sessionToken(user *entity.GatewayAdminUser, expire int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(user.UserName)) + "." +
+		strconv.FormatInt(expire, 10) + "." + sessionSign(user, expire)
+}
+
+//sessionSign 签名包含密码hash，修改密码后旧登录态失效
+func sessionSign(user *entity.GatewayAdminUser, expire int64) string {
+	mac := hmac.New(sha256.New, []byte(config.AuthConf.SessionSecret))
+	mac.Write([]byte(user.UserName + "\n" + strconv.FormatInt(expire, 10) + "\n" + user.Password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//checkLogin 校验用户名密码
+//用户表为空时使用admin.json中的账号初始化首个管理员
+func (admin *Admin) checkLogin(userName, password string) (*entity.GatewayAdminUser, error) {
+	if userName == "" || password == "" {
+		return nil, errLoginFailed
+	}
+	user, fallback, err := findUser(userName)
+	if err != nil {
+		return nil, err
+	}
+	if fallback {
+		if subtle.ConstantTimeCompare([]byte(password), []byte(config.AuthConf.AdminPassport)) != 1 {
+			return nil, errLoginFailed
+		}
+		return user, nil
+	}
+	if user.ID == 0 {
+		return admin.initAdminUser(userName, password)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, errLoginFailed
+	}
+	return user, nil
+}
+
+//findUser 按用户名查找用户
+//用户表无法读取时(如未建表)，降级为admin.json中配置的管理员，fallback为true
+func findUser(userName string) (*entity.GatewayAdminUser, bool, error) {
+	if config.DBEnabled() {
+		user, err := (&entity.GatewayAdminUser{}).FindByName(config.DB, userName)
+		if err == nil {
+			return user, false, nil
+		}
+		config.SysLog.Warn("[admin user table unavailable] user:%s err:%v, fallback to admin.json", userName, err)
+	}
+	if config.AuthConf.AdminName == "" || config.AuthConf.AdminPassport == "" ||
+		subtle.ConstantTimeCompare([]byte(userName), []byte(config.AuthConf.AdminName)) != 1 {
+		return nil, true, errLoginFailed
+	}
+	//签名使用admin.json中的密码，修改密码后旧登录态失效
+	user := &entity.GatewayAdminUser{
+		UserName: config.AuthConf.AdminName,
+		Password: config.AuthConf.AdminPassport,
+		Role:     RoleAdmin,
+	}
+	return user, true, nil
+}
+
+//initAdminUser 初始化首个管理员
+func (admin *Admin) initAdminUser(userName, password string) (*entity.GatewayAdminUser, error) {
+	count, err := (&entity.GatewayAdminUser{}).Count(config.DB)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 || config.AuthConf.AdminName == "" || config.AuthConf.AdminPassport == "" {
+		return nil, errLoginFailed
+	}
+	if subtle.ConstantTimeCompare([]byte(userName), []byte(config.AuthConf.AdminName)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(config.AuthConf.AdminPassport)) != 1 {
+		return nil, errLoginFailed
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &entity.GatewayAdminUser{UserName: userName, Password: hash, Role: RoleAdmin}
+	if err := user.Save(config.DB); err != nil {
+		return nil, err
+	}
+	config.SysLog.Warn("[init admin user] %s created from admin.json, please change the password", userName)
+	return user, nil
+}
+
+//hashPassword bcrypt加密密码
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+//canAccessModule 用户是否可访问指定模块，管理员及未限定模块的用户可访问全部模块
+func canAccessModule(user *entity.GatewayAdminUser, moduleName string) bool {
+	if user.Role == RoleAdmin || strings.TrimSpace(user.Modules) == "" {
+		return true
+	}
+	return util.InStringList(moduleName, splitModules(user.Modules))
+}
+
+//splitModules 拆分用户授权的模块列表
+func splitModules(modules string) []string {
+	list := []string{}
+	for _, item := range strings.Split(modules, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+//currentUser 当前登录用户，未经过Auth中间件时返回nil
+func (admin *Admin) currentUser(c *gin.Context) *entity.GatewayAdminUser {
+	if value, ok := c.Get(constant.MiddlewareAdminUserKey); ok {
+		if user, ok := value.(*entity.GatewayAdminUser); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+//loginUser 当前登录用户名
+func (admin *Admin) loginUser(c *gin.Context) string {
+	if user := admin.currentUser(c); user != nil {
+		return user.UserName
+	}
+	return ""
+}
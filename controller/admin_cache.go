@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"gatekeeper/core/service"
+	"gatekeeper/model/entity"
+	"gatekeeper/util"
+)
+
+//parseCacheForm 解析响应缓存表单，未开启且未填写任何配置时返回nil
+func parseCacheForm(c *gin.Context) (*entity.GatewayCache, error) {
+	cache := &entity.GatewayCache{
+		Backend:  strings.TrimSpace(c.PostForm("cache.backend")),
+		TTLRules: strings.TrimSpace(strings.Replace(c.PostForm("cache.ttl_rules"), "\r\n", "\n", -1)),
+		KeyParts: strings.TrimSpace(c.PostForm("cache.key_parts")),
+	}
+	if c.PostForm("cache.open") == "1" {
+		cache.Open = 1
+	}
+	numbers := []struct {
+		field string
+		name  string
+		value *int64
+	}{
+		{"cache.default_ttl", "默认缓存时间", &cache.DefaultTTL},
+		{"cache.stale_while_revalidate", "过期后台刷新时间", &cache.StaleWhileRevalidate},
+		{"cache.stale_if_error", "异常返回旧数据时间", &cache.StaleIfError},
+		{"cache.max_body_size", "最大缓存响应", &cache.MaxBodySize},
+	}
+	for _, number := range numbers {
+		value := strings.TrimSpace(c.PostForm(number.field))
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New(number.name + " 格式化错误:" + err.Error())
+		}
+		*number.value = n
+	}
+	//存储类型下拉框总有值，不作为是否填写的依据
+	unset := *cache
+	unset.Backend = ""
+	if unset == (entity.GatewayCache{}) {
+		return nil, nil
+	}
+	return cache, nil
+}
+
+//CachePurge 清除模块缓存action，path为空时清除整个模块
+func (admin *Admin) CachePurge(c *gin.Context) {
+	moduleName := c.PostForm("module_name")
+	path := strings.TrimSpace(c.PostForm("path"))
+	if service.SysConfMgr.GetModuleConfigByName(moduleName) == nil {
+		util.ResponseError(c, 500, errors.New("module_name not found"))
+		return
+	}
+	if path != "" && !strings.HasPrefix(path, "/") {
+		util.ResponseError(c, 500, errors.New("清除路径必须以/开头"))
+		return
+	}
+	if err := service.ClusterPurgeCache(moduleName, path); err != nil {
+		util.ResponseError(c, 500, errors.New("ClusterPurgeCache:"+err.Error()))
+		return
+	}
+	util.ResponseSuccess(c, fmt.Sprintf("module %s cache purged", moduleName))
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/core/service"
+	"gatekeeper/util"
+)
+
+//importConfirmName 导入配置时需输入的确认名称
+const importConfirmName = "import"
+
+//ConfigIO 配置导入导出页面action
+func (admin *Admin) ConfigIO(c *gin.Context) {
+	t, err := admin.getTemplateByURL("/admin/config_io")
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	if err := admin.executeTemplate(t, c, importConfirmName, "/admin/config_io"); err != nil {
+		util.ResponseError(c, 500, err)
+	}
+}
+
+//ExportConfig 导出全量配置action，format支持yaml、toml、json
+func (admin *Admin) ExportConfig(c *gin.Context) {
+	format := c.DefaultQuery("format", service.ConfigFormatYAML)
+	snapshot, err := service.LoadDBConfig(config.DB)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("LoadDBConfig:"+err.Error()))
+		return
+	}
+	data, err := service.ExportConfig(snapshot, format)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("ExportConfig:"+err.Error()))
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=gatekeeper."+format)
+	c.Data(200, "text/plain; charset=utf-8", data)
+}
+
+//ImportPlan 预览导入配置的变更计划action
+func (admin *Admin) ImportPlan(c *gin.Context) {
+	target, err := admin.importTarget(c)
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	current, err := service.LoadDBConfig(config.DB)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("LoadDBConfig:"+err.Error()))
+		return
+	}
+	util.ResponseSuccess(c, admin.importPlanItems(service.PlanImport(current, target)))
+}
+
+//ImportConfig 导入配置action，在事务中执行变更计划
+func (admin *Admin) ImportConfig(c *gin.Context) {
+	if err := checkConfirm(c, importConfirmName); err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	target, err := admin.importTarget(c)
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+
+	tx := config.DB.Begin()
+	current, err := service.LoadDBConfig(tx)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("LoadDBConfig:"+err.Error()))
+		return
+	}
+	changes := service.PlanImport(current, target)
+	if err := service.ApplyConfig(tx, changes, admin.loginUser(c), service.ConfigActionImport); err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("导入失败:"+err.Error()))
+		return
+	}
+	tx.Commit()
+	if len(changes) > 0 {
+		if err := admin.ClusterReloadModule(); err != nil {
+			util.ResponseError(c, 500, errors.New("ClusterReloadModule:"+err.Error()))
+			return
+		}
+	}
+	util.ResponseSuccess(c, admin.importPlanItems(changes))
+}
+
+//importTarget 解析表单中的声明式配置
+func (admin *Admin) importTarget(c *gin.Context) (*service.ConfigSnapshot, error) {
+	content := c.PostForm("content")
+	if content == "" {
+		return nil, errors.New("配置内容不能为空")
+	}
+	target, err := service.ImportConfig([]byte(content), c.DefaultPostForm("format", service.ConfigFormatYAML))
+	if err != nil {
+		return nil, errors.New("配置解析失败:" + err.Error())
+	}
+	return target, nil
+}
+
+//importPlanItems 变更计划转为展示结构，更新项附带差异行
+func (admin *Admin) importPlanItems(changes []*service.ConfigChange) []*ImportPlanItem {
+	items := []*ImportPlanItem{}
+	for _, change := range changes {
+		item := &ImportPlanItem{Type: change.Type, Name: change.Name, Action: change.Action}
+		if change.Action == service.ConfigActionUpdate {
+			for _, line := range util.DiffLines(service.ChangeContent(change.Before), service.ChangeContent(change.After)) {
+				if line.Type != " " {
+					item.Lines = append(item.Lines, line)
+				}
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"gatekeeper/core/resource"
+	"gatekeeper/model/entity"
+)
+
+//eventHeartbeat 事件流心跳间隔，避免代理断开空闲连接
+const eventHeartbeat = 15 * time.Second
+
+//eventRetry 断开后浏览器重连的间隔，单位ms
+const eventRetry = 3000
+
+//Events 本节点实时事件流action，输出Server-Sent Events
+//module不为空时只推送该模块的事件，限定了模块的用户只能收到授权模块及全局事件
+//断线重连时按Last-Event-ID补发，last_event_id=0时先补发最近的全部事件
+func (admin *Admin) Events(c *gin.Context) {
+	lastID := int64(-1)
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		lastID, _ = strconv.ParseInt(id, 10, 64)
+	} else if id := c.Query("last_event_id"); id != "" {
+		lastID, _ = strconv.ParseInt(id, 10, 64)
+	}
+	user := admin.currentUser(c)
+	moduleName := c.Query("module")
+	sub, replay := resource.Events.Subscribe(lastID)
+	defer resource.Events.Unsubscribe(sub)
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(200)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", eventRetry)
+	for _, event := range replay {
+		writeEvent(c, user, moduleName, event)
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(eventHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-sub.C:
+			//订阅因消费过慢被断开，由浏览器重连补发
+			if !ok {
+				return
+			}
+			writeEvent(c, user, moduleName, event)
+			c.Writer.Flush()
+		case <-ticker.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+//writeEvent 输出一条用户可见的事件
+func writeEvent(c *gin.Context, user *entity.GatewayAdminUser, moduleName string, event *resource.Event) {
+	if moduleName != "" && event.Module != moduleName {
+		return
+	}
+	if event.Module != "" && !canAccessModule(user, event.Module) {
+		return
+	}
+	bts, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(c.Writer, "id: %d\ndata: %s\n\n", event.ID, bts)
+}
@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/core/service"
+	"gatekeeper/model/entity"
+	"gatekeeper/model/running"
+	"gatekeeper/util"
+)
+
+//historyPageSize 历史列表每页条数
+const historyPageSize = 50
+
+//History 配置历史列表action
+func (admin *Admin) History(c *gin.Context) {
+	historyType := c.Query("type")
+	name := c.Query("name")
+	page, _ := strconv.Atoi(c.Query("page"))
+	if page < 1 {
+		page = 1
+	}
+	list, err := (&entity.GatewayConfigHistory{}).GetList(config.DB, historyType, name, (page-1)*historyPageSize, historyPageSize)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("GetList:"+err.Error()))
+		return
+	}
+	t, err := admin.getTemplateByURL("/admin/history")
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	listInfo := &HistoryListInfo{
+		Type:     historyType,
+		Name:     name,
+		List:     list,
+		Page:     page,
+		PrevPage: page - 1,
+		NextPage: page + 1,
+		HasMore:  len(list) == historyPageSize,
+	}
+	if err := admin.executeTemplate(t, c, listInfo, "/admin/history"); err != nil {
+		util.ResponseError(c, 500, err)
+	}
+}
+
+//HistoryDiff 版本对比action
+//base_id为空时与同一配置项的上一版本对比，scope=config时对比全量配置
+func (admin *Admin) HistoryDiff(c *gin.Context) {
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	history, err := (&entity.GatewayConfigHistory{}).FindByID(config.DB, id)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("FindByID:"+err.Error()))
+		return
+	}
+	if history.ID == 0 {
+		util.ResponseError(c, 500, errors.New("版本不存在"))
+		return
+	}
+	base := &entity.GatewayConfigHistory{}
+	if baseID, _ := strconv.ParseInt(c.Query("base_id"), 10, 64); baseID > 0 {
+		base, err = base.FindByID(config.DB, baseID)
+	} else {
+		base, err = history.FindPrevious(config.DB)
+	}
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("FindBase:"+err.Error()))
+		return
+	}
+
+	diffInfo := &HistoryDiffInfo{History: history, Base: base, Scope: c.Query("scope")}
+	if diffInfo.Scope == "config" {
+		baseSnapshot, err := base.FullSnapshot(config.DB)
+		if err != nil {
+			util.ResponseError(c, 500, errors.New("FullSnapshot:"+err.Error()))
+			return
+		}
+		historySnapshot, err := history.FullSnapshot(config.DB)
+		if err != nil {
+			util.ResponseError(c, 500, errors.New("FullSnapshot:"+err.Error()))
+			return
+		}
+		diffInfo.Lines = util.DiffLines(baseSnapshot, historySnapshot)
+	} else {
+		diffInfo.Lines = util.DiffLines(base.Content, history.Content)
+	}
+	t, err := admin.getTemplateByURL("/admin/history_diff")
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	if err := admin.executeTemplate(t, c, diffInfo, "/admin/history"); err != nil {
+		util.ResponseError(c, 500, err)
+	}
+}
+
+//Rollback 回滚action
+//scope=config时回滚全量配置，否则仅回滚该版本对应的模块或租户
+func (admin *Admin) Rollback(c *gin.Context) {
+	id, _ := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	if err := checkConfirm(c, strconv.FormatInt(id, 10)); err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	history, err := (&entity.GatewayConfigHistory{}).FindByID(config.DB, id)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("FindByID:"+err.Error()))
+		return
+	}
+	if history.ID == 0 {
+		util.ResponseError(c, 500, errors.New("版本不存在"))
+		return
+	}
+
+	tx := config.DB.Begin()
+	current, err := service.LoadDBConfig(tx)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("LoadDBConfig:"+err.Error()))
+		return
+	}
+	target, err := admin.rollbackTarget(tx, history, current, c.PostForm("scope") == "config")
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, err)
+		return
+	}
+	changes := service.PlanConfig(current, target)
+	if err := service.ApplyConfig(tx, changes, admin.loginUser(c), service.ConfigActionRollback); err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("回滚失败:"+err.Error()))
+		return
+	}
+	tx.Commit()
+	if err := admin.ClusterReloadModule(); err != nil {
+		util.ResponseError(c, 500, errors.New("ClusterReloadModule:"+err.Error()))
+		return
+	}
+	util.ResponseSuccess(c, changes)
+}
+
+//rollbackTarget 根据历史版本构造回滚后的目标配置
+func (admin *Admin) rollbackTarget(tx *gorm.DB, history *entity.GatewayConfigHistory, current *service.ConfigSnapshot, all bool) (*service.ConfigSnapshot, error) {
+	if all {
+		snapshot, err := history.FullSnapshot(tx)
+		if err != nil {
+			return nil, errors.New("FullSnapshot:" + err.Error())
+		}
+		target := &service.ConfigSnapshot{}
+		if err := json.Unmarshal([]byte(snapshot), target); err != nil {
+			return nil, errors.New("版本快照解析失败:" + err.Error())
+		}
+		return target, nil
+	}
+
+	target := &service.ConfigSnapshot{
+		Module: map[string]*running.GatewayModule{},
+		Apps:   map[string]*entity.GatewayAPP{},
+	}
+	for name, module := range current.Module {
+		target.Module[name] = module
+	}
+	for name, app := range current.Apps {
+		target.Apps[name] = app
+	}
+	switch history.Type {
+	case service.ConfigTypeModule:
+		delete(target.Module, history.Name)
+		if history.Content != "" {
+			module := &running.GatewayModule{}
+			if err := json.Unmarshal([]byte(history.Content), module); err != nil {
+				return nil, errors.New("版本内容解析失败:" + err.Error())
+			}
+			target.Module[history.Name] = module
+		}
+	case service.ConfigTypeApp:
+		for name, app := range target.Apps {
+			if app.AppID == history.Name {
+				delete(target.Apps, name)
+			}
+		}
+		if history.Content != "" {
+			app := &entity.GatewayAPP{}
+			if err := json.Unmarshal([]byte(history.Content), app); err != nil {
+				return nil, errors.New("版本内容解析失败:" + err.Error())
+			}
+			target.Apps[app.Name] = app
+		}
+	default:
+		return nil, errors.New("未知的配置类型:" + history.Type)
+	}
+	return target, nil
+}
+
+//recordHistory 在事务中记录配置变更，失败时回滚事务并输出错误
+func (admin *Admin) recordHistory(c *gin.Context, tx *gorm.DB, configType, name, action string) bool {
+	if err := service.RecordHistory(tx, configType, name, action, admin.loginUser(c)); err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("RecordHistory:"+err.Error()))
+		return false
+	}
+	return true
+}
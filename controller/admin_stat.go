@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/core/resource"
+	"gatekeeper/core/service"
+	"gatekeeper/util"
+)
+
+//statRangeDefault 未指定开始时间时查询最近24小时
+const statRangeDefault = 24 * time.Hour
+
+//statTimeFormats 统计查询支持的时间格式，按time_location解析
+var statTimeFormats = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+//StatRangeResult 流量统计查询结果
+type StatRangeResult struct {
+	Type       string                      `json:"type"`
+	Name       string                      `json:"name"`
+	Resolution string                      `json:"resolution"`
+	From       int64                       `json:"from"`
+	To         int64                       `json:"to"`
+	Total      int64                       `json:"total"`
+	Points     []*resource.TimeSeriesPoint `json:"points"`
+}
+
+//StatChartInfo 流量趋势页面结构体
+type StatChartInfo struct {
+	Type string
+	Name string
+}
+
+//targetStat 统计查询的目标，type为module时按模块授权
+func targetStat(c *gin.Context) (string, string) {
+	return c.Query("type"), c.Query("name")
+}
+
+//parseStatTime 解析统计查询时间，支持unix时间戳及statTimeFormats，为空时返回def
+func parseStatTime(value string, def time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return def, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	for _, format := range statTimeFormats {
+		if t, err := time.ParseInLocation(format, value, config.TimeLocation); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("时间格式错误:" + value)
+}
+
+//autoResolution 按查询范围选择粒度：6小时内按分钟，7天内按小时，否则按天
+func autoResolution(from, to time.Time) string {
+	switch span := to.Sub(from); {
+	case span <= 6*time.Hour:
+		return resource.ResolutionMinute
+	case span <= 7*24*time.Hour:
+		return resource.ResolutionHour
+	}
+	return resource.ResolutionDay
+}
+
+//StatRange 流量统计查询接口
+//type为module或app，resolution为minute、hour、day，为空或auto时按范围自动选择
+//from、to为unix时间戳或"2006-01-02 15:04"格式，默认查询最近24小时
+func (admin *Admin) StatRange(c *gin.Context) {
+	statType := c.Query("type")
+	name := c.Query("name")
+	var series string
+	switch statType {
+	case service.ConfigTypeModule:
+		series = resource.ModuleSeries(name)
+	case service.ConfigTypeApp:
+		series = resource.APPSeries(name)
+	default:
+		util.ResponseError(c, 500, errors.New("type必须为module或app"))
+		return
+	}
+	if name == "" {
+		util.ResponseError(c, 500, errors.New("name不能为空"))
+		return
+	}
+	now := time.Now()
+	to, err := parseStatTime(c.Query("to"), now)
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	from, err := parseStatTime(c.Query("from"), to.Add(-statRangeDefault))
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	resolution := c.DefaultQuery("resolution", "auto")
+	if resolution == "auto" {
+		resolution = autoResolution(from, to)
+	}
+	points, err := resource.TimeSeries.Range(series, resolution, from, to)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("TimeSeries.Range:"+err.Error()))
+		return
+	}
+	result := &StatRangeResult{
+		Type:       statType,
+		Name:       name,
+		Resolution: resolution,
+		From:       from.Unix(),
+		To:         to.Unix(),
+		Points:     points,
+	}
+	for _, point := range points {
+		result.Total += point.Value
+	}
+	util.ResponseSuccess(c, result)
+}
+
+//StatChart 流量趋势页面
+func (admin *Admin) StatChart(c *gin.Context) {
+	info := &StatChartInfo{Type: c.Query("type"), Name: c.Query("name")}
+	if info.Type != service.ConfigTypeModule && info.Type != service.ConfigTypeApp {
+		util.ResponseError(c, 500, errors.New("type必须为module或app"))
+		return
+	}
+	t, err := admin.getTemplateByURL("/admin/stat_chart")
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	activeURL := "/admin/service_list"
+	if info.Type == service.ConfigTypeApp {
+		activeURL = "/admin/app_list"
+	}
+	if err := admin.executeTemplate(t, c, info, activeURL); err != nil {
+		util.ResponseError(c, 500, err)
+	}
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/core/discovery"
+	"gatekeeper/core/resource"
+	"gatekeeper/core/service"
+	"gatekeeper/model/entity"
+	"gatekeeper/model/running"
+)
+
+//upstreamGroupFields 上游分组表单字段，每个分组一行，字段以空格分隔，如：
+//name=canary percent=10 ip_list=127.0.0.1:8702 weight_list=100 force_rule=header:X-Canary=1;cookie:canary=1
+var upstreamGroupFields = []string{"name", "percent", "ip_list", "weight_list", "discovery_type", "discovery_target", "force_rule"}
+
+//parseUpstreamGroups 解析上游分组表单
+func parseUpstreamGroups(text string) ([]*entity.GatewayUpstreamGroup, error) {
+	groups := []*entity.GatewayUpstreamGroup{}
+	for _, line := range strings.Split(strings.Replace(text, "\r", "\n", -1), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		group := &entity.GatewayUpstreamGroup{}
+		for _, field := range strings.Fields(line) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.New("字段需为key=value格式:" + field)
+			}
+			switch kv[0] {
+			case "name":
+				group.Name = kv[1]
+			case "percent":
+				percent, err := strconv.ParseInt(kv[1], 10, 64)
+				if err != nil {
+					return nil, errors.New("percent必须为数字:" + kv[1])
+				}
+				group.Percent = percent
+			case "ip_list":
+				group.IPList = kv[1]
+			case "weight_list":
+				group.WeightList = kv[1]
+			case "discovery_type":
+				group.DiscoveryType = kv[1]
+			case "discovery_target":
+				group.DiscoveryTarget = kv[1]
+			case "force_rule":
+				group.ForceRule = kv[1]
+			default:
+				return nil, errors.New("未知字段:" + kv[0])
+			}
+		}
+		if group.Name == "" {
+			return nil, errors.New("name必须填写:" + line)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+//formatUpstreamGroups 上游分组转为表单内容，省略空字段
+func formatUpstreamGroups(groups []*entity.GatewayUpstreamGroup) string {
+	lines := []string{}
+	for _, group := range groups {
+		values := []string{group.Name, strconv.FormatInt(group.Percent, 10), group.IPList, group.WeightList,
+			group.DiscoveryType, group.DiscoveryTarget, group.ForceRule}
+		fields := []string{}
+		for i, value := range values {
+			if value != "" {
+				fields = append(fields, upstreamGroupFields[i]+"="+value)
+			}
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	return strings.Join(lines, "\r")
+}
+
+//upstreamGroupStats 模块各上游分组的节点及流量统计，首项为默认分组，配置镜像时末项为镜像统计
+func (admin *Admin) upstreamGroupStats(module *running.GatewayModule) []*UpstreamGroupStat {
+	if len(module.UpstreamGroups) == 0 {
+		return nil
+	}
+	var percent int64 = 100
+	for _, group := range module.UpstreamGroups {
+		percent -= group.Percent
+	}
+	defaultGroup := &entity.GatewayUpstreamGroup{
+		Name:          service.DefaultUpstreamGroup,
+		Percent:       percent,
+		DiscoveryType: module.LoadBalance.DiscoveryType,
+		IPList:        module.LoadBalance.IPList,
+	}
+	stats := []*UpstreamGroupStat{}
+	for _, group := range append([]*entity.GatewayUpstreamGroup{defaultGroup}, module.UpstreamGroups...) {
+		key := service.UpstreamGroupKey(module.Base.Name, group.Name)
+		stat := &UpstreamGroupStat{
+			Group:         group,
+			AvailableList: service.SysConfMgr.GetAvailableIPList(key),
+		}
+		if discovery.IsStatic(group.DiscoveryType) {
+			stat.NodeList = strings.Split(group.IPList, ",")
+		} else {
+			stat.NodeList = discovery.Addrs(service.SysConfMgr.GetModuleNodes(key))
+		}
+		counter := resource.FlowCounters.GetGroupCounter(module.Base.Name, group.Name)
+		stat.QPS = counter.QPS
+		stat.DayRequest = counter.TotalCount
+		groupStat := resource.GroupStats.GetGroupStat(module.Base.Name + ":" + group.Name)
+		stat.Requests = groupStat.Requests
+		stat.Errors = groupStat.Errors
+		stat.AvgLatency = groupStat.AvgLatency()
+		stats = append(stats, stat)
+	}
+	if mirrorGroup := module.LoadBalance.MirrorGroup; mirrorGroup != "" {
+		for _, stat := range stats {
+			if stat.Group.Name != mirrorGroup {
+				continue
+			}
+			mirrorStat := resource.GroupStats.GetGroupStat(service.MirrorStatKey(module.Base.Name, mirrorGroup))
+			stats = append(stats, &UpstreamGroupStat{
+				Group:         &entity.GatewayUpstreamGroup{Name: mirrorGroup, Percent: module.LoadBalance.MirrorPercent},
+				Mirror:        true,
+				NodeList:      stat.NodeList,
+				AvailableList: stat.AvailableList,
+				Requests:      mirrorStat.Requests,
+				Errors:        mirrorStat.Errors,
+				AvgLatency:    mirrorStat.AvgLatency(),
+				Dropped:       mirrorStat.Dropped,
+			})
+			break
+		}
+	}
+	return stats
+}
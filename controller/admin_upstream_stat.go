@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"gatekeeper/core/resource"
+	"gatekeeper/core/service"
+	"gatekeeper/util"
+)
+
+//upstreamStatWindows 节点统计可选窗口
+var upstreamStatWindows = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+}
+
+//defaultUpstreamStatWindow 默认统计最近1分钟
+const defaultUpstreamStatWindow = "1m"
+
+//upstreamStatWindow 请求参数中的统计窗口，不支持的窗口使用默认值
+func upstreamStatWindow(c *gin.Context) string {
+	window := c.DefaultQuery("window", defaultUpstreamStatWindow)
+	if _, ok := upstreamStatWindows[window]; !ok {
+		return defaultUpstreamStatWindow
+	}
+	return window
+}
+
+//UpstreamNodeStat 上游节点状态及统计
+type UpstreamNodeStat struct {
+	Addr              string                         `json:"addr"`
+	Weight            string                         `json:"weight"`
+	Healthy           bool                           `json:"healthy"`   //探活正常
+	Forbid            bool                           `json:"forbid"`    //流量已关闭
+	Drain             bool                           `json:"drain"`     //排空中
+	Available         bool                           `json:"available"` //接收新请求
+	ActiveRequests    int64                          `json:"active_requests"`
+	SlowStartProgress int                            `json:"slow_start_progress"`
+	Stat              *resource.UpstreamStatSnapshot `json:"stat"`
+}
+
+//UpstreamStatResult 上游节点统计接口返回值
+type UpstreamStatResult struct {
+	Module   string                `json:"module"`
+	Window   string                `json:"window"`
+	Bulkhead *service.BulkheadStat `json:"bulkhead"` //未限制模块并发时为null
+	Nodes    []*UpstreamNodeStat   `json:"nodes"`
+}
+
+//UpstreamStat 上游节点统计接口，window可选1m、5m、15m
+func (admin *Admin) UpstreamStat(c *gin.Context) {
+	moduleName := c.Query("module_name")
+	module := service.SysConfMgr.GetModuleConfigByName(moduleName)
+	if module == nil {
+		util.ResponseError(c, 500, errors.New("module_name not found"))
+		return
+	}
+	ipList, weightList := admin.moduleNodes(module)
+	detailInfo := &ServiceDetailInfo{
+		Module:          module,
+		StatWindow:      upstreamStatWindow(c),
+		ActiveIPList:    service.SysConfMgr.GetActiveIPList(moduleName),
+		ForbidIPList:    service.SysConfMgr.GetForbidIPList(moduleName),
+		DrainIPList:     service.SysConfMgr.GetDrainIPList(moduleName),
+		AvaliableIPList: service.SysConfMgr.GetAvailableIPList(moduleName),
+	}
+	nodes := []*UpstreamNodeStat{}
+	for i, ip := range ipList {
+		if ip == "" {
+			continue
+		}
+		node := &UpstreamNodeStat{
+			Addr:              ip,
+			Healthy:           detailInfo.IsActive(ip),
+			Forbid:            detailInfo.IsForbid(ip),
+			Drain:             detailInfo.IsDrain(ip),
+			Available:         util.InStringList(ip, detailInfo.AvaliableIPList),
+			ActiveRequests:    detailInfo.ActiveRequests(ip),
+			SlowStartProgress: detailInfo.SlowStartProgress(ip),
+			Stat:              detailInfo.NodeStat(ip),
+		}
+		if i < len(weightList) {
+			node.Weight = weightList[i]
+		}
+		nodes = append(nodes, node)
+	}
+	util.ResponseSuccess(c, &UpstreamStatResult{
+		Module:   moduleName,
+		Window:   detailInfo.StatWindow,
+		Bulkhead: service.SysConfMgr.GetBulkheadStat(moduleName),
+		Nodes:    nodes,
+	})
+}
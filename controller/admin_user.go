@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"gatekeeper/config"
+	"gatekeeper/model/entity"
+	"gatekeeper/util"
+)
+
+//minPasswordLen 密码最小长度
+const minPasswordLen = 8
+
+var userNameRegexp = regexp.MustCompile("^[0-9a-zA-Z_.@-]+$")
+
+//UserList 管理员用户列表action
+func (admin *Admin) UserList(c *gin.Context) {
+	users, err := (&entity.GatewayAdminUser{}).GetAll(config.DB)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("GetAll:"+err.Error()))
+		return
+	}
+	t, err := admin.getTemplateByURL("/admin/user_list")
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	if err := admin.executeTemplate(t, c, users, "/admin/user_list"); err != nil {
+		util.ResponseError(c, 500, err)
+	}
+}
+
+//AddUser 新增管理员用户action
+func (admin *Admin) AddUser(c *gin.Context) {
+	t, err := admin.getTemplateByURL("/admin/add_user")
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	userForm := &UserFormInfo{User: &entity.GatewayAdminUser{Role: RoleViewer}, Roles: []string{RoleViewer, RoleOperator, RoleAdmin}}
+	if err := admin.executeTemplate(t, c, userForm, "/admin/user_list"); err != nil {
+		util.ResponseError(c, 500, err)
+	}
+}
+
+//EditUser 修改管理员用户action
+func (admin *Admin) EditUser(c *gin.Context) {
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	user, err := (&entity.GatewayAdminUser{}).FindByID(config.DB, id)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("FindByID:"+err.Error()))
+		return
+	}
+	if user.ID == 0 {
+		util.ResponseError(c, 500, errors.New("用户不存在"))
+		return
+	}
+	t, err := admin.getTemplateByURL("/admin/edit_user")
+	if err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	userForm := &UserFormInfo{User: user, Roles: []string{RoleViewer, RoleOperator, RoleAdmin}}
+	if err := admin.executeTemplate(t, c, userForm, "/admin/user_list"); err != nil {
+		util.ResponseError(c, 500, err)
+	}
+}
+
+//SaveUser 保存管理员用户action，修改时密码为空则不变
+func (admin *Admin) SaveUser(c *gin.Context) {
+	id, _ := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	userName := strings.TrimSpace(c.PostForm("user_name"))
+	password := c.PostForm("password")
+	role := c.PostForm("role")
+	modules := strings.Join(splitModules(c.PostForm("modules")), ",")
+
+	if !userNameRegexp.MatchString(userName) {
+		util.ResponseError(c, 500, errors.New("用户名格式错误，支持：英文、数字、_.@-"))
+		return
+	}
+	if _, ok := roleLevels[role]; !ok {
+		util.ResponseError(c, 500, errors.New("未知的角色:"+role))
+		return
+	}
+	if id == 0 && password == "" {
+		util.ResponseError(c, 500, errors.New("密码必须填写！"))
+		return
+	}
+	if password != "" && len(password) < minPasswordLen {
+		util.ResponseError(c, 500, errors.New("密码至少"+strconv.Itoa(minPasswordLen)+"位！"))
+		return
+	}
+
+	tx := config.DB.Begin()
+	exist, err := (&entity.GatewayAdminUser{}).FindByName(tx, userName)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("FindByName:"+err.Error()))
+		return
+	}
+	if exist.ID != 0 && exist.ID != id {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("用户名已存在"))
+		return
+	}
+	user := &entity.GatewayAdminUser{}
+	if id != 0 {
+		if user, err = user.FindByID(tx, id); err != nil {
+			tx.Rollback()
+			util.ResponseError(c, 500, errors.New("FindByID:"+err.Error()))
+			return
+		}
+		if user.ID == 0 {
+			tx.Rollback()
+			util.ResponseError(c, 500, errors.New("用户不存在"))
+			return
+		}
+		if user.Role == RoleAdmin && role != RoleAdmin {
+			if err := admin.checkLastAdmin(tx, user); err != nil {
+				tx.Rollback()
+				util.ResponseError(c, 500, err)
+				return
+			}
+		}
+	}
+	user.UserName = userName
+	user.Role = role
+	user.Modules = modules
+	if password != "" {
+		if user.Password, err = hashPassword(password); err != nil {
+			tx.Rollback()
+			util.ResponseError(c, 500, errors.New("hashPassword:"+err.Error()))
+			return
+		}
+	}
+	if err := user.Save(tx); err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("GatewayAdminUser.Save:"+err.Error()))
+		return
+	}
+	tx.Commit()
+	util.ResponseSuccess(c, "")
+}
+
+//DelUser 删除管理员用户action
+func (admin *Admin) DelUser(c *gin.Context) {
+	userName := c.PostForm("user_name")
+	if userName == admin.loginUser(c) {
+		util.ResponseError(c, 500, errors.New("不能删除当前登录用户"))
+		return
+	}
+	if err := checkConfirm(c, userName); err != nil {
+		util.ResponseError(c, 500, err)
+		return
+	}
+	tx := config.DB.Begin()
+	user, err := (&entity.GatewayAdminUser{}).FindByName(tx, userName)
+	if err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("FindByName:"+err.Error()))
+		return
+	}
+	if user.ID == 0 {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("用户不存在"))
+		return
+	}
+	if user.Role == RoleAdmin {
+		if err := admin.checkLastAdmin(tx, user); err != nil {
+			tx.Rollback()
+			util.ResponseError(c, 500, err)
+			return
+		}
+	}
+	if err := user.Del(tx); err != nil {
+		tx.Rollback()
+		util.ResponseError(c, 500, errors.New("GatewayAdminUser.Del:"+err.Error()))
+		return
+	}
+	tx.Commit()
+	util.ResponseSuccess(c, "")
+}
+
+//Password 修改当前用户密码action
+func (admin *Admin) Password(c *gin.Context) {
+	if c.Request.Method != http.MethodPost {
+		t, err := admin.getTemplateByURL("/admin/password")
+		if err != nil {
+			util.ResponseError(c, 500, err)
+			return
+		}
+		if err := admin.executeTemplate(t, c, admin.currentUser(c), "/admin/password"); err != nil {
+			util.ResponseError(c, 500, err)
+		}
+		return
+	}
+
+	user := admin.currentUser(c)
+	oldPassword := c.PostForm("old_password")
+	password := c.PostForm("password")
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(oldPassword)); err != nil {
+		util.ResponseError(c, 500, errors.New("原密码错误"))
+		return
+	}
+	if len(password) < minPasswordLen {
+		util.ResponseError(c, 500, errors.New("密码至少"+strconv.Itoa(minPasswordLen)+"位！"))
+		return
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("hashPassword:"+err.Error()))
+		return
+	}
+	user.Password = hash
+	if err := user.Save(config.DB); err != nil {
+		util.ResponseError(c, 500, errors.New("GatewayAdminUser.Save:"+err.Error()))
+		return
+	}
+	//签名包含密码hash，需重新下发登录态
+	admin.setSession(c, user)
+	util.ResponseSuccess(c, "")
+}
+
+//checkLastAdmin 至少保留一个管理员
+func (admin *Admin) checkLastAdmin(tx *gorm.DB, user *entity.GatewayAdminUser) error {
+	users, err := (&entity.GatewayAdminUser{}).GetAll(tx)
+	if err != nil {
+		return errors.New("GetAll:" + err.Error())
+	}
+	for _, item := range users {
+		if item.ID != user.ID && item.Role == RoleAdmin {
+			return nil
+		}
+	}
+	return errors.New("至少保留一个管理员")
+}
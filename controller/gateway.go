@@ -1,7 +1,10 @@
 package controller
 
 import (
+	"fmt"
+
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 
 	"gatekeeper/core/service"
 	"gatekeeper/util"
@@ -29,3 +32,19 @@ func (g *Gateway) Reload(c *gin.Context) {
 	util.ResponseSuccess(c, string("gateway config loaded"))
 	return
 }
+
+//CachePurge /cache_purge 清除本节点模块缓存
+func (g *Gateway) CachePurge(c *gin.Context) {
+	moduleName := c.Query("module_name")
+	if moduleName == "" {
+		util.ResponseError(c, 400, errors.New("module_name empty"))
+		return
+	}
+	count, err := service.SysConfMgr.PurgeCache(moduleName, c.Query("path"))
+	if err != nil {
+		util.ResponseError(c, 500, errors.New("PurgeCache:"+err.Error()))
+		return
+	}
+	util.ResponseSuccess(c, fmt.Sprintf("%d cache purged", count))
+	return
+}
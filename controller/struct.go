@@ -1,8 +1,13 @@
 package controller
 
 import (
+	"html/template"
+
+	"gatekeeper/core/resource"
+	"gatekeeper/core/service"
 	"gatekeeper/model/entity"
 	"gatekeeper/model/running"
+	"gatekeeper/util"
 )
 
 //APPDetailInfo app详情结构体
@@ -23,6 +28,7 @@ type ServiceDetailInfo struct {
 	ActiveIPCount    int      //活动ip数
 	ForbidIPList     []string //禁用ip
 	ForbidIPCount    int      //禁用ip数
+	DrainIPList      []string //排空中ip
 	AvaliableIPList  []string //可用ip
 	AvaliableIPCount int      //可用ip数
 	ClusterIP        string   //集群地址
@@ -32,6 +38,9 @@ type ServiceDetailInfo struct {
 	DailyHourStat    string //当日流量统计
 	DailyHourAvg     string
 	DailyStatMax     int64 //当日流量统计
+	UpstreamGroups   []*UpstreamGroupStat
+	Bulkhead         *service.BulkheadStat //模块并发隔离状态
+	StatWindow       string                //节点统计窗口
 
 	//for edit
 	MatchRule     string
@@ -43,6 +52,22 @@ type ServiceDetailInfo struct {
 	Passport      string
 	FilterRule    string
 	RoutePrefix   string
+	GroupList     string //上游分组
+	Cache         *entity.GatewayCache
+}
+
+//UpstreamGroupStat 上游分组统计结构体
+type UpstreamGroupStat struct {
+	Group         *entity.GatewayUpstreamGroup
+	NodeList      []string //分组节点
+	AvailableList []string //可用节点
+	QPS           int64
+	DayRequest    int64
+	Requests      int64 //本节点启动以来请求数
+	Errors        int64 //本节点启动以来5xx数
+	AvgLatency    int64 //平均耗时，单位ms
+	Mirror        bool  //是否为流量镜像统计
+	Dropped       int64 //镜像丢弃数
 }
 
 //IsActive ip是否激活
@@ -65,6 +90,35 @@ func (u *ServiceDetailInfo) IsForbid(ip string) bool {
 	return false
 }
 
+//IsDrain ip是否排空中
+func (u *ServiceDetailInfo) IsDrain(ip string) bool {
+	for _, item := range u.DrainIPList {
+		if item == ip {
+			return true
+		}
+	}
+	return false
+}
+
+//ActiveRequests ip在本节点进行中的请求数
+func (u *ServiceDetailInfo) ActiveRequests(ip string) int64 {
+	return resource.ActiveRequests.Get(u.Module.Base.Name, ip)
+}
+
+//SlowStartProgress ip慢启动进度，100为已满权重
+func (u *ServiceDetailInfo) SlowStartProgress(ip string) int {
+	return service.SysConfMgr.SlowStartProgress(u.Module.Base.Name, ip)
+}
+
+//NodeStat ip在统计窗口内的请求数、状态码分布及耗时分位数
+func (u *ServiceDetailInfo) NodeStat(ip string) *resource.UpstreamStatSnapshot {
+	window, ok := upstreamStatWindows[u.StatWindow]
+	if !ok {
+		window = upstreamStatWindows[defaultUpstreamStatWindow]
+	}
+	return resource.UpstreamStats.Snapshot(u.Module.Base.Name, ip, window)
+}
+
 //APPListObj app列表结构体
 type APPListObj struct {
 	List      []APPItemObj
@@ -78,6 +132,62 @@ type APPItemObj struct {
 	QPD int64
 }
 
+//ValidateInput 配置校验请求结构体
+type ValidateInput struct {
+	Module map[string]*running.GatewayModule `json:"module"`
+	Apps   map[string]*entity.GatewayAPP     `json:"apps"`
+}
+
+//HistoryListInfo 配置历史列表结构体
+type HistoryListInfo struct {
+	Type     string //配置类型过滤
+	Name     string //配置名称过滤
+	List     []*entity.GatewayConfigHistory
+	Page     int
+	PrevPage int
+	NextPage int
+	HasMore  bool
+}
+
+//AuditListInfo 审计日志列表结构体
+type AuditListInfo struct {
+	Actor      string                    `json:"actor"`
+	Action     string                    `json:"action"`
+	TargetType string                    `json:"target_type"`
+	TargetName string                    `json:"target_name"`
+	Result     string                    `json:"result"`
+	StartTime  string                    `json:"start_time"`
+	EndTime    string                    `json:"end_time"`
+	Query      template.URL              `json:"-"` //分页及导出链接参数
+	List       []*entity.GatewayAuditLog `json:"list"`
+	Page       int                       `json:"page"`
+	PrevPage   int                       `json:"-"`
+	NextPage   int                       `json:"-"`
+	HasMore    bool                      `json:"has_more"`
+}
+
+//UserFormInfo 管理员用户编辑结构体
+type UserFormInfo struct {
+	User  *entity.GatewayAdminUser
+	Roles []string //可选角色
+}
+
+//ImportPlanItem 配置导入计划项，更新时附带差异行
+type ImportPlanItem struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name"`
+	Action string          `json:"action"`
+	Lines  []util.DiffLine `json:"lines"`
+}
+
+//HistoryDiffInfo 版本对比结构体
+type HistoryDiffInfo struct {
+	History *entity.GatewayConfigHistory //当前版本
+	Base    *entity.GatewayConfigHistory //对比版本
+	Scope   string                       //config为全量对比
+	Lines   []util.DiffLine
+}
+
 //Admin admin结构体
 type Admin struct {
 }
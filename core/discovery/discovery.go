@@ -0,0 +1,120 @@
+package discovery
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"gatekeeper/constant"
+	"gatekeeper/model/entity"
+)
+
+// 服务发现类型
+const (
+	TypeStatic = "static" //load_balance.ip_list手工配置，默认
+	TypeDNS    = "dns"    //DNS A记录(host:port)或SRV记录(_service._proto.name)
+	TypeFile   = "file"   //本地json/yaml节点文件，文件变更时重新加载
+	TypeHTTP   = "http"   //轮询注册中心接口，基于ETag判断是否变更
+	TypeRedis  = "redis"  //后端以TTL心跳自注册到redis
+)
+
+// Node 后端节点
+type Node struct {
+	Addr   string `json:"addr" yaml:"addr"`
+	Weight int64  `json:"weight" yaml:"weight"`
+}
+
+// Discovery 服务发现
+// 获取失败时由调用方保留上一次的节点列表
+type Discovery interface {
+	Nodes() ([]*Node, error)
+}
+
+// New 按负载配置创建服务发现
+func New(lb *entity.GatewayLoadBalance) (Discovery, error) {
+	switch lb.DiscoveryType {
+	case "", TypeStatic:
+		return &staticDiscovery{ipList: lb.IPList, weightList: lb.WeightList}, nil
+	case TypeDNS:
+		return newDNSDiscovery(lb.DiscoveryTarget)
+	case TypeFile:
+		return newFileDiscovery(lb.DiscoveryTarget)
+	case TypeHTTP:
+		return newHTTPDiscovery(lb.DiscoveryTarget, lb.CheckTimeout)
+	case TypeRedis:
+		return newRedisDiscovery(lb.DiscoveryTarget)
+	}
+	return nil, errors.New("unknown discovery type: " + lb.DiscoveryType)
+}
+
+// IsStatic 是否为手工配置的节点列表
+func IsStatic(discoveryType string) bool {
+	return discoveryType == "" || discoveryType == TypeStatic
+}
+
+// Addrs 节点地址列表
+func Addrs(nodes []*Node) []string {
+	addrs := []string{}
+	for _, node := range nodes {
+		addrs = append(addrs, node.Addr)
+	}
+	return addrs
+}
+
+// 手工配置的节点
+type staticDiscovery struct {
+	ipList     string
+	weightList string
+}
+
+func (d *staticDiscovery) Nodes() ([]*Node, error) {
+	nodes := []*Node{}
+	weightList := strings.Split(d.weightList, ",")
+	for index, addr := range strings.Split(d.ipList, ",") {
+		if addr == "" {
+			continue
+		}
+		weight := int64(constant.IPDefaultWeight)
+		if index < len(weightList) {
+			if w, err := strconv.ParseInt(weightList[index], 10, 64); err == nil {
+				weight = w
+			}
+		}
+		nodes = append(nodes, &Node{Addr: addr, Weight: weight})
+	}
+	return nodes, nil
+}
+
+// 节点文件及注册中心接口的返回格式
+// {"nodes": [{"addr": "127.0.0.1:8080", "weight": 50}]}
+type nodeList struct {
+	Nodes []*Node `json:"nodes" yaml:"nodes"`
+}
+
+// parseNodes 解析json或yaml格式的节点列表，未设置权重时使用默认权重
+func parseNodes(data []byte, isYAML bool) ([]*Node, error) {
+	list := &nodeList{}
+	var err error
+	if isYAML {
+		err = yaml.Unmarshal(data, list)
+	} else {
+		err = json.Unmarshal(data, list)
+	}
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*Node{}
+	for _, node := range list.Nodes {
+		if node == nil || node.Addr == "" {
+			continue
+		}
+		if node.Weight <= 0 {
+			node.Weight = constant.IPDefaultWeight
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/constant"
+)
+
+// DNS服务发现
+// 以_开头的目标按SRV记录解析，端口及权重取自记录；否则按host:port解析A记录
+type dnsDiscovery struct {
+	host string
+	port string
+	srv  bool
+}
+
+func newDNSDiscovery(target string) (*dnsDiscovery, error) {
+	if strings.HasPrefix(target, "_") {
+		return &dnsDiscovery{host: target, srv: true}, nil
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "dns target must be host:port or _service._proto.name")
+	}
+	return &dnsDiscovery{host: host, port: port}, nil
+}
+
+func (d *dnsDiscovery) Nodes() ([]*Node, error) {
+	nodes := []*Node{}
+	if d.srv {
+		_, records, err := net.LookupSRV("", "", d.host)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			weight := int64(record.Weight)
+			if weight == 0 {
+				weight = constant.IPDefaultWeight
+			}
+			host := strings.TrimSuffix(record.Target, ".")
+			nodes = append(nodes, &Node{Addr: net.JoinHostPort(host, strconv.Itoa(int(record.Port))), Weight: weight})
+		}
+		return nodes, nil
+	}
+	ips, err := net.LookupHost(d.host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		nodes = append(nodes, &Node{Addr: net.JoinHostPort(ip, d.port), Weight: constant.IPDefaultWeight})
+	}
+	return nodes, nil
+}
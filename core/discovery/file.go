@@ -0,0 +1,52 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 文件服务发现
+// 仅在文件修改时间变化时重新解析，.yaml/.yml按yaml解析，其余按json解析
+type fileDiscovery struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	nodes   []*Node
+}
+
+func newFileDiscovery(path string) (*fileDiscovery, error) {
+	if path == "" {
+		return nil, errors.New("discovery file path is empty")
+	}
+	return &fileDiscovery{path: path}, nil
+}
+
+func (d *fileDiscovery) Nodes() ([]*Node, error) {
+	d.Lock()
+	defer d.Unlock()
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return nil, err
+	}
+	if d.nodes != nil && info.ModTime().Equal(d.modTime) {
+		return d.nodes, nil
+	}
+	data, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(d.path))
+	nodes, err := parseNodes(data, ext == ".yaml" || ext == ".yml")
+	if err != nil {
+		return nil, errors.Wrap(err, "parse "+d.path)
+	}
+	d.nodes = nodes
+	d.modTime = info.ModTime()
+	return nodes, nil
+}
@@ -0,0 +1,60 @@
+package discovery
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/util"
+)
+
+// 注册中心默认请求超时，单位ms
+const defaultHTTPTimeout = 2000
+
+// 注册中心服务发现
+// 携带If-None-Match轮询，返回304时沿用上一次的节点列表
+type httpDiscovery struct {
+	sync.Mutex
+	url     string
+	timeout int
+	etag    string
+	nodes   []*Node
+}
+
+func newHTTPDiscovery(url string, timeout int) (*httpDiscovery, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, errors.New("discovery url must start with http:// or https://")
+	}
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+	return &httpDiscovery{url: url, timeout: timeout}, nil
+}
+
+func (d *httpDiscovery) Nodes() ([]*Node, error) {
+	d.Lock()
+	defer d.Unlock()
+	header := http.Header{}
+	if d.etag != "" && d.nodes != nil {
+		header.Set("If-None-Match", d.etag)
+	}
+	resp, body, err := util.HttpGET(d.url, nil, d.timeout, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return d.nodes, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("discovery %s status %d", d.url, resp.StatusCode)
+	}
+	nodes, err := parseNodes(body, strings.Contains(resp.Header.Get("Content-Type"), "yaml"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse "+d.url)
+	}
+	d.nodes = nodes
+	d.etag = resp.Header.Get("ETag")
+	return nodes, nil
+}
@@ -0,0 +1,93 @@
+package discovery
+
+import (
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/constant"
+)
+
+// 单次SCAN返回的key数量
+const redisScanCount = 100
+
+// redis自注册服务发现
+// 后端按心跳周期执行 SET gatekeeper_discovery_{service}:{ip:port} {weight} EX {ttl}，
+// 停止心跳后key过期即自动下线
+type redisDiscovery struct {
+	prefix string
+}
+
+func newRedisDiscovery(service string) (*redisDiscovery, error) {
+	if service == "" {
+		return nil, errors.New("discovery service name is empty")
+	}
+	if !config.RedisEnabled() {
+		return nil, errors.New("redis discovery requires redis")
+	}
+	return &redisDiscovery{prefix: RedisKeyPrefix(service)}, nil
+}
+
+// RedisKeyPrefix 服务在redis中的注册key前缀
+func RedisKeyPrefix(service string) string {
+	return constant.DiscoveryRedisPrefix + service + ":"
+}
+
+func (d *redisDiscovery) Nodes() ([]*Node, error) {
+	keys := []string{}
+	//SCAN在rehash期间可能重复返回同一个key
+	seen := map[string]bool{}
+	cursor := int64(0)
+	for {
+		reply, err := redis.Values(config.RedisDo("SCAN", cursor, "MATCH", d.prefix+"*", "COUNT", redisScanCount))
+		if err != nil {
+			return nil, err
+		}
+		if len(reply) != 2 {
+			return nil, errors.New("unexpected scan reply")
+		}
+		if cursor, err = redis.Int64(reply[0], nil); err != nil {
+			return nil, err
+		}
+		batch, err := redis.Strings(reply[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range batch {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+
+	nodes := []*Node{}
+	if len(keys) == 0 {
+		return nodes, nil
+	}
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	values, err := redis.Values(config.RedisDo("MGET", args...))
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		//SCAN之后过期的节点
+		if i >= len(values) || values[i] == nil {
+			continue
+		}
+		weight, err := redis.Int64(values[i], nil)
+		if err != nil || weight <= 0 {
+			weight = constant.IPDefaultWeight
+		}
+		nodes = append(nodes, &Node{Addr: strings.TrimPrefix(key, d.prefix), Weight: weight})
+	}
+	return nodes, nil
+}
@@ -6,19 +6,28 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"gatekeeper/constant"
 	"gatekeeper/core/service"
-	"gatekeeper/util"
 )
 
 func AccessControl() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		gws, ok := c.MustGet(MiddlewareServiceKey).(*service.GateWayService)
 		if !ok {
-			util.ResponseError(c, http.StatusBadRequest, errors.New("gateway_service not valid"))
+			rejectRequest(c, constant.DLTagAccessControlFailure, errors.New("gateway_service not valid"))
 			return
 		}
-		if err := gws.AccessControl(); err != nil {
-			util.ResponseError(c, http.StatusUnauthorized, err)
+		span := startSpan(c, "access_control")
+		err := gws.AccessControl()
+		span.End(err)
+		gws.RateLimit().SetHeader(c.Writer.Header())
+		if err != nil {
+			tag := constant.DLTagAccessControlFailure
+			if gerr := service.AsGatewayError(err); gerr.Status == http.StatusTooManyRequests {
+				tag = constant.DLTagRateLimitFailure
+				service.PublishLimitTrip(gws.CurrentModule().Base.Name, c.Request.Header.Get("app_id"), gerr)
+			}
+			rejectRequest(c, tag, err)
 			return
 		}
 		c.Set(MiddlewareServiceKey, gws)
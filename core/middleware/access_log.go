@@ -1,12 +1,15 @@
 package middleware
 
 import (
-	"bytes"
 	"context"
-	"gatekeeper/config"
-	"github.com/gin-gonic/gin"
-	"io/ioutil"
+	"strings"
 	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"gatekeeper/constant"
+	"gatekeeper/core/service"
+	"gatekeeper/util"
 )
 
 func RequestTraceLog() gin.HandlerFunc {
@@ -17,42 +20,67 @@ func RequestTraceLog() gin.HandlerFunc {
 	}
 }
 
+// RequestInLog 记录请求开始时间，请求体不在此读取，由转发时按需流式读取
 func RequestInLog(c *gin.Context) {
-	bodyBytes, err := ioutil.ReadAll(c.Request.Body)
-	if err != nil {
-
-	}
-	c.Set(MiddlewareRequestBodyKey, bodyBytes)
 	c.Set("startExecTime", time.Now())
 	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), "request_url", c.Request.URL.Path))
-	c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
 }
 
+// RequestOutLog 输出访问日志，网关请求附带模块、上游及拒绝原因
+// 模块配置了独立访问日志文件时写入该文件
 func RequestOutLog(c *gin.Context) {
-	endExecTime := time.Now()
-	st, ok := c.Get("startExecTime")
-	if !ok {
-		config.AccessLog.Info("[uri:%s] [method:%s] [args:%+v] [from:%s] [key:%s]",
-			c.Request.RequestURI,
-			c.Request.Method,
-			c.Request.PostForm,
-			c.ClientIP(),
-			"st")
-	}
-	startExecTime, ok := st.(time.Time)
-	if !ok {
-		config.AccessLog.Info("[uri:%s] [method:%s] [args:%+v] [from:%s] [key:%s] [proc_time:%f]",
-			c.Request.RequestURI,
-			c.Request.Method,
-			c.Request.PostForm,
-			c.ClientIP(),
-			"st",
-			st)
-	}
-	config.AccessLog.Info("[uri:%s] [method:%s] [args:%+v] [from:%s] [proc_time:%f]",
-		c.Request.RequestURI,
-		c.Request.Method,
-		c.Request.PostForm,
-		c.ClientIP(),
-		endExecTime.Sub(startExecTime).Seconds())
+	entry := &service.AccessLogEntry{
+		Time:          time.Now(),
+		RequestID:     c.Request.Header.Get(service.RequestIDHeader),
+		TraceID:       util.TraceID(c.Request.Context()),
+		ClientIP:      c.ClientIP(),
+		Method:        c.Request.Method,
+		URI:           c.Request.RequestURI,
+		Status:        c.Writer.Status(),
+		RequestLength: c.Request.ContentLength,
+		AppID:         c.Request.Header.Get("app_id"),
+		Cache:         c.Writer.Header().Get(constant.CacheStatusHeader),
+		UserAgent:     c.Request.UserAgent(),
+		Referer:       c.Request.Referer(),
+	}
+	if size := c.Writer.Size(); size > 0 {
+		entry.BytesSent = size
+	}
+	if entry.RequestLength < 0 {
+		entry.RequestLength = 0
+	}
+	st, _ := c.Get("startExecTime")
+	if startExecTime, ok := st.(time.Time); ok {
+		entry.ProcTime = entry.Time.Sub(startExecTime)
+	}
+	if reason, ok := c.Get(constant.MiddlewareRejectKey); ok {
+		entry.RejectReason, _ = reason.(string)
+	}
+	if err := c.Errors.Last(); err != nil {
+		entry.Error = err.Error()
+	}
+	file := ""
+	if v, ok := c.Get(MiddlewareServiceKey); ok {
+		if gws, ok := v.(*service.GateWayService); ok {
+			gws.AccessLog(entry)
+			file = gws.CurrentModule().Base.AccessLogFile
+		}
+	}
+	service.WriteAccessLog(entry, file)
+}
+
+// 拒绝请求并记录拒绝原因，tag为constant中的DLTag
+// 非网关错误按内部错误处理，错误详情只写入访问日志，按模块错误页输出给客户端
+func rejectRequest(c *gin.Context, tag string, err error) {
+	gerr := service.AsGatewayError(err)
+	c.Set(constant.MiddlewareRejectKey, strings.TrimSpace(tag))
+	c.Error(gerr)
+	module := ""
+	if v, ok := c.Get(MiddlewareServiceKey); ok {
+		if gws, ok := v.(*service.GateWayService); ok && gws.CurrentModule() != nil {
+			module = gws.CurrentModule().Base.Name
+		}
+	}
+	service.SysConfMgr.WriteGatewayError(c.Writer, c.Request, module, gerr)
+	c.Abort()
 }
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/constant"
+	"gatekeeper/core/service"
+	"gatekeeper/util"
+)
+
+// BodyLimit 按模块max_body_size限制请求体大小
+// 声明的Content-Length超限时直接返回413，未声明长度的请求在转发读取时判断
+func BodyLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		gws, ok := c.MustGet(MiddlewareServiceKey).(*service.GateWayService)
+		if !ok {
+			rejectRequest(c, constant.DLTagBodyLimitFailure, errors.New("gateway_service not valid"))
+			return
+		}
+		maxBodySize := gws.CurrentModule().LoadBalance.MaxBodySize
+		if maxBodySize > 0 {
+			if c.Request.ContentLength > maxBodySize {
+				rejectRequest(c, constant.DLTagBodyLimitFailure, service.NewGatewayError(service.ErrCodeBodyTooLarge,
+					errors.New(fmt.Sprintf("request body too large, limit %d bytes", maxBodySize))))
+				return
+			}
+			c.Request.Body = service.NewLimitedBody(c.Request.Body, maxBodySize)
+		}
+		c.Next()
+	}
+}
+
+// AdminBodyLimit 限制管理后台请求体大小
+func AdminBodyLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		maxBodySize := config.BaseConf.Http.AdminMaxBodySize
+		if maxBodySize <= 0 {
+			maxBodySize = constant.AdminDefaultMaxBodySize
+		}
+		if c.Request.ContentLength > maxBodySize {
+			util.ResponseError(c, http.StatusRequestEntityTooLarge,
+				errors.New(fmt.Sprintf("request body too large, limit %d bytes", maxBodySize)))
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize)
+		c.Next()
+	}
+}
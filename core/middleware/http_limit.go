@@ -2,12 +2,13 @@ package middleware
 
 import (
 	"fmt"
-	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 
+	"gatekeeper/constant"
 	"gatekeeper/core/resource"
 	"gatekeeper/core/service"
 	"gatekeeper/util"
@@ -19,7 +20,7 @@ func HTTPLimit() gin.HandlerFunc {
 		// 获取上游服务
 		gws, ok := c.MustGet(MiddlewareServiceKey).(*service.GateWayService)
 		if !ok {
-			util.ResponseError(c, http.StatusBadRequest, errors.New("gateway_service not valid"))
+			rejectRequest(c, constant.DLTagHTTPLimitFailure, errors.New("gateway_service not valid"))
 			return
 		}
 
@@ -29,14 +30,21 @@ func HTTPLimit() gin.HandlerFunc {
 		counter.Increase(c.Request.Context(), c.Request.RemoteAddr)
 
 		// 客户端ip限流
+		span := startSpan(c, "http_limit")
 		remoteIP := util.Substr(c.Request.RemoteAddr, 0, int64(strings.Index(c.Request.RemoteAddr, ":")))
 		if currentModule.AccessControl.ClientFlowLimit > 0 {
 			limiter := resource.Limiters.GetLimiter(currentModule.Base.Name+"_"+remoteIP, currentModule.AccessControl.ClientFlowLimit)
 			if limiter.Allow() == false {
 				errMsg := fmt.Sprintf("moduleName:%s remoteIP：%s, QPS limit : %d, %d", currentModule.Base.Name, remoteIP, int64(limiter.Limit()), limiter.Burst())
-				util.ResponseError(c, http.StatusBadRequest, errors.New(errMsg))
+				gerr := service.NewGatewayError(service.ErrCodeRateLimited, errors.New(errMsg))
+				gerr.RetryAfter = time.Second
+				service.PublishLimitTrip(currentModule.Base.Name, remoteIP, gerr)
+				span.End(gerr)
+				rejectRequest(c, constant.DLTagRateLimitFailure, gerr)
+				return
 			}
 		}
+		span.End(nil)
 		c.Next()
 	}
 }
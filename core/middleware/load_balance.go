@@ -1,36 +1,68 @@
 package middleware
 
 import (
-	"bytes"
 	"errors"
-	"io/ioutil"
-	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"gatekeeper/constant"
+	"gatekeeper/core/resource"
 	"gatekeeper/core/service"
-	"gatekeeper/util"
 )
 
 func LoadBalance() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		gws, ok := c.MustGet(MiddlewareServiceKey).(*service.GateWayService)
 		if !ok {
-			util.ResponseError(c, http.StatusBadRequest, errors.New("gateway_service not valid"))
+			rejectRequest(c, constant.DLTagLoadBalanceFailure, errors.New("gateway_service not valid"))
 			return
 		}
+		span := startSpan(c, "load_balance")
 		proxy, err := gws.LoadBalance()
+		span.SetAttribute("gatekeeper.upstream_group", gws.UpstreamGroup())
+		span.End(err)
 		if err != nil {
-			util.ResponseError(c, http.StatusProxyAuthRequired, err)
+			rejectRequest(c, constant.DLTagLoadBalanceFailure, err)
 			return
 		}
-		requestBody, ok := c.MustGet(MiddlewareRequestBodyKey).([]byte)
-		if !ok {
-			util.ResponseError(c, http.StatusBadRequest, errors.New("request_body not valid"))
+		c.Request = gws.ProxyRequest()
+		defer gws.ReleaseUpstream()
+
+		// 请求体默认流式转发，仅在需要镜像时缓存
+		moduleName := gws.CurrentModule().Base.Name
+		if service.SysConfMgr.MirrorSample(moduleName) {
+			body, err := service.BufferRequestBody(c.Request.Body)
+			if err == service.ErrBodyTooLarge {
+				rejectRequest(c, constant.DLTagBodyLimitFailure, service.NewGatewayError(service.ErrCodeBodyTooLarge, err))
+				return
+			}
+			if err != nil {
+				rejectRequest(c, constant.DLTagLoadBalanceFailure, service.NewGatewayError(service.ErrCodeBadRequest, errors.New("read request body:"+err.Error())))
+				return
+			}
+			defer body.Close()
+			service.SysConfMgr.MirrorRequest(moduleName, c.Request, body)
+			reader, err := body.NewReader()
+			if err != nil {
+				rejectRequest(c, constant.DLTagLoadBalanceFailure, errors.New("read request body:"+err.Error()))
+				return
+			}
+			defer reader.Close()
+			c.Request.Body = reader
+		}
+
+		// 配置了上游分组时按分组统计流量
+		if len(gws.CurrentModule().UpstreamGroups) == 0 {
+			service.SysConfMgr.ServeHTTP(moduleName, proxy, c.Writer, c.Request)
+			c.Abort()
 			return
 		}
-		c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(requestBody))
-		proxy.ServeHTTP(c.Writer, c.Request)
+		groupKey := moduleName + ":" + gws.UpstreamGroup()
+		resource.FlowCounters.GetGroupCounter(moduleName, gws.UpstreamGroup()).Increase(c.Request.Context(), c.Request.RemoteAddr)
+		start := time.Now()
+		service.SysConfMgr.ServeHTTP(moduleName, proxy, c.Writer, c.Request)
+		resource.GroupStats.GetGroupStat(groupKey).Record(c.Writer.Status(), time.Since(start))
 		c.Abort()
 	}
 }
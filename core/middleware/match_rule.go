@@ -1,26 +1,34 @@
 package middleware
 
 import (
-	"net/http"
-
 	"github.com/gin-gonic/gin"
 
+	"gatekeeper/constant"
 	"gatekeeper/core/service"
-	"gatekeeper/util"
 )
 
 //中间件常量
 const (
-	MiddlewareServiceKey     = "gateway_service"
-	MiddlewareRequestBodyKey = "request_body"
+	MiddlewareServiceKey = "gateway_service"
 )
 
 //MatchRule 匹配模块中间件
 func MatchRule() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		gws := service.NewGateWayService(c.Writer, c.Request)
-		if err := gws.MatchRule(); err != nil {
-			util.ResponseError(c, http.StatusBadRequest, err)
+		span := startSpan(c, "match_rule")
+		err := gws.MatchRule()
+		span.End(err)
+		if err != nil {
+			if gws.CurrentModule() != nil {
+				c.Set(MiddlewareServiceKey, gws)
+			}
+			rejectRequest(c, constant.DLTagMatchRuleFailure, err)
+			return
+		}
+		if redirect := gws.Redirect(); redirect != nil {
+			c.Redirect(redirect.Code, redirect.Location)
+			c.Abort()
 			return
 		}
 		c.Set(MiddlewareServiceKey, gws)
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"github.com/e421083458/golang_common/lib"
+	"github.com/gin-gonic/gin"
+
+	"gatekeeper/constant"
+	"gatekeeper/core/resource"
+	"gatekeeper/core/service"
+)
+
+// Tracing 链路追踪中间件
+// 接收或新建W3C traceparent，请求结束时记录根span并导出各阶段span
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trace, req := service.StartTrace(c.Request)
+		c.Request = req
+		//错误输出的trace_id
+		c.Set("trace", &lib.TraceContext{Trace: lib.Trace{TraceId: trace.TraceID, SpanId: trace.Root().SpanID()}})
+		c.Next()
+
+		root := trace.Root()
+		if v, ok := c.Get(MiddlewareServiceKey); ok {
+			if gws, ok := v.(*service.GateWayService); ok {
+				root.SetAttribute("gatekeeper.module", gws.CurrentModule().Base.Name)
+				if group := gws.UpstreamGroup(); group != "" {
+					root.SetAttribute("gatekeeper.upstream_group", group)
+				}
+			}
+		}
+		if reason, ok := c.Get(constant.MiddlewareRejectKey); ok {
+			root.SetAttribute("gatekeeper.reject_reason", reason)
+		}
+		if cache := c.Writer.Header().Get(constant.CacheStatusHeader); cache != "" {
+			root.SetAttribute("gatekeeper.cache", cache)
+		}
+		trace.Finish(c.Writer.Status())
+	}
+}
+
+// 开始记录当前阶段的span，请求未开启链路时返回nil
+func startSpan(c *gin.Context, name string) *service.Span {
+	return service.TraceFromContext(c.Request.Context()).StartSpan(name, resource.SpanKindInternal)
+}
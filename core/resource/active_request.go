@@ -0,0 +1,68 @@
+package resource
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var ActiveRequests *ActiveRequestManager
+
+// 上游节点进行中请求数管理器
+// 按模块或分组运行时key及节点地址统计本节点正在转发的请求，模块重载后继续累计
+type ActiveRequestManager struct {
+	sync.RWMutex
+	counters map[string]*int64
+}
+
+func NewActiveRequestManager() *ActiveRequestManager {
+	return &ActiveRequestManager{counters: map[string]*int64{}}
+}
+
+func activeRequestKey(key, addr string) string {
+	return key + "|" + addr
+}
+
+// 节点进行中请求数未达上限时开始一个请求，limit<=0不限制，已达上限时返回nil
+// 返回的计数在请求结束时传给Release
+func (m *ActiveRequestManager) TryAcquire(key, addr string, limit int64) *int64 {
+	counter := m.counter(key, addr)
+	if n := atomic.AddInt64(counter, 1); limit > 0 && n > limit {
+		atomic.AddInt64(counter, -1)
+		return nil
+	}
+	return counter
+}
+
+func (m *ActiveRequestManager) counter(key, addr string) *int64 {
+	name := activeRequestKey(key, addr)
+	m.RLock()
+	counter, ok := m.counters[name]
+	m.RUnlock()
+	if !ok {
+		m.Lock()
+		if counter, ok = m.counters[name]; !ok {
+			counter = new(int64)
+			m.counters[name] = counter
+		}
+		m.Unlock()
+	}
+	return counter
+}
+
+// 结束一个请求
+func (m *ActiveRequestManager) Release(counter *int64) {
+	if counter != nil {
+		atomic.AddInt64(counter, -1)
+	}
+}
+
+// 节点进行中的请求数
+func (m *ActiveRequestManager) Get(key, addr string) int64 {
+	m.RLock()
+	counter, ok := m.counters[activeRequestKey(key, addr)]
+	m.RUnlock()
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(counter)
+}
@@ -0,0 +1,161 @@
+package resource
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"gatekeeper/config"
+)
+
+// CacheStore 响应缓存存储
+type CacheStore interface {
+	// Get 获取缓存，不存在或已过期时返回nil
+	Get(key string) ([]byte, error)
+	// Set 写入缓存并设置过期时间
+	Set(key string, value []byte, expire time.Duration) error
+	// Purge 删除指定前缀的缓存，返回删除数量
+	Purge(prefix string) (int, error)
+}
+
+// MemoryCaches 本节点内存缓存，各模块共用并按总字节数淘汰
+var MemoryCaches CacheStore
+
+// 按模块配置的存储类型获取缓存存储，未配置redis时使用内存
+func GetCacheStore(backend string) CacheStore {
+	if backend == "redis" && config.RedisEnabled() {
+		return &redisCacheStore{}
+	}
+	return MemoryCaches
+}
+
+// redis缓存存储，集群共享
+type redisCacheStore struct{}
+
+func (s *redisCacheStore) Get(key string) ([]byte, error) {
+	value, err := redis.Bytes(config.RedisDo("GET", key))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (s *redisCacheStore) Set(key string, value []byte, expire time.Duration) error {
+	seconds := int64(expire / time.Second)
+	if seconds <= 0 {
+		seconds = 1
+	}
+	_, err := config.RedisDo("SET", key, value, "EX", seconds)
+	return err
+}
+
+func (s *redisCacheStore) Purge(prefix string) (int, error) {
+	pattern := redisGlobEscaper.Replace(prefix) + "*"
+	count := 0
+	cursor := "0"
+	for {
+		values, err := redis.Values(config.RedisDo("SCAN", cursor, "MATCH", pattern, "COUNT", 500))
+		if err != nil {
+			return count, err
+		}
+		if len(values) != 2 {
+			return count, nil
+		}
+		cursor, _ = redis.String(values[0], nil)
+		keys, _ := redis.Strings(values[1], nil)
+		if len(keys) > 0 {
+			args := make([]interface{}, len(keys))
+			for i, key := range keys {
+				args[i] = key
+			}
+			n, err := redis.Int(config.RedisDo("DEL", args...))
+			if err != nil {
+				return count, err
+			}
+			count += n
+		}
+		if cursor == "0" {
+			return count, nil
+		}
+	}
+}
+
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// 内存缓存存储，超过maxBytes时淘汰最久未访问的缓存
+type memoryCacheStore struct {
+	sync.Mutex
+	maxBytes int64
+	size     int64
+	lru      *list.List
+	items    map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+// 创建内存缓存存储
+func NewMemoryCacheStore(maxBytes int64) CacheStore {
+	return &memoryCacheStore{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (s *memoryCacheStore) Get(key string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := elem.Value.(*memoryCacheItem)
+	if time.Now().After(item.expireAt) {
+		s.remove(elem)
+		return nil, nil
+	}
+	s.lru.MoveToFront(elem)
+	return item.value, nil
+}
+
+func (s *memoryCacheStore) Set(key string, value []byte, expire time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+	item := &memoryCacheItem{key: key, value: value, expireAt: time.Now().Add(expire)}
+	s.items[key] = s.lru.PushFront(item)
+	s.size += int64(len(value))
+	for s.size > s.maxBytes && s.lru.Len() > 1 {
+		s.remove(s.lru.Back())
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) Purge(prefix string) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	count := 0
+	for key, elem := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			s.remove(elem)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryCacheStore) remove(elem *list.Element) {
+	item := elem.Value.(*memoryCacheItem)
+	s.lru.Remove(elem)
+	delete(s.items, item.key)
+	s.size -= int64(len(item.value))
+}
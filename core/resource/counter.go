@@ -7,18 +7,21 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
-
 	"gatekeeper/config"
 	"gatekeeper/constant"
 )
 
 var FlowCounters *FlowCounterManager
 
+// 计数过期时间
+const counterExpire = 86400 * time.Second
+
 // 流量统计管理器
 type FlowCounterManager struct {
 	requestCountMap     map[string]*RequestCountService
 	requestCountMapLock sync.RWMutex
+	groupCountMap       map[string]*RequestCountService //上游分组统计，与模块统计分开存储
+	groupCountMapLock   sync.RWMutex
 	appCountMap         map[string]*APPCountService
 	appCountMapLock     sync.RWMutex
 }
@@ -35,6 +38,10 @@ type RequestCountService struct {
 	Unix        int64
 	TickerCount int64
 	ReqDate     string
+
+	series     string //时间序列名
+	dayPrefix  string //天计数key前缀
+	hourPrefix string //小时计数key前缀
 }
 
 // app统计结构体
@@ -56,20 +63,36 @@ func NewFlowCounterManager() *FlowCounterManager {
 	return &FlowCounterManager{
 		requestCountMap:     make(map[string]*RequestCountService),
 		requestCountMapLock: sync.RWMutex{},
+		groupCountMap:       make(map[string]*RequestCountService),
+		groupCountMapLock:   sync.RWMutex{},
 		appCountMap:         make(map[string]*APPCountService),
 		appCountMapLock:     sync.RWMutex{},
 	}
 }
 
 func NewRequestCountService(moduleName string, interval time.Duration, maxCnt int) (*RequestCountService, error) {
+	return newRequestCountService(moduleName, ModuleSeries(moduleName),
+		constant.RequestModuleCounterPrefix, constant.RequestModuleHourCounterPrefix, interval), nil
+}
+
+// 创建上游分组统计，计数key及时间序列与模块统计区分
+func NewGroupCountService(moduleName, group string, interval time.Duration) *RequestCountService {
+	return newRequestCountService(moduleName+":"+group, GroupSeries(moduleName, group),
+		constant.RequestGroupCounterPrefix, constant.RequestGroupHourCounterPrefix, interval)
+}
+
+func newRequestCountService(name, series, dayPrefix, hourPrefix string, interval time.Duration) *RequestCountService {
 	reqCounter := &RequestCountService{
-		ModuleName:  moduleName,
+		ModuleName:  name,
 		Interval:    interval,
 		ReqCount:    0,
 		QPS:         0,
 		Unix:        0,
 		TickerCount: 0,
 		ReqDate:     "",
+		series:      series,
+		dayPrefix:   dayPrefix,
+		hourPrefix:  hourPrefix,
 	}
 	go func() {
 		defer func() {
@@ -80,20 +103,17 @@ func NewRequestCountService(moduleName string, interval time.Duration, maxCnt in
 		ticker := time.NewTicker(interval)
 		for {
 			<-ticker.C
-			tickerCount := atomic.LoadInt64(&reqCounter.TickerCount) // 获取数据
-			atomic.StoreInt64(&reqCounter.TickerCount, 0)            // 重置数据
-			today := time.Now().In(config.TimeLocation).Format("2006-01-02 15:04:05")
-			redisKey := constant.RequestModuleCounterPrefix + today + "_" + reqCounter.ModuleName
-			todayHour := time.Now().In(config.TimeLocation).Format("2006010215")
-			redisHourKey := constant.RequestModuleHourCounterPrefix + todayHour + "_" + reqCounter.ModuleName
-			config.RedisPipeline(
-				func(c redis.Conn) {
-					c.Send("INCRBY", redisKey, tickerCount)
-					c.Send("EXPIRE", redisKey, 86400)
-					c.Send("INCRBY", redisHourKey, tickerCount)
-					c.Send("EXPIRE", redisHourKey, 86400)
-				})
-			if currentCount, err := redis.Int64(config.RedisDo("GET", redisKey)); err == nil {
+			tickerCount := atomic.SwapInt64(&reqCounter.TickerCount, 0) // 获取并重置数据
+			now := time.Now()
+			if tickerCount > 0 {
+				TimeSeries.Add(reqCounter.series, now, tickerCount)
+			}
+			today := now.In(config.TimeLocation).Format(constant.DateFormat)
+			redisKey := reqCounter.dayPrefix + today + "_" + reqCounter.ModuleName
+			todayHour := now.In(config.TimeLocation).Format("2006010215")
+			redisHourKey := reqCounter.hourPrefix + todayHour + "_" + reqCounter.ModuleName
+			Counters.IncrBy(redisHourKey, tickerCount, counterExpire)
+			if currentCount, err := Counters.IncrBy(redisKey, tickerCount, counterExpire); err == nil {
 				nowUnix := time.Now().Unix()
 				nowDate := time.Now().In(config.TimeLocation).Format(constant.DateFormat)
 				if reqCounter.ReqDate != nowDate {
@@ -102,6 +122,7 @@ func NewRequestCountService(moduleName string, interval time.Duration, maxCnt in
 				}
 				if reqCounter.Unix == 0 {
 					reqCounter.Unix = time.Now().Unix()
+					reqCounter.TotalCount = currentCount
 				} else {
 					if currentCount >= reqCounter.TotalCount && nowUnix > reqCounter.Unix {
 						reqCounter.QPS = (currentCount - reqCounter.TotalCount) / (nowUnix - reqCounter.Unix)
@@ -112,7 +133,7 @@ func NewRequestCountService(moduleName string, interval time.Duration, maxCnt in
 			}
 		}
 	}()
-	return reqCounter, nil
+	return reqCounter
 }
 
 func NewAPPCountService(appID string, interval time.Duration, maxCnt int) (*APPCountService, error) {
@@ -135,22 +156,17 @@ func NewAPPCountService(appID string, interval time.Duration, maxCnt int) (*APPC
 		ticker := time.NewTicker(interval)
 		for {
 			<-ticker.C
-			tickerCount := atomic.LoadInt64(&reqCounter.TickerCount) //获取数据
-			atomic.StoreInt64(&reqCounter.TickerCount, 0)            //重置数据
-
-			// todo
-			today := time.Now().In(config.TimeLocation).Format("")
+			tickerCount := atomic.SwapInt64(&reqCounter.TickerCount, 0) //获取并重置数据
+			now := time.Now()
+			if tickerCount > 0 {
+				TimeSeries.Add(APPSeries(appID), now, tickerCount)
+			}
+			today := now.In(config.TimeLocation).Format(constant.DateFormat)
 			totalAppKey := fmt.Sprintf("%s%s_%s", constant.AccessControlAppIDTotalCallPrefix, today, appID)
-			todayHour := time.Now().In(config.TimeLocation).Format("2006010215")
+			todayHour := now.In(config.TimeLocation).Format("2006010215")
 			redisHourKey := fmt.Sprintf("%s%s_%s", constant.AccessControlAppIDHourTotalCallPrefix, todayHour, appID)
-			config.RedisPipeline(
-				func(c redis.Conn) {
-					c.Send("INCRBY", totalAppKey, tickerCount)
-					c.Send("EXPIRE", totalAppKey, 86400)
-					c.Send("INCRBY", redisHourKey, tickerCount)
-					c.Send("EXPIRE", redisHourKey, 86400)
-				})
-			if currentCount, err := redis.Int64(config.RedisDo("GET", totalAppKey)); err == nil {
+			Counters.IncrBy(redisHourKey, tickerCount, counterExpire)
+			if currentCount, err := Counters.IncrBy(totalAppKey, tickerCount, counterExpire); err == nil {
 				nowUnix := time.Now().Unix()
 				nowDate := time.Now().In(config.TimeLocation).Format(constant.DateFormat)
 				if reqCounter.ReqDate != nowDate {
@@ -159,6 +175,7 @@ func NewAPPCountService(appID string, interval time.Duration, maxCnt int) (*APPC
 				}
 				if reqCounter.Unix == 0 {
 					reqCounter.Unix = time.Now().Unix()
+					reqCounter.TotalCount = currentCount
 				} else {
 					if currentCount >= reqCounter.TotalCount && nowUnix > reqCounter.Unix {
 						reqCounter.QPS = (currentCount - reqCounter.TotalCount) / (nowUnix - reqCounter.Unix)
@@ -191,6 +208,25 @@ func (c *FlowCounterManager) GetRequestCounter(moduleName string) *RequestCountS
 	return newCounter
 }
 
+// 获取上游分组统计，不存在就创建一个
+func (c *FlowCounterManager) GetGroupCounter(moduleName, group string) *RequestCountService {
+	key := moduleName + ":" + group
+	c.groupCountMapLock.RLock()
+	if counter, ok := c.groupCountMap[key]; ok {
+		c.groupCountMapLock.RUnlock()
+		return counter
+	}
+	c.groupCountMapLock.RUnlock()
+	c.groupCountMapLock.Lock()
+	defer c.groupCountMapLock.Unlock()
+	if counter, ok := c.groupCountMap[key]; ok {
+		return counter
+	}
+	newCounter := NewGroupCountService(moduleName, group, 1*time.Second)
+	c.groupCountMap[key] = newCounter
+	return newCounter
+}
+
 func (c *FlowCounterManager) GetAPPCounter(appID string) *APPCountService {
 	c.appCountMapLock.RLock()
 	if counter, ok := c.appCountMap[appID]; ok {
@@ -220,13 +256,13 @@ func (o *RequestCountService) Increase(ctx context.Context, remoteAddr string) {
 }
 
 func (o *RequestCountService) GetHourCount(dayHour string) (int64, error) {
-	redisKey := constant.RequestModuleHourCounterPrefix + dayHour + "_" + o.ModuleName
-	return redis.Int64(config.RedisDo("GET", redisKey))
+	redisKey := o.hourPrefix + dayHour + "_" + o.ModuleName
+	return Counters.Get(redisKey)
 }
 
 func (o *RequestCountService) GetDayCount(day string) (int64, error) {
-	redisKey := constant.RequestModuleCounterPrefix + day + "_" + o.ModuleName
-	return redis.Int64(config.RedisDo("GET", redisKey))
+	redisKey := o.dayPrefix + day + "_" + o.ModuleName
+	return Counters.Get(redisKey)
 }
 
 func (o *APPCountService) Increase() {
@@ -242,10 +278,10 @@ func (o *APPCountService) Increase() {
 
 func (o *APPCountService) GetHourCount(dayHour string) (int64, error) {
 	redisKey := constant.AccessControlAppIDHourTotalCallPrefix + dayHour + "_" + o.AppID
-	return redis.Int64(config.RedisDo("GET", redisKey))
+	return Counters.Get(redisKey)
 }
 
 func (o *APPCountService) GetDayCount(day string) (int64, error) {
 	redisKey := constant.AccessControlAppIDTotalCallPrefix + day + "_" + o.AppID
-	return redis.Int64(config.RedisDo("GET", redisKey))
+	return Counters.Get(redisKey)
 }
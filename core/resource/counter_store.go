@@ -0,0 +1,216 @@
+package resource
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+)
+
+// CounterStore 计数存储
+// 配置redis时集群共享计数，否则计数仅保存在本节点内存
+type CounterStore interface {
+	// IncrBy 累加计数并设置过期时间，返回累加后的值
+	IncrBy(key string, value int64, expire time.Duration) (int64, error)
+	// Get 获取计数，不存在时返回0
+	Get(key string) (int64, error)
+	// Consume 原子地为多个计数窗口各累加1
+	// 任一窗口累加后超过上限时均不累加，exceeded为该窗口下标，否则为-1
+	// counts为各窗口累加后（超限时为当前）的计数
+	Consume(windows []CounterWindow) (counts []int64, exceeded int, err error)
+}
+
+// CounterWindow 带上限的计数窗口
+type CounterWindow struct {
+	Key      string
+	Limit    int64     //上限，0为不限制
+	ExpireAt time.Time //计数过期时间
+}
+
+var Counters CounterStore
+
+// 按配置创建计数存储
+func NewCounterStore() CounterStore {
+	if config.RedisEnabled() {
+		return &redisCounterStore{}
+	}
+	return NewMemoryCounterStore(time.Minute)
+}
+
+// redis计数存储
+type redisCounterStore struct{}
+
+func (s *redisCounterStore) IncrBy(key string, value int64, expire time.Duration) (int64, error) {
+	var count int64
+	var err error
+	if perr := config.RedisPipeline(
+		func(c redis.Conn) {
+			c.Send("INCRBY", key, value)
+			c.Send("EXPIRE", key, int64(expire/time.Second))
+			if err = c.Flush(); err != nil {
+				return
+			}
+			count, err = redis.Int64(c.Receive())
+			c.Receive()
+		}); perr != nil {
+		return 0, perr
+	}
+	return count, err
+}
+
+func (s *redisCounterStore) Get(key string) (int64, error) {
+	count, err := redis.Int64(config.RedisDo("GET", key))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return count, err
+}
+
+// 先检查全部窗口，均未超限时再累加，KEYS为计数key，ARGV依次为各窗口的上限和过期时间戳
+var consumeScript = redis.NewScript(-1, `
+local n = #KEYS
+local counts = {}
+for i = 1, n do
+	local count = tonumber(redis.call("GET", KEYS[i]) or "0")
+	local limit = tonumber(ARGV[2*i-1])
+	if limit > 0 and count >= limit then
+		counts[i] = count
+		for j = i+1, n do
+			counts[j] = tonumber(redis.call("GET", KEYS[j]) or "0")
+		end
+		return {i, counts}
+	end
+	counts[i] = count
+end
+for i = 1, n do
+	counts[i] = redis.call("INCR", KEYS[i])
+	if counts[i] == 1 then
+		redis.call("EXPIREAT", KEYS[i], ARGV[2*i])
+	end
+end
+return {0, counts}
+`)
+
+func (s *redisCounterStore) Consume(windows []CounterWindow) ([]int64, int, error) {
+	args := make([]interface{}, 0, 1+3*len(windows))
+	args = append(args, len(windows))
+	for _, w := range windows {
+		args = append(args, w.Key)
+	}
+	for _, w := range windows {
+		args = append(args, w.Limit, w.ExpireAt.Unix())
+	}
+	var reply []interface{}
+	var err error
+	if perr := config.RedisPipeline(
+		func(c redis.Conn) {
+			reply, err = redis.Values(consumeScript.Do(c, args...))
+		}); perr != nil {
+		return nil, -1, perr
+	}
+	if err != nil {
+		return nil, -1, err
+	}
+	if len(reply) != 2 {
+		return nil, -1, errors.New("unexpected consume reply")
+	}
+	exceeded, err := redis.Int(reply[0], nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	counts, err := redis.Int64s(reply[1], nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	return counts, exceeded - 1, nil
+}
+
+// 内存计数存储
+type memoryCounterStore struct {
+	sync.Mutex
+	items map[string]*memoryCounter
+}
+
+type memoryCounter struct {
+	value    int64
+	expireAt time.Time
+}
+
+// 创建内存计数存储，按cleanInterval定时清理过期计数
+func NewMemoryCounterStore(cleanInterval time.Duration) CounterStore {
+	s := &memoryCounterStore{items: map[string]*memoryCounter{}}
+	go func() {
+		for {
+			time.Sleep(cleanInterval)
+			s.cleanup()
+		}
+	}()
+	return s
+}
+
+func (s *memoryCounterStore) IncrBy(key string, value int64, expire time.Duration) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	item, ok := s.items[key]
+	if !ok || now.After(item.expireAt) {
+		item = &memoryCounter{}
+		s.items[key] = item
+	}
+	item.value += value
+	item.expireAt = now.Add(expire)
+	return item.value, nil
+}
+
+func (s *memoryCounterStore) Get(key string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	item, ok := s.items[key]
+	if !ok || time.Now().After(item.expireAt) {
+		return 0, nil
+	}
+	return item.value, nil
+}
+
+func (s *memoryCounterStore) Consume(windows []CounterWindow) ([]int64, int, error) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	counts := make([]int64, len(windows))
+	exceeded := -1
+	for i, w := range windows {
+		if item, ok := s.items[w.Key]; ok && now.Before(item.expireAt) {
+			counts[i] = item.value
+		}
+		if exceeded < 0 && w.Limit > 0 && counts[i] >= w.Limit {
+			exceeded = i
+		}
+	}
+	if exceeded >= 0 {
+		return counts, exceeded, nil
+	}
+	for i, w := range windows {
+		item, ok := s.items[w.Key]
+		if !ok || !now.Before(item.expireAt) {
+			item = &memoryCounter{expireAt: w.ExpireAt}
+			s.items[w.Key] = item
+		}
+		item.value++
+		counts[i] = item.value
+	}
+	return counts, -1, nil
+}
+
+func (s *memoryCounterStore) cleanup() {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for key, item := range s.items {
+		if now.After(item.expireAt) {
+			delete(s.items, key)
+		}
+	}
+}
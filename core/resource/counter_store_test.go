@@ -0,0 +1,74 @@
+package resource
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryCounterStoreConsume(t *testing.T) {
+	expireAt := time.Now().Add(time.Hour)
+	windows := func(hourLimit, dayLimit int64) []CounterWindow {
+		return []CounterWindow{
+			{Key: "hour", Limit: hourLimit, ExpireAt: expireAt},
+			{Key: "day", Limit: dayLimit, ExpireAt: expireAt},
+		}
+	}
+	tests := []struct {
+		name         string
+		calls        int
+		hour, day    int64
+		wantCounts   []int64
+		wantExceeded int
+	}{
+		{name: "unlimited", calls: 3, wantCounts: []int64{3, 3}, wantExceeded: -1},
+		{name: "within limit", calls: 2, hour: 2, day: 5, wantCounts: []int64{2, 2}, wantExceeded: -1},
+		{name: "first window exceeded", calls: 3, hour: 2, day: 5, wantCounts: []int64{2, 2}, wantExceeded: 0},
+		{name: "second window exceeded", calls: 4, hour: 5, day: 3, wantCounts: []int64{3, 3}, wantExceeded: 1},
+		{name: "both exceeded reports first", calls: 2, hour: 1, day: 1, wantCounts: []int64{1, 1}, wantExceeded: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &memoryCounterStore{items: map[string]*memoryCounter{}}
+			var counts []int64
+			var exceeded int
+			for i := 0; i < tt.calls; i++ {
+				var err error
+				if counts, exceeded, err = s.Consume(windows(tt.hour, tt.day)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if exceeded != tt.wantExceeded || !reflect.DeepEqual(counts, tt.wantCounts) {
+				t.Errorf("got counts %v exceeded %d, want %v %d", counts, exceeded, tt.wantCounts, tt.wantExceeded)
+			}
+		})
+	}
+}
+
+func TestMemoryCounterStoreConsumeExpired(t *testing.T) {
+	s := &memoryCounterStore{items: map[string]*memoryCounter{}}
+	window := []CounterWindow{{Key: "k", Limit: 1, ExpireAt: time.Now().Add(-time.Second)}}
+	// 已过期的计数不计入上限，每次重新从1开始
+	for i := 0; i < 3; i++ {
+		counts, exceeded, err := s.Consume(window)
+		if err != nil || exceeded != -1 || counts[0] != 1 {
+			t.Fatalf("call %d: counts %v exceeded %d err %v", i, counts, exceeded, err)
+		}
+	}
+}
+
+func TestMemoryCounterStoreIncrBy(t *testing.T) {
+	s := &memoryCounterStore{items: map[string]*memoryCounter{}}
+	if v, _ := s.IncrBy("k", 2, time.Minute); v != 2 {
+		t.Fatalf("IncrBy = %d, want 2", v)
+	}
+	if v, _ := s.IncrBy("k", 3, time.Minute); v != 5 {
+		t.Fatalf("IncrBy = %d, want 5", v)
+	}
+	if v, _ := s.Get("k"); v != 5 {
+		t.Fatalf("Get = %d, want 5", v)
+	}
+	if v, _ := s.Get("missing"); v != 0 {
+		t.Fatalf("Get missing = %d, want 0", v)
+	}
+}
@@ -0,0 +1,198 @@
+package resource
+
+import (
+	"sync"
+	"time"
+)
+
+var Events *EventBus
+
+// 事件类型
+const (
+	EventUpstreamUp   = "upstream_up"   //节点探活恢复
+	EventUpstreamDown = "upstream_down" //节点探活失败
+	EventNodeForbid   = "node_forbid"   //节点关闭流量
+	EventNodeDrain    = "node_drain"    //节点排空流量
+	EventNodeOpen     = "node_open"     //节点恢复流量
+	EventConfigReload = "config_reload" //刷新配置有变更或失败
+	EventLimitTrip    = "limit_trip"    //限流、配额或并发隔离拒绝请求
+)
+
+const (
+	// 保留最近的事件数，用于订阅时补发
+	eventHistorySize = 200
+	// 每个订阅者的缓冲，消费过慢时断开订阅，由客户端重连补发
+	eventSubscriberBuffer = 64
+	// 同一限流目标的事件合并间隔，也是补发合并计数的周期
+	eventThrottleInterval = time.Second
+	// 限流合并状态的最大key数，超出时补发合并计数后清空
+	eventThrottleMaxKeys = 10000
+)
+
+// Event 网关事件
+type Event struct {
+	ID      int64     `json:"id"`
+	Type    string    `json:"type"`
+	Module  string    `json:"module"`
+	Target  string    `json:"target"` //节点地址、app_id或客户端ip
+	Message string    `json:"message"`
+	Count   int64     `json:"count"` //合并的事件数，限流事件为距上次事件的触发次数
+	Time    time.Time `json:"time"`
+}
+
+// EventSubscriber 事件订阅者，C关闭表示订阅已断开
+type EventSubscriber struct {
+	C chan *Event
+}
+
+// EventBus 本节点的事件总线
+// 发布不阻塞，订阅者缓冲已满时断开该订阅
+type EventBus struct {
+	sync.Mutex
+	nextID      int64
+	history     []*Event
+	subscribers map[*EventSubscriber]struct{}
+	throttle    map[string]*eventThrottle
+}
+
+// 限流事件的合并状态，pending为最近一次被合并的事件
+type eventThrottle struct {
+	last       time.Time
+	suppressed int64
+	pending    *Event
+}
+
+// 创建事件总线，定时补发突发结束后未发布的合并计数
+func NewEventBus() *EventBus {
+	b := &EventBus{
+		subscribers: map[*EventSubscriber]struct{}{},
+		throttle:    map[string]*eventThrottle{},
+	}
+	go func() {
+		for {
+			time.Sleep(eventThrottleInterval)
+			b.Lock()
+			b.flushThrottled(time.Now(), false)
+			b.Unlock()
+		}
+	}()
+	return b
+}
+
+// Publish 发布事件并分配事件id
+func (b *EventBus) Publish(e *Event) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.publish(e)
+}
+
+func (b *EventBus) publish(e *Event) {
+	b.nextID++
+	e.ID = b.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Count == 0 {
+		e.Count = 1
+	}
+	b.history = append(b.history, e)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+	for sub := range b.subscribers {
+		select {
+		case sub.C <- e:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// PublishThrottled 按key合并高频事件，同一key每秒最多发布一次，Count为合并的次数
+// 间隔内被合并的事件在下次触发或间隔到期时补发，计数不会丢失
+func (b *EventBus) PublishThrottled(key string, e *Event) {
+	if b == nil {
+		return
+	}
+	now := time.Now()
+	b.Lock()
+	defer b.Unlock()
+	t, ok := b.throttle[key]
+	if !ok {
+		if len(b.throttle) >= eventThrottleMaxKeys {
+			b.flushThrottled(now, true)
+		}
+		t = &eventThrottle{}
+		b.throttle[key] = t
+	}
+	e.Time = now
+	if now.Sub(t.last) < eventThrottleInterval {
+		t.suppressed++
+		t.pending = e
+		return
+	}
+	e.Count = t.suppressed + 1
+	t.last = now
+	t.suppressed = 0
+	t.pending = nil
+	b.publish(e)
+}
+
+// 补发间隔已到期的合并计数，并清理空闲的合并状态，all为true时补发全部并清空
+func (b *EventBus) flushThrottled(now time.Time, all bool) {
+	for key, t := range b.throttle {
+		if !all && now.Sub(t.last) < eventThrottleInterval {
+			continue
+		}
+		if t.suppressed > 0 {
+			t.pending.Count = t.suppressed
+			b.publish(t.pending)
+			t.last = now
+			t.suppressed = 0
+			t.pending = nil
+			if !all {
+				continue
+			}
+		}
+		delete(b.throttle, key)
+	}
+}
+
+// Subscribe 订阅事件，并返回id大于lastID的最近事件用于补发，lastID小于0时不补发
+func (b *EventBus) Subscribe(lastID int64) (*EventSubscriber, []*Event) {
+	sub := &EventSubscriber{C: make(chan *Event, eventSubscriberBuffer)}
+	if b == nil {
+		close(sub.C)
+		return sub, nil
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.subscribers[sub] = struct{}{}
+	if lastID < 0 {
+		return sub, nil
+	}
+	replay := []*Event{}
+	for _, e := range b.history {
+		if e.ID > lastID {
+			replay = append(replay, e)
+		}
+	}
+	return sub, replay
+}
+
+// Unsubscribe 取消订阅
+func (b *EventBus) Unsubscribe(sub *EventSubscriber) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.C)
+	}
+}
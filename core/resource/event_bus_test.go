@@ -0,0 +1,156 @@
+package resource
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// 不启动定时补发，由测试控制flushThrottled的时间
+func newTestEventBus() *EventBus {
+	return &EventBus{
+		subscribers: map[*EventSubscriber]struct{}{},
+		throttle:    map[string]*eventThrottle{},
+	}
+}
+
+func eventCounts(events []*Event) []int64 {
+	counts := []int64{}
+	for _, e := range events {
+		counts = append(counts, e.Count)
+	}
+	return counts
+}
+
+func TestEventBusPublishThrottled(t *testing.T) {
+	tests := []struct {
+		name       string
+		bursts     map[string]int //key -> 间隔内连续发布的次数
+		flushAfter time.Duration
+		flushAll   bool
+		published  []int64 //补发前已发布事件的Count
+		flushed    []int64 //补发的Count
+		keysLeft   int
+	}{
+		{
+			name:       "single event",
+			bursts:     map[string]int{"a": 1},
+			flushAfter: 2 * eventThrottleInterval,
+			published:  []int64{1},
+			flushed:    []int64{},
+		},
+		{
+			name:       "burst is merged and flushed",
+			bursts:     map[string]int{"a": 5},
+			flushAfter: 2 * eventThrottleInterval,
+			published:  []int64{1},
+			flushed:    []int64{4},
+			keysLeft:   1,
+		},
+		{
+			name:       "flush waits for interval",
+			bursts:     map[string]int{"a": 3},
+			flushAfter: 0,
+			published:  []int64{1},
+			flushed:    []int64{},
+			keysLeft:   1,
+		},
+		{
+			name:       "flush all publishes pending and clears state",
+			bursts:     map[string]int{"a": 3, "b": 1},
+			flushAfter: 0,
+			flushAll:   true,
+			published:  []int64{1, 1},
+			flushed:    []int64{2},
+		},
+		{
+			name:       "keys are throttled separately",
+			bursts:     map[string]int{"a": 3, "b": 2},
+			flushAfter: 2 * eventThrottleInterval,
+			published:  []int64{1, 1},
+			flushed:    []int64{1, 2},
+			keysLeft:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestEventBus()
+			for key, n := range tt.bursts {
+				for i := 0; i < n; i++ {
+					b.PublishThrottled(key, &Event{Type: EventLimitTrip, Target: key})
+				}
+			}
+			published := eventCounts(b.history)
+			b.flushThrottled(time.Now().Add(tt.flushAfter), tt.flushAll)
+			flushed := eventCounts(b.history[len(published):])
+			sort.Slice(flushed, func(i, j int) bool { return flushed[i] < flushed[j] })
+			if !reflect.DeepEqual(published, tt.published) {
+				t.Errorf("published counts %v, want %v", published, tt.published)
+			}
+			if !reflect.DeepEqual(flushed, tt.flushed) {
+				t.Errorf("flushed counts %v, want %v", flushed, tt.flushed)
+			}
+			if len(b.throttle) != tt.keysLeft {
+				t.Errorf("%d throttle keys left, want %d", len(b.throttle), tt.keysLeft)
+			}
+		})
+	}
+}
+
+// 补发后的下一次补发清理空闲状态
+func TestEventBusFlushThrottledCleanup(t *testing.T) {
+	b := newTestEventBus()
+	b.PublishThrottled("a", &Event{})
+	b.PublishThrottled("a", &Event{})
+	now := time.Now()
+	b.flushThrottled(now.Add(2*eventThrottleInterval), false)
+	if len(b.history) != 2 || len(b.throttle) != 1 {
+		t.Fatalf("after flush: %d events, %d keys", len(b.history), len(b.throttle))
+	}
+	b.flushThrottled(now.Add(4*eventThrottleInterval), false)
+	if len(b.history) != 2 || len(b.throttle) != 0 {
+		t.Fatalf("after cleanup: %d events, %d keys", len(b.history), len(b.throttle))
+	}
+}
+
+func TestEventBusSubscribe(t *testing.T) {
+	b := newTestEventBus()
+	for i := 0; i < 3; i++ {
+		b.Publish(&Event{Type: EventConfigReload})
+	}
+	tests := []struct {
+		name   string
+		lastID int64
+		replay int
+	}{
+		{name: "no replay", lastID: -1, replay: 0},
+		{name: "replay all", lastID: 0, replay: 3},
+		{name: "replay after id", lastID: 2, replay: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay := b.Subscribe(tt.lastID)
+			defer b.Unsubscribe(sub)
+			if len(replay) != tt.replay {
+				t.Errorf("replay %d events, want %d", len(replay), tt.replay)
+			}
+		})
+	}
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	b := newTestEventBus()
+	sub, _ := b.Subscribe(-1)
+	for i := 0; i <= eventSubscriberBuffer; i++ {
+		b.Publish(&Event{})
+	}
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != eventSubscriberBuffer || len(b.subscribers) != 0 {
+		t.Fatalf("received %d events, %d subscribers left", n, len(b.subscribers))
+	}
+}
+
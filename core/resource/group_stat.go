@@ -0,0 +1,70 @@
+package resource
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var GroupStats *GroupStatManager
+
+// 上游分组统计管理器
+// 统计本节点启动以来各分组及流量镜像的请求数、5xx错误数及耗时
+type GroupStatManager struct {
+	sync.RWMutex
+	stats map[string]*GroupStat
+}
+
+// 上游分组统计
+type GroupStat struct {
+	Requests     int64
+	Errors       int64
+	LatencyTotal int64 //单位ms
+	Dropped      int64 //丢弃数，用于流量镜像
+}
+
+func NewGroupStatManager() *GroupStatManager {
+	return &GroupStatManager{stats: map[string]*GroupStat{}}
+}
+
+// 获取分组统计，不存在就创建一个
+// @param name 模块名:分组名
+func (m *GroupStatManager) GetGroupStat(name string) *GroupStat {
+	m.RLock()
+	stat, ok := m.stats[name]
+	m.RUnlock()
+	if ok {
+		return stat
+	}
+	m.Lock()
+	defer m.Unlock()
+	if stat, ok := m.stats[name]; ok {
+		return stat
+	}
+	stat = &GroupStat{}
+	m.stats[name] = stat
+	return stat
+}
+
+// 记录一次请求
+func (s *GroupStat) Record(status int, latency time.Duration) {
+	atomic.AddInt64(&s.Requests, 1)
+	atomic.AddInt64(&s.LatencyTotal, int64(latency/time.Millisecond))
+	if status >= 500 {
+		atomic.AddInt64(&s.Errors, 1)
+	}
+}
+
+// 记录一次丢弃
+func (s *GroupStat) RecordDrop() {
+	atomic.AddInt64(&s.Dropped, 1)
+}
+
+// 平均耗时，单位ms
+func (s *GroupStat) AvgLatency() int64 {
+	requests := atomic.LoadInt64(&s.Requests)
+	if requests == 0 {
+		return 0
+	}
+	return atomic.LoadInt64(&s.LatencyTotal) / requests
+}
@@ -0,0 +1,309 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/constant"
+)
+
+var Spans *SpanExporter
+
+// span导出方式
+const (
+	SpanExporterFile = "file"
+	SpanExporterOTLP = "otlp"
+)
+
+// span类型，取值同OTLP
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+)
+
+// span状态，取值同OTLP
+const (
+	SpanStatusUnset = 0
+	SpanStatusOK    = 1
+	SpanStatusError = 2
+)
+
+// Span 一个已结束的span
+type Span struct {
+	TraceID       string
+	SpanID        string
+	ParentSpanID  string
+	TraceState    string
+	Name          string
+	Kind          int
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	Status        int
+	StatusMessage string
+}
+
+// SpanExporter 批量导出span
+// span先进入队列，由后台协程按批次或间隔以OTLP/JSON格式写入文件或发送到collector
+type SpanExporter struct {
+	Dropped       int64 //队列满丢弃的span数，放在首位保证原子操作对齐
+	exporter      string
+	file          *os.File
+	endpoint      string
+	client        *http.Client
+	serviceName   string
+	hostName      string
+	batchSize     int
+	flushInterval time.Duration
+	queue         chan *Span
+	stop          chan struct{}
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewSpanExporter 按配置创建span导出器，未配置导出方式时返回nil
+func NewSpanExporter(conf *config.TraceConfig) (*SpanExporter, error) {
+	if conf == nil || conf.Exporter == "" {
+		return nil, nil
+	}
+	e := &SpanExporter{
+		exporter:      conf.Exporter,
+		serviceName:   conf.ServiceName,
+		batchSize:     conf.BatchSize,
+		flushInterval: time.Duration(conf.FlushInterval) * time.Millisecond,
+		queue:         make(chan *Span, constant.TraceQueueSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if e.serviceName == "" {
+		e.serviceName = constant.TraceDefaultServiceName
+	}
+	e.hostName, _ = os.Hostname()
+	if e.batchSize <= 0 {
+		e.batchSize = constant.TraceDefaultBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = constant.TraceDefaultFlushInterval * time.Millisecond
+	}
+	switch conf.Exporter {
+	case SpanExporterFile:
+		if conf.File == "" {
+			return nil, errors.New("trace.file required for file exporter")
+		}
+		file, err := os.OpenFile(conf.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, errors.Wrap(err, "open trace file")
+		}
+		e.file = file
+	case SpanExporterOTLP:
+		if conf.Endpoint == "" {
+			return nil, errors.New("trace.endpoint required for otlp exporter")
+		}
+		e.endpoint = conf.Endpoint
+		e.client = &http.Client{Timeout: 5 * time.Second}
+	default:
+		return nil, errors.New("trace.exporter must be file or otlp, got " + conf.Exporter)
+	}
+	go e.run()
+	return e, nil
+}
+
+// Export 加入导出队列，队列满时丢弃
+func (e *SpanExporter) Export(spans ...*Span) {
+	if e == nil {
+		return
+	}
+	for _, span := range spans {
+		select {
+		case e.queue <- span:
+		default:
+			atomic.AddInt64(&e.Dropped, 1)
+		}
+	}
+}
+
+// Close 导出队列中剩余的span并停止导出
+func (e *SpanExporter) Close() {
+	if e == nil {
+		return
+	}
+	e.closeOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+		if e.file != nil {
+			e.file.Close()
+		}
+	})
+}
+
+func (e *SpanExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, e.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.write(batch); err != nil {
+			config.SysLog.Warn("[trace export failed] [exporter:%s] [spans:%d] [err:%s]", e.exporter, len(batch), err.Error())
+		}
+		batch = make([]*Span, 0, e.batchSize)
+	}
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *SpanExporter) write(batch []*Span) error {
+	payload, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+	if e.file != nil {
+		_, err = e.file.Write(append(payload, '\n'))
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New("collector response " + resp.Status + ": " + string(body))
+	}
+	return nil
+}
+
+// OTLP/JSON编码，trace_id与span_id为16进制字符串，64位整数为字符串
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func (e *SpanExporter) encode(batch []*Span) *otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		spans = append(spans, otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			TraceState:        span.TraceState,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: span.Status, Message: span.StatusMessage},
+		})
+	}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes(map[string]interface{}{
+			"service.name": e.serviceName,
+			"host.name":    e.hostName,
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: constant.TraceDefaultServiceName},
+			Spans: spans,
+		}},
+	}}}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attributes))
+	for key, value := range attributes {
+		kv := otlpKeyValue{Key: key}
+		switch v := value.(type) {
+		case int:
+			s := strconv.Itoa(v)
+			kv.Value.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			kv.Value.IntValue = &s
+		case bool:
+			kv.Value.BoolValue = &v
+		case string:
+			kv.Value.StringValue = &v
+		default:
+			continue
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs
+}
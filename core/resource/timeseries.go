@@ -0,0 +1,222 @@
+package resource
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/constant"
+)
+
+// 时间序列粒度
+const (
+	ResolutionMinute = "minute"
+	ResolutionHour   = "hour"
+	ResolutionDay    = "day"
+)
+
+// Resolutions 全部粒度，写入时每个粒度各累加一次
+var Resolutions = []string{ResolutionMinute, ResolutionHour, ResolutionDay}
+
+// 单次查询最多返回的点数
+const timeSeriesMaxPoints = 2000
+
+// redis单次MGET的key数
+const timeSeriesBatch = 500
+
+var TimeSeries TimeSeriesStore
+
+// TimeSeriesStore 流量统计时间序列存储
+// 每次写入按分钟、小时、天三个粒度分别累加，各粒度按配置的天数保留
+type TimeSeriesStore interface {
+	// Add 累加series在at所在时间点的计数
+	Add(series string, at time.Time, value int64) error
+	// Range 查询[from, to]范围内各时间点的计数，没有数据的时间点为0
+	Range(series, resolution string, from, to time.Time) ([]*TimeSeriesPoint, error)
+}
+
+// TimeSeriesPoint 时间序列中的一个点
+type TimeSeriesPoint struct {
+	Time  int64 `json:"time"` //时间点开始的unix时间戳
+	Value int64 `json:"value"`
+}
+
+// ModuleSeries 模块请求数的时间序列名
+func ModuleSeries(moduleName string) string {
+	return "module_" + moduleName
+}
+
+// GroupSeries 上游分组请求数的时间序列名
+func GroupSeries(moduleName, group string) string {
+	return "group_" + moduleName + ":" + group
+}
+
+// APPSeries 租户请求数的时间序列名
+func APPSeries(appID string) string {
+	return "app_" + appID
+}
+
+// 按配置创建时间序列存储
+func NewTimeSeriesStore() TimeSeriesStore {
+	if config.RedisEnabled() {
+		return &redisTimeSeries{}
+	}
+	return &memoryTimeSeries{store: NewMemoryCounterStore(time.Minute)}
+}
+
+// 时间点所在的时间段开始时间，按time_location划分
+func truncateTime(resolution string, t time.Time) time.Time {
+	t = t.In(config.TimeLocation)
+	switch resolution {
+	case ResolutionMinute:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	case ResolutionHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
+
+// 下一个时间段的开始时间
+func nextTime(resolution string, t time.Time) time.Time {
+	switch resolution {
+	case ResolutionMinute:
+		return t.Add(time.Minute)
+	case ResolutionHour:
+		return t.Add(time.Hour)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+func timeSeriesKey(series, resolution string, start time.Time) string {
+	var stamp string
+	switch resolution {
+	case ResolutionMinute:
+		stamp = start.Format("200601021504")
+	case ResolutionHour:
+		stamp = start.Format("2006010215")
+	default:
+		stamp = start.Format("20060102")
+	}
+	return constant.TimeSeriesPrefix + series + "_" + resolution + "_" + stamp
+}
+
+// 时间段结束后按保留天数过期
+func timeSeriesExpire(resolution string, start time.Time) time.Duration {
+	minute, hour, day := config.BaseConf.StatsRetention()
+	days := day
+	switch resolution {
+	case ResolutionMinute:
+		days = minute
+	case ResolutionHour:
+		days = hour
+	}
+	return time.Until(nextTime(resolution, start)) + time.Duration(days)*24*time.Hour
+}
+
+// 查询范围内各时间段的开始时间
+func timeSeriesStarts(resolution string, from, to time.Time) ([]time.Time, error) {
+	switch resolution {
+	case ResolutionMinute, ResolutionHour, ResolutionDay:
+	default:
+		return nil, errors.Errorf("unknown resolution %s, want minute, hour or day", resolution)
+	}
+	if to.Before(from) {
+		return nil, errors.New("end time is before start time")
+	}
+	starts := []time.Time{}
+	for t := truncateTime(resolution, from); !t.After(to); t = nextTime(resolution, t) {
+		if len(starts) >= timeSeriesMaxPoints {
+			return nil, errors.Errorf("range has more than %d points, use a coarser resolution", timeSeriesMaxPoints)
+		}
+		starts = append(starts, t)
+	}
+	return starts, nil
+}
+
+// redis时间序列，每个时间点一个计数key
+type redisTimeSeries struct{}
+
+func (s *redisTimeSeries) Add(series string, at time.Time, value int64) error {
+	var err error
+	if perr := config.RedisPipeline(
+		func(c redis.Conn) {
+			for _, resolution := range Resolutions {
+				start := truncateTime(resolution, at)
+				key := timeSeriesKey(series, resolution, start)
+				c.Send("INCRBY", key, value)
+				c.Send("EXPIRE", key, int64(timeSeriesExpire(resolution, start)/time.Second))
+			}
+			if err = c.Flush(); err != nil {
+				return
+			}
+			for range Resolutions {
+				c.Receive()
+				c.Receive()
+			}
+		}); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func (s *redisTimeSeries) Range(series, resolution string, from, to time.Time) ([]*TimeSeriesPoint, error) {
+	starts, err := timeSeriesStarts(resolution, from, to)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]*TimeSeriesPoint, 0, len(starts))
+	for i := 0; i < len(starts); i += timeSeriesBatch {
+		end := i + timeSeriesBatch
+		if end > len(starts) {
+			end = len(starts)
+		}
+		keys := make([]interface{}, 0, end-i)
+		for _, start := range starts[i:end] {
+			keys = append(keys, timeSeriesKey(series, resolution, start))
+		}
+		values, err := redis.Values(config.RedisDo("MGET", keys...))
+		if err != nil {
+			return nil, err
+		}
+		for j, start := range starts[i:end] {
+			value, _ := redis.Int64(values[j], nil)
+			points = append(points, &TimeSeriesPoint{Time: start.Unix(), Value: value})
+		}
+	}
+	return points, nil
+}
+
+// 内存时间序列，未配置redis时使用，仅保存本节点数据
+type memoryTimeSeries struct {
+	store CounterStore
+}
+
+func (s *memoryTimeSeries) Add(series string, at time.Time, value int64) error {
+	for _, resolution := range Resolutions {
+		start := truncateTime(resolution, at)
+		if _, err := s.store.IncrBy(timeSeriesKey(series, resolution, start), value, timeSeriesExpire(resolution, start)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryTimeSeries) Range(series, resolution string, from, to time.Time) ([]*TimeSeriesPoint, error) {
+	starts, err := timeSeriesStarts(resolution, from, to)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]*TimeSeriesPoint, 0, len(starts))
+	for _, start := range starts {
+		value, err := s.store.Get(timeSeriesKey(series, resolution, start))
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, &TimeSeriesPoint{Time: start.Unix(), Value: value})
+	}
+	return points, nil
+}
@@ -0,0 +1,226 @@
+package resource
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var UpstreamStats *UpstreamStatManager
+
+// 滚动统计的时间片长度及数量，最长可统计15分钟
+const (
+	upstreamStatSlot  = 10 * time.Second
+	upstreamStatSlots = 90
+)
+
+// 耗时分布的桶上限，单位ms，超出最后一个桶的请求计入溢出桶
+var upstreamLatencyBounds = []int64{1, 2, 5, 10, 20, 50, 100, 200, 300, 500, 1000, 2000, 5000, 10000, 30000}
+
+// 上游节点统计管理器
+// 按模块或分组运行时key及节点地址统计本节点转发的请求，模块重载后继续累计
+type UpstreamStatManager struct {
+	sync.RWMutex
+	stats map[string]*UpstreamStat
+}
+
+// 上游节点滚动统计
+type UpstreamStat struct {
+	sync.Mutex
+	created time.Time
+	slots   [upstreamStatSlots]upstreamStatSlotData
+}
+
+// 一个时间片内的统计
+type upstreamStatSlotData struct {
+	epoch     int64 //时间片序号，与当前不一致时视为过期
+	requests  int64
+	status    [6]int64 //0为未收到响应，1-5为1xx-5xx
+	latency   int64    //耗时合计，单位ms
+	histogram [16]int64
+}
+
+// UpstreamStatSnapshot 节点在统计窗口内的数据
+type UpstreamStatSnapshot struct {
+	Window     string  `json:"window"`
+	Requests   int64   `json:"requests"`
+	QPS        float64 `json:"qps"`
+	Status2xx  int64   `json:"status_2xx"`
+	Status3xx  int64   `json:"status_3xx"`
+	Status4xx  int64   `json:"status_4xx"`
+	Status5xx  int64   `json:"status_5xx"`
+	Failures   int64   `json:"failures"`   //连接失败、超时等未收到响应的请求
+	ErrorRate  float64 `json:"error_rate"` //5xx及未收到响应的比例，0-100
+	AvgLatency int64   `json:"avg_latency"`
+	P50        int64   `json:"p50"`
+	P95        int64   `json:"p95"`
+	P99        int64   `json:"p99"`
+}
+
+func NewUpstreamStatManager() *UpstreamStatManager {
+	return &UpstreamStatManager{stats: map[string]*UpstreamStat{}}
+}
+
+// 获取节点统计，不存在就创建一个
+func (m *UpstreamStatManager) GetUpstreamStat(key, addr string) *UpstreamStat {
+	name := activeRequestKey(key, addr)
+	m.RLock()
+	stat, ok := m.stats[name]
+	m.RUnlock()
+	if ok {
+		return stat
+	}
+	m.Lock()
+	defer m.Unlock()
+	if stat, ok := m.stats[name]; ok {
+		return stat
+	}
+	stat = &UpstreamStat{created: time.Now()}
+	m.stats[name] = stat
+	return stat
+}
+
+// 记录一次请求，status为0表示未收到上游响应
+func (s *UpstreamStat) Record(status int, latency time.Duration) {
+	now := time.Now()
+	epoch := now.UnixNano() / int64(upstreamStatSlot)
+	ms := int64(latency / time.Millisecond)
+	class := status / 100
+	if class < 1 || class > 5 {
+		class = 0
+	}
+	s.Lock()
+	defer s.Unlock()
+	slot := &s.slots[epoch%upstreamStatSlots]
+	if slot.epoch != epoch {
+		*slot = upstreamStatSlotData{epoch: epoch}
+	}
+	slot.requests++
+	slot.status[class]++
+	slot.latency += ms
+	slot.histogram[latencyBucket(ms)]++
+}
+
+func latencyBucket(ms int64) int {
+	return sort.Search(len(upstreamLatencyBounds), func(i int) bool {
+		return ms <= upstreamLatencyBounds[i]
+	})
+}
+
+// Snapshot 最近window时间内的统计，window按时间片取整，最长15分钟
+func (s *UpstreamStat) Snapshot(window time.Duration) *UpstreamStatSnapshot {
+	n := upstreamStatWindowSlots(window)
+	total := upstreamStatSlotData{}
+	s.sum(n, &total)
+	return newUpstreamStatSnapshot(&total, n, s.created)
+}
+
+// 统计窗口包含的时间片数
+func upstreamStatWindowSlots(window time.Duration) int64 {
+	n := int64(window / upstreamStatSlot)
+	if n < 1 {
+		n = 1
+	}
+	if n > upstreamStatSlots {
+		n = upstreamStatSlots
+	}
+	return n
+}
+
+// 将最近n个时间片的数据累加到total
+func (s *UpstreamStat) sum(n int64, total *upstreamStatSlotData) {
+	current := time.Now().UnixNano() / int64(upstreamStatSlot)
+	s.Lock()
+	defer s.Unlock()
+	for i := int64(0); i < n; i++ {
+		slot := &s.slots[(current-i)%upstreamStatSlots]
+		if slot.epoch != current-i {
+			continue
+		}
+		total.requests += slot.requests
+		total.latency += slot.latency
+		for j := range slot.status {
+			total.status[j] += slot.status[j]
+		}
+		for j := range slot.histogram {
+			total.histogram[j] += slot.histogram[j]
+		}
+	}
+}
+
+func newUpstreamStatSnapshot(total *upstreamStatSlotData, n int64, created time.Time) *UpstreamStatSnapshot {
+	//当前时间片未结束，按已经过的时间计算qps，节点统计创建不足一个窗口时按创建以来的时间计算
+	elapsed := time.Duration(n-1)*upstreamStatSlot + time.Duration(time.Now().UnixNano()%int64(upstreamStatSlot))
+	if since := time.Since(created); since < elapsed {
+		elapsed = since
+	}
+	snapshot := &UpstreamStatSnapshot{
+		Window:    (time.Duration(n) * upstreamStatSlot).String(),
+		Requests:  total.requests,
+		Status2xx: total.status[2],
+		Status3xx: total.status[3],
+		Status4xx: total.status[4],
+		Status5xx: total.status[5],
+		Failures:  total.status[0],
+	}
+	if total.requests == 0 {
+		return snapshot
+	}
+	snapshot.QPS = float64(total.requests) / elapsed.Seconds()
+	snapshot.ErrorRate = float64(total.status[5]+total.status[0]) * 100 / float64(total.requests)
+	snapshot.AvgLatency = total.latency / total.requests
+	snapshot.P50 = latencyPercentile(total.histogram, total.requests, 0.50)
+	snapshot.P95 = latencyPercentile(total.histogram, total.requests, 0.95)
+	snapshot.P99 = latencyPercentile(total.histogram, total.requests, 0.99)
+	return snapshot
+}
+
+// 按耗时分布估算分位数，返回所在桶的上限，溢出桶返回最后一个桶上限
+func latencyPercentile(histogram [16]int64, requests int64, p float64) int64 {
+	rank := int64(float64(requests)*p + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var count int64
+	for i, n := range histogram {
+		count += n
+		if count >= rank {
+			if i >= len(upstreamLatencyBounds) {
+				break
+			}
+			return upstreamLatencyBounds[i]
+		}
+	}
+	return upstreamLatencyBounds[len(upstreamLatencyBounds)-1]
+}
+
+// Snapshot 节点最近window时间内的统计，没有统计时返回空数据
+func (m *UpstreamStatManager) Snapshot(key, addr string, window time.Duration) *UpstreamStatSnapshot {
+	m.RLock()
+	stat, ok := m.stats[activeRequestKey(key, addr)]
+	m.RUnlock()
+	if !ok {
+		stat = &UpstreamStat{}
+	}
+	return stat.Snapshot(window)
+}
+
+// MergedSnapshot 多个节点合并后最近window时间内的统计，用于按模块整体计算错误率及耗时分位数
+func (m *UpstreamStatManager) MergedSnapshot(key string, addrs []string, window time.Duration) *UpstreamStatSnapshot {
+	n := upstreamStatWindowSlots(window)
+	total := upstreamStatSlotData{}
+	created := time.Now()
+	for _, addr := range addrs {
+		m.RLock()
+		stat, ok := m.stats[activeRequestKey(key, addr)]
+		m.RUnlock()
+		if !ok {
+			continue
+		}
+		stat.sum(n, &total)
+		if stat.created.Before(created) {
+			created = stat.created
+		}
+	}
+	return newUpstreamStatSnapshot(&total, n, created)
+}
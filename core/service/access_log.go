@@ -0,0 +1,331 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"git.baijiahulian.com/plt/go-common/util/log"
+
+	"gatekeeper/config"
+	"gatekeeper/core/resource"
+)
+
+// 访问日志格式
+const (
+	AccessLogFormatJSON   = "json"
+	AccessLogFormatLogfmt = "logfmt"
+	AccessLogFormatText   = "text" //[key:value] 形式的文本
+)
+
+// AccessLogFields 访问日志全部字段，未配置access_log_fields时按此顺序输出
+var AccessLogFields = []string{
+	"time", "request_id", "trace_id", "client_ip", "method", "uri", "status", "bytes_sent", "request_length", "proc_time",
+	"module", "app_id", "upstream_group", "upstream_addr", "upstream_status", "upstream_latency",
+	"cache", "reject_reason", "error", "user_agent", "referer",
+}
+
+// AccessLogEntry 一次请求的访问日志
+type AccessLogEntry struct {
+	Time            time.Time
+	RequestID       string
+	TraceID         string
+	ClientIP        string
+	Method          string
+	URI             string
+	Status          int
+	BytesSent       int
+	RequestLength   int64
+	ProcTime        time.Duration
+	Module          string
+	AppID           string
+	UpstreamGroup   string
+	UpstreamAddr    string
+	UpstreamStatus  int
+	UpstreamLatency time.Duration
+	Cache           string
+	RejectReason    string
+	Error           string
+	UserAgent       string
+	Referer         string
+}
+
+// 字段值，耗时以秒为单位
+func (e *AccessLogEntry) value(field string) interface{} {
+	switch field {
+	case "time":
+		return e.Time.In(config.TimeLocation).Format("2006-01-02T15:04:05.000Z07:00")
+	case "request_id":
+		return e.RequestID
+	case "trace_id":
+		return e.TraceID
+	case "client_ip":
+		return e.ClientIP
+	case "method":
+		return e.Method
+	case "uri":
+		return e.URI
+	case "status":
+		return e.Status
+	case "bytes_sent":
+		return e.BytesSent
+	case "request_length":
+		return e.RequestLength
+	case "proc_time":
+		return e.ProcTime.Seconds()
+	case "module":
+		return e.Module
+	case "app_id":
+		return e.AppID
+	case "upstream_group":
+		return e.UpstreamGroup
+	case "upstream_addr":
+		return e.UpstreamAddr
+	case "upstream_status":
+		return e.UpstreamStatus
+	case "upstream_latency":
+		return e.UpstreamLatency.Seconds()
+	case "cache":
+		return e.Cache
+	case "reject_reason":
+		return e.RejectReason
+	case "error":
+		return e.Error
+	case "user_agent":
+		return e.UserAgent
+	case "referer":
+		return e.Referer
+	}
+	return ""
+}
+
+// 访问日志输出，模块独立日志文件的logger按文件名复用
+type accessLogWriter struct {
+	format  string
+	fields  []string
+	mu      sync.Mutex
+	loggers map[string]log.Logger
+}
+
+var accessLog = &accessLogWriter{
+	format:  AccessLogFormatJSON,
+	fields:  AccessLogFields,
+	loggers: map[string]log.Logger{},
+}
+
+// InitAccessLog 按base配置初始化访问日志格式和字段
+func InitAccessLog() error {
+	format := config.BaseConf.AccessLogFormat
+	if format == "" {
+		format = AccessLogFormatJSON
+	}
+	if format != AccessLogFormatJSON && format != AccessLogFormatLogfmt && format != AccessLogFormatText {
+		return errors.New("access_log_format must be json, logfmt or text, got " + format)
+	}
+	fields := config.BaseConf.AccessLogFields
+	if len(fields) == 0 {
+		fields = AccessLogFields
+	}
+	for _, field := range fields {
+		if !isAccessLogField(field) {
+			return errors.New("unknown access_log_fields item " + field)
+		}
+	}
+	accessLog = &accessLogWriter{
+		format:  format,
+		fields:  fields,
+		loggers: map[string]log.Logger{},
+	}
+	return nil
+}
+
+func isAccessLogField(field string) bool {
+	for _, f := range AccessLogFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteAccessLog 输出访问日志
+// file为模块独立访问日志文件，为空时写入全局访问日志
+func WriteAccessLog(entry *AccessLogEntry, file string) {
+	accessLog.logger(file).Info("%s", accessLog.encode(entry))
+}
+
+func (w *accessLogWriter) logger(file string) log.Logger {
+	if file == "" {
+		return config.AccessLog
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if logger, ok := w.loggers[file]; ok {
+		return logger
+	}
+	//除文件名外沿用全局访问日志的切割配置
+	logConf := *config.BaseConf.AccessLog
+	logConf.Filename = file
+	logger := log.NewDefaultLogrus()
+	logger.SetOutput(&logConf)
+	logger.SetLevel(logConf.LogLevel)
+	w.loggers[file] = logger
+	return logger
+}
+
+func (w *accessLogWriter) encode(entry *AccessLogEntry) string {
+	buf := &bytes.Buffer{}
+	switch w.format {
+	case AccessLogFormatLogfmt:
+		for i, field := range w.fields {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(field)
+			buf.WriteByte('=')
+			buf.WriteString(logfmtValue(entry.value(field)))
+		}
+	case AccessLogFormatText:
+		for i, field := range w.fields {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString("[" + field + ":" + formatLogValue(entry.value(field)) + "]")
+		}
+	default:
+		//按字段顺序输出，不使用map
+		buf.WriteByte('{')
+		for i, field := range w.fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(field)
+			value, _ := json.Marshal(entry.value(field))
+			buf.Write(key)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+	}
+	return buf.String()
+}
+
+func formatLogValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', 6, 64)
+	}
+	return ""
+}
+
+// logfmt值，含空格、引号或=时加引号
+func logfmtValue(value interface{}) string {
+	s := formatLogValue(value)
+	if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// 上游转发信息，随请求context传递，由代理回调填充后输出到访问日志及链路
+type upstreamTrace struct {
+	key     string //模块或分组运行时key，用于统计节点进行中的请求
+	active  *int64
+	busy    bool //节点并发均已满，未请求上游
+	addr    string
+	start   time.Time
+	status  int
+	latency time.Duration
+	err     string
+	reject  string
+	span    *Span
+}
+
+type upstreamTraceKey struct{}
+
+// 从请求context获取上游转发信息，不存在时返回nil
+func upstreamTraceFromContext(ctx context.Context) *upstreamTrace {
+	trace, _ := ctx.Value(upstreamTraceKey{}).(*upstreamTrace)
+	return trace
+}
+
+// 开始请求上游节点，转发请求以proxy span为父节点传递traceparent
+func (t *upstreamTrace) begin(req *http.Request, addr string) {
+	if t == nil {
+		return
+	}
+	t.addr = addr
+	t.start = time.Now()
+	t.status = 0
+	t.err = ""
+	trace := TraceFromContext(req.Context())
+	t.span = trace.StartSpan("proxy", resource.SpanKindClient)
+	t.span.SetAttribute("net.peer.name", addr)
+	t.span.SetAttribute("http.url", req.URL.String())
+	trace.Inject(req.Header, t.span)
+}
+
+// 占用上游节点的并发名额，limit<=0不限制，节点已满时返回false
+func (t *upstreamTrace) acquire(addr string, limit int64) bool {
+	if t == nil {
+		return true
+	}
+	resource.ActiveRequests.Release(t.active)
+	t.active = resource.ActiveRequests.TryAcquire(t.key, addr, limit)
+	t.busy = t.active == nil
+	return !t.busy
+}
+
+// 转发结束，响应已写完或请求失败，记录节点统计
+// 被网关拒绝的请求不计入节点统计
+func (t *upstreamTrace) release() {
+	if t == nil || t.active == nil {
+		return
+	}
+	resource.ActiveRequests.Release(t.active)
+	t.active = nil
+	if t.reject == "" {
+		resource.UpstreamStats.GetUpstreamStat(t.key, t.addr).Record(t.status, t.latency)
+	}
+}
+
+// 收到上游响应
+func (t *upstreamTrace) end(status int) {
+	if t == nil {
+		return
+	}
+	t.status = status
+	t.latency = time.Since(t.start)
+	t.span.SetAttribute("http.status_code", status)
+	if status >= http.StatusInternalServerError {
+		t.span.End(errors.New(http.StatusText(status)))
+		return
+	}
+	t.span.End(nil)
+}
+
+// 请求上游失败，reject为请求被网关拒绝的原因
+func (t *upstreamTrace) fail(err error, reject string) {
+	if t == nil {
+		return
+	}
+	if !t.start.IsZero() {
+		t.latency = time.Since(t.start)
+	}
+	t.err = err.Error()
+	t.reject = reject
+	t.span.End(err)
+}
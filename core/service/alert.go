@@ -0,0 +1,561 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/core/discovery"
+	"gatekeeper/core/resource"
+	"gatekeeper/model/entity"
+	"gatekeeper/util"
+)
+
+// 告警规则类型
+const (
+	AlertTypeUpstreamDown  = "upstream_down"   //节点探活失败
+	AlertTypeNoAvailableIP = "no_available_ip" //模块没有可用节点
+	AlertTypeErrorRate     = "error_rate"      //模块5xx及未收到响应的比例超过阈值
+	AlertTypeLatency       = "latency"         //模块耗时超过阈值
+	AlertTypeAppQuota      = "app_quota"       //租户日配额用量超过阈值
+	AlertTypeReloadFailed  = "reload_failed"   //刷新配置失败
+)
+
+// 告警状态
+const (
+	AlertStatusPending  = "pending" //条件已满足，未达到持续时间
+	AlertStatusFiring   = "firing"
+	AlertStatusResolved = "resolved"
+)
+
+// 告警级别
+const (
+	AlertSeverityCritical = "critical"
+	AlertSeverityWarning  = "warning"
+	AlertSeverityInfo     = "info"
+)
+
+// 告警默认检查间隔，单位ms
+const alertDefaultInterval = 10000
+
+// 告警可用的统计窗口，与节点统计一致
+var alertWindows = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+}
+
+// Alerts 全局告警管理器，未配置告警规则时为nil
+var Alerts *AlertManager
+
+// Alert 一条告警，同一规则同一目标只有一条，触发后条件消失即恢复
+type Alert struct {
+	Fingerprint string     `json:"fingerprint"`
+	Rule        string     `json:"rule"`
+	Type        string     `json:"type"`
+	Severity    string     `json:"severity"`
+	Target      string     `json:"target"`
+	Status      string     `json:"status"`
+	Summary     string     `json:"summary"`
+	Value       float64    `json:"value"`
+	Threshold   float64    `json:"threshold"`
+	Instance    string     `json:"instance"`  //产生告警的网关节点
+	StartsAt    time.Time  `json:"starts_at"` //pending时为条件首次满足的时间
+	EndsAt      *time.Time `json:"ends_at"`
+}
+
+// 规则检查得到的一个满足条件的目标
+type alertCondition struct {
+	target  string
+	value   float64
+	summary string
+}
+
+// 校验后的告警规则
+type alertRule struct {
+	*config.AlertRule
+	window   time.Duration
+	hold     time.Duration
+	webhooks []*alertWebhook
+}
+
+// 告警运行状态
+type alertState struct {
+	alert      *Alert
+	rule       *alertRule
+	notifiedAt time.Time
+	historyID  int64
+}
+
+// AlertManager 告警管理器
+// 按interval检查全部规则，每个节点各自检查并通知，告警状态保存在内存中
+type AlertManager struct {
+	interval time.Duration
+	repeat   time.Duration
+	rules    []*alertRule
+	webhooks []*alertWebhook
+	instance string
+
+	states       map[string]*alertState
+	statesLocker sync.Mutex
+}
+
+// NewAlertManager 按配置创建告警管理器，未配置规则时返回nil
+func NewAlertManager(conf *config.AlertConfig) (*AlertManager, error) {
+	if conf == nil || len(conf.Rules) == 0 {
+		return nil, nil
+	}
+	m := &AlertManager{
+		interval: time.Duration(conf.Interval) * time.Millisecond,
+		repeat:   time.Duration(conf.RepeatInterval) * time.Millisecond,
+		states:   map[string]*alertState{},
+	}
+	if conf.Interval <= 0 {
+		m.interval = alertDefaultInterval * time.Millisecond
+	}
+	m.instance, _ = os.Hostname()
+	if config.BaseConf.Cluster != nil && config.BaseConf.Cluster.ClusterIP != "" {
+		m.instance = config.BaseConf.Cluster.ClusterIP
+	}
+	webhookMap := map[string]*alertWebhook{}
+	for _, wconf := range conf.Webhooks {
+		webhook, err := newAlertWebhook(wconf)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := webhookMap[webhook.Name]; ok {
+			return nil, errors.Errorf("alert webhook %s: duplicate name", webhook.Name)
+		}
+		webhookMap[webhook.Name] = webhook
+		m.webhooks = append(m.webhooks, webhook)
+	}
+	names := map[string]bool{}
+	for _, rconf := range conf.Rules {
+		rule, err := newAlertRule(rconf, webhookMap)
+		if err != nil {
+			return nil, err
+		}
+		if rule.webhooks == nil {
+			rule.webhooks = m.webhooks
+		}
+		if names[rule.Name] {
+			return nil, errors.Errorf("alert rule %s: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+		m.rules = append(m.rules, rule)
+	}
+	return m, nil
+}
+
+// 校验规则并填充默认值
+func newAlertRule(conf *config.AlertRule, webhookMap map[string]*alertWebhook) (*alertRule, error) {
+	if conf.Name == "" {
+		return nil, errors.New("alert rule: name is required")
+	}
+	rule := &alertRule{AlertRule: conf, hold: time.Duration(conf.For) * time.Millisecond}
+	switch conf.Type {
+	case AlertTypeUpstreamDown, AlertTypeNoAvailableIP, AlertTypeReloadFailed:
+	case AlertTypeErrorRate, AlertTypeLatency:
+		if conf.Window == "" {
+			conf.Window = "1m"
+		}
+		window, ok := alertWindows[conf.Window]
+		if !ok {
+			return nil, errors.Errorf("alert rule %s: unknown window %s, want 1m, 5m or 15m", conf.Name, conf.Window)
+		}
+		rule.window = window
+		if conf.Type == AlertTypeErrorRate && (conf.Threshold < 0 || conf.Threshold > 100) {
+			return nil, errors.Errorf("alert rule %s: threshold must be between 0 and 100", conf.Name)
+		}
+		if conf.Type == AlertTypeLatency {
+			if conf.Threshold <= 0 {
+				return nil, errors.Errorf("alert rule %s: threshold must be greater than 0", conf.Name)
+			}
+			if conf.Percentile == "" {
+				conf.Percentile = "p95"
+			}
+			if !util.InStringList(conf.Percentile, []string{"avg", "p50", "p95", "p99"}) {
+				return nil, errors.Errorf("alert rule %s: unknown percentile %s, want avg, p50, p95 or p99", conf.Name, conf.Percentile)
+			}
+		}
+	case AlertTypeAppQuota:
+		if conf.Threshold <= 0 || conf.Threshold > 100 {
+			return nil, errors.Errorf("alert rule %s: threshold must be between 0 and 100", conf.Name)
+		}
+	default:
+		return nil, errors.Errorf("alert rule %s: unknown type %s", conf.Name, conf.Type)
+	}
+	if conf.For < 0 || conf.MinRequests < 0 {
+		return nil, errors.Errorf("alert rule %s: for and min_requests must not be negative", conf.Name)
+	}
+	if conf.Severity == "" {
+		conf.Severity = AlertSeverityWarning
+	}
+	if !util.InStringList(conf.Severity, []string{AlertSeverityCritical, AlertSeverityWarning, AlertSeverityInfo}) {
+		return nil, errors.Errorf("alert rule %s: unknown severity %s", conf.Name, conf.Severity)
+	}
+	for _, name := range conf.Webhooks {
+		webhook, ok := webhookMap[name]
+		if !ok {
+			return nil, errors.Errorf("alert rule %s: webhook %s not found", conf.Name, name)
+		}
+		rule.webhooks = append(rule.webhooks, webhook)
+	}
+	return rule, nil
+}
+
+// Start 启动告警检查协程
+func (m *AlertManager) Start() {
+	if m == nil {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(m.interval)
+			m.safeEvaluate()
+		}
+	}()
+}
+
+// 单次检查出错时不影响后续检查
+func (m *AlertManager) safeEvaluate() {
+	defer func() {
+		if err := recover(); err != nil {
+			config.SysLog.Error("[alert recover] [err:%v]", err)
+		}
+	}()
+	m.evaluate(time.Now())
+}
+
+// Rules 告警规则
+func (m *AlertManager) Rules() []*config.AlertRule {
+	if m == nil {
+		return nil
+	}
+	rules := []*config.AlertRule{}
+	for _, rule := range m.rules {
+		rules = append(rules, rule.AlertRule)
+	}
+	return rules
+}
+
+// WebhookNames 已配置的webhook名称
+func (m *AlertManager) WebhookNames() []string {
+	if m == nil {
+		return nil
+	}
+	names := []string{}
+	for _, webhook := range m.webhooks {
+		names = append(names, webhook.Name)
+	}
+	return names
+}
+
+// Active 本节点当前pending及firing的告警，按开始时间排序
+func (m *AlertManager) Active() []*Alert {
+	if m == nil {
+		return nil
+	}
+	m.statesLocker.Lock()
+	alerts := []*Alert{}
+	for _, state := range m.states {
+		alert := *state.alert
+		alerts = append(alerts, &alert)
+	}
+	m.statesLocker.Unlock()
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+	})
+	return alerts
+}
+
+// 检查全部规则，更新告警状态并发送通知
+func (m *AlertManager) evaluate(now time.Time) {
+	seen := map[string]bool{}
+	for _, rule := range m.rules {
+		for _, cond := range m.check(rule, now) {
+			fingerprint := rule.Name + "|" + cond.target
+			seen[fingerprint] = true
+			m.update(rule, fingerprint, cond, now)
+		}
+	}
+	m.statesLocker.Lock()
+	resolved := []*alertState{}
+	for fingerprint, state := range m.states {
+		if seen[fingerprint] {
+			continue
+		}
+		delete(m.states, fingerprint)
+		if state.alert.Status == AlertStatusFiring {
+			state.alert.Status = AlertStatusResolved
+			state.alert.EndsAt = &now
+			resolved = append(resolved, state)
+		}
+	}
+	m.statesLocker.Unlock()
+	for _, state := range resolved {
+		config.SysLog.Info("[alert resolved] [rule:%s] [target:%s]", state.alert.Rule, state.alert.Target)
+		if state.historyID > 0 {
+			if err := (&entity.GatewayAlertHistory{}).Resolve(config.DB, state.historyID, now); err != nil {
+				config.SysLog.Error("[alert history save failed] [rule:%s] [target:%s] [err:%v]", state.alert.Rule, state.alert.Target, err)
+			}
+		}
+		m.notify(state)
+	}
+}
+
+// 条件满足时更新告警，持续时间达到for后触发，触发后按repeat_interval重复通知
+func (m *AlertManager) update(rule *alertRule, fingerprint string, cond *alertCondition, now time.Time) {
+	m.statesLocker.Lock()
+	state, ok := m.states[fingerprint]
+	if !ok {
+		state = &alertState{
+			rule: rule,
+			alert: &Alert{
+				Fingerprint: fingerprint,
+				Rule:        rule.Name,
+				Type:        rule.Type,
+				Severity:    rule.Severity,
+				Target:      cond.target,
+				Status:      AlertStatusPending,
+				Threshold:   rule.Threshold,
+				Instance:    m.instance,
+				StartsAt:    now,
+			},
+		}
+		m.states[fingerprint] = state
+	}
+	state.alert.Value = cond.value
+	state.alert.Summary = cond.summary
+	fire := state.alert.Status == AlertStatusPending && now.Sub(state.alert.StartsAt) >= rule.hold
+	if fire {
+		state.alert.Status = AlertStatusFiring
+		state.alert.StartsAt = now
+	}
+	repeat := !fire && state.alert.Status == AlertStatusFiring && m.repeat > 0 && now.Sub(state.notifiedAt) >= m.repeat
+	m.statesLocker.Unlock()
+
+	if fire {
+		config.SysLog.Warn("[alert firing] [rule:%s] [target:%s] [summary:%s]", rule.Name, cond.target, cond.summary)
+		m.saveHistory(state)
+	}
+	if fire || repeat {
+		m.notify(state)
+	}
+}
+
+// 记录告警历史，仅DB存储模式下可用
+func (m *AlertManager) saveHistory(state *alertState) {
+	if !config.DBEnabled() {
+		return
+	}
+	alert := state.alert
+	history := &entity.GatewayAlertHistory{
+		Fingerprint: alert.Fingerprint,
+		Rule:        alert.Rule,
+		Type:        alert.Type,
+		Severity:    alert.Severity,
+		Target:      alert.Target,
+		Status:      AlertStatusFiring,
+		Summary:     alert.Summary,
+		Value:       alert.Value,
+		Threshold:   alert.Threshold,
+		Instance:    alert.Instance,
+		StartedAt:   alert.StartsAt,
+	}
+	if err := history.Save(config.DB); err != nil {
+		config.SysLog.Error("[alert history save failed] [rule:%s] [target:%s] [err:%v]", alert.Rule, alert.Target, err)
+		return
+	}
+	m.statesLocker.Lock()
+	state.historyID = history.ID
+	m.statesLocker.Unlock()
+}
+
+// 异步通知规则对应的webhook，并记录通知结果
+func (m *AlertManager) notify(state *alertState) {
+	m.statesLocker.Lock()
+	alert := *state.alert
+	historyID := state.historyID
+	state.notifiedAt = time.Now()
+	m.statesLocker.Unlock()
+	if len(state.rule.webhooks) == 0 {
+		return
+	}
+	go func() {
+		results := []string{}
+		for _, webhook := range state.rule.webhooks {
+			if err := webhook.send(&alert); err != nil {
+				config.SysLog.Warn("[alert notify failed] [webhook:%s] [rule:%s] [target:%s] [err:%s]", webhook.Name, alert.Rule, alert.Target, err.Error())
+				results = append(results, webhook.Name+": "+err.Error())
+			} else {
+				results = append(results, webhook.Name+": ok")
+			}
+		}
+		if historyID == 0 {
+			return
+		}
+		result := truncateRunes(alert.Status+" "+strings.Join(results, "; "), 1000)
+		if err := (&entity.GatewayAlertHistory{}).UpdateNotifyResult(config.DB, historyID, result); err != nil {
+			config.SysLog.Error("[alert history save failed] [rule:%s] [target:%s] [err:%v]", alert.Rule, alert.Target, err)
+		}
+	}()
+}
+
+// TestWebhook 向webhook发送一条测试告警
+func (m *AlertManager) TestWebhook(name string) error {
+	if m == nil {
+		return errors.New("alert is not configured")
+	}
+	for _, webhook := range m.webhooks {
+		if webhook.Name == name {
+			return webhook.send(&Alert{
+				Fingerprint: "test|" + name,
+				Rule:        "test",
+				Type:        "test",
+				Severity:    AlertSeverityInfo,
+				Target:      name,
+				Status:      AlertStatusFiring,
+				Summary:     "这是一条测试告警",
+				Instance:    m.instance,
+				StartsAt:    time.Now(),
+			})
+		}
+	}
+	return errors.Errorf("webhook %s not found", name)
+}
+
+// 按规则类型检查，返回满足告警条件的目标
+func (m *AlertManager) check(rule *alertRule, now time.Time) []*alertCondition {
+	switch rule.Type {
+	case AlertTypeUpstreamDown:
+		return m.checkUpstreamDown(rule)
+	case AlertTypeNoAvailableIP:
+		return m.checkNoAvailableIP(rule)
+	case AlertTypeErrorRate, AlertTypeLatency:
+		return m.checkUpstreamStat(rule)
+	case AlertTypeAppQuota:
+		return m.checkAppQuota(rule, now)
+	case AlertTypeReloadFailed:
+		if err := SysConfMgr.ReloadError(); err != nil {
+			return []*alertCondition{{target: "config", value: 1, summary: "刷新配置失败: " + err.Error()}}
+		}
+	}
+	return nil
+}
+
+// 规则检查的模块，未指定时为全部模块
+func (m *AlertManager) ruleModules(rule *alertRule) []string {
+	modules := SysConfMgr.GetModuleConfig().Module
+	names := []string{}
+	for name := range modules {
+		if len(rule.Targets) == 0 || util.InStringList(name, rule.Targets) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *AlertManager) checkUpstreamDown(rule *alertRule) []*alertCondition {
+	conds := []*alertCondition{}
+	for _, name := range m.ruleModules(rule) {
+		//首次探活前不检查
+		SysConfMgr.moduleActiveIPListMapLocker.RLock()
+		activeIPList, checked := SysConfMgr.moduleActiveIPListMap[name]
+		SysConfMgr.moduleActiveIPListMapLocker.RUnlock()
+		if !checked {
+			continue
+		}
+		for _, addr := range discovery.Addrs(SysConfMgr.GetModuleNodes(name)) {
+			if addr == "" || util.InStringList(addr, activeIPList) {
+				continue
+			}
+			conds = append(conds, &alertCondition{
+				target:  name + "/" + addr,
+				value:   1,
+				summary: fmt.Sprintf("模块%s节点%s探活失败", name, addr),
+			})
+		}
+	}
+	return conds
+}
+
+func (m *AlertManager) checkNoAvailableIP(rule *alertRule) []*alertCondition {
+	conds := []*alertCondition{}
+	for _, name := range m.ruleModules(rule) {
+		if len(SysConfMgr.GetAvailableIPList(name)) > 0 {
+			continue
+		}
+		conds = append(conds, &alertCondition{
+			target:  name,
+			value:   0,
+			summary: fmt.Sprintf("模块%s没有可用节点", name),
+		})
+	}
+	return conds
+}
+
+// 按模块全部节点合并统计错误率及耗时
+func (m *AlertManager) checkUpstreamStat(rule *alertRule) []*alertCondition {
+	conds := []*alertCondition{}
+	for _, name := range m.ruleModules(rule) {
+		addrs := discovery.Addrs(SysConfMgr.GetModuleNodes(name))
+		stat := resource.UpstreamStats.MergedSnapshot(name, addrs, rule.window)
+		if stat.Requests == 0 || stat.Requests < rule.MinRequests {
+			continue
+		}
+		if rule.Type == AlertTypeErrorRate {
+			if stat.ErrorRate <= rule.Threshold {
+				continue
+			}
+			conds = append(conds, &alertCondition{
+				target:  name,
+				value:   stat.ErrorRate,
+				summary: fmt.Sprintf("模块%s最近%s错误率%.2f%%，超过%.2f%%，请求数%d", name, rule.Window, stat.ErrorRate, rule.Threshold, stat.Requests),
+			})
+			continue
+		}
+		latency := map[string]int64{"avg": stat.AvgLatency, "p50": stat.P50, "p95": stat.P95, "p99": stat.P99}[rule.Percentile]
+		if float64(latency) <= rule.Threshold {
+			continue
+		}
+		conds = append(conds, &alertCondition{
+			target:  name,
+			value:   float64(latency),
+			summary: fmt.Sprintf("模块%s最近%s %s耗时%dms，超过%.0fms，请求数%d", name, rule.Window, rule.Percentile, latency, rule.Threshold, stat.Requests),
+		})
+	}
+	return conds
+}
+
+// 租户日配额用量达到阈值
+func (m *AlertManager) checkAppQuota(rule *alertRule, now time.Time) []*alertCondition {
+	conds := []*alertCondition{}
+	for _, app := range SysConfMgr.GetAppConfig().Apps {
+		if app.TotalQueryDaily <= 0 || (len(rule.Targets) > 0 && !util.InStringList(app.AppID, rule.Targets)) {
+			continue
+		}
+		used, err := resource.Counters.Get(QuotaKey(app.AppID, QuotaWindowDay, now))
+		if err != nil {
+			config.SysLog.Warn("[alert check failed] [rule:%s] [app_id:%s] [err:%s]", rule.Name, app.AppID, err.Error())
+			continue
+		}
+		percent := float64(used) * 100 / float64(app.TotalQueryDaily)
+		if percent < rule.Threshold {
+			continue
+		}
+		conds = append(conds, &alertCondition{
+			target:  app.AppID,
+			value:   percent,
+			summary: fmt.Sprintf("租户%s(%s)今日已使用配额%d/%d(%.1f%%)", app.Name, app.AppID, used, app.TotalQueryDaily, percent),
+		})
+	}
+	return conds
+}
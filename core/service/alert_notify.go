@@ -0,0 +1,135 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+)
+
+// webhook默认超时时间，单位ms
+const alertWebhookTimeout = 5000
+
+// 内置的聊天工具消息模板
+var alertBuiltinTemplates = map[string]string{
+	"dingtalk": `{"msgtype":"markdown","markdown":{"title":{{json .Title}},"text":{{json .Text}}}}`,
+	"feishu":   `{"msg_type":"text","content":{"text":{{json .Text}}}}`,
+	"wecom":    `{"msgtype":"markdown","markdown":{"content":{{json .Text}}}}`,
+	"slack":    `{"text":{{json .Text}}}`,
+}
+
+// 模板函数，json用于在模板中输出转义后的json字符串
+var alertTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		bts, err := json.Marshal(v)
+		return string(bts), err
+	},
+}
+
+// 告警通知webhook
+type alertWebhook struct {
+	*config.AlertWebhook
+	tmpl   *template.Template //为nil时发送json格式的告警
+	client *http.Client
+}
+
+func newAlertWebhook(conf *config.AlertWebhook) (*alertWebhook, error) {
+	if conf.Name == "" || conf.URL == "" {
+		return nil, errors.New("alert webhook: name and url are required")
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = alertWebhookTimeout
+	}
+	webhook := &alertWebhook{
+		AlertWebhook: conf,
+		client:       &http.Client{Timeout: time.Duration(timeout) * time.Millisecond},
+	}
+	if conf.Template == "" {
+		return webhook, nil
+	}
+	text, ok := alertBuiltinTemplates[conf.Template]
+	if !ok {
+		text = conf.Template
+	}
+	tmpl, err := template.New(conf.Name).Funcs(alertTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "alert webhook %s: template", conf.Name)
+	}
+	webhook.tmpl = tmpl
+	return webhook, nil
+}
+
+// 发送告警，非2xx响应视为失败
+func (w *alertWebhook) send(alert *Alert) error {
+	var body []byte
+	if w.tmpl == nil {
+		bts, err := json.Marshal(alert)
+		if err != nil {
+			return err
+		}
+		body = bts
+	} else {
+		buf := &bytes.Buffer{}
+		if err := w.tmpl.Execute(buf, alert); err != nil {
+			return errors.Wrap(err, "template")
+		}
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("status %d: %s", resp.StatusCode, truncateRunes(string(respBody), 200))
+	}
+	return nil
+}
+
+// Title 通知标题
+func (a *Alert) Title() string {
+	state := "告警"
+	if a.Status == AlertStatusResolved {
+		state = "恢复"
+	}
+	return fmt.Sprintf("[%s][%s] %s %s", state, a.Severity, a.Rule, a.Target)
+}
+
+// Text 通知正文，供聊天工具模板使用
+func (a *Alert) Text() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, a.Title())
+	fmt.Fprintln(buf, "内容:", a.Summary)
+	fmt.Fprintln(buf, "开始时间:", a.StartsAt.In(config.TimeLocation).Format("2006-01-02 15:04:05"))
+	if a.EndsAt != nil {
+		fmt.Fprintln(buf, "恢复时间:", a.EndsAt.In(config.TimeLocation).Format("2006-01-02 15:04:05"))
+	}
+	fmt.Fprint(buf, "网关节点: ", a.Instance)
+	return buf.String()
+}
+
+// 按字符截取，避免截断多字节字符
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
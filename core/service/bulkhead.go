@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/constant"
+	"gatekeeper/core"
+	"gatekeeper/model/running"
+)
+
+// 并发隔离拒绝请求的原因
+var (
+	ErrBulkheadFull    = errors.New("module concurrency limit reached and wait queue full")
+	ErrBulkheadTimeout = errors.New("module concurrency wait queue timeout")
+	ErrUpstreamBusy    = errors.New("all upstream nodes reached concurrency limit")
+)
+
+// 模块并发隔离
+// 限制模块同时转发到上游的请求数，超出时最多排队queueSize个请求，排队超时或队列已满时快速返回503
+type moduleBulkhead struct {
+	slots     chan struct{}
+	queueSize int64
+	timeout   time.Duration
+	waiting   int64
+	rejected  int64
+}
+
+// BulkheadStat 模块并发隔离状态
+type BulkheadStat struct {
+	Limit    int   `json:"limit"`
+	Active   int   `json:"active"`
+	Waiting  int64 `json:"waiting"`
+	Rejected int64 `json:"rejected"` //本节点启动以来拒绝数
+}
+
+// 按模块配置创建并发隔离，未限制模块并发时返回nil
+func newModuleBulkhead(module *running.GatewayModule) *moduleBulkhead {
+	lb := module.LoadBalance
+	if lb.MaxConcurrency <= 0 {
+		return nil
+	}
+	timeout := lb.QueueTimeout
+	if timeout <= 0 {
+		timeout = constant.BulkheadDefaultQueueTimeout
+	}
+	return &moduleBulkhead{
+		slots:     make(chan struct{}, lb.MaxConcurrency),
+		queueSize: int64(lb.QueueSize),
+		timeout:   time.Duration(timeout) * time.Millisecond,
+	}
+}
+
+// 占用一个并发名额，已满时排队等待
+func (b *moduleBulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if atomic.AddInt64(&b.waiting, 1) > b.queueSize {
+		atomic.AddInt64(&b.waiting, -1)
+		atomic.AddInt64(&b.rejected, 1)
+		return ErrBulkheadFull
+	}
+	defer atomic.AddInt64(&b.waiting, -1)
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		atomic.AddInt64(&b.rejected, 1)
+		return ErrBulkheadTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *moduleBulkhead) release() {
+	<-b.slots
+}
+
+func (b *moduleBulkhead) stat() *BulkheadStat {
+	return &BulkheadStat{
+		Limit:    cap(b.slots),
+		Active:   len(b.slots),
+		Waiting:  atomic.LoadInt64(&b.waiting),
+		Rejected: atomic.LoadInt64(&b.rejected),
+	}
+}
+
+// 在模块并发限制内转发请求
+func (b *moduleBulkhead) handler(s *SysConfigManage, moduleName string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := b.acquire(req.Context()); err != nil {
+			upstreamTraceFromContext(req.Context()).fail(err, constant.DLTagBulkheadFailure)
+			gerr := busyError(err)
+			PublishLimitTrip(moduleName, "", gerr)
+			s.WriteGatewayError(w, req, moduleName, gerr)
+			return
+		}
+		defer b.release()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// 并发已满的错误，提示客户端稍后重试
+func busyError(err error) *GatewayError {
+	gerr := NewGatewayError(ErrCodeUpstreamBusy, err)
+	gerr.RetryAfter = time.Second
+	return gerr
+}
+
+// 选择上游节点，跳过进行中请求数达到上限的节点
+// 最多尝试attempts个节点，全部已满时返回false
+func pickUpstream(rr core.RR, trace *upstreamTrace, limit int64, attempts int) (string, bool) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	for i := 0; i < attempts; i++ {
+		addr, ok := rr.Next().(string)
+		if !ok {
+			return "", false
+		}
+		if trace.acquire(addr, limit) {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+// 节点并发已满时不再请求上游，由ErrorHandler返回503
+type bulkheadTransport struct {
+	http.RoundTripper
+}
+
+// 模块transport不存在时使用默认transport
+func newBulkheadTransport(mtp *http.Transport) *bulkheadTransport {
+	if mtp == nil {
+		return &bulkheadTransport{RoundTripper: http.DefaultTransport}
+	}
+	return &bulkheadTransport{RoundTripper: mtp}
+}
+
+func (t *bulkheadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if trace := upstreamTraceFromContext(req.Context()); trace != nil && trace.busy {
+		return nil, ErrUpstreamBusy
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/util"
+)
+
+// ClusterReload 通知集群内各节点重新加载配置
+func ClusterReload() error {
+	clusterList := config.BaseConf.Cluster.ClusterList
+	clusterAddr := config.BaseConf.Cluster.ClusterAddr
+
+	//集群配置更新
+	for _, item := range strings.Split(clusterList, ",") {
+		resp, bts, err := util.HttpGET(fmt.Sprintf(
+			"http://%s%s/reload",
+			item, clusterAddr), nil, 5000, nil)
+		if err != nil {
+			return errors.New("clusterList.update:" + err.Error())
+		}
+		if resp.StatusCode != 200 {
+			return errors.New("clusterList.update:" + string(bts))
+		}
+	}
+	return nil
+}
+
+// ClusterPurgeCache 通知集群内各节点清除模块缓存
+func ClusterPurgeCache(moduleName, path string) error {
+	clusterList := config.BaseConf.Cluster.ClusterList
+	clusterAddr := config.BaseConf.Cluster.ClusterAddr
+
+	query := url.Values{}
+	query.Set("module_name", moduleName)
+	query.Set("path", path)
+	for _, item := range strings.Split(clusterList, ",") {
+		resp, bts, err := util.HttpGET(fmt.Sprintf(
+			"http://%s%s/cache_purge?%s",
+			item, clusterAddr, query.Encode()), nil, 5000, nil)
+		if err != nil {
+			return errors.New("clusterList.purge:" + err.Error())
+		}
+		if resp.StatusCode != 200 {
+			return errors.New("clusterList.purge:" + string(bts))
+		}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -22,6 +23,8 @@ import (
 	"gatekeeper/config"
 	"gatekeeper/constant"
 	"gatekeeper/core"
+	"gatekeeper/core/discovery"
+	"gatekeeper/core/resource"
 	"gatekeeper/model/entity"
 	"gatekeeper/model/running"
 	"gatekeeper/util"
@@ -35,13 +38,19 @@ type SysConfigManage struct {
 	moduleConfig       *running.Modules
 	moduleConfigLocker sync.RWMutex
 
-	moduleIPListMap       map[string][]string //可用ip
+	moduleNodeMap        map[string][]*discovery.Node //配置或服务发现得到的节点
+	moduleEmptyNodeCount map[string]int               //服务发现连续返回空节点列表的次数
+	moduleNodeMapLocker  sync.RWMutex
+
+	moduleIPListMap       map[string][]string             //可用ip
+	moduleNodeStartMap    map[string]map[string]time.Time //节点加入可用ip的时间，用于慢启动
 	moduleIPListMapLocker sync.RWMutex
 
 	moduleActiveIPListMap       map[string][]string //活动ip
 	moduleActiveIPListMapLocker sync.RWMutex
 
 	moduleForbidIPListMap       map[string][]string //禁用ip
+	moduleDrainIPListMap        map[string][]string //排空中的ip
 	moduleForbidIPListMapLocker sync.RWMutex
 
 	moduleProxyFuncMap       map[string]func(rr core.RR) *httputil.ReverseProxy
@@ -54,54 +63,156 @@ type SysConfigManage struct {
 	moduleRRMapLocker sync.RWMutex
 
 	appConfig       *running.Apps
+	appIDMap        map[string]*entity.GatewayAPP //按app_id索引的租户，配置中租户以名称为key
+	appQuotaMap     map[string]map[string]Quota   //租户的模块配额，租户配置加载时解析
 	appConfigLocker sync.RWMutex
 
-	loadConfigContext context.Context //重新载入配置时，需要执行close
-	loadConfigCancel  func()          //停止配置自动检查
+	moduleContextMap       map[string]*moduleContext //模块运行上下文，模块变更或删除时执行cancel
+	moduleContextMapLocker sync.Mutex
+
+	moduleGroupMap       map[string][]*upstreamGroup //模块上游分组
+	moduleGroupMapLocker sync.RWMutex
+
+	moduleMirrorMap       map[string]*moduleMirror //模块流量镜像
+	moduleMirrorMapLocker sync.RWMutex
+
+	moduleBulkheadMap       map[string]*moduleBulkhead //模块并发隔离
+	moduleBulkheadMapLocker sync.RWMutex
+
+	moduleRewriteMap       map[string][]*URLRewriteRule //模块url重写规则，模块启动时编译
+	moduleRewriteMapLocker sync.RWMutex
+
+	moduleCacheMap       map[string]*moduleCache //模块响应缓存
+	moduleCacheMapLocker sync.RWMutex
+
+	moduleErrorPagesMap       map[string]errorPages //模块自定义错误页，模块启动时编译
+	moduleErrorPagesMapLocker sync.RWMutex
+
+	reloadErr       error //最近一次刷新配置的错误，刷新成功后清空
+	reloadErrLocker sync.RWMutex
+
+	reloadLocker sync.Mutex //串行化初始化及刷新配置，定时刷新与/reload可能并发
+}
+
+// 模块运行上下文
+// 控制该模块探活及负载刷新协程的生命周期
+type moduleContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // 实例化全局系统配置
 func NewSysConfigManage() *SysConfigManage {
 	return &SysConfigManage{
+		moduleNodeMap:         map[string][]*discovery.Node{},
+		moduleEmptyNodeCount:  map[string]int{},
 		moduleIPListMap:       map[string][]string{},
 		moduleActiveIPListMap: map[string][]string{},
 		moduleForbidIPListMap: map[string][]string{},
+		moduleDrainIPListMap:  map[string][]string{},
+		moduleNodeStartMap:    map[string]map[string]time.Time{},
 		moduleProxyFuncMap:    map[string]func(rr core.RR) *httputil.ReverseProxy{},
 		moduleTransportMap:    map[string]*http.Transport{},
 		moduleRRMap:           map[string]core.RR{},
+		moduleContextMap:      map[string]*moduleContext{},
+		moduleGroupMap:        map[string][]*upstreamGroup{},
+		moduleMirrorMap:       map[string]*moduleMirror{},
+		moduleBulkheadMap:     map[string]*moduleBulkhead{},
+		moduleRewriteMap:      map[string][]*URLRewriteRule{},
+		moduleCacheMap:        map[string]*moduleCache{},
+		moduleErrorPagesMap:   map[string]errorPages{},
 	}
 }
 
 // InitConfig 初始化配置
 func (s *SysConfigManage) InitConfig() {
-	s.loadConfigContext, s.loadConfigCancel = context.WithCancel(context.Background())
+	s.reloadLocker.Lock()
+	defer s.reloadLocker.Unlock()
 	if err := s.refreshAPPConfig(); err != nil {
 		config.SysLog.Error("err:%s", err.Error())
 	}
 	if err := s.refreshModuleConfig(); err != nil {
 		config.SysLog.Error("err:%s", err.Error())
 	}
-	s.checkIPList()
-	s.configModuleRR()
-	s.configModuleProxyMap()
+	for _, module := range s.GetModuleConfig().Module {
+		s.startModule(module)
+	}
 }
 
 // ReloadConfig 刷新配置
+// 仅重建配置发生变化的模块，未变化模块保留transport、探活状态及负载位置
 func (s *SysConfigManage) ReloadConfig() {
+	s.reloadLocker.Lock()
+	defer s.reloadLocker.Unlock()
+	oldConf := s.GetModuleConfig()
+
 	// 刷新获取配置
-	s.refreshAPPConfig()
-	s.refreshModuleConfig()
+	appErr := s.refreshAPPConfig()
+	moduleErr := s.refreshModuleConfig()
+	oldErr := s.ReloadError()
+	s.setReloadError(appErr, moduleErr)
+	newConf := s.GetModuleConfig()
+
+	added, changed, nodeChanged, removed := diffModuleConf(oldConf, newConf)
+	for _, name := range removed {
+		s.stopModule(name)
+		s.cleanModule(name)
+	}
+	for _, module := range changed {
+		s.stopModule(module.Base.Name)
+		s.cleanModuleGroups(module.Base.Name)
+		s.startModule(module)
+	}
+	for _, module := range nodeChanged {
+		s.updateModuleNodeState(module)
+	}
+	for _, module := range added {
+		s.startModule(module)
+	}
+	config.SysLog.Info("[reload module config] [added:%d] [changed:%d] [node_changed:%d] [removed:%d]",
+		len(added), len(changed), len(nodeChanged), len(removed))
+	s.publishReloadEvent(oldErr, len(added), len(changed)+len(nodeChanged), len(removed))
+}
 
-	// 如果非首次加载，执行cancel
-	if s.loadConfigCancel != nil {
-		s.loadConfigCancel()
+// 配置有变更、刷新失败或失败后恢复时发布事件，连续相同的失败只发布一次
+func (s *SysConfigManage) publishReloadEvent(oldErr error, added, changed, removed int) {
+	err := s.ReloadError()
+	var message string
+	switch {
+	case err != nil && (oldErr == nil || oldErr.Error() != err.Error()):
+		message = "刷新配置失败: " + err.Error()
+	case err == nil && oldErr != nil:
+		message = "刷新配置已恢复"
+	case added+changed+removed == 0:
+		return
 	}
-	s.loadConfigContext, s.loadConfigCancel = context.WithCancel(context.Background())
+	if added+changed+removed > 0 {
+		message = strings.TrimPrefix(fmt.Sprintf("%s 新增%d 修改%d 删除%d", message, added, changed, removed), " ")
+	}
+	resource.Events.Publish(&resource.Event{Type: resource.EventConfigReload, Message: message})
+}
+
+// 记录刷新结果，租户或模块配置任一刷新失败即视为失败
+func (s *SysConfigManage) setReloadError(appErr, moduleErr error) {
+	var err error
+	switch {
+	case appErr != nil && moduleErr != nil:
+		err = errors.Errorf("app: %s; module: %s", appErr.Error(), moduleErr.Error())
+	case appErr != nil:
+		err = errors.Wrap(appErr, "app")
+	case moduleErr != nil:
+		err = errors.Wrap(moduleErr, "module")
+	}
+	s.reloadErrLocker.Lock()
+	s.reloadErr = err
+	s.reloadErrLocker.Unlock()
+}
 
-	//检测及配置
-	s.checkIPList()
-	s.configModuleRR()
-	s.configModuleProxyMap()
+// ReloadError 最近一次刷新配置的错误，成功时为nil
+func (s *SysConfigManage) ReloadError() error {
+	s.reloadErrLocker.RLock()
+	defer s.reloadErrLocker.RUnlock()
+	return s.reloadErr
 }
 
 // MonitorConfig 自动刷新配置
@@ -119,21 +230,42 @@ func (s *SysConfigManage) MonitorConfig() {
 	}()
 }
 
-// ConfigChangeNotice 配置变更通知
-func (s *SysConfigManage) ConfigChangeNotice() <-chan struct{} {
-	return s.loadConfigContext.Done()
+// ModuleChangeNotice 模块配置变更通知
+// 模块被修改或删除时关闭，模块不存在时返回已关闭的channel
+func (s *SysConfigManage) ModuleChangeNotice(moduleName string) <-chan struct{} {
+	s.moduleContextMapLocker.Lock()
+	defer s.moduleContextMapLocker.Unlock()
+	if mc, ok := s.moduleContextMap[moduleName]; ok {
+		return mc.ctx.Done()
+	}
+	done := make(chan struct{})
+	close(done)
+	return done
 }
 
 // GetModuleConfig 获取全局系统配置
 func (s *SysConfigManage) GetModuleConfig() *running.Modules {
 	s.moduleConfigLocker.RLock()
 	defer s.moduleConfigLocker.RUnlock()
+	if s.moduleConfig == nil {
+		return &running.Modules{Module: map[string]*running.GatewayModule{}}
+	}
 	return s.moduleConfig
 }
 
+// GetAppConfig 获取全部租户配置
+func (s *SysConfigManage) GetAppConfig() *running.Apps {
+	s.appConfigLocker.RLock()
+	defer s.appConfigLocker.RUnlock()
+	if s.appConfig == nil {
+		return &running.Apps{Apps: map[string]*entity.GatewayAPP{}}
+	}
+	return s.appConfig
+}
+
 // GetModuleConfigByName 通过模块名获取模块配置
 func (s *SysConfigManage) GetModuleConfigByName(name string) *running.GatewayModule {
-	module, ok := s.moduleConfig.Module[name]
+	module, ok := s.GetModuleConfig().Module[name]
 	if !ok {
 		return nil
 	}
@@ -156,6 +288,14 @@ func (s *SysConfigManage) GetForbidIPList(moduleName string) []string {
 	return ipList
 }
 
+// GetDrainIPList 获取排空中的IP
+func (s *SysConfigManage) GetDrainIPList(moduleName string) []string {
+	s.moduleForbidIPListMapLocker.RLock()
+	ipList, _ := s.moduleDrainIPListMap[moduleName]
+	s.moduleForbidIPListMapLocker.RUnlock()
+	return ipList
+}
+
 // GetAvailableIPList 获取可用IP
 func (s *SysConfigManage) GetAvailableIPList(moduleName string) []string {
 	s.moduleIPListMapLocker.RLock()
@@ -164,6 +304,22 @@ func (s *SysConfigManage) GetAvailableIPList(moduleName string) []string {
 	return ipList
 }
 
+// GetModuleNodes 获取配置或服务发现得到的节点
+func (s *SysConfigManage) GetModuleNodes(moduleName string) []*discovery.Node {
+	s.moduleNodeMapLocker.RLock()
+	defer s.moduleNodeMapLocker.RUnlock()
+	return s.moduleNodeMap[moduleName]
+}
+
+// GetModuleWeightMap 返回模块节点及权重
+func (s *SysConfigManage) GetModuleWeightMap(moduleName string) map[string]int64 {
+	weightMap := map[string]int64{}
+	for _, node := range s.GetModuleNodes(moduleName) {
+		weightMap[node.Addr] = node.Weight
+	}
+	return weightMap
+}
+
 // GetModuleIPList 获取当前可用的ip列表
 func (s *SysConfigManage) GetModuleIPList(moduleName string) ([]string, error) {
 	ipList := s.GetAvailableIPList(moduleName)
@@ -214,16 +370,94 @@ func (s *SysConfigManage) GetConfIPWeightMap(module *running.GatewayModule, defa
 
 // GetAppConfigByAPPID 获取租户数据
 func (s *SysConfigManage) GetAppConfigByAPPID(appID string) (*entity.GatewayAPP, error) {
-	app, ok := s.appConfig.Apps[appID]
+	s.appConfigLocker.RLock()
+	app, ok := s.appIDMap[appID]
+	s.appConfigLocker.RUnlock()
 	if !ok {
 		return nil, errors.New("app config empty")
 	}
 	return app, nil
 }
 
+// SelectUpstreamGroup 选择请求转发的上游分组
+func (s *SysConfigManage) SelectUpstreamGroup(moduleName string, req *http.Request) string {
+	s.moduleGroupMapLocker.RLock()
+	groups := s.moduleGroupMap[moduleName]
+	s.moduleGroupMapLocker.RUnlock()
+	return selectUpstreamGroup(groups, req)
+}
+
+// MirrorSample 按模块镜像比例判断本次请求是否需要镜像
+func (s *SysConfigManage) MirrorSample(moduleName string) bool {
+	s.moduleMirrorMapLocker.RLock()
+	mirror := s.moduleMirrorMap[moduleName]
+	s.moduleMirrorMapLocker.RUnlock()
+	return mirror != nil && mirror.sample()
+}
+
+// MirrorRequest 异步复制请求到模块的镜像分组，body为已缓存的请求体
+func (s *SysConfigManage) MirrorRequest(moduleName string, req *http.Request, body *RequestBody) {
+	s.moduleMirrorMapLocker.RLock()
+	mirror := s.moduleMirrorMap[moduleName]
+	s.moduleMirrorMapLocker.RUnlock()
+	if mirror != nil {
+		mirror.mirror(req, body)
+	}
+}
+
+// RewriteRequest 按模块重写规则改写请求地址，命中跳转规则时返回跳转信息
+func (s *SysConfigManage) RewriteRequest(moduleName string, req *http.Request) (*URLRedirect, error) {
+	s.moduleRewriteMapLocker.RLock()
+	rules := s.moduleRewriteMap[moduleName]
+	s.moduleRewriteMapLocker.RUnlock()
+	return rewriteRequest(rules, req)
+}
+
+// ServeHTTP 转发请求到上游，模块开启缓存时先查找缓存
+func (s *SysConfigManage) ServeHTTP(moduleName string, proxy http.Handler, w http.ResponseWriter, req *http.Request) {
+	s.moduleBulkheadMapLocker.RLock()
+	bulkhead := s.moduleBulkheadMap[moduleName]
+	s.moduleBulkheadMapLocker.RUnlock()
+	if bulkhead != nil {
+		proxy = bulkhead.handler(s, moduleName, proxy)
+	}
+	s.moduleCacheMapLocker.RLock()
+	cache := s.moduleCacheMap[moduleName]
+	s.moduleCacheMapLocker.RUnlock()
+	if cache == nil {
+		proxy.ServeHTTP(w, req)
+		return
+	}
+	cache.serveHTTP(proxy, w, req)
+}
+
+// GetBulkheadStat 获取模块并发隔离状态，未限制模块并发时返回nil
+func (s *SysConfigManage) GetBulkheadStat(moduleName string) *BulkheadStat {
+	s.moduleBulkheadMapLocker.RLock()
+	bulkhead := s.moduleBulkheadMap[moduleName]
+	s.moduleBulkheadMapLocker.RUnlock()
+	if bulkhead == nil {
+		return nil
+	}
+	return bulkhead.stat()
+}
+
+// PurgeCache 清除本节点模块缓存，path为空时清除整个模块，否则清除该路径前缀下的缓存
+// 内存及redis中的缓存均会清除，避免切换存储后残留旧数据
+func (s *SysConfigManage) PurgeCache(moduleName, path string) (int, error) {
+	prefix := CacheKeyPrefix(moduleName) + path
+	count, err := resource.GetCacheStore("memory").Purge(prefix)
+	if err != nil || !config.RedisEnabled() {
+		return count, err
+	}
+	n, err := resource.GetCacheStore("redis").Purge(prefix)
+	return count + n, err
+}
+
 // GetModuleHTTPProxy 获取http代理方法
-func (s *SysConfigManage) GetModuleHTTPProxy(moduleName string) (*httputil.ReverseProxy, error) {
-	rr, err := s.GetModuleRR(moduleName)
+// rrKey为分组运行时key，默认分组即模块名
+func (s *SysConfigManage) GetModuleHTTPProxy(moduleName, rrKey string) (*httputil.ReverseProxy, error) {
+	rr, err := s.GetModuleRR(rrKey)
 	if err != nil {
 		return nil, err
 	}
@@ -245,19 +479,27 @@ func (s *SysConfigManage) refreshModuleConfig() error {
 		}
 	}()
 	configFile := *config.Conf + "module.json"
-	config.SysLog.Info("[start refresh module config from file:%s]", configFile)
-	fileConf, err := s.getFileModuleConf(configFile)
-	if err != nil {
-		config.SysLog.Error("[get module from file:%s] [err:%s]", configFile, err.Error())
-		return err
+
+	//优先使用db配置，file存储模式或db不可用时使用配置文件
+	var dbConf *running.Modules
+	var err error
+	if config.DBEnabled() {
+		if dbConf, err = s.getDBModuleConf(false); err != nil {
+			config.SysLog.Error("[get module from db] [err:%s]", err.Error())
+		}
 	}
 
-	dbConf, err := s.getDBModuleConf(true)
-	if err != nil {
-		config.SysLog.Error("[get module from db] [err:%s]", err.Error())
+	//db配置校验失败时拒绝加载，保留最近一次正确的配置
+	if dbConf != nil {
+		if verr := s.checkModuleConf(dbConf); verr != nil {
+			config.SysLog.Error("[module config from db rejected] [err:%s]", verr.Error())
+			dbConf = nil
+			if s.hasModuleConfig() {
+				return verr
+			}
+		}
 	}
 
-	//如果db挂了默认降级走file
 	if dbConf != nil {
 		s.moduleConfigLocker.Lock()
 		s.moduleConfig = dbConf
@@ -269,19 +511,52 @@ func (s *SysConfigManage) refreshModuleConfig() error {
 		} else {
 			config.SysLog.Info("module_file_was_override.")
 		}
-	} else if fileConf != nil {
+	} else {
+		//如果db挂了默认降级走file
+		config.SysLog.Info("[start refresh module config from file:%s]", configFile)
+		fileConf, err := s.getFileModuleConf(configFile)
+		if err != nil {
+			config.SysLog.Error("[get module from file:%s] [err:%s]", configFile, err.Error())
+			return err
+		}
+		//文件校验失败时，已有配置则保留，启动时仍加载以免无配置可用
+		if verr := s.checkModuleConf(fileConf); verr != nil {
+			config.SysLog.Error("[module config from file:%s invalid] [err:%s]", configFile, verr.Error())
+			if s.hasModuleConfig() {
+				return verr
+			}
+		}
 		s.moduleConfigLocker.Lock()
 		s.moduleConfig = fileConf
 		s.moduleConfigLocker.Unlock()
 		config.SysLog.Info("module_configured_by_file.")
-	} else {
-		config.SysLog.Info("get_dbConf_and_fileConf_both_error")
-		return err
 	}
 	config.SysLog.Info("ModuleConf:%v", s.GetModuleConfig())
+
+	//模块与租户之间的引用仅做提示
+	s.appConfigLocker.RLock()
+	appConf := s.appConfig
+	s.appConfigLocker.RUnlock()
+	for _, item := range ValidateConfig(s.GetModuleConfig(), appConf).Warnings {
+		config.SysLog.Warn("[config validate warning] [%s]", item.String())
+	}
 	return nil
 }
 
+// 内存中是否已有生效的模块配置
+func (s *SysConfigManage) hasModuleConfig() bool {
+	s.moduleConfigLocker.RLock()
+	defer s.moduleConfigLocker.RUnlock()
+	return s.moduleConfig != nil
+}
+
+// 内存中是否已有生效的租户配置
+func (s *SysConfigManage) hasAppConfig() bool {
+	s.appConfigLocker.RLock()
+	defer s.appConfigLocker.RUnlock()
+	return s.appConfig != nil
+}
+
 // 刷新租户信息从DB到内存
 // 如果DB挂了，从本地配置文件恢复
 func (s *SysConfigManage) refreshAPPConfig() error {
@@ -291,24 +566,30 @@ func (s *SysConfigManage) refreshAPPConfig() error {
 		}
 	}()
 	configFile := *config.Conf + "app.json"
-	config.SysLog.Info("module_file:%s", configFile)
-	fileConf, err := s.getFileAPPConf(configFile)
-	if err != nil {
-		config.SysLog.Error("GetFileAPPConf_error:%v", err)
-		return err
+
+	//优先使用db配置，file存储模式或db不可用时使用配置文件
+	var dbConf *running.Apps
+	var err error
+	if config.DBEnabled() {
+		if dbConf, err = s.getDBAPPConf(false); err != nil {
+			config.SysLog.Error("GetDBAPPConf_error:%v", err)
+		}
+		config.SysLog.Info("GetDBAPPConf:%v", dbConf)
 	}
-	config.SysLog.Info("GetFileAPPConf:%v", fileConf)
-	dbConf, err := s.getDBAPPConf(false)
-	if err != nil {
-		config.SysLog.Error("GetDBAPPConf_error:%v", err)
+
+	//db配置校验失败时拒绝加载，保留最近一次正确的配置
+	if dbConf != nil {
+		if verr := s.checkAppConf(dbConf); verr != nil {
+			config.SysLog.Error("[app config from db rejected] [err:%s]", verr.Error())
+			dbConf = nil
+			if s.hasAppConfig() {
+				return verr
+			}
+		}
 	}
-	config.SysLog.Info("GetDBAPPConf:%v", dbConf)
 
-	//如果db挂了默认降级走file
 	if dbConf != nil {
-		s.appConfigLocker.Lock()
-		s.appConfig = dbConf
-		s.appConfigLocker.Unlock()
+		s.setAppConfig(dbConf)
 		config.SysLog.Info("app_configured_by_db.")
 		err := s.writeFileAppConf(configFile, dbConf)
 		if err != nil {
@@ -316,85 +597,296 @@ func (s *SysConfigManage) refreshAPPConfig() error {
 		} else {
 			config.SysLog.Info("app_file_was_override.")
 		}
-	} else if fileConf != nil {
-		s.appConfigLocker.Lock()
-		s.appConfig = fileConf
-		s.appConfigLocker.Unlock()
-		config.SysLog.Info("app_configured_by_file.")
 	} else {
-		config.SysLog.Info("get_dbConf_and_fileConf_both_error")
-		return err
+		//如果db挂了默认降级走file
+		config.SysLog.Info("module_file:%s", configFile)
+		fileConf, err := s.getFileAPPConf(configFile)
+		if err != nil {
+			config.SysLog.Error("GetFileAPPConf_error:%v", err)
+			return err
+		}
+		//文件校验失败时，已有配置则保留，启动时仍加载以免无配置可用
+		if verr := s.checkAppConf(fileConf); verr != nil {
+			config.SysLog.Error("[app config from file:%s invalid] [err:%s]", configFile, verr.Error())
+			if s.hasAppConfig() {
+				return verr
+			}
+		}
+		s.setAppConfig(fileConf)
+		config.SysLog.Info("app_configured_by_file.")
 	}
 	config.SysLog.Info("APPConf:%v", s.appConfig)
 	return nil
 }
 
+// 替换内存中的租户配置并解析模块配额，配置已经过校验
+func (s *SysConfigManage) setAppConfig(conf *running.Apps) {
+	appIDMap := map[string]*entity.GatewayAPP{}
+	quotaMap := map[string]map[string]Quota{}
+	for _, app := range conf.Apps {
+		appIDMap[app.AppID] = app
+		if quotas, err := ParseModuleQuota(app.ModuleQuota); err == nil && len(quotas) > 0 {
+			quotaMap[app.AppID] = quotas
+		}
+	}
+	s.appConfigLocker.Lock()
+	s.appConfig = conf
+	s.appIDMap = appIDMap
+	s.appQuotaMap = quotaMap
+	s.appConfigLocker.Unlock()
+}
+
+// GetAppModuleQuota 租户在指定模块上的配额
+func (s *SysConfigManage) GetAppModuleQuota(appID, moduleName string) Quota {
+	s.appConfigLocker.RLock()
+	defer s.appConfigLocker.RUnlock()
+	return s.appQuotaMap[appID][moduleName]
+}
+
 // 配置模块服务发现检测
-// 已运行模块周期刷新，直到IpContext停止
-func (s *SysConfigManage) checkIPList() {
-	moduleConfiger := s.GetModuleConfig()
-	s.moduleIPListMapLocker.Lock()
-	s.moduleForbidIPListMapLocker.Lock()
-	for _, module := range moduleConfiger.Module {
-		s.moduleIPListMap[module.Base.Name] = strings.Split(module.LoadBalance.IPList, ",")
-		s.moduleForbidIPListMap[module.Base.Name] = strings.Split(module.LoadBalance.ForbidList, ",")
+// 模块周期刷新节点并探活，直到模块上下文停止
+// 服务发现失败时保留上一次的节点列表，key为模块名或分组运行时key
+func (s *SysConfigManage) checkIPList(ctx context.Context, key string, balance *entity.GatewayLoadBalance) {
+	nodeDiscovery, err := discovery.New(balance)
+	if err != nil {
+		config.SysLog.Error("[discovery init error] [module:%s] [err:%s]", key, err.Error())
+		return
 	}
-	s.moduleForbidIPListMapLocker.Unlock()
-	s.moduleIPListMapLocker.Unlock()
-	for _, modulePt := range moduleConfiger.Module {
-		module := modulePt
-		go func() {
-			defer func() {
-				if err := recover(); err != nil {
-					config.SysLog.Warn("checkModuleIpList_recover:%v", err)
+	s.refreshModuleNodes(key, nodeDiscovery)
+	s.setNodeStateList(key, balance)
+	s.setAvailableIPList(key, s.excludeDisabledIPList(key, discovery.Addrs(s.GetModuleNodes(key))))
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				config.SysLog.Warn("checkModuleIpList_recover:%v", err)
+			}
+		}()
+		t1 := time.NewTimer(time.Second * 10)
+	Loop:
+		for {
+			select {
+			case <-t1.C:
+				s.refreshModuleNodes(key, nodeDiscovery)
+				configIPList := discovery.Addrs(s.GetModuleNodes(key))
+				activeIPList := s.checkModuleIPList(balance, configIPList)
+				s.moduleActiveIPListMapLocker.Lock()
+				oldActiveIPList, checked := s.moduleActiveIPListMap[key]
+				s.moduleActiveIPListMap[key] = activeIPList
+				s.moduleActiveIPListMapLocker.Unlock()
+				//首次探活前视为全部正常，只发布探活失败的节点
+				if !checked {
+					oldActiveIPList = configIPList
 				}
-			}()
-			t1 := time.NewTimer(time.Second * 10)
-		Loop:
-			for {
-				select {
-				case <-t1.C:
-					activeIPList := s.checkModuleIPList(module.LoadBalance)
-					s.moduleActiveIPListMapLocker.Lock()
-					s.moduleActiveIPListMap[module.Base.Name] = activeIPList
-					s.moduleActiveIPListMapLocker.Unlock()
-
-					s.moduleForbidIPListMapLocker.Lock()
-					forbidIPList, ok := s.moduleForbidIPListMap[module.Base.Name]
-					s.moduleForbidIPListMapLocker.Unlock()
-					if !ok {
-						forbidIPList = []string{}
-					}
+				publishHealthEvents(key, configIPList, oldActiveIPList, activeIPList)
+
+				// 剔除禁用及排空中的节点
+				newIPList := s.excludeDisabledIPList(key, activeIPList)
+				s.setAvailableIPList(key, newIPList)
+				config.SysLog.Info("%s CheckModuleIpList newIPList=%+v configIPList=%+v", key, newIPList, configIPList)
+				t1.Reset(time.Millisecond * time.Duration(balance.CheckInterval))
+			case <-ctx.Done():
+				t1.Stop()
+				config.SysLog.Info(key + "_CheckModuleIpList done")
+				break Loop
+			}
+		}
+	}()
+}
 
-					// 剔除禁用节点
-					newIPList := []string{}
-					for _, newIP := range activeIPList {
+// 更新节点的关闭及排空列表，列表变化时发布节点状态事件
+func (s *SysConfigManage) setNodeStateList(key string, balance *entity.GatewayLoadBalance) {
+	forbidIPList := strings.Split(balance.ForbidList, ",")
+	drainIPList := strings.Split(balance.DrainList, ",")
+	s.moduleForbidIPListMapLocker.Lock()
+	oldForbidIPList, reload := s.moduleForbidIPListMap[key]
+	oldDrainIPList := s.moduleDrainIPListMap[key]
+	s.moduleForbidIPListMap[key] = forbidIPList
+	s.moduleDrainIPListMap[key] = drainIPList
+	s.moduleForbidIPListMapLocker.Unlock()
+	if reload {
+		publishNodeStateEvents(key, oldForbidIPList, oldDrainIPList, forbidIPList, drainIPList)
+	}
+}
 
-						if !util.InStringList(newIP, forbidIPList) {
-							newIPList = append(newIPList, newIP)
-						}
-					}
+// 仅节点关闭或排空列表变化时原地更新可用节点，不重启模块
+// 保留transport、探活状态、负载位置及并发隔离的计数
+func (s *SysConfigManage) updateModuleNodeState(module *running.GatewayModule) {
+	balances := map[string]*entity.GatewayLoadBalance{module.Base.Name: module.LoadBalance}
+	for _, group := range module.UpstreamGroups {
+		balances[UpstreamGroupKey(module.Base.Name, group.Name)] = groupLoadBalance(module, group)
+	}
+	for key, balance := range balances {
+		s.setNodeStateList(key, balance)
+		s.moduleActiveIPListMapLocker.RLock()
+		ipList, checked := s.moduleActiveIPListMap[key]
+		s.moduleActiveIPListMapLocker.RUnlock()
+		//首次探活前视为全部正常
+		if !checked {
+			ipList = discovery.Addrs(s.GetModuleNodes(key))
+		}
+		s.setAvailableIPList(key, s.excludeDisabledIPList(key, ipList))
+	}
+	config.SysLog.Info("[update module node state] [module:%s]", module.Base.Name)
+}
+
+// 发布节点探活状态变化事件
+func publishHealthEvents(key string, configIPList, oldActiveIPList, activeIPList []string) {
+	moduleName, prefix := splitUpstreamGroupKey(key)
+	if prefix != "" {
+		prefix = "分组" + prefix + " "
+	}
+	for _, ip := range configIPList {
+		if ip == "" {
+			continue
+		}
+		wasActive := util.InStringList(ip, oldActiveIPList)
+		isActive := util.InStringList(ip, activeIPList)
+		switch {
+		case wasActive && !isActive:
+			resource.Events.Publish(&resource.Event{Type: resource.EventUpstreamDown, Module: moduleName, Target: ip, Message: prefix + "节点探活失败"})
+		case !wasActive && isActive:
+			resource.Events.Publish(&resource.Event{Type: resource.EventUpstreamUp, Module: moduleName, Target: ip, Message: prefix + "节点探活恢复"})
+		}
+	}
+}
+
+// 发布节点关闭、排空及恢复流量事件，节点状态以关闭优先
+func publishNodeStateEvents(key string, oldForbidIPList, oldDrainIPList, forbidIPList, drainIPList []string) {
+	moduleName, prefix := splitUpstreamGroupKey(key)
+	if prefix != "" {
+		prefix = "分组" + prefix + " "
+	}
+	state := func(ip string, forbid, drain []string) string {
+		switch {
+		case util.InStringList(ip, forbid):
+			return resource.EventNodeForbid
+		case util.InStringList(ip, drain):
+			return resource.EventNodeDrain
+		}
+		return resource.EventNodeOpen
+	}
+	messages := map[string]string{
+		resource.EventNodeForbid: "节点已关闭流量",
+		resource.EventNodeDrain:  "节点开始排空流量",
+		resource.EventNodeOpen:   "节点已恢复流量",
+	}
+	seen := map[string]bool{}
+	for _, list := range [][]string{oldForbidIPList, oldDrainIPList, forbidIPList, drainIPList} {
+		for _, ip := range list {
+			if ip == "" || seen[ip] {
+				continue
+			}
+			seen[ip] = true
+			oldState := state(ip, oldForbidIPList, oldDrainIPList)
+			newState := state(ip, forbidIPList, drainIPList)
+			if oldState != newState {
+				resource.Events.Publish(&resource.Event{Type: newState, Module: moduleName, Target: ip, Message: prefix + messages[newState]})
+			}
+		}
+	}
+}
+
+// 剔除禁用及排空中的节点
+func (s *SysConfigManage) excludeDisabledIPList(key string, ipList []string) []string {
+	s.moduleForbidIPListMapLocker.RLock()
+	forbidIPList := s.moduleForbidIPListMap[key]
+	drainIPList := s.moduleDrainIPListMap[key]
+	s.moduleForbidIPListMapLocker.RUnlock()
+	newIPList := []string{}
+	for _, ip := range ipList {
+		if !util.InStringList(ip, forbidIPList) && !util.InStringList(ip, drainIPList) {
+			newIPList = append(newIPList, ip)
+		}
+	}
+	return newIPList
+}
 
-					configIPList := strings.Split(module.LoadBalance.IPList, ",")
-					s.moduleIPListMapLocker.Lock()
-					s.moduleIPListMap[module.Base.Name] = newIPList
-					s.moduleIPListMapLocker.Unlock()
-					config.SysLog.Info("%s CheckModuleIpList newIPList=%+v configIPList=%+v", module.Base.Name, newIPList, configIPList)
-					t1.Reset(time.Millisecond * time.Duration(module.LoadBalance.CheckInterval))
-				case <-s.ConfigChangeNotice():
-					config.SysLog.Info(module.Base.Name + "_CheckModuleIpList done")
-					break Loop
+// 更新可用ip，并记录新加入节点的时间
+// 模块重载时沿用重载前的可用ip，只有重新打开、恢复健康或新增的节点才进入慢启动
+func (s *SysConfigManage) setAvailableIPList(key string, ipList []string) {
+	now := time.Now()
+	s.moduleIPListMapLocker.Lock()
+	defer s.moduleIPListMapLocker.Unlock()
+	oldIPList, loaded := s.moduleIPListMap[key]
+	oldStart := s.moduleNodeStartMap[key]
+	nodeStart := map[string]time.Time{}
+	for _, ip := range ipList {
+		if start, ok := oldStart[ip]; ok && util.InStringList(ip, oldIPList) {
+			nodeStart[ip] = start
+		} else if loaded && !util.InStringList(ip, oldIPList) {
+			nodeStart[ip] = now
+		}
+	}
+	s.moduleIPListMap[key] = ipList
+	s.moduleNodeStartMap[key] = nodeStart
+}
+
+// 慢启动中的节点按加入时间线性提升权重，最低为1
+func (s *SysConfigManage) slowStartWeightMap(key string, ipList []string, weightMap map[string]int64, slowStart int) map[string]int64 {
+	s.moduleIPListMapLocker.RLock()
+	nodeStart := s.moduleNodeStartMap[key]
+	s.moduleIPListMapLocker.RUnlock()
+	window := time.Duration(slowStart) * time.Millisecond
+	newWeightMap := map[string]int64{}
+	for _, ip := range ipList {
+		weight, ok := weightMap[ip]
+		if !ok {
+			weight = constant.IPDefaultWeight
+		}
+		if start, ok := nodeStart[ip]; ok && window > 0 {
+			if elapsed := time.Since(start); elapsed < window {
+				weight = int64(math.Ceil(float64(weight) * float64(elapsed) / float64(window)))
+				if weight < 1 {
+					weight = 1
 				}
 			}
-		}()
+		}
+		newWeightMap[ip] = weight
+	}
+	return newWeightMap
+}
+
+// SlowStartProgress 节点慢启动进度，0-100，未在慢启动中为100
+func (s *SysConfigManage) SlowStartProgress(moduleName, ip string) int {
+	module := s.GetModuleConfigByName(moduleName)
+	if module == nil || module.LoadBalance.SlowStart <= 0 {
+		return 100
+	}
+	s.moduleIPListMapLocker.RLock()
+	start, ok := s.moduleNodeStartMap[moduleName][ip]
+	s.moduleIPListMapLocker.RUnlock()
+	window := time.Duration(module.LoadBalance.SlowStart) * time.Millisecond
+	if !ok || time.Since(start) >= window {
+		return 100
+	}
+	return int(time.Since(start) * 100 / window)
+}
+
+// 刷新模块节点，失败时保留原节点
+// 返回空列表时需连续DiscoveryEmptyThreshold次才清空原节点
+func (s *SysConfigManage) refreshModuleNodes(moduleName string, nodeDiscovery discovery.Discovery) {
+	nodes, err := nodeDiscovery.Nodes()
+	if err != nil {
+		config.SysLog.Warn("[discovery error] [module:%s] [err:%s]", moduleName, err.Error())
+		return
+	}
+	s.moduleNodeMapLocker.Lock()
+	defer s.moduleNodeMapLocker.Unlock()
+	if len(nodes) == 0 && len(s.moduleNodeMap[moduleName]) > 0 {
+		s.moduleEmptyNodeCount[moduleName]++
+		if s.moduleEmptyNodeCount[moduleName] < constant.DiscoveryEmptyThreshold {
+			config.SysLog.Warn("[discovery empty] [module:%s] [count:%d] keep last nodes", moduleName, s.moduleEmptyNodeCount[moduleName])
+			return
+		}
 	}
+	delete(s.moduleEmptyNodeCount, moduleName)
+	s.moduleNodeMap[moduleName] = nodes
 }
 
 // 后端服务器探活
 // 返回存活状态的ip列表
-func (s *SysConfigManage) checkModuleIPList(balance *entity.GatewayLoadBalance) []string {
+func (s *SysConfigManage) checkModuleIPList(balance *entity.GatewayLoadBalance, ipList []string) []string {
 	newIPList := []string{}
-	ipList := strings.Split(balance.IPList, ",")
 	for _, ip := range ipList {
 		checkURL := fmt.Sprintf("http://%s%s", ip, balance.CheckURL)
 		response, _, err := util.HttpGET(checkURL, nil, balance.CheckInterval, nil)
@@ -408,7 +900,7 @@ func (s *SysConfigManage) checkModuleIPList(balance *entity.GatewayLoadBalance)
 }
 
 // 检查Module配置合法性
-// Base配置不能为空，LoadBalance配置不能为空
+// Base配置不能为空，LoadBalance配置不能为空，其余规则见ValidateConfig
 func (s *SysConfigManage) checkModuleConf(conf *running.Modules) error {
 	if conf == nil || len(conf.Module) == 0 {
 		return errors.New("conf is empty")
@@ -421,7 +913,7 @@ func (s *SysConfigManage) checkModuleConf(conf *running.Modules) error {
 			return errors.New("module.load_balance is empty")
 		}
 	}
-	return nil
+	return ValidateConfig(conf, nil).Err()
 }
 
 // 读取配置文件中的ModuleConfig
@@ -429,7 +921,7 @@ func (s *SysConfigManage) getFileModuleConf(confPath string) (*running.Modules,
 	moduleConf := &running.Modules{}
 	file, err := os.Open(confPath)
 	if err != nil {
-		return moduleConf, err
+		return nil, err
 	}
 	defer file.Close()
 	bts, err := ioutil.ReadAll(file)
@@ -439,29 +931,12 @@ func (s *SysConfigManage) getFileModuleConf(confPath string) (*running.Modules,
 	if err := json.Unmarshal(bts, moduleConf); err != nil {
 		return nil, err
 	}
-	if err := s.checkModuleConf(moduleConf); err != nil {
-		return nil, err
-	}
 	return moduleConf, nil
 }
 
 // 将ModuleConf写入到配置文件
 func (s *SysConfigManage) writeFileModuleConf(confPath string, moduleConf *running.Modules) error {
-	var out bytes.Buffer
-	data, err := json.Marshal(moduleConf)
-	if err != nil {
-		return err
-	}
-	err = json.Indent(&out, data, "", "\t")
-	bakPath := strings.Replace(confPath, ".json", "_bak.json", -1)
-	os.Remove(bakPath)
-	if err := os.Rename(confPath, bakPath); err != nil {
-		return err
-	}
-	if err := ioutil.WriteFile(confPath, out.Bytes(), 0644); err != nil {
-		return err
-	}
-	return nil
+	return writeConfigFile(confPath, moduleConf)
 }
 
 // 读取DB中的ModuleConfig
@@ -471,49 +946,10 @@ func (s *SysConfigManage) getDBModuleConf(isCheck bool) (*running.Modules, error
 			config.SysLog.Error("GetDBModuleConf_recover:%v", err)
 		}
 	}()
-	moduleConf := &running.Modules{Module: make(map[string]*running.GatewayModule)}
-	bases, err := (&entity.GatewayModuleBase{}).GetAll(config.DB)
-	if err != nil {
-		return nil, err
-	}
-	matchRuleArr, err := (&entity.GatewayMatchRule{}).GetAll(config.DB)
-	if err != nil {
-		return nil, err
-	}
-	accessControlArr, err := (&entity.GatewayAccessControl{}).GetAll(config.DB)
+	moduleConf, err := loadDBModules(config.DB)
 	if err != nil {
 		return nil, err
 	}
-	loadBalanceArr, err := (&entity.GatewayLoadBalance{}).GetAll(config.DB)
-	if err != nil {
-		return nil, err
-	}
-	for _, base := range bases {
-		matchRules := &entity.GatewayMatchRule{}
-		for _, x := range matchRuleArr {
-			if x.ModuleID == base.ID {
-				matchRules = x
-			}
-		}
-		accessControl := &entity.GatewayAccessControl{}
-		for _, x := range accessControlArr {
-			if x.ModuleID == base.ID {
-				accessControl = x
-			}
-		}
-		loadBalance := &entity.GatewayLoadBalance{}
-		for _, x := range loadBalanceArr {
-			if x.ModuleID == base.ID {
-				loadBalance = x
-			}
-		}
-		moduleConf.Module[base.Name] = &running.GatewayModule{
-			Base:          base,
-			MatchRule:     matchRules,
-			AccessControl: accessControl,
-			LoadBalance:   loadBalance,
-		}
-	}
 	if isCheck {
 		if err := s.checkModuleConf(moduleConf); err != nil {
 			return nil, err
@@ -538,7 +974,7 @@ func (s *SysConfigManage) checkAppConf(conf *running.Apps) error {
 			return errors.New("app.app_id is empty")
 		}
 	}
-	return nil
+	return ValidateConfig(nil, conf).Err()
 }
 
 // 获取配置文件中的App
@@ -546,7 +982,7 @@ func (s *SysConfigManage) getFileAPPConf(confPath string) (*running.Apps, error)
 	appConf := &running.Apps{}
 	file, err := os.Open(confPath)
 	if err != nil {
-		return appConf, err
+		return nil, err
 	}
 	defer file.Close()
 	bts, err := ioutil.ReadAll(file)
@@ -556,9 +992,6 @@ func (s *SysConfigManage) getFileAPPConf(confPath string) (*running.Apps, error)
 	if err := json.Unmarshal(bts, appConf); err != nil {
 		return nil, err
 	}
-	if err := s.checkAppConf(appConf); err != nil {
-		return nil, err
-	}
 	return appConf, nil
 }
 
@@ -569,15 +1002,10 @@ func (s *SysConfigManage) getDBAPPConf(isCheck bool) (*running.Apps, error) {
 			config.SysLog.Error("GetDBAPPConf_recover:%v", err)
 		}
 	}()
-	db := config.DB
-	apps, err := (&entity.GatewayAPP{}).GetAll(db)
+	appConf, err := loadDBApps(config.DB)
 	if err != nil {
 		return nil, err
 	}
-	appConf := &running.Apps{Apps: make(map[string]*entity.GatewayAPP)}
-	for _, app := range apps {
-		appConf.Apps[app.Name] = app
-	}
 	if isCheck {
 		if err := s.checkAppConf(appConf); err != nil {
 			return nil, err
@@ -588,153 +1016,152 @@ func (s *SysConfigManage) getDBAPPConf(isCheck bool) (*running.Apps, error) {
 
 // 将AppConf写入到配置文件当中
 func (s *SysConfigManage) writeFileAppConf(confPath string, appConf *running.Apps) error {
-	var out bytes.Buffer
-	data, err := json.Marshal(appConf)
-	if err != nil {
-		return err
-	}
-	err = json.Indent(&out, data, "", "\t")
-	bakPath := strings.Replace(confPath, ".json", "_bak.json", -1)
-	os.Remove(bakPath)
-	if err := os.Rename(confPath, bakPath); err != nil {
-		return err
-	}
-	if err := ioutil.WriteFile(confPath, out.Bytes(), 0644); err != nil {
-		return err
-	}
-	return nil
+	return writeConfigFile(confPath, appConf)
 }
 
-// 配置模块负载信息到ModuleRRMap+已运行模块周期刷新，直到IpContext停止
-func (s *SysConfigManage) configModuleRR() error {
-	modules := s.GetModuleConfig()
-	for _, modulePointer := range modules.Module {
-		currentModule := modulePointer
-		go func(currentModule *running.GatewayModule) {
-			defer func() {
-				if err := recover(); err != nil {
-					config.SysLog.Error("ConfigModuleRR_recover:%v", err)
-				}
-			}()
-			if currentModule.Base.LoadType != "http" {
-				return
+// 配置模块负载信息到ModuleRRMap
+// 模块周期刷新，直到模块上下文停止，key为模块名或分组运行时key
+func (s *SysConfigManage) configModuleRR(ctx context.Context, key string, currentModule *running.GatewayModule) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				config.SysLog.Error("ConfigModuleRR_recover:%v", err)
 			}
-			t1 := time.NewTimer(0)
-			ipList := []string{}
-			ipWeightMap := map[string]int64{}
-		Loop:
-			for {
-				select {
-				case <-t1.C:
-					newIPList := s.GetAvailableIPList(currentModule.Base.Name)
-					newIPWeightMap := s.GetConfIPWeightMap(currentModule, constant.IPDefaultWeight)
-					if !reflect.DeepEqual(ipList, newIPList) || !reflect.DeepEqual(ipWeightMap, newIPWeightMap) {
-						Rw := core.NewWeightedRR(core.RRNginx)
-						for _, ipAddr := range newIPList {
-							w, ok := newIPWeightMap[ipAddr]
-							if ok {
-								Rw.Add(ipAddr, int(w))
-							} else {
-								Rw.Add(ipAddr, constant.IPDefaultWeight)
-							}
-						}
-						s.moduleRRMapLocker.Lock()
-						s.moduleRRMap[currentModule.Base.Name] = Rw
-						s.moduleRRMapLocker.Unlock()
+		}()
+		if currentModule.Base.LoadType != "http" {
+			return
+		}
+		t1 := time.NewTimer(0)
+		ipList := []string{}
+		ipWeightMap := map[string]int64{}
+	Loop:
+		for {
+			select {
+			case <-t1.C:
+				newIPList := s.GetAvailableIPList(key)
+				newIPWeightMap := s.slowStartWeightMap(key, newIPList, s.GetModuleWeightMap(key), currentModule.LoadBalance.SlowStart)
+				if !reflect.DeepEqual(ipList, newIPList) || !reflect.DeepEqual(ipWeightMap, newIPWeightMap) {
+					Rw := core.NewWeightedRR(core.RRNginx)
+					for _, ipAddr := range newIPList {
+						Rw.Add(ipAddr, int(newIPWeightMap[ipAddr]))
 					}
-					ipList = newIPList
-					ipWeightMap = newIPWeightMap
-					t1.Reset(time.Millisecond * time.Duration(currentModule.LoadBalance.CheckInterval))
-				case <-s.ConfigChangeNotice():
-					t1.Stop()
-					break Loop
+					s.moduleRRMapLocker.Lock()
+					s.moduleRRMap[key] = Rw
+					s.moduleRRMapLocker.Unlock()
 				}
+				ipList = newIPList
+				ipWeightMap = newIPWeightMap
+				t1.Reset(time.Millisecond * time.Duration(currentModule.LoadBalance.CheckInterval))
+			case <-ctx.Done():
+				t1.Stop()
+				break Loop
 			}
-		}(currentModule)
-	}
-	return nil
+		}
+	}()
 }
 
 // 配置Transport和ProxyFunc
-func (s *SysConfigManage) configModuleProxyMap() error {
-	modules := s.GetModuleConfig()
-	for _, modulePointer := range modules.Module {
-		currentModule := modulePointer
-		proxyFunc := func(rr core.RR) *httputil.ReverseProxy {
-			mtp, _ := s.getModuleTransport(currentModule.Base.Name)
-			proxy := &httputil.ReverseProxy{
-				Director: func(req *http.Request) {
-					if rHost, ok := rr.Next().(string); ok {
-						req.URL.Scheme = "http"
-						if req.TLS != nil {
-							req.URL.Scheme = "https"
-						}
-						req.URL.Host = rHost
-						req.Host = config.BaseConf.Http.ReqHost
-					}
-				},
-				ModifyResponse: func(response *http.Response) error {
-					if strings.Contains(response.Header.Get("Connection"), "Upgrade") {
-						return nil
-					}
-					var payload []byte
-					var readErr error
-					if strings.Contains(response.Header.Get("Content-Encoding"), "gzip") {
-						gr, err := gzip.NewReader(response.Body)
-						if err != nil {
-							config.SysLog.Error("err:%s", err.Error())
-						}
-						payload, readErr = ioutil.ReadAll(gr)
-						response.Header.Del(constant.ContentEncoding)
-					} else {
-						payload, readErr = ioutil.ReadAll(response.Body)
+func (s *SysConfigManage) configModuleProxyMap(currentModule *running.GatewayModule, headerConf *proxyHeaderConf) {
+	nodeLimit := int64(currentModule.LoadBalance.MaxConcurrencyPerNode)
+	proxyFunc := func(rr core.RR) *httputil.ReverseProxy {
+		mtp, _ := s.getModuleTransport(currentModule.Base.Name)
+		proxy := &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				trace := upstreamTraceFromContext(req.Context())
+				attempts := 1
+				if nodeLimit > 0 && trace != nil {
+					attempts = len(s.GetAvailableIPList(trace.key))
+				}
+				if rHost, ok := pickUpstream(rr, trace, nodeLimit, attempts); ok {
+					req.URL.Scheme = "http"
+					if req.TLS != nil {
+						req.URL.Scheme = "https"
 					}
-					if readErr != nil {
-						return readErr
+					req.URL.Host = rHost
+					vars := proxyVarsFromContext(req.Context())
+					vars.upstreamAddr = rHost
+					headerConf.applyRequest(req, vars)
+					trace.begin(req, rHost)
+				}
+			},
+			ModifyResponse: func(response *http.Response) error {
+				if response.Request != nil {
+					upstreamTraceFromContext(response.Request.Context()).end(response.StatusCode)
+				}
+				headerConf.applyResponse(response)
+				if strings.Contains(response.Header.Get("Connection"), "Upgrade") {
+					return nil
+				}
+				var payload []byte
+				var readErr error
+				if strings.Contains(response.Header.Get("Content-Encoding"), "gzip") {
+					gr, err := gzip.NewReader(response.Body)
+					if err != nil {
+						config.SysLog.Error("err:%s", err.Error())
 					}
+					payload, readErr = ioutil.ReadAll(gr)
+					response.Header.Del(constant.ContentEncoding)
+				} else {
+					payload, readErr = ioutil.ReadAll(response.Body)
+				}
+				if readErr != nil {
+					return readErr
+				}
 
-					newPayload := payload
+				newPayload := payload
 
-					//过滤请求数据
-					response.Body = ioutil.NopCloser(bytes.NewBuffer(newPayload))
-					response.ContentLength = int64(len(newPayload))
-					response.Header.Set("Content-Length", strconv.FormatInt(int64(len(newPayload)), 10))
-					//if err := ModifyResponse(currentModule, response.Request, response); err != nil {
-					//	return err
-					//}
-					return nil
-				},
-				Transport: mtp,
-				ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
-					util.HTTPError(http.StatusGatewayTimeout, fmt.Sprint(err), w, req)
+				//过滤请求数据
+				response.Body = ioutil.NopCloser(bytes.NewBuffer(newPayload))
+				response.ContentLength = int64(len(newPayload))
+				response.Header.Set("Content-Length", strconv.FormatInt(int64(len(newPayload)), 10))
+				//if err := ModifyResponse(currentModule, response.Request, response); err != nil {
+				//	return err
+				//}
+				return nil
+			},
+			Transport: newBulkheadTransport(mtp),
+			ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+				if err == ErrUpstreamBusy {
+					upstreamTraceFromContext(req.Context()).fail(err, constant.DLTagBulkheadFailure)
+					gerr := busyError(err)
+					PublishLimitTrip(currentModule.Base.Name, "", gerr)
+					s.WriteGatewayError(w, req, currentModule.Base.Name, gerr)
 					return
-				},
-			}
-			return proxy
-		}
-		mtp := &http.Transport{
-			//请求下游的时间
-			DialContext: (&net.Dialer{
-				//限制建立TCP连接的时间
-				Timeout: time.Duration(currentModule.LoadBalance.ProxyConnectTimeout) * time.Millisecond,
-			}).DialContext,
-			//单机最大连接数
-			MaxConnsPerHost: 0,
-			//最大空闲链接数
-			MaxIdleConns: currentModule.LoadBalance.MaxIdleConn,
-			//链接最大空闲时间
-			IdleConnTimeout: time.Duration(currentModule.LoadBalance.IdleConnTimeout) * time.Millisecond,
-			//限制读取response header的时间
-			ResponseHeaderTimeout: time.Duration(currentModule.LoadBalance.ProxyHeaderTimeout) * time.Millisecond,
-		}
-		s.moduleTransportMapLocker.Lock()
-		s.moduleTransportMap[currentModule.Base.Name] = mtp
-		s.moduleTransportMapLocker.Unlock()
-		s.moduleProxyFuncMapLocker.Lock()
-		s.moduleProxyFuncMap[currentModule.Base.Name] = proxyFunc
-		s.moduleProxyFuncMapLocker.Unlock()
+				}
+				//流式转发中请求体超过模块限制
+				if body, ok := req.Body.(*LimitedBody); ok && body.Exceeded() {
+					upstreamTraceFromContext(req.Context()).fail(ErrBodyTooLarge, constant.DLTagBodyLimitFailure)
+					s.WriteGatewayError(w, req, currentModule.Base.Name, NewGatewayError(ErrCodeBodyTooLarge, ErrBodyTooLarge))
+					return
+				}
+				upstreamTraceFromContext(req.Context()).fail(err, "")
+				s.WriteGatewayError(w, req, currentModule.Base.Name, upstreamError(err))
+				return
+			},
+		}
+		return proxy
 	}
-	return nil
+	mtp := &http.Transport{
+		//请求下游的时间
+		DialContext: (&net.Dialer{
+			//限制建立TCP连接的时间
+			Timeout: time.Duration(currentModule.LoadBalance.ProxyConnectTimeout) * time.Millisecond,
+		}).DialContext,
+		//单机最大连接数
+		MaxConnsPerHost: currentModule.LoadBalance.MaxConnsPerHost,
+		//最大空闲链接数
+		MaxIdleConns: currentModule.LoadBalance.MaxIdleConn,
+		//链接最大空闲时间
+		IdleConnTimeout: time.Duration(currentModule.LoadBalance.IdleConnTimeout) * time.Millisecond,
+		//限制读取response header的时间
+		ResponseHeaderTimeout: time.Duration(currentModule.LoadBalance.ProxyHeaderTimeout) * time.Millisecond,
+	}
+	s.moduleTransportMapLocker.Lock()
+	s.moduleTransportMap[currentModule.Base.Name] = mtp
+	s.moduleTransportMapLocker.Unlock()
+	s.moduleProxyFuncMapLocker.Lock()
+	s.moduleProxyFuncMap[currentModule.Base.Name] = proxyFunc
+	s.moduleProxyFuncMapLocker.Unlock()
 }
 
 // 获取对应模块的Transport
@@ -747,3 +1174,206 @@ func (s *SysConfigManage) getModuleTransport(name string) (*http.Transport, erro
 	s.moduleTransportMapLocker.RUnlock()
 	return nil, errors.New("transport not found")
 }
+
+// 启动模块运行时：探活、负载刷新及代理配置
+func (s *SysConfigManage) startModule(module *running.GatewayModule) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.moduleContextMapLocker.Lock()
+	old, ok := s.moduleContextMap[module.Base.Name]
+	s.moduleContextMap[module.Base.Name] = &moduleContext{ctx: ctx, cancel: cancel}
+	s.moduleContextMapLocker.Unlock()
+	//已在运行时先停止旧协程，避免重复探活及刷新
+	if ok {
+		old.cancel()
+	}
+
+	s.checkIPList(ctx, module.Base.Name, module.LoadBalance)
+	s.configModuleRR(ctx, module.Base.Name, module)
+	for _, group := range module.UpstreamGroups {
+		key := UpstreamGroupKey(module.Base.Name, group.Name)
+		s.checkIPList(ctx, key, groupLoadBalance(module, group))
+		s.configModuleRR(ctx, key, module)
+	}
+	s.moduleGroupMapLocker.Lock()
+	s.moduleGroupMap[module.Base.Name] = newUpstreamGroups(module)
+	s.moduleGroupMapLocker.Unlock()
+	s.moduleRewriteMapLocker.Lock()
+	s.moduleRewriteMap[module.Base.Name] = newURLRewriteRules(module)
+	s.moduleRewriteMapLocker.Unlock()
+	s.moduleCacheMapLocker.Lock()
+	s.moduleCacheMap[module.Base.Name] = newModuleCache(module)
+	s.moduleCacheMapLocker.Unlock()
+	s.moduleErrorPagesMapLocker.Lock()
+	s.moduleErrorPagesMap[module.Base.Name] = newErrorPages(module)
+	s.moduleErrorPagesMapLocker.Unlock()
+	s.moduleMirrorMapLocker.Lock()
+	headerConf := newProxyHeaderConf(module)
+	s.moduleMirrorMap[module.Base.Name] = newModuleMirror(module, headerConf)
+	s.moduleMirrorMapLocker.Unlock()
+	s.moduleBulkheadMapLocker.Lock()
+	s.moduleBulkheadMap[module.Base.Name] = newModuleBulkhead(module)
+	s.moduleBulkheadMapLocker.Unlock()
+	s.configModuleProxyMap(module, headerConf)
+	config.SysLog.Info("[start module] [module:%s]", module.Base.Name)
+}
+
+// 停止模块运行时协程，并释放旧transport的空闲连接
+func (s *SysConfigManage) stopModule(moduleName string) {
+	s.moduleContextMapLocker.Lock()
+	mc, ok := s.moduleContextMap[moduleName]
+	delete(s.moduleContextMap, moduleName)
+	s.moduleContextMapLocker.Unlock()
+	if ok {
+		mc.cancel()
+	}
+	if mtp, err := s.getModuleTransport(moduleName); err == nil {
+		mtp.CloseIdleConnections()
+	}
+	config.SysLog.Info("[stop module] [module:%s]", moduleName)
+}
+
+// 清理已删除模块的运行时数据
+func (s *SysConfigManage) cleanModule(moduleName string) {
+	s.cleanModuleGroups(moduleName)
+
+	s.moduleGroupMapLocker.Lock()
+	delete(s.moduleGroupMap, moduleName)
+	s.moduleGroupMapLocker.Unlock()
+
+	s.moduleMirrorMapLocker.Lock()
+	delete(s.moduleMirrorMap, moduleName)
+	s.moduleMirrorMapLocker.Unlock()
+
+	s.moduleBulkheadMapLocker.Lock()
+	delete(s.moduleBulkheadMap, moduleName)
+	s.moduleBulkheadMapLocker.Unlock()
+
+	s.moduleRewriteMapLocker.Lock()
+	delete(s.moduleRewriteMap, moduleName)
+	s.moduleRewriteMapLocker.Unlock()
+
+	s.moduleCacheMapLocker.Lock()
+	delete(s.moduleCacheMap, moduleName)
+	s.moduleCacheMapLocker.Unlock()
+
+	s.moduleErrorPagesMapLocker.Lock()
+	delete(s.moduleErrorPagesMap, moduleName)
+	s.moduleErrorPagesMapLocker.Unlock()
+
+	s.moduleNodeMapLocker.Lock()
+	delete(s.moduleNodeMap, moduleName)
+	delete(s.moduleEmptyNodeCount, moduleName)
+	s.moduleNodeMapLocker.Unlock()
+
+	s.moduleIPListMapLocker.Lock()
+	delete(s.moduleIPListMap, moduleName)
+	delete(s.moduleNodeStartMap, moduleName)
+	s.moduleIPListMapLocker.Unlock()
+
+	s.moduleActiveIPListMapLocker.Lock()
+	delete(s.moduleActiveIPListMap, moduleName)
+	s.moduleActiveIPListMapLocker.Unlock()
+
+	s.moduleForbidIPListMapLocker.Lock()
+	delete(s.moduleForbidIPListMap, moduleName)
+	delete(s.moduleDrainIPListMap, moduleName)
+	s.moduleForbidIPListMapLocker.Unlock()
+
+	s.moduleProxyFuncMapLocker.Lock()
+	delete(s.moduleProxyFuncMap, moduleName)
+	s.moduleProxyFuncMapLocker.Unlock()
+
+	s.moduleTransportMapLocker.Lock()
+	delete(s.moduleTransportMap, moduleName)
+	s.moduleTransportMapLocker.Unlock()
+
+	s.moduleRRMapLocker.Lock()
+	delete(s.moduleRRMap, moduleName)
+	s.moduleRRMapLocker.Unlock()
+}
+
+// 清理模块下各分组的运行时数据，分组在模块重建时按新配置重新生成
+func (s *SysConfigManage) cleanModuleGroups(moduleName string) {
+	prefix := moduleName + ":"
+	s.moduleNodeMapLocker.Lock()
+	for key := range s.moduleNodeMap {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.moduleNodeMap, key)
+			delete(s.moduleEmptyNodeCount, key)
+		}
+	}
+	s.moduleNodeMapLocker.Unlock()
+
+	s.moduleIPListMapLocker.Lock()
+	for key := range s.moduleIPListMap {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.moduleIPListMap, key)
+			delete(s.moduleNodeStartMap, key)
+		}
+	}
+	s.moduleIPListMapLocker.Unlock()
+
+	s.moduleActiveIPListMapLocker.Lock()
+	for key := range s.moduleActiveIPListMap {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.moduleActiveIPListMap, key)
+		}
+	}
+	s.moduleActiveIPListMapLocker.Unlock()
+
+	s.moduleForbidIPListMapLocker.Lock()
+	for key := range s.moduleForbidIPListMap {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.moduleForbidIPListMap, key)
+			delete(s.moduleDrainIPListMap, key)
+		}
+	}
+	s.moduleForbidIPListMapLocker.Unlock()
+
+	s.moduleRRMapLocker.Lock()
+	for key := range s.moduleRRMap {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.moduleRRMap, key)
+		}
+	}
+	s.moduleRRMapLocker.Unlock()
+}
+
+// 比较新旧模块配置
+// 返回新增模块、需重启的变更模块、仅节点关闭或排空列表变化的模块及已删除模块名
+func diffModuleConf(oldConf, newConf *running.Modules) (added, changed, nodeChanged []*running.GatewayModule, removed []string) {
+	for name, newModule := range newConf.Module {
+		oldModule, ok := oldConf.Module[name]
+		if !ok {
+			added = append(added, newModule)
+			continue
+		}
+		if reflect.DeepEqual(oldModule, newModule) {
+			continue
+		}
+		if onlyNodeStateChanged(oldModule, newModule) {
+			nodeChanged = append(nodeChanged, newModule)
+		} else {
+			changed = append(changed, newModule)
+		}
+	}
+	for name := range oldConf.Module {
+		if _, ok := newConf.Module[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	return added, changed, nodeChanged, removed
+}
+
+// 两份模块配置是否只有节点关闭及排空列表不同
+func onlyNodeStateChanged(oldModule, newModule *running.GatewayModule) bool {
+	if oldModule.LoadBalance == nil || newModule.LoadBalance == nil {
+		return false
+	}
+	lb := *oldModule.LoadBalance
+	lb.ForbidList = newModule.LoadBalance.ForbidList
+	lb.DrainList = newModule.LoadBalance.DrainList
+	module := *oldModule
+	module.LoadBalance = &lb
+	return reflect.DeepEqual(&module, newModule)
+}
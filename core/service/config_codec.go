@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"gatekeeper/model/entity"
+	"gatekeeper/model/running"
+)
+
+// 声明式配置文件格式
+const (
+	ConfigFormatJSON = "json"
+	ConfigFormatYAML = "yaml"
+	ConfigFormatTOML = "toml"
+)
+
+// ConfigFormatByPath 根据文件扩展名识别配置格式，无法识别时为json
+func ConfigFormatByPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ConfigFormatYAML
+	case ".toml":
+		return ConfigFormatTOML
+	}
+	return ConfigFormatJSON
+}
+
+// ExportConfig 导出配置，去除自增主键便于在不同环境间同步
+func ExportConfig(snapshot *ConfigSnapshot, format string) ([]byte, error) {
+	export := &ConfigSnapshot{
+		Module: map[string]*running.GatewayModule{},
+		Apps:   map[string]*entity.GatewayAPP{},
+	}
+	for name, module := range snapshot.Module {
+		stripped := stripModuleID(module)
+		export.Module[name] = &stripped
+	}
+	for name, app := range snapshot.Apps {
+		stripped := *app
+		stripped.ID = 0
+		export.Apps[name] = &stripped
+	}
+
+	switch format {
+	case ConfigFormatJSON:
+		return json.MarshalIndent(export, "", "  ")
+	case ConfigFormatYAML:
+		tree, err := jsonTree(export)
+		if err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(tree)
+	case ConfigFormatTOML:
+		buf := &bytes.Buffer{}
+		if err := toml.NewEncoder(buf).Encode(export); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, errors.New("unknown config format: " + format)
+}
+
+// ImportConfig 解析声明式配置
+// 文件中缺少module或apps时对应字段为nil，由调用方决定是否保留现有配置
+func ImportConfig(data []byte, format string) (*ConfigSnapshot, error) {
+	snapshot := &ConfigSnapshot{}
+	switch format {
+	case ConfigFormatJSON:
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			return nil, err
+		}
+	case ConfigFormatYAML:
+		var tree interface{}
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return nil, err
+		}
+		bts, err := json.Marshal(yamlToJSON(tree))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(bts, snapshot); err != nil {
+			return nil, err
+		}
+	case ConfigFormatTOML:
+		if _, err := toml.Decode(string(data), snapshot); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unknown config format: " + format)
+	}
+
+	// 名称以map的key为准，省略时自动补全
+	for name, module := range snapshot.Module {
+		if module == nil || module.Base == nil {
+			return nil, errors.Errorf("module %s: base is empty", name)
+		}
+		if module.Base.Name == "" {
+			module.Base.Name = name
+		}
+	}
+	for name, app := range snapshot.Apps {
+		if app == nil {
+			return nil, errors.Errorf("app %s is empty", name)
+		}
+		if app.Name == "" {
+			app.Name = name
+		}
+	}
+	return snapshot, nil
+}
+
+// ChangeContent 去除自增主键后的模块或租户json，用于展示变更差异
+func ChangeContent(v interface{}) string {
+	var target interface{}
+	switch x := v.(type) {
+	case *running.GatewayModule:
+		stripped := stripModuleID(x)
+		target = &stripped
+	case *entity.GatewayAPP:
+		stripped := *x
+		stripped.ID = 0
+		target = &stripped
+	default:
+		return ""
+	}
+	data, err := json.MarshalIndent(target, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// jsonTree 按json标签将结构体转为通用结构，整数保持为int64
+func jsonTree(v interface{}) (interface{}, error) {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bts))
+	decoder.UseNumber()
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return normalizeNumber(tree), nil
+}
+
+func normalizeNumber(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, item := range x {
+			x[k] = normalizeNumber(item)
+		}
+	case []interface{}:
+		for i, item := range x {
+			x[i] = normalizeNumber(item)
+		}
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	}
+	return v
+}
+
+// yamlToJSON yaml解析出的map[interface{}]interface{}转为json可序列化的结构
+func yamlToJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			m[fmt.Sprint(k)] = yamlToJSON(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range x {
+			x[i] = yamlToJSON(item)
+		}
+	}
+	return v
+}
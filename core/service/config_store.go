@@ -0,0 +1,556 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"gatekeeper/model/entity"
+	"gatekeeper/model/running"
+)
+
+// 配置类型
+const (
+	ConfigTypeModule = "module"
+	ConfigTypeApp    = "app"
+	ConfigTypeConfig = "config" //全量配置
+)
+
+// 配置变更动作
+const (
+	ConfigActionCreate   = "create"
+	ConfigActionUpdate   = "update"
+	ConfigActionDelete   = "delete"
+	ConfigActionOpen     = "open"
+	ConfigActionClose    = "close"
+	ConfigActionDrain    = "drain"
+	ConfigActionRollback = "rollback"
+	ConfigActionImport   = "import"
+)
+
+// ConfigSnapshot 全量配置快照
+type ConfigSnapshot struct {
+	Module map[string]*running.GatewayModule `json:"module" toml:"module"`
+	Apps   map[string]*entity.GatewayAPP     `json:"apps" toml:"apps"`
+}
+
+// ConfigChange 单个模块或租户的配置变更
+type ConfigChange struct {
+	Type   string      `json:"type"`
+	Name   string      `json:"name"`
+	Action string      `json:"action"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// LoadDBConfig 读取DB中的模块及租户配置
+// 传入事务时可读取到事务内未提交的变更
+func LoadDBConfig(db *gorm.DB) (*ConfigSnapshot, error) {
+	modules, err := loadDBModules(db)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := loadDBApps(db)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigSnapshot{Module: modules.Module, Apps: apps.Apps}, nil
+}
+
+// LoadDBTarget 仅读取DB中指定模块或租户的配置，返回json内容，不存在时返回空字符串
+// 与全量快照的Target输出一致，用于审计等只关心单个配置项的场景
+func LoadDBTarget(db *gorm.DB, configType, name string) (string, error) {
+	snapshot := &ConfigSnapshot{
+		Module: map[string]*running.GatewayModule{},
+		Apps:   map[string]*entity.GatewayAPP{},
+	}
+	switch configType {
+	case ConfigTypeModule:
+		module, err := loadDBModule(db, name)
+		if err != nil {
+			return "", err
+		}
+		if module != nil {
+			snapshot.Module[name] = module
+		}
+	case ConfigTypeApp:
+		app, err := (&entity.GatewayAPP{}).FindByAppID(db, name)
+		if err != nil {
+			return "", err
+		}
+		if app.AppID == name {
+			snapshot.Apps[app.Name] = app
+		}
+	default:
+		full, err := LoadDBConfig(db)
+		if err != nil {
+			return "", err
+		}
+		snapshot = full
+	}
+	return snapshot.Target(configType, name)
+}
+
+// loadDBModule 读取单个模块，关联记录缺省值与loadDBModules一致，模块不存在时返回nil
+func loadDBModule(db *gorm.DB, name string) (*running.GatewayModule, error) {
+	base, err := (&entity.GatewayModuleBase{}).FindByName(db, name)
+	if err != nil {
+		return nil, err
+	}
+	if base.Name != name {
+		return nil, nil
+	}
+	module := &running.GatewayModule{
+		Base:          base,
+		MatchRule:     &entity.GatewayMatchRule{},
+		AccessControl: &entity.GatewayAccessControl{},
+		LoadBalance:   &entity.GatewayLoadBalance{},
+	}
+	matchRules, err := (&entity.GatewayMatchRule{}).GetByModule(db, base.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchRules) > 0 {
+		module.MatchRule = matchRules[len(matchRules)-1]
+	}
+	accessControl, err := (&entity.GatewayAccessControl{}).GetByModule(db, base.ID)
+	if err != nil {
+		return nil, err
+	}
+	if accessControl != nil {
+		module.AccessControl = accessControl
+	}
+	loadBalance, err := (&entity.GatewayLoadBalance{}).GetByModule(db, base.ID)
+	if err != nil {
+		return nil, err
+	}
+	if loadBalance != nil {
+		module.LoadBalance = loadBalance
+	}
+	if module.UpstreamGroups, err = (&entity.GatewayUpstreamGroup{}).GetByModule(db, base.ID); err != nil {
+		return nil, err
+	}
+	if module.Cache, err = (&entity.GatewayCache{}).GetByModule(db, base.ID); err != nil {
+		return nil, err
+	}
+	return module, nil
+}
+
+func loadDBModules(db *gorm.DB) (*running.Modules, error) {
+	moduleConf := &running.Modules{Module: make(map[string]*running.GatewayModule)}
+	bases, err := (&entity.GatewayModuleBase{}).GetAll(db)
+	if err != nil {
+		return nil, err
+	}
+	matchRuleArr, err := (&entity.GatewayMatchRule{}).GetAll(db)
+	if err != nil {
+		return nil, err
+	}
+	accessControlArr, err := (&entity.GatewayAccessControl{}).GetAll(db)
+	if err != nil {
+		return nil, err
+	}
+	loadBalanceArr, err := (&entity.GatewayLoadBalance{}).GetAll(db)
+	if err != nil {
+		return nil, err
+	}
+	upstreamGroupArr, err := (&entity.GatewayUpstreamGroup{}).GetAll(db)
+	if err != nil {
+		return nil, err
+	}
+	cacheArr, err := (&entity.GatewayCache{}).GetAll(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, base := range bases {
+		matchRules := &entity.GatewayMatchRule{}
+		for _, x := range matchRuleArr {
+			if x.ModuleID == base.ID {
+				matchRules = x
+			}
+		}
+		accessControl := &entity.GatewayAccessControl{}
+		for _, x := range accessControlArr {
+			if x.ModuleID == base.ID {
+				accessControl = x
+			}
+		}
+		loadBalance := &entity.GatewayLoadBalance{}
+		for _, x := range loadBalanceArr {
+			if x.ModuleID == base.ID {
+				loadBalance = x
+			}
+		}
+		var upstreamGroups []*entity.GatewayUpstreamGroup
+		for _, x := range upstreamGroupArr {
+			if x.ModuleID == base.ID {
+				upstreamGroups = append(upstreamGroups, x)
+			}
+		}
+		var cache *entity.GatewayCache
+		for _, x := range cacheArr {
+			if x.ModuleID == base.ID {
+				cache = x
+			}
+		}
+		moduleConf.Module[base.Name] = &running.GatewayModule{
+			Base:           base,
+			MatchRule:      matchRules,
+			AccessControl:  accessControl,
+			LoadBalance:    loadBalance,
+			UpstreamGroups: upstreamGroups,
+			Cache:          cache,
+		}
+	}
+	return moduleConf, nil
+}
+
+func loadDBApps(db *gorm.DB) (*running.Apps, error) {
+	apps, err := (&entity.GatewayAPP{}).GetAll(db)
+	if err != nil {
+		return nil, err
+	}
+	appConf := &running.Apps{Apps: make(map[string]*entity.GatewayAPP)}
+	for _, app := range apps {
+		appConf.Apps[app.Name] = app
+	}
+	return appConf, nil
+}
+
+// PlanConfig 计算当前配置到目标配置所需的变更
+// 比较时忽略自增主键，按类型、名称排序输出
+func PlanConfig(current, target *ConfigSnapshot) []*ConfigChange {
+	changes := []*ConfigChange{}
+	for name, module := range target.Module {
+		old, ok := current.Module[name]
+		if !ok {
+			changes = append(changes, &ConfigChange{Type: ConfigTypeModule, Name: name, Action: ConfigActionCreate, After: module})
+		} else if !sameModule(old, module) {
+			changes = append(changes, &ConfigChange{Type: ConfigTypeModule, Name: name, Action: ConfigActionUpdate, Before: old, After: module})
+		}
+	}
+	for name, module := range current.Module {
+		if _, ok := target.Module[name]; !ok {
+			changes = append(changes, &ConfigChange{Type: ConfigTypeModule, Name: name, Action: ConfigActionDelete, Before: module})
+		}
+	}
+
+	currentApps := map[string]*entity.GatewayAPP{}
+	for _, app := range current.Apps {
+		currentApps[app.AppID] = app
+	}
+	targetApps := map[string]*entity.GatewayAPP{}
+	for _, app := range target.Apps {
+		targetApps[app.AppID] = app
+	}
+	for appID, app := range targetApps {
+		old, ok := currentApps[appID]
+		if !ok {
+			changes = append(changes, &ConfigChange{Type: ConfigTypeApp, Name: appID, Action: ConfigActionCreate, After: app})
+		} else if !sameApp(old, app) {
+			changes = append(changes, &ConfigChange{Type: ConfigTypeApp, Name: appID, Action: ConfigActionUpdate, Before: old, After: app})
+		}
+	}
+	for appID, app := range currentApps {
+		if _, ok := targetApps[appID]; !ok {
+			changes = append(changes, &ConfigChange{Type: ConfigTypeApp, Name: appID, Action: ConfigActionDelete, Before: app})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Type != changes[j].Type {
+			return changes[i].Type > changes[j].Type
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// PlanImport 计算导入配置所需的变更，导入文件中未包含module或apps时对应部分保持不变
+func PlanImport(current, target *ConfigSnapshot) []*ConfigChange {
+	merged := &ConfigSnapshot{Module: target.Module, Apps: target.Apps}
+	if merged.Module == nil {
+		merged.Module = current.Module
+	}
+	if merged.Apps == nil {
+		merged.Apps = current.Apps
+	}
+	return PlanConfig(current, merged)
+}
+
+// ApplyConfig 在事务中执行配置变更并记录历史
+// 变更后的全量配置校验失败时返回错误，由调用方回滚事务
+func ApplyConfig(tx *gorm.DB, changes []*ConfigChange, author, action string) error {
+	for _, change := range changes {
+		var err error
+		switch change.Type {
+		case ConfigTypeModule:
+			if change.Action == ConfigActionDelete {
+				err = DeleteDBModule(tx, change.Name)
+			} else if module, ok := change.After.(*running.GatewayModule); ok {
+				err = SaveDBModule(tx, module)
+			} else {
+				err = errors.New("invalid module change: " + change.Name)
+			}
+		case ConfigTypeApp:
+			if change.Action == ConfigActionDelete {
+				err = DeleteDBApp(tx, change.Name)
+			} else if app, ok := change.After.(*entity.GatewayAPP); ok {
+				err = SaveDBApp(tx, app)
+			} else {
+				err = errors.New("invalid app change: " + change.Name)
+			}
+		default:
+			err = errors.New("unknown config type: " + change.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshot, err := LoadDBConfig(tx)
+	if err != nil {
+		return err
+	}
+	if err := ValidateConfig(&running.Modules{Module: snapshot.Module}, &running.Apps{Apps: snapshot.Apps}).Err(); err != nil {
+		return err
+	}
+	// 同批次变更共用一份全量快照，由首条记录保存
+	var snapshotID int64
+	for _, change := range changes {
+		history := &entity.GatewayConfigHistory{
+			Type:   change.Type,
+			Name:   change.Name,
+			Action: action,
+			Author: author,
+		}
+		if history.Action == "" {
+			history.Action = change.Action
+		}
+		if err := saveHistory(tx, snapshot, history, snapshotID); err != nil {
+			return err
+		}
+		if snapshotID == 0 {
+			snapshotID = history.ID
+		}
+	}
+	return nil
+}
+
+// SaveDBModule 保存模块配置，已存在的模块沿用原模块id并重建关联记录
+func SaveDBModule(tx *gorm.DB, module *running.GatewayModule) error {
+	if module == nil || module.Base == nil || module.LoadBalance == nil {
+		return errors.New("module.base or module.load_balance is empty")
+	}
+	baseInfo, err := (&entity.GatewayModuleBase{}).FindByName(tx, module.Base.Name)
+	if err != nil {
+		return err
+	}
+	if err := DeleteDBModule(tx, module.Base.Name); err != nil {
+		return err
+	}
+
+	base := *module.Base
+	base.ID = baseInfo.ID
+	if err := base.Save(tx); err != nil {
+		return errors.Wrap(err, "GatewayModuleBase.Save")
+	}
+	if module.MatchRule != nil {
+		matchRule := *module.MatchRule
+		matchRule.ID = 0
+		matchRule.ModuleID = base.ID
+		if err := matchRule.Save(tx); err != nil {
+			return errors.Wrap(err, "GatewayMatchRule.Save")
+		}
+	}
+	loadBalance := *module.LoadBalance
+	loadBalance.ID = 0
+	loadBalance.ModuleID = base.ID
+	if err := loadBalance.Save(tx); err != nil {
+		return errors.Wrap(err, "GatewayLoadBalance.Save")
+	}
+	if module.AccessControl != nil {
+		accessControl := *module.AccessControl
+		accessControl.ID = 0
+		accessControl.ModuleID = base.ID
+		if err := accessControl.Save(tx); err != nil {
+			return errors.Wrap(err, "GatewayAccessControl.Save")
+		}
+	}
+	for _, group := range module.UpstreamGroups {
+		upstreamGroup := *group
+		upstreamGroup.ID = 0
+		upstreamGroup.ModuleID = base.ID
+		if err := upstreamGroup.Save(tx); err != nil {
+			return errors.Wrap(err, "GatewayUpstreamGroup.Save")
+		}
+	}
+	if module.Cache != nil {
+		cache := *module.Cache
+		cache.ID = 0
+		cache.ModuleID = base.ID
+		if err := cache.Save(tx); err != nil {
+			return errors.Wrap(err, "GatewayCache.Save")
+		}
+	}
+	return nil
+}
+
+// DeleteDBModule 删除模块及其关联记录，模块不存在时直接返回
+func DeleteDBModule(tx *gorm.DB, name string) error {
+	baseInfo, err := (&entity.GatewayModuleBase{}).FindByName(tx, name)
+	if err != nil {
+		return err
+	}
+	if baseInfo.Name != name {
+		return nil
+	}
+	if err := baseInfo.Del(tx); err != nil {
+		return err
+	}
+	if err := (&entity.GatewayMatchRule{ModuleID: baseInfo.ID}).Del(tx); err != nil {
+		return err
+	}
+	if err := (&entity.GatewayLoadBalance{ModuleID: baseInfo.ID}).Del(tx); err != nil {
+		return err
+	}
+	if err := (&entity.GatewayAccessControl{ModuleID: baseInfo.ID}).Del(tx); err != nil {
+		return err
+	}
+	if err := (&entity.GatewayUpstreamGroup{ModuleID: baseInfo.ID}).Del(tx); err != nil {
+		return err
+	}
+	return (&entity.GatewayCache{ModuleID: baseInfo.ID}).Del(tx)
+}
+
+// SaveDBApp 保存租户配置，已存在的租户沿用原主键
+func SaveDBApp(tx *gorm.DB, app *entity.GatewayAPP) error {
+	appInfo, err := (&entity.GatewayAPP{}).FindByAppID(tx, app.AppID)
+	if err != nil {
+		return err
+	}
+	newApp := *app
+	newApp.ID = appInfo.ID
+	return newApp.Save(tx)
+}
+
+// DeleteDBApp 删除租户，租户不存在时直接返回
+func DeleteDBApp(tx *gorm.DB, appID string) error {
+	appInfo, err := (&entity.GatewayAPP{}).FindByAppID(tx, appID)
+	if err != nil {
+		return err
+	}
+	if appInfo.AppID != appID {
+		return nil
+	}
+	return appInfo.Del(tx)
+}
+
+// RecordHistory 记录配置项变更后的快照
+// 模块以模块名标识，租户以app_id标识
+func RecordHistory(tx *gorm.DB, configType, name, action, author string) error {
+	snapshot, err := LoadDBConfig(tx)
+	if err != nil {
+		return err
+	}
+	history := &entity.GatewayConfigHistory{
+		Type:   configType,
+		Name:   name,
+		Action: action,
+		Author: author,
+	}
+	return saveHistory(tx, snapshot, history, 0)
+}
+
+// saveHistory 从变更后的快照中取出配置项内容并保存
+// snapshotID为0时保存全量快照，否则引用同批次已保存的快照
+func saveHistory(tx *gorm.DB, snapshot *ConfigSnapshot, history *entity.GatewayConfigHistory, snapshotID int64) error {
+	content, err := snapshot.Target(history.Type, history.Name)
+	if err != nil {
+		return err
+	}
+	history.Content = content
+	if snapshotID > 0 {
+		history.SnapshotID = snapshotID
+	} else if history.Snapshot, err = snapshot.Target(ConfigTypeConfig, ""); err != nil {
+		return err
+	}
+	return history.Save(tx)
+}
+
+// Target 获取快照中指定模块或租户的json内容，不存在时返回空字符串
+// configType为config时返回全量配置
+func (c *ConfigSnapshot) Target(configType, name string) (string, error) {
+	var target interface{}
+	switch configType {
+	case ConfigTypeModule:
+		if module, ok := c.Module[name]; ok {
+			target = module
+		}
+	case ConfigTypeApp:
+		for _, app := range c.Apps {
+			if app.AppID == name {
+				target = app
+			}
+		}
+	case ConfigTypeConfig:
+		target = c
+	}
+	if target == nil {
+		return "", nil
+	}
+	bts, err := json.MarshalIndent(target, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	return string(bts), nil
+}
+
+// 比较模块配置，忽略自增主键及模块id
+func sameModule(a, b *running.GatewayModule) bool {
+	return reflect.DeepEqual(stripModuleID(a), stripModuleID(b))
+}
+
+func stripModuleID(module *running.GatewayModule) running.GatewayModule {
+	stripped := running.GatewayModule{}
+	if module.Base != nil {
+		base := *module.Base
+		base.ID = 0
+		stripped.Base = &base
+	}
+	if module.MatchRule != nil {
+		matchRule := *module.MatchRule
+		matchRule.ID, matchRule.ModuleID = 0, 0
+		stripped.MatchRule = &matchRule
+	}
+	if module.LoadBalance != nil {
+		loadBalance := *module.LoadBalance
+		loadBalance.ID, loadBalance.ModuleID = 0, 0
+		stripped.LoadBalance = &loadBalance
+	}
+	if module.AccessControl != nil {
+		accessControl := *module.AccessControl
+		accessControl.ID, accessControl.ModuleID = 0, 0
+		stripped.AccessControl = &accessControl
+	}
+	for _, group := range module.UpstreamGroups {
+		upstreamGroup := *group
+		upstreamGroup.ID, upstreamGroup.ModuleID = 0, 0
+		stripped.UpstreamGroups = append(stripped.UpstreamGroups, &upstreamGroup)
+	}
+	if module.Cache != nil {
+		cache := *module.Cache
+		cache.ID, cache.ModuleID = 0, 0
+		stripped.Cache = &cache
+	}
+	return stripped
+}
+
+// 比较租户配置，忽略自增主键
+func sameApp(a, b *entity.GatewayAPP) bool {
+	x, y := *a, *b
+	x.ID, y.ID = 0, 0
+	return x == y
+}
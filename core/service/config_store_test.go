@@ -0,0 +1,118 @@
+package service
+
+import (
+	"testing"
+
+	"gatekeeper/model/entity"
+	"gatekeeper/model/running"
+)
+
+func TestPlanConfig(t *testing.T) {
+	withIDs := func(m *running.GatewayModule, id int64) *running.GatewayModule {
+		m.Base.ID = id
+		m.MatchRule.ID, m.MatchRule.ModuleID = id, id
+		m.LoadBalance.ID, m.LoadBalance.ModuleID = id, id
+		m.AccessControl.ID, m.AccessControl.ModuleID = id, id
+		return m
+	}
+	changedModule := testModule("a", "/a")
+	changedModule.LoadBalance.IPList = "127.0.0.1:9000"
+	changedApp := testApp("x")
+	changedApp.QPS = 10
+	storedApp := testApp("x")
+	storedApp.ID = 5
+
+	tests := []struct {
+		name    string
+		current *ConfigSnapshot
+		target  *ConfigSnapshot
+		want    []string //type:name:action，按输出顺序
+	}{
+		{
+			name:    "ids are ignored",
+			current: snapshot([]*running.GatewayModule{withIDs(testModule("a", "/a"), 3)}, []*entity.GatewayAPP{storedApp}),
+			target:  snapshot([]*running.GatewayModule{testModule("a", "/a")}, []*entity.GatewayAPP{testApp("x")}),
+			want:    []string{},
+		},
+		{
+			name:    "create update delete modules",
+			current: snapshot([]*running.GatewayModule{testModule("a", "/a"), testModule("c", "/c")}, nil),
+			target:  snapshot([]*running.GatewayModule{changedModule, testModule("b", "/b")}, nil),
+			want: []string{
+				"module:a:update",
+				"module:b:create",
+				"module:c:delete",
+			},
+		},
+		{
+			name:    "apps are keyed by app_id",
+			current: snapshot(nil, []*entity.GatewayAPP{testApp("x"), testApp("z")}),
+			target:  snapshot(nil, []*entity.GatewayAPP{changedApp, testApp("y")}),
+			want: []string{
+				"app:x:update",
+				"app:y:create",
+				"app:z:delete",
+			},
+		},
+		{
+			name:    "modules before apps",
+			current: snapshot(nil, nil),
+			target:  snapshot([]*running.GatewayModule{testModule("b", "/b")}, []*entity.GatewayAPP{testApp("a")}),
+			want: []string{
+				"module:b:create",
+				"app:a:create",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := PlanConfig(tt.current, tt.target)
+			got := []string{}
+			for _, change := range changes {
+				got = append(got, change.Type+":"+change.Name+":"+change.Action)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("changes %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("changes %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	current := snapshot([]*running.GatewayModule{testModule("a", "/a")}, []*entity.GatewayAPP{testApp("x")})
+	tests := []struct {
+		name   string
+		target *ConfigSnapshot
+		want   int
+	}{
+		{name: "missing parts are kept", target: &ConfigSnapshot{}, want: 0},
+		{name: "empty module removes modules", target: &ConfigSnapshot{Module: map[string]*running.GatewayModule{}}, want: 1},
+		{name: "empty apps removes apps", target: &ConfigSnapshot{Apps: map[string]*entity.GatewayAPP{}}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if changes := PlanImport(current, tt.target); len(changes) != tt.want {
+				t.Errorf("got %d changes, want %d", len(changes), tt.want)
+			}
+		})
+	}
+}
+
+func snapshot(modules []*running.GatewayModule, apps []*entity.GatewayAPP) *ConfigSnapshot {
+	s := &ConfigSnapshot{
+		Module: map[string]*running.GatewayModule{},
+		Apps:   map[string]*entity.GatewayAPP{},
+	}
+	for _, module := range modules {
+		s.Module[module.Base.Name] = module
+	}
+	for _, app := range apps {
+		s.Apps[app.Name] = app
+	}
+	return s
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"sort"
+	"testing"
+
+	"gatekeeper/model/running"
+)
+
+func TestDiffModuleConf(t *testing.T) {
+	modify := func(f func(m *running.GatewayModule)) *running.GatewayModule {
+		m := testModule("a", "/a")
+		f(m)
+		return m
+	}
+	tests := []struct {
+		name        string
+		old         []*running.GatewayModule
+		new         []*running.GatewayModule
+		added       []string
+		changed     []string
+		nodeChanged []string
+		removed     []string
+	}{
+		{
+			name: "unchanged",
+			old:  []*running.GatewayModule{testModule("a", "/a")},
+			new:  []*running.GatewayModule{testModule("a", "/a")},
+		},
+		{
+			name:    "added and removed",
+			old:     []*running.GatewayModule{testModule("a", "/a"), testModule("b", "/b")},
+			new:     []*running.GatewayModule{testModule("a", "/a"), testModule("c", "/c")},
+			added:   []string{"c"},
+			removed: []string{"b"},
+		},
+		{
+			name:    "ip list changed",
+			old:     []*running.GatewayModule{testModule("a", "/a")},
+			new:     []*running.GatewayModule{modify(func(m *running.GatewayModule) { m.LoadBalance.IPList = "127.0.0.1:8001" })},
+			changed: []string{"a"},
+		},
+		{
+			name: "forbid and drain changed",
+			old:  []*running.GatewayModule{testModule("a", "/a")},
+			new: []*running.GatewayModule{modify(func(m *running.GatewayModule) {
+				m.LoadBalance.ForbidList = "127.0.0.1:8001"
+				m.LoadBalance.DrainList = "127.0.0.1:8002"
+			})},
+			nodeChanged: []string{"a"},
+		},
+		{
+			name: "forbid changed with other fields",
+			old:  []*running.GatewayModule{testModule("a", "/a")},
+			new: []*running.GatewayModule{modify(func(m *running.GatewayModule) {
+				m.LoadBalance.ForbidList = "127.0.0.1:8001"
+				m.MatchRule.Rule = "/b"
+			})},
+			changed: []string{"a"},
+		},
+		{
+			name:    "empty load balance",
+			old:     []*running.GatewayModule{modify(func(m *running.GatewayModule) { m.LoadBalance = nil })},
+			new:     []*running.GatewayModule{testModule("a", "/a")},
+			changed: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, changed, nodeChanged, removed := diffModuleConf(modulesOf(tt.old), modulesOf(tt.new))
+			check := func(kind string, got, want []string) {
+				sort.Strings(got)
+				if len(got) != len(want) {
+					t.Errorf("%s = %v, want %v", kind, got, want)
+					return
+				}
+				for i := range got {
+					if got[i] != want[i] {
+						t.Errorf("%s = %v, want %v", kind, got, want)
+						return
+					}
+				}
+			}
+			check("added", moduleNames(added), tt.added)
+			check("changed", moduleNames(changed), tt.changed)
+			check("node changed", moduleNames(nodeChanged), tt.nodeChanged)
+			check("removed", removed, tt.removed)
+		})
+	}
+}
+
+func modulesOf(modules []*running.GatewayModule) *running.Modules {
+	conf := &running.Modules{Module: map[string]*running.GatewayModule{}}
+	for _, module := range modules {
+		conf.Module[module.Base.Name] = module
+	}
+	return conf
+}
+
+func moduleNames(modules []*running.GatewayModule) []string {
+	names := []string{}
+	for _, module := range modules {
+		names = append(names, module.Base.Name)
+	}
+	return names
+}
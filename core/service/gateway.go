@@ -1,16 +1,18 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
+	"gatekeeper/config"
 	"gatekeeper/constant"
 	"gatekeeper/core/resource"
 	"gatekeeper/model/running"
@@ -21,6 +23,11 @@ type GateWayService struct {
 	currentModule *running.GatewayModule
 	w             http.ResponseWriter
 	req           *http.Request
+	upstreamGroup string
+	requestID     string
+	redirect      *URLRedirect
+	trace         *upstreamTrace
+	rateLimit     *RateLimitStatus
 }
 
 func NewGateWayService(w http.ResponseWriter, req *http.Request) *GateWayService {
@@ -34,6 +41,11 @@ func (s *GateWayService) CurrentModule() *running.GatewayModule {
 	return s.currentModule
 }
 
+// RateLimit 租户配额的限流状态，未设置配额时为nil
+func (s *GateWayService) RateLimit() *RateLimitStatus {
+	return s.rateLimit
+}
+
 func (s *GateWayService) SetCurrentModule(currentModule *running.GatewayModule) {
 	s.currentModule = currentModule
 }
@@ -52,11 +64,11 @@ func (s *GateWayService) AccessControl() error {
 	// 首先校验app_id是否存在
 	appID := s.req.Header.Get("app_id")
 	if appID == "" {
-		return errors.New("app_id empty")
+		return NewGatewayError(ErrCodeAppIDRequired, errors.New("app_id empty"))
 	}
 	switch {
 	case s.authInBlackIPList():
-		return errors.New("msg:AuthInBlackIPList")
+		return NewGatewayError(ErrCodeIPForbidden, errors.New("msg:AuthInBlackIPList"))
 	case s.authInWhiteIPList():
 		return nil
 	case s.authInWhiteHostList():
@@ -64,7 +76,7 @@ func (s *GateWayService) AccessControl() error {
 	}
 
 	if err := s.authAppSign(appID); err != nil {
-		return err
+		return NewGatewayError(ErrCodeUnauthorized, err)
 	}
 
 	if err := s.authLimit(appID); err != nil {
@@ -73,58 +85,121 @@ func (s *GateWayService) AccessControl() error {
 	return nil
 }
 
+// 负载均衡：选择上游分组并返回该分组的代理
+// 分组没有可用节点时降级到默认分组
 func (s *GateWayService) LoadBalance() (*httputil.ReverseProxy, error) {
-	ipList, err := SysConfMgr.GetModuleIPList(s.currentModule.Base.Name)
+	moduleName := s.currentModule.Base.Name
+	s.upstreamGroup = SysConfMgr.SelectUpstreamGroup(moduleName, s.req)
+	ipList, err := SysConfMgr.GetModuleIPList(UpstreamGroupKey(moduleName, s.upstreamGroup))
+	if err != nil && s.upstreamGroup != DefaultUpstreamGroup {
+		config.SysLog.Warn("[upstream group empty, fallback to default] [module:%s] [group:%s]", moduleName, s.upstreamGroup)
+		s.upstreamGroup = DefaultUpstreamGroup
+		ipList, err = SysConfMgr.GetModuleIPList(moduleName)
+	}
 	if err != nil {
-		return nil, errors.New("get_iplist_error")
+		return nil, NewGatewayError(ErrCodeNoUpstream, errors.New("get_iplist_error"))
 	}
 	if len(ipList) == 0 {
-		return nil, errors.New("empty_iplist_error")
+		return nil, NewGatewayError(ErrCodeNoUpstream, errors.New("empty_iplist_error"))
 	}
 	proxy, err := s.GetModuleHTTPProxy()
 	if err != nil {
-		return nil, err
+		return nil, NewGatewayError(ErrCodeNoUpstream, err)
 	}
 	return proxy, nil
 }
 
 func (s *GateWayService) GetModuleHTTPProxy() (*httputil.ReverseProxy, error) {
-	proxy, err := SysConfMgr.GetModuleHTTPProxy(s.currentModule.Base.Name)
+	moduleName := s.currentModule.Base.Name
+	proxy, err := SysConfMgr.GetModuleHTTPProxy(moduleName, UpstreamGroupKey(moduleName, s.upstreamGroup))
 	if err != nil {
 		return &httputil.ReverseProxy{}, err
 	}
 	return proxy, nil
 }
 
+// RequestID 请求id，优先使用客户端传入的X-Request-Id
+func (s *GateWayService) RequestID() string {
+	if s.requestID == "" {
+		s.requestID = s.req.Header.Get(RequestIDHeader)
+		if s.requestID == "" {
+			s.requestID = newRequestID()
+		}
+	}
+	return s.requestID
+}
+
+// ProxyRequest 附带转发变量的请求，用于header规则中的变量替换
+func (s *GateWayService) ProxyRequest() *http.Request {
+	vars := &proxyVars{
+		clientIP:  util.RemoteIP(s.req),
+		appID:     s.req.Header.Get("app_id"),
+		module:    s.currentModule.Base.Name,
+		requestID: s.RequestID(),
+	}
+	s.trace = &upstreamTrace{key: UpstreamGroupKey(s.currentModule.Base.Name, s.upstreamGroup)}
+	ctx := context.WithValue(s.req.Context(), proxyVarsKey{}, vars)
+	return s.req.WithContext(context.WithValue(ctx, upstreamTraceKey{}, s.trace))
+}
+
+// ReleaseUpstream 转发结束，释放上游节点的进行中请求计数
+func (s *GateWayService) ReleaseUpstream() {
+	s.trace.release()
+}
+
+// AccessLog 填充访问日志中模块及上游转发相关的字段
+func (s *GateWayService) AccessLog(entry *AccessLogEntry) {
+	if s.currentModule != nil {
+		entry.Module = s.currentModule.Base.Name
+	}
+	//请求未转发时不生成请求id
+	if s.requestID != "" {
+		entry.RequestID = s.requestID
+	}
+	entry.UpstreamGroup = s.upstreamGroup
+	if s.trace == nil {
+		return
+	}
+	entry.UpstreamAddr = s.trace.addr
+	entry.UpstreamStatus = s.trace.status
+	entry.UpstreamLatency = s.trace.latency
+	if entry.Error == "" {
+		entry.Error = s.trace.err
+	}
+	if entry.RejectReason == "" && s.trace.reject != "" {
+		entry.RejectReason = strings.TrimSpace(s.trace.reject)
+	}
+}
+
+// UpstreamGroup 请求转发的上游分组，LoadBalance之后有效
+func (s *GateWayService) UpstreamGroup() string {
+	return s.upstreamGroup
+}
+
+// MatchRule 匹配模块并执行url重写，命中跳转规则时记录跳转信息
 func (s *GateWayService) MatchRule() error {
 	moduleName := s.req.Header.Get("module")
 	if moduleName == "" {
-		return errors.New("module name empty")
+		return NewGatewayError(ErrCodeModuleNotFound, errors.New("module name empty"))
 	}
 	module := SysConfMgr.GetModuleConfigByName(moduleName)
 	if module == nil {
-		return errors.New("module not found")
-	}
-	if module.MatchRule.URLRewrite != "" {
-		for _, uw := range strings.Split(module.MatchRule.URLRewrite, ",") {
-			uws := strings.Split(uw, " ")
-			if len(uws) == 2 {
-				re, err := regexp.Compile(uws[0])
-				if err != nil {
-					return err
-				}
-				rep := re.ReplaceAllString(s.req.URL.Path, uws[1])
-				s.req.URL.Path = rep
-				if s.req.URL.Path != s.req.URL.Path {
-					break
-				}
-			}
-		}
+		return NewGatewayError(ErrCodeModuleNotFound, errors.New("module not found: "+moduleName))
 	}
 	s.SetCurrentModule(module)
+	redirect, err := SysConfMgr.RewriteRequest(moduleName, s.req)
+	if err != nil {
+		return NewGatewayError(ErrCodeInternal, err)
+	}
+	s.redirect = redirect
 	return nil
 }
 
+// Redirect 命中的跳转规则，未命中时为nil
+func (s *GateWayService) Redirect() *URLRedirect {
+	return s.redirect
+}
+
 func (s *GateWayService) authModuleOpened() bool {
 	if s.currentModule.AccessControl.Open == 1 {
 		return true
@@ -195,7 +270,7 @@ func (s *GateWayService) authAppSign(appID string) error {
 func (s *GateWayService) authLimit(appID string) error {
 	appConfig, err := SysConfMgr.GetAppConfigByAPPID(appID)
 	if err != nil {
-		return err
+		return NewGatewayError(ErrCodeUnauthorized, err)
 	}
 	v := s.req.Context().Value("request_url")
 	reqPath, ok := v.(string)
@@ -204,14 +279,16 @@ func (s *GateWayService) authLimit(appID string) error {
 	}
 	if !util.InOrPrefixStringList(reqPath, strings.Split(appConfig.OpenAPI, ",")) {
 		errmsg := "You don't have rights for this path:" + reqPath + " - " + appConfig.OpenAPI
-		return errors.New(errmsg)
+		return NewGatewayError(ErrCodePathForbidden, errors.New(errmsg))
 	}
 
 	//限速器
 	limiter := resource.Limiters.GetLimiter(appID, appConfig.QPS)
 	if appConfig.QPS > 0 && limiter.Allow() == false {
 		errmsg := fmt.Sprintf("QPS limit : %d, %d", int64(limiter.Limit()), limiter.Burst())
-		return errors.New(errmsg)
+		gerr := NewGatewayError(ErrCodeRateLimited, errors.New(errmsg))
+		gerr.RetryAfter = time.Second
+		return gerr
 	}
 
 	if appConfig.GroupID > 0 {
@@ -219,11 +296,13 @@ func (s *GateWayService) authLimit(appID string) error {
 		s.req.Header.Add(constant.HeaderKeyUserGroupKey, constant.UserGroupPerfix+strconv.Itoa(int(appConfig.GroupID)))
 	}
 
-	counter := resource.FlowCounters.GetAPPCounter(appID)
-	if appConfig.TotalQueryDaily > 0 && counter.TotalCount > appConfig.TotalQueryDaily {
-		errmsg := fmt.Sprintf("total query daily limit: %d", appConfig.TotalQueryDaily)
-		return errors.New(errmsg)
+	//小时、日、月配额，租户整体与模块配额原子计数
+	moduleName := s.currentModule.Base.Name
+	status, err := ConsumeQuota(appID, moduleName, AppQuota(appConfig), SysConfMgr.GetAppModuleQuota(appID, moduleName))
+	s.rateLimit = status
+	if err != nil {
+		return err
 	}
-	counter.Increase()
+	resource.FlowCounters.GetAPPCounter(appID).Increase()
 	return nil
 }
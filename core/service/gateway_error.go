@@ -0,0 +1,355 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/core/resource"
+	"gatekeeper/model/running"
+	"gatekeeper/util"
+)
+
+// 网关错误码
+const (
+	ErrCodeModuleNotFound  = "module_not_found"
+	ErrCodeAppIDRequired   = "app_id_required"
+	ErrCodeUnauthorized    = "unauthorized"
+	ErrCodeIPForbidden     = "ip_forbidden"
+	ErrCodePathForbidden   = "path_forbidden"
+	ErrCodeRateLimited     = "rate_limited"
+	ErrCodeQuotaExceeded   = "quota_exceeded"
+	ErrCodeBodyTooLarge    = "body_too_large"
+	ErrCodeBadRequest      = "bad_request"
+	ErrCodeNoUpstream      = "no_upstream"
+	ErrCodeUpstreamBusy    = "upstream_busy"
+	ErrCodeUpstreamError   = "upstream_error"
+	ErrCodeUpstreamTimeout = "upstream_timeout"
+	ErrCodeInternal        = "internal_error"
+)
+
+// GatewayErrorDef 错误码对应的http状态码及返回给客户端的信息
+type GatewayErrorDef struct {
+	Code    string `json:"code"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// GatewayErrorCatalogue 网关错误码目录
+var GatewayErrorCatalogue = []*GatewayErrorDef{
+	{ErrCodeModuleNotFound, http.StatusNotFound, "no module matches the request"},
+	{ErrCodeAppIDRequired, http.StatusUnauthorized, "app_id is required"},
+	{ErrCodeUnauthorized, http.StatusUnauthorized, "invalid app_id or sign"},
+	{ErrCodeIPForbidden, http.StatusForbidden, "client ip is not allowed"},
+	{ErrCodePathForbidden, http.StatusForbidden, "app is not allowed to access this path"},
+	{ErrCodeRateLimited, http.StatusTooManyRequests, "too many requests"},
+	{ErrCodeQuotaExceeded, http.StatusTooManyRequests, "request quota exceeded"},
+	{ErrCodeBodyTooLarge, http.StatusRequestEntityTooLarge, "request body too large"},
+	{ErrCodeBadRequest, http.StatusBadRequest, "bad request"},
+	{ErrCodeNoUpstream, http.StatusServiceUnavailable, "no upstream available"},
+	{ErrCodeUpstreamBusy, http.StatusServiceUnavailable, "service busy, please retry later"},
+	{ErrCodeUpstreamError, http.StatusBadGateway, "upstream request failed"},
+	{ErrCodeUpstreamTimeout, http.StatusGatewayTimeout, "upstream timeout"},
+	{ErrCodeInternal, http.StatusInternalServerError, "internal error"},
+}
+
+var gatewayErrorDefs = func() map[string]*GatewayErrorDef {
+	defs := map[string]*GatewayErrorDef{}
+	for _, def := range GatewayErrorCatalogue {
+		defs[def.Code] = def
+	}
+	return defs
+}()
+
+// GatewayError 网关产生的错误
+// Error()包含内部原因，只用于日志，客户端只能看到错误码目录中的信息
+type GatewayError struct {
+	*GatewayErrorDef
+	Cause      error
+	RetryAfter time.Duration //大于0时输出Retry-After
+}
+
+// NewGatewayError 按错误码创建网关错误，cause为内部原因
+func NewGatewayError(code string, cause error) *GatewayError {
+	def, ok := gatewayErrorDefs[code]
+	if !ok {
+		def = gatewayErrorDefs[ErrCodeInternal]
+	}
+	return &GatewayError{GatewayErrorDef: def, Cause: cause}
+}
+
+func (e *GatewayError) Error() string {
+	if e.Cause == nil {
+		return e.Code
+	}
+	return e.Code + ": " + e.Cause.Error()
+}
+
+// AsGatewayError 转换为网关错误，其他错误视为内部错误
+func AsGatewayError(err error) *GatewayError {
+	if gerr, ok := err.(*GatewayError); ok {
+		return gerr
+	}
+	return NewGatewayError(ErrCodeInternal, err)
+}
+
+// PublishLimitTrip 发布限流、配额或并发隔离拒绝请求的事件，同一模块、目标及错误码每秒最多一条
+func PublishLimitTrip(module, target string, gerr *GatewayError) {
+	resource.Events.PublishThrottled(gerr.Code+"|"+module+"|"+target, &resource.Event{
+		Type:    resource.EventLimitTrip,
+		Module:  module,
+		Target:  target,
+		Message: gerr.Error(),
+	})
+}
+
+// 上游请求失败的错误，超时为504，其余为502
+func upstreamError(err error) *GatewayError {
+	if errors.Cause(err) == context.DeadlineExceeded {
+		return NewGatewayError(ErrCodeUpstreamTimeout, err)
+	}
+	if nerr, ok := errors.Cause(err).(net.Error); ok && nerr.Timeout() {
+		return NewGatewayError(ErrCodeUpstreamTimeout, err)
+	}
+	return NewGatewayError(ErrCodeUpstreamError, err)
+}
+
+// 错误页格式
+const (
+	ErrorPageJSON = "json"
+	ErrorPageHTML = "html"
+)
+
+// 错误页
+// 匹配优先级：错误码 > 状态码 > 状态码类别(如5xx) > *，同一优先级按客户端Accept选择json或html
+type errorPage struct {
+	match       string
+	format      string
+	contentType string
+	body        string
+}
+
+type errorPages []*errorPage
+
+// ParseErrorPages 解析模块错误页配置，每行一条：匹配 格式 模板
+// 匹配为错误码、状态码、状态码类别(如5xx)或*；格式为json或html；模板以@开头时读取该文件
+// 模板变量：$status $code $message $trace_id $request_id $module $retry_after
+func ParseErrorPages(s string) (errorPages, error) {
+	pages := errorPages{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(strings.Replace(s, "\r", "\n", -1), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || strings.TrimSpace(fields[2]) == "" {
+			return nil, errors.Errorf("want match, format and template: %s", line)
+		}
+		page := &errorPage{match: fields[0], format: fields[1], body: strings.TrimSpace(fields[2])}
+		if !validErrorPageMatch(page.match) {
+			return nil, errors.Errorf("unknown match %s, want error code, status, Nxx or *", page.match)
+		}
+		switch page.format {
+		case ErrorPageJSON:
+			page.contentType = "application/json; charset=utf-8"
+		case ErrorPageHTML:
+			page.contentType = "text/html; charset=utf-8"
+		default:
+			return nil, errors.Errorf("unknown format %s, want json or html", page.format)
+		}
+		if seen[page.match+" "+page.format] {
+			return nil, errors.Errorf("duplicate %s %s", page.match, page.format)
+		}
+		seen[page.match+" "+page.format] = true
+		if strings.HasPrefix(page.body, "@") {
+			body, err := ioutil.ReadFile(page.body[1:])
+			if err != nil {
+				return nil, errors.Wrap(err, "read template")
+			}
+			page.body = string(body)
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+func validErrorPageMatch(match string) bool {
+	if match == "*" {
+		return true
+	}
+	if _, ok := gatewayErrorDefs[match]; ok {
+		return true
+	}
+	if len(match) == 3 && match[0] >= '4' && match[0] <= '5' {
+		if match[1:] == "xx" {
+			return true
+		}
+		status, err := strconv.Atoi(match)
+		return err == nil && status >= 400 && status <= 599
+	}
+	return false
+}
+
+// 编译模块的错误页，配置已在校验时检查，解析失败时使用默认错误页
+func newErrorPages(module *running.GatewayModule) errorPages {
+	pages, err := ParseErrorPages(module.Base.ErrorPages)
+	if err != nil {
+		config.SysLog.Warn("[error pages invalid] [module:%s] [err:%s]", module.Base.Name, err.Error())
+		return errorPages{}
+	}
+	return pages
+}
+
+// 按错误及客户端偏好的格式选择错误页
+func (pages errorPages) find(gerr *GatewayError, formats []string) *errorPage {
+	status := strconv.Itoa(gerr.Status)
+	for _, match := range []string{gerr.Code, status, status[:1] + "xx", "*"} {
+		for _, format := range formats {
+			for _, page := range pages {
+				if page.match == match && page.format == format {
+					return page
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 默认错误页
+var defaultErrorPages = errorPages{
+	{match: "*", format: ErrorPageJSON, contentType: "application/json; charset=utf-8",
+		body: `{"errno":$status,"errmsg":"$message","code":"$code","data":"","trace_id":"$trace_id"}`},
+	{match: "*", format: ErrorPageHTML, contentType: "text/html; charset=utf-8",
+		body: "<html><head><title>$status $message</title></head><body><h1>$status $message</h1><p>$code</p><hr><p>trace_id: $trace_id</p></body></html>"},
+}
+
+// 按Accept选择错误页格式，html权重高于json时优先html
+func negotiateErrorFormat(accept string) []string {
+	var jsonQ, htmlQ float64
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch mediaType {
+		case "application/json", "application/*":
+			jsonQ = maxFloat(jsonQ, q)
+		case "text/html", "text/*":
+			htmlQ = maxFloat(htmlQ, q)
+		case "*/*":
+			jsonQ = maxFloat(jsonQ, q)
+			htmlQ = maxFloat(htmlQ, q)
+		}
+	}
+	if htmlQ > jsonQ {
+		return []string{ErrorPageHTML, ErrorPageJSON}
+	}
+	return []string{ErrorPageJSON, ErrorPageHTML}
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 替换模板变量，json模板按json字符串转义，html模板按html转义
+func (p *errorPage) render(gerr *GatewayError, req *http.Request, module string) []byte {
+	escape := html.EscapeString
+	if p.format == ErrorPageJSON {
+		escape = func(s string) string {
+			b, _ := json.Marshal(s)
+			return string(b[1 : len(b)-1])
+		}
+	}
+	retryAfter := ""
+	if gerr.RetryAfter > 0 {
+		retryAfter = strconv.FormatInt(ceilSeconds(gerr.RetryAfter), 10)
+	}
+	body := os.Expand(p.body, func(name string) string {
+		switch name {
+		case "status":
+			return strconv.Itoa(gerr.Status)
+		case "code":
+			return gerr.Code
+		case "message":
+			return escape(gerr.Message)
+		case "trace_id":
+			return util.TraceID(req.Context())
+		case "request_id":
+			return escape(req.Header.Get(RequestIDHeader))
+		case "module":
+			return escape(module)
+		case "retry_after":
+			return retryAfter
+		}
+		return ""
+	})
+	return []byte(body)
+}
+
+// 向上取整的秒数，至少为1
+func ceilSeconds(d time.Duration) int64 {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// WriteGatewayError 输出网关错误，使用模块自定义错误页，未配置时使用默认错误页
+// module为空时（未匹配到模块）只使用默认错误页
+func (s *SysConfigManage) WriteGatewayError(w http.ResponseWriter, req *http.Request, module string, gerr *GatewayError) {
+	formats := negotiateErrorFormat(req.Header.Get("Accept"))
+	var page *errorPage
+	if module != "" {
+		s.moduleErrorPagesMapLocker.RLock()
+		pages := s.moduleErrorPagesMap[module]
+		s.moduleErrorPagesMapLocker.RUnlock()
+		page = pages.find(gerr, formats)
+	}
+	if page == nil {
+		page = defaultErrorPages.find(gerr, formats)
+	}
+	body := page.render(gerr, req, module)
+	header := w.Header()
+	header.Set("Content-Type", page.contentType)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("X-Content-Type-Options", "nosniff")
+	if gerr.RetryAfter > 0 {
+		header.Set("Retry-After", strconv.FormatInt(ceilSeconds(gerr.RetryAfter), 10))
+	}
+	w.WriteHeader(gerr.Status)
+	w.Write(body)
+}
+
+// 错误页模板是否为合法json，仅对json格式校验
+func checkErrorPageJSON(page *errorPage) error {
+	if page.format != ErrorPageJSON {
+		return nil
+	}
+	sample := page.render(&GatewayError{GatewayErrorDef: gatewayErrorDefs[ErrCodeInternal], RetryAfter: time.Second},
+		&http.Request{Header: http.Header{}}, "module")
+	if !json.Valid(sample) {
+		return errors.Errorf("%s json template is not valid json", page.match)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/model/running"
+)
+
+// 转发Host模式
+const (
+	HostModeFixed    = "fixed"    //使用fixed_host，为空时使用全局req_host
+	HostModePreserve = "preserve" //保留客户端请求的Host
+	HostModeUpstream = "upstream" //使用后端节点地址
+)
+
+// header规则动作
+const (
+	headerActionSet    = "set"    //set Name value
+	headerActionAppend = "append" //append Name value
+	headerActionRemove = "remove" //remove Name
+	headerActionRename = "rename" //rename Name NewName
+)
+
+// header名称允许的字符
+var headerNameRegexp = regexp.MustCompile("^[0-9A-Za-z!#$%&'*+.^_`|~-]+$")
+
+// RequestIDHeader 请求id header，客户端未携带时由网关生成
+const RequestIDHeader = "X-Request-Id"
+
+// header规则
+// value支持变量：$client_ip、$app_id、$module、$request_id、$upstream_addr
+type headerRule struct {
+	action string
+	name   string
+	value  string
+}
+
+type headerRules []*headerRule
+
+// 解析header规则，每行一条，#开头为注释
+func parseHeaderRules(s string) (headerRules, error) {
+	rules := headerRules{}
+	for _, line := range strings.Split(strings.Replace(s, "\r", "\n", -1), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// 动作及名称以空白分隔，set/append的值为名称之后的剩余部分
+		fields := strings.Fields(line)
+		rule := &headerRule{action: fields[0]}
+		if len(fields) > 1 {
+			rule.name = fields[1]
+			rest := strings.TrimSpace(line[len(fields[0]):])
+			rule.value = strings.TrimSpace(rest[len(fields[1]):])
+		}
+		switch rule.action {
+		case headerActionSet, headerActionAppend:
+			if len(fields) < 3 {
+				return nil, errors.Errorf("%s requires name and value: %s", rule.action, line)
+			}
+		case headerActionRemove:
+			if len(fields) != 2 {
+				return nil, errors.Errorf("remove requires name only: %s", line)
+			}
+		case headerActionRename:
+			if len(fields) != 3 {
+				return nil, errors.Errorf("rename requires name and new name: %s", line)
+			}
+			if !headerNameRegexp.MatchString(rule.value) {
+				return nil, errors.Errorf("invalid header name %s: %s", rule.value, line)
+			}
+		default:
+			return nil, errors.Errorf("unknown action %s", rule.action)
+		}
+		if !headerNameRegexp.MatchString(rule.name) {
+			return nil, errors.Errorf("invalid header name %s: %s", rule.name, line)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// 按顺序执行header规则
+func (rules headerRules) apply(header http.Header, vars *proxyVars) {
+	for _, rule := range rules {
+		switch rule.action {
+		case headerActionSet:
+			header.Set(rule.name, vars.expand(rule.value))
+		case headerActionAppend:
+			header.Add(rule.name, vars.expand(rule.value))
+		case headerActionRemove:
+			header.Del(rule.name)
+		case headerActionRename:
+			if values, ok := header[http.CanonicalHeaderKey(rule.name)]; ok {
+				header.Del(rule.name)
+				for _, value := range values {
+					header.Add(rule.value, value)
+				}
+			}
+		}
+	}
+}
+
+// 转发变量，随请求context传递到代理
+type proxyVars struct {
+	clientIP     string
+	appID        string
+	module       string
+	requestID    string
+	upstreamAddr string
+}
+
+type proxyVarsKey struct{}
+
+// 替换值中的变量，未知变量替换为空
+func (v *proxyVars) expand(value string) string {
+	if v == nil || !strings.Contains(value, "$") {
+		return value
+	}
+	return os.Expand(value, func(name string) string {
+		switch name {
+		case "client_ip":
+			return v.clientIP
+		case "app_id":
+			return v.appID
+		case "module":
+			return v.module
+		case "request_id":
+			return v.requestID
+		case "upstream_addr":
+			return v.upstreamAddr
+		}
+		return ""
+	})
+}
+
+// 从请求context获取转发变量的副本，不存在时返回空变量
+func proxyVarsFromContext(ctx context.Context) *proxyVars {
+	if v, ok := ctx.Value(proxyVarsKey{}).(*proxyVars); ok {
+		vars := *v
+		return &vars
+	}
+	return &proxyVars{}
+}
+
+// 生成请求id
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// 模块转发header配置
+type proxyHeaderConf struct {
+	hostMode      string
+	fixedHost     string
+	requestRules  headerRules
+	responseRules headerRules
+}
+
+// 编译模块的header配置，规则已在配置校验时检查，解析失败的规则忽略
+func newProxyHeaderConf(module *running.GatewayModule) *proxyHeaderConf {
+	lb := module.LoadBalance
+	conf := &proxyHeaderConf{hostMode: lb.HostMode, fixedHost: lb.FixedHost}
+	var err error
+	if conf.requestRules, err = parseHeaderRules(lb.RequestHeaderRules); err != nil {
+		config.SysLog.Error("[request header rules error] [module:%s] [err:%s]", module.Base.Name, err.Error())
+	}
+	if conf.responseRules, err = parseHeaderRules(lb.ResponseHeaderRules); err != nil {
+		config.SysLog.Error("[response header rules error] [module:%s] [err:%s]", module.Base.Name, err.Error())
+	}
+	return conf
+}
+
+// 设置转发请求的Host并执行请求header规则
+func (c *proxyHeaderConf) applyRequest(req *http.Request, vars *proxyVars) {
+	switch c.hostMode {
+	case HostModePreserve:
+	case HostModeUpstream:
+		req.Host = vars.upstreamAddr
+	default:
+		req.Host = c.fixedHost
+		if req.Host == "" {
+			req.Host = config.BaseConf.Http.ReqHost
+		}
+	}
+	c.requestRules.apply(req.Header, vars)
+}
+
+// 执行响应header规则
+func (c *proxyHeaderConf) applyResponse(resp *http.Response) {
+	if len(c.responseRules) == 0 || resp.Request == nil {
+		return
+	}
+	vars := proxyVarsFromContext(resp.Request.Context())
+	vars.upstreamAddr = resp.Request.URL.Host
+	c.responseRules.apply(resp.Header, vars)
+}
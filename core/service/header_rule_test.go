@@ -0,0 +1,133 @@
+package service
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaderRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    headerRules
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  headerRules{},
+		},
+		{
+			name:  "comment and blank lines",
+			input: "# comment\n\n  \nremove X-Debug\n",
+			want:  headerRules{{action: headerActionRemove, name: "X-Debug"}},
+		},
+		{
+			name:  "value keeps inner spaces",
+			input: "set X-Forwarded-By  gatekeeper  $module ",
+			want:  headerRules{{action: headerActionSet, name: "X-Forwarded-By", value: "gatekeeper  $module"}},
+		},
+		{
+			name:  "tab separated",
+			input: "append\tX-Tag\tv1",
+			want:  headerRules{{action: headerActionAppend, name: "X-Tag", value: "v1"}},
+		},
+		{
+			name:  "crlf line endings",
+			input: "set X-A 1\r\nrename X-B X-C\r\n",
+			want: headerRules{
+				{action: headerActionSet, name: "X-A", value: "1"},
+				{action: headerActionRename, name: "X-B", value: "X-C"},
+			},
+		},
+		{
+			name:    "set without value",
+			input:   "set X-A",
+			wantErr: true,
+		},
+		{
+			name:    "remove with value",
+			input:   "remove X-A 1",
+			wantErr: true,
+		},
+		{
+			name:    "rename without new name",
+			input:   "rename X-A",
+			wantErr: true,
+		},
+		{
+			name:    "rename to invalid name",
+			input:   "rename X-A X:B",
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			input:   "set X(A) 1",
+			wantErr: true,
+		},
+		{
+			name:    "unknown action",
+			input:   "drop X-A",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaderRules(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderRulesApply(t *testing.T) {
+	vars := &proxyVars{clientIP: "10.0.0.1", appID: "app", module: "svc", requestID: "rid"}
+	tests := []struct {
+		name   string
+		rules  string
+		header http.Header
+		want   http.Header
+	}{
+		{
+			name:   "set with variables",
+			rules:  "set X-Client $client_ip/$app_id/$module/$request_id/$unknown",
+			header: http.Header{},
+			want:   http.Header{"X-Client": {"10.0.0.1/app/svc/rid/"}},
+		},
+		{
+			name:   "append keeps existing",
+			rules:  "append X-Tag b",
+			header: http.Header{"X-Tag": {"a"}},
+			want:   http.Header{"X-Tag": {"a", "b"}},
+		},
+		{
+			name:   "rename moves all values",
+			rules:  "rename x-old X-New",
+			header: http.Header{"X-Old": {"1", "2"}},
+			want:   http.Header{"X-New": {"1", "2"}},
+		},
+		{
+			name:   "rules run in order",
+			rules:  "set X-A 1\nremove X-A\nset X-B 2",
+			header: http.Header{},
+			want:   http.Header{"X-B": {"2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseHeaderRules(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules.apply(tt.header, vars)
+			if !reflect.DeepEqual(tt.header, tt.want) {
+				t.Errorf("got %v, want %v", tt.header, tt.want)
+			}
+		})
+	}
+}
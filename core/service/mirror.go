@@ -0,0 +1,137 @@
+package service
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"gatekeeper/config"
+	"gatekeeper/constant"
+	"gatekeeper/core/resource"
+	"gatekeeper/model/running"
+)
+
+// 模块流量镜像
+// 镜像请求异步发送到镜像分组，响应丢弃，并发达到上限时直接丢弃镜像请求，不影响主请求
+type moduleMirror struct {
+	moduleName string
+	group      string
+	percent    int64
+	sem        chan struct{}
+	client     *http.Client
+	headerConf *proxyHeaderConf
+}
+
+// 按模块配置创建流量镜像，未配置镜像分组时返回nil
+// 镜像请求与主请求使用相同的header规则
+func newModuleMirror(module *running.GatewayModule, headerConf *proxyHeaderConf) *moduleMirror {
+	lb := module.LoadBalance
+	if lb.MirrorGroup == "" || lb.MirrorPercent <= 0 {
+		return nil
+	}
+	concurrency := lb.MirrorMaxConcurrency
+	if concurrency <= 0 {
+		concurrency = constant.MirrorDefaultConcurrency
+	}
+	return &moduleMirror{
+		moduleName: module.Base.Name,
+		group:      lb.MirrorGroup,
+		percent:    lb.MirrorPercent,
+		sem:        make(chan struct{}, concurrency),
+		headerConf: headerConf,
+		client: &http.Client{
+			//镜像使用独立的连接池，避免占用主请求的连接
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout: time.Duration(lb.ProxyConnectTimeout) * time.Millisecond,
+				}).DialContext,
+				MaxIdleConnsPerHost:   concurrency,
+				IdleConnTimeout:       time.Duration(lb.IdleConnTimeout) * time.Millisecond,
+				ResponseHeaderTimeout: time.Duration(lb.ProxyHeaderTimeout) * time.Millisecond,
+			},
+			Timeout: time.Duration(lb.ProxyConnectTimeout+lb.ProxyHeaderTimeout+lb.ProxyBodyTimeout) * time.Millisecond,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// MirrorStatKey 镜像统计key
+func MirrorStatKey(moduleName, group string) string {
+	return moduleName + ":" + group + ":mirror"
+}
+
+// 按镜像比例抽样，命中时才需要缓存请求体
+func (m *moduleMirror) sample() bool {
+	return rand.Int63n(100) < m.percent
+}
+
+// 复制请求到镜像分组，body为缓存的请求体
+func (m *moduleMirror) mirror(req *http.Request, body *RequestBody) {
+	stat := resource.GroupStats.GetGroupStat(MirrorStatKey(m.moduleName, m.group))
+	select {
+	case m.sem <- struct{}{}:
+	default:
+		stat.RecordDrop()
+		return
+	}
+	rr, err := SysConfMgr.GetModuleRR(UpstreamGroupKey(m.moduleName, m.group))
+	if err != nil {
+		<-m.sem
+		stat.RecordDrop()
+		return
+	}
+	host, ok := rr.Next().(string)
+	if !ok || host == "" {
+		<-m.sem
+		stat.RecordDrop()
+		return
+	}
+	var reader io.ReadCloser = http.NoBody
+	if body.Size() > 0 {
+		if reader, err = body.NewReader(); err != nil {
+			<-m.sem
+			stat.RecordDrop()
+			return
+		}
+	}
+	mirrorReq, err := http.NewRequest(req.Method, "http://"+host+req.URL.RequestURI(), reader)
+	if err != nil {
+		reader.Close()
+		<-m.sem
+		stat.RecordDrop()
+		return
+	}
+	mirrorReq.ContentLength = body.Size()
+	mirrorReq.Header = copyHeader(req.Header)
+	mirrorReq.Host = req.Host
+	vars := proxyVarsFromContext(req.Context())
+	vars.upstreamAddr = host
+	m.headerConf.applyRequest(mirrorReq, vars)
+	mirrorReq.Header.Set(constant.MirrorHeader, "1")
+	trace := TraceFromContext(req.Context())
+	trace.Inject(mirrorReq.Header, trace.Root())
+
+	go func() {
+		defer func() {
+			<-m.sem
+			if err := recover(); err != nil {
+				config.SysLog.Error("[mirror recover] [module:%s] [err:%v]", m.moduleName, err)
+			}
+		}()
+		start := time.Now()
+		resp, err := m.client.Do(mirrorReq)
+		if err != nil {
+			stat.Record(http.StatusBadGateway, time.Since(start))
+			config.SysLog.Warn("[mirror error] [module:%s] [host:%s] [err:%s]", m.moduleName, host, err.Error())
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		stat.Record(resp.StatusCode, time.Since(start))
+	}()
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/constant"
+	"gatekeeper/core/resource"
+	"gatekeeper/model/entity"
+)
+
+// 配额窗口
+const (
+	QuotaWindowHour  = "hour"
+	QuotaWindowDay   = "day"
+	QuotaWindowMonth = "month"
+)
+
+// QuotaWindows 全部配额窗口
+var QuotaWindows = []string{QuotaWindowHour, QuotaWindowDay, QuotaWindowMonth}
+
+// 计数过期时间在窗口结束后多保留一段时间，便于查询上一窗口的用量
+const quotaExpireMargin = time.Hour
+
+// Quota 各窗口的请求上限，0为不限制
+type Quota struct {
+	Hour  int64
+	Day   int64
+	Month int64
+}
+
+func (q Quota) limit(window string) int64 {
+	switch window {
+	case QuotaWindowHour:
+		return q.Hour
+	case QuotaWindowDay:
+		return q.Day
+	case QuotaWindowMonth:
+		return q.Month
+	}
+	return 0
+}
+
+// Empty 是否未设置任何上限
+func (q Quota) Empty() bool {
+	return q.Hour <= 0 && q.Day <= 0 && q.Month <= 0
+}
+
+// AppQuota 租户整体配额
+func AppQuota(app *entity.GatewayAPP) Quota {
+	return Quota{Hour: app.TotalQueryHourly, Day: app.TotalQueryDaily, Month: app.TotalQueryMonthly}
+}
+
+// ParseModuleQuota 解析租户的模块配额，每行一条：模块名 hour=N day=N month=N
+// 空行及#开头的行忽略，未写的窗口不限制
+func ParseModuleQuota(text string) (map[string]Quota, error) {
+	quotas := map[string]Quota{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, errors.Errorf("line %d: want module name and at least one window", i+1)
+		}
+		module := fields[0]
+		if _, ok := quotas[module]; ok {
+			return nil, errors.Errorf("line %d: duplicate module %s", i+1, module)
+		}
+		quota := Quota{}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.Errorf("line %d: %s should be window=N", i+1, field)
+			}
+			n, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.Errorf("line %d: %s is not a non-negative integer", i+1, kv[1])
+			}
+			switch kv[0] {
+			case QuotaWindowHour:
+				quota.Hour = n
+			case QuotaWindowDay:
+				quota.Day = n
+			case QuotaWindowMonth:
+				quota.Month = n
+			default:
+				return nil, errors.Errorf("line %d: unknown window %s, want hour, day or month", i+1, kv[0])
+			}
+		}
+		quotas[module] = quota
+	}
+	return quotas, nil
+}
+
+// 窗口的起止时间，按time_location划分
+func quotaWindowRange(window string, now time.Time) (stamp string, reset time.Time) {
+	now = now.In(config.TimeLocation)
+	switch window {
+	case QuotaWindowHour:
+		start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+		return start.Format("2006010215"), start.Add(time.Hour)
+	case QuotaWindowDay:
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		return start.Format("20060102"), start.AddDate(0, 0, 1)
+	default:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return start.Format("200601"), start.AddDate(0, 1, 0)
+	}
+}
+
+// QuotaKey 配额计数key，scope为app_id或 app_id:模块名
+func QuotaKey(scope, window string, now time.Time) string {
+	stamp, _ := quotaWindowRange(window, now)
+	return constant.QuotaPrefix + scope + "_" + window + "_" + stamp
+}
+
+// RateLimitStatus 限流状态，取剩余请求数最少的窗口，用于X-RateLimit-* header
+type RateLimitStatus struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Time
+}
+
+// SetHeader 输出X-RateLimit-* header，Reset为距窗口重置的秒数
+func (st *RateLimitStatus) SetHeader(header http.Header) {
+	if st == nil {
+		return
+	}
+	header.Set("X-RateLimit-Limit", strconv.FormatInt(st.Limit, 10))
+	header.Set("X-RateLimit-Remaining", strconv.FormatInt(st.Remaining, 10))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(time.Until(st.Reset)), 10))
+}
+
+// 一个待消耗的配额窗口
+type quotaWindow struct {
+	scope  string
+	window string
+	limit  int64
+	reset  time.Time
+}
+
+// ConsumeQuota 原子地消耗租户整体及模块配额，任一窗口超限时均不计数
+// 未设置配额时返回nil状态
+func ConsumeQuota(appID, module string, appQuota Quota, moduleQuota Quota) (*RateLimitStatus, error) {
+	now := time.Now()
+	windows := []quotaWindow{}
+	add := func(scope string, quota Quota) {
+		for _, window := range QuotaWindows {
+			if limit := quota.limit(window); limit > 0 {
+				_, reset := quotaWindowRange(window, now)
+				windows = append(windows, quotaWindow{scope: scope, window: window, limit: limit, reset: reset})
+			}
+		}
+	}
+	add(appID, appQuota)
+	add(appID+":"+module, moduleQuota)
+	if len(windows) == 0 {
+		return nil, nil
+	}
+
+	counterWindows := make([]resource.CounterWindow, 0, len(windows))
+	for _, w := range windows {
+		counterWindows = append(counterWindows, resource.CounterWindow{
+			Key:      QuotaKey(w.scope, w.window, now),
+			Limit:    w.limit,
+			ExpireAt: w.reset.Add(quotaExpireMargin),
+		})
+	}
+	counts, exceeded, err := resource.Counters.Consume(counterWindows)
+	if err != nil {
+		//计数存储不可用时放行，避免redis故障导致全部请求被拒绝
+		config.SysLog.Warn("[quota consume failed] [app_id:%s] [module:%s] [err:%s]", appID, module, err.Error())
+		return nil, nil
+	}
+	if exceeded >= 0 {
+		w := windows[exceeded]
+		gerr := NewGatewayError(ErrCodeQuotaExceeded, errors.Errorf("%s %s limit %d", w.scope, w.window, w.limit))
+		gerr.RetryAfter = w.reset.Sub(now)
+		return &RateLimitStatus{Limit: w.limit, Remaining: 0, Reset: w.reset}, gerr
+	}
+
+	var status *RateLimitStatus
+	for i, w := range windows {
+		remaining := w.limit - counts[i]
+		if remaining < 0 {
+			remaining = 0
+		}
+		if status == nil || remaining < status.Remaining || (remaining == status.Remaining && w.reset.Before(status.Reset)) {
+			status = &RateLimitStatus{Limit: w.limit, Remaining: remaining, Reset: w.reset}
+		}
+	}
+	return status, nil
+}
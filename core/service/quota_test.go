@@ -0,0 +1,86 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseModuleQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]Quota
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  map[string]Quota{},
+		},
+		{
+			name:  "windows in any order",
+			input: "# comment\nsvc_a month=300 hour=10\n\n  svc_b   day=0  \n",
+			want: map[string]Quota{
+				"svc_a": {Hour: 10, Month: 300},
+				"svc_b": {},
+			},
+		},
+		{
+			name:  "all windows",
+			input: "svc hour=1 day=2 month=3",
+			want:  map[string]Quota{"svc": {Hour: 1, Day: 2, Month: 3}},
+		},
+		{
+			name:    "module without window",
+			input:   "svc",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate module",
+			input:   "svc hour=1\nsvc day=1",
+			wantErr: true,
+		},
+		{
+			name:    "missing value",
+			input:   "svc hour",
+			wantErr: true,
+		},
+		{
+			name:    "negative value",
+			input:   "svc hour=-1",
+			wantErr: true,
+		},
+		{
+			name:    "unknown window",
+			input:   "svc week=1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseModuleQuota(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaEmpty(t *testing.T) {
+	tests := []struct {
+		quota Quota
+		want  bool
+	}{
+		{Quota{}, true},
+		{Quota{Hour: 1}, false},
+		{Quota{Month: 1}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.quota.Empty(); got != tt.want {
+			t.Errorf("%+v.Empty() = %v, want %v", tt.quota, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/constant"
+)
+
+// ErrBodyTooLarge 请求体超过模块限制
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// LimitedBody 限制请求体大小，读取超过上限时返回ErrBodyTooLarge
+// 请求体以流的方式转发，超限可能发生在转发过程中，由代理的ErrorHandler据Exceeded返回413
+type LimitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+// NewLimitedBody 创建限制大小的请求体
+func NewLimitedBody(body io.ReadCloser, limit int64) *LimitedBody {
+	return &LimitedBody{body: body, remaining: limit}
+}
+
+func (b *LimitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	// 多读一个字节用于判断是否超限
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.body.Read(p)
+	if int64(n) > b.remaining {
+		b.exceeded = true
+		return int(b.remaining), ErrBodyTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *LimitedBody) Close() error {
+	return b.body.Close()
+}
+
+// Exceeded 是否已读到超过上限的内容
+func (b *LimitedBody) Exceeded() bool {
+	return b.exceeded
+}
+
+// RequestBody 缓存的请求体，可多次读取
+// 仅在镜像等需要重放请求体时使用，超过内存阈值的部分写入临时文件
+type RequestBody struct {
+	mem  []byte
+	file *os.File
+	size int64
+}
+
+// BufferRequestBody 读取并缓存请求体
+func BufferRequestBody(body io.Reader) (*RequestBody, error) {
+	bufferSize := config.BaseConf.Http.BodyBufferSize
+	if bufferSize <= 0 {
+		bufferSize = constant.BodyDefaultBufferSize
+	}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, body, bufferSize+1)
+	if err == io.EOF {
+		return &RequestBody{mem: buf.Bytes(), size: n}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile("", "gatekeeper_body_")
+	if err != nil {
+		return nil, errors.Wrap(err, "create body temp file")
+	}
+	rb := &RequestBody{file: file}
+	if rb.size, err = io.Copy(file, io.MultiReader(&buf, body)); err != nil {
+		rb.Close()
+		return nil, err
+	}
+	return rb, nil
+}
+
+// Size 请求体字节数
+func (b *RequestBody) Size() int64 {
+	return b.size
+}
+
+// NewReader 从头读取请求体
+// 临时文件在Close时删除，已打开的reader仍可读完，因此异步的镜像请求不受主请求结束影响
+func (b *RequestBody) NewReader() (io.ReadCloser, error) {
+	if b.file == nil {
+		return ioutil.NopCloser(bytes.NewReader(b.mem)), nil
+	}
+	return os.Open(b.file.Name())
+}
+
+// Close 删除临时文件
+func (b *RequestBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
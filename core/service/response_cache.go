@@ -0,0 +1,545 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/constant"
+	"gatekeeper/core/resource"
+	"gatekeeper/model/running"
+)
+
+// 缓存key组成部分，path始终包含
+const (
+	cacheKeyPath   = "path"
+	cacheKeyQuery  = "query"
+	cacheKeyAppID  = "app_id"
+	cacheKeyHeader = "header" //header:X-Lang
+)
+
+// 缓存命中状态，写入CacheStatusHeader
+const (
+	cacheStatusHit    = "HIT"
+	cacheStatusMiss   = "MISS"
+	cacheStatusStale  = "STALE"
+	cacheStatusBypass = "BYPASS"
+)
+
+// 可缓存的响应状态码
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// 模块响应缓存
+// 仅缓存GET请求，过期后在stale_while_revalidate内返回旧数据并后台刷新，
+// 上游返回5xx时在stale_if_error内返回旧数据
+type moduleCache struct {
+	moduleName           string
+	store                resource.CacheStore
+	defaultTTL           int64
+	ttlRules             []*cacheTTLRule
+	keyQuery             bool
+	keyAppID             bool
+	keyHeaders           []string
+	staleWhileRevalidate int64
+	staleIfError         int64
+	maxBodySize          int64
+	refreshing           sync.Map
+}
+
+// 按路径覆盖缓存时间
+type cacheTTLRule struct {
+	re  *regexp.Regexp
+	ttl int64
+}
+
+// 缓存内容，Vary不为空时为索引项，实际内容按Vary header的值另存
+type cacheEntry struct {
+	Status                    int         `json:"status"`
+	Header                    http.Header `json:"header,omitempty"`
+	Body                      []byte      `json:"body,omitempty"`
+	Vary                      []string    `json:"vary,omitempty"`
+	StoredAt                  int64       `json:"stored_at"`
+	FreshUntil                int64       `json:"fresh_until"`
+	StaleWhileRevalidateUntil int64       `json:"stale_while_revalidate_until"`
+	StaleIfErrorUntil         int64       `json:"stale_if_error_until"`
+}
+
+// CacheKeyPrefix 模块缓存key前缀，其后为请求路径
+func CacheKeyPrefix(moduleName string) string {
+	return constant.CachePrefix + moduleName + ":"
+}
+
+// 解析缓存key组成，每项以逗号分隔
+func parseCacheKeyParts(s string) (query, appID bool, headers []string, err error) {
+	if strings.TrimSpace(s) == "" {
+		return true, false, nil, nil
+	}
+	for _, part := range splitList(s) {
+		switch {
+		case part == cacheKeyPath:
+		case part == cacheKeyQuery:
+			query = true
+		case part == cacheKeyAppID:
+			appID = true
+		case strings.HasPrefix(part, cacheKeyHeader+":") && len(part) > len(cacheKeyHeader)+1:
+			headers = append(headers, http.CanonicalHeaderKey(part[len(cacheKeyHeader)+1:]))
+		default:
+			return false, false, nil, errors.Errorf("unknown key part %s", part)
+		}
+	}
+	return query, appID, headers, nil
+}
+
+// 模块是否开启了访问控制，开启时请求按租户区分
+func accessControlOpen(module *running.GatewayModule) bool {
+	return module.AccessControl != nil && module.AccessControl.Open == 1
+}
+
+// 解析按路径覆盖的缓存时间，每行 "正则 秒数"
+func parseCacheTTLRules(s string) ([]*cacheTTLRule, error) {
+	rules := []*cacheTTLRule{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("invalid ttl rule %s", line)
+		}
+		re, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid ttl regexp "+fields[0])
+		}
+		ttl, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || ttl < 0 {
+			return nil, errors.Errorf("invalid ttl seconds in %s", line)
+		}
+		rules = append(rules, &cacheTTLRule{re: re, ttl: ttl})
+	}
+	return rules, nil
+}
+
+// 按模块配置创建响应缓存，未开启时返回nil
+func newModuleCache(module *running.GatewayModule) *moduleCache {
+	conf := module.Cache
+	if conf == nil || conf.Open != 1 || module.Base.LoadType != "http" {
+		return nil
+	}
+	query, appID, headers, err := parseCacheKeyParts(conf.KeyParts)
+	if err != nil {
+		config.SysLog.Warn("[cache key invalid] [module:%s] [err:%s]", module.Base.Name, err.Error())
+		return nil
+	}
+	// 开启访问控制时默认按app_id区分，避免不同租户共用缓存
+	if strings.TrimSpace(conf.KeyParts) == "" && accessControlOpen(module) {
+		appID = true
+	}
+	ttlRules, err := parseCacheTTLRules(conf.TTLRules)
+	if err != nil {
+		config.SysLog.Warn("[cache ttl rule invalid] [module:%s] [err:%s]", module.Base.Name, err.Error())
+		return nil
+	}
+	maxBodySize := conf.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = constant.CacheDefaultMaxBodySize
+	}
+	return &moduleCache{
+		moduleName:           module.Base.Name,
+		store:                resource.GetCacheStore(conf.Backend),
+		defaultTTL:           conf.DefaultTTL,
+		ttlRules:             ttlRules,
+		keyQuery:             query,
+		keyAppID:             appID,
+		keyHeaders:           headers,
+		staleWhileRevalidate: conf.StaleWhileRevalidate,
+		staleIfError:         conf.StaleIfError,
+		maxBodySize:          maxBodySize,
+	}
+}
+
+// 经过缓存转发请求，next为实际的上游代理
+func (m *moduleCache) serveHTTP(next http.Handler, w http.ResponseWriter, req *http.Request) {
+	reqCC := parseCacheControl(req.Header)
+	if req.Method != http.MethodGet || reqCC.has("no-store") {
+		w.Header().Set(constant.CacheStatusHeader, cacheStatusBypass)
+		next.ServeHTTP(w, req)
+		return
+	}
+	key := m.key(req)
+	entry := m.lookup(key, req)
+	now := time.Now().Unix()
+	if entry != nil && !reqCC.has("no-cache") && req.Header.Get("Pragma") != "no-cache" {
+		if now < entry.FreshUntil {
+			writeCacheEntry(w, entry, cacheStatusHit)
+			return
+		}
+		if now < entry.StaleWhileRevalidateUntil {
+			writeCacheEntry(w, entry, cacheStatusStale)
+			m.revalidate(next, key, req)
+			return
+		}
+	}
+
+	rec := newCacheRecorder(w, m.maxBodySize)
+	next.ServeHTTP(rec, req)
+	if rec.passthrough {
+		return
+	}
+	if rec.status >= http.StatusInternalServerError && entry != nil && now < entry.StaleIfErrorUntil {
+		writeCacheEntry(w, entry, cacheStatusStale)
+		return
+	}
+	m.save(key, req, rec)
+	rec.flush(cacheStatusMiss)
+}
+
+// 后台刷新过期缓存，同一key同时只刷新一次
+func (m *moduleCache) revalidate(next http.Handler, key string, req *http.Request) {
+	if _, loaded := m.refreshing.LoadOrStore(key, true); loaded {
+		return
+	}
+	//后台请求使用独立的上游转发信息，同样受节点并发限制并计入节点统计
+	//不记录到前台请求的访问日志及链路
+	trace := &upstreamTrace{key: m.moduleName}
+	if fgTrace := upstreamTraceFromContext(req.Context()); fgTrace != nil {
+		trace.key = fgTrace.key
+	}
+	ctx := context.WithValue(detachedContext{req.Context()}, upstreamTraceKey{}, trace)
+	ctx = context.WithValue(ctx, traceKey{}, (*Trace)(nil))
+	bgReq := req.WithContext(ctx)
+	bgReq.Header = copyHeader(req.Header)
+	bgReq.Body = http.NoBody
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				config.SysLog.Warn("[cache revalidate failed] [module:%s] [key:%s] [err:%v]", m.moduleName, key, err)
+			}
+			trace.release()
+			m.refreshing.Delete(key)
+		}()
+		rec := newCacheRecorder(nil, m.maxBodySize)
+		next.ServeHTTP(rec, bgReq)
+		if !rec.passthrough {
+			m.save(key, bgReq, rec)
+		}
+	}()
+}
+
+// 缓存key：前缀+客户端请求路径，其后依次为query、app_id及指定header
+// 使用重写前的地址，便于按客户端路径清除缓存
+func (m *moduleCache) key(req *http.Request) string {
+	path, query := clientURI(req)
+	var buf bytes.Buffer
+	buf.WriteString(CacheKeyPrefix(m.moduleName))
+	buf.WriteString(path)
+	if m.keyQuery {
+		if values, err := url.ParseQuery(query); err == nil {
+			query = values.Encode()
+		}
+		buf.WriteString("?" + query)
+	}
+	if m.keyAppID {
+		buf.WriteString("|app_id=" + req.Header.Get("app_id"))
+	}
+	for _, name := range m.keyHeaders {
+		buf.WriteString("|" + name + "=" + req.Header.Get(name))
+	}
+	return buf.String()
+}
+
+// 客户端请求的路径及query，url重写不影响RequestURI
+func clientURI(req *http.Request) (string, string) {
+	if u, err := url.ParseRequestURI(req.RequestURI); err == nil {
+		return u.Path, u.RawQuery
+	}
+	return req.URL.Path, req.URL.RawQuery
+}
+
+// 按Vary header的值区分的缓存key
+func varyKey(key string, vary []string, req *http.Request) string {
+	var buf bytes.Buffer
+	buf.WriteString(key)
+	for _, name := range vary {
+		buf.WriteString("|vary:" + name + "=" + strings.Join(req.Header[name], ","))
+	}
+	return buf.String()
+}
+
+func (m *moduleCache) get(key string) *cacheEntry {
+	value, err := m.store.Get(key)
+	if err != nil {
+		config.SysLog.Warn("[cache get failed] [module:%s] [err:%s]", m.moduleName, err.Error())
+		return nil
+	}
+	if value == nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(value, entry); err != nil {
+		return nil
+	}
+	return entry
+}
+
+func (m *moduleCache) set(key string, entry *cacheEntry) {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	expire := maxInt64(entry.StaleWhileRevalidateUntil, entry.StaleIfErrorUntil, entry.FreshUntil) - entry.StoredAt
+	if err := m.store.Set(key, value, time.Duration(expire)*time.Second); err != nil {
+		config.SysLog.Warn("[cache set failed] [module:%s] [err:%s]", m.moduleName, err.Error())
+	}
+}
+
+// 查找缓存，命中索引项时按Vary继续查找
+func (m *moduleCache) lookup(key string, req *http.Request) *cacheEntry {
+	entry := m.get(key)
+	if entry != nil && len(entry.Vary) > 0 {
+		return m.get(varyKey(key, entry.Vary, req))
+	}
+	return entry
+}
+
+// 响应可缓存时写入缓存
+func (m *moduleCache) save(key string, req *http.Request, rec *cacheRecorder) {
+	if !cacheableStatus[rec.status] || rec.header.Get("Set-Cookie") != "" {
+		return
+	}
+	respCC := parseCacheControl(rec.header)
+	if respCC.has("no-store") || respCC.has("private") || respCC.has("no-cache") {
+		return
+	}
+	if req.Header.Get("Authorization") != "" && !respCC.has("public") && !respCC.has("s-maxage") {
+		return
+	}
+	vary := []string{}
+	for _, name := range splitList(strings.Join(rec.header["Vary"], ",")) {
+		if name == "*" {
+			return
+		}
+		vary = append(vary, http.CanonicalHeaderKey(name))
+	}
+	sort.Strings(vary)
+	ttl, ok := m.ttl(req, rec.header, respCC)
+	if !ok || ttl <= 0 {
+		return
+	}
+	now := time.Now().Unix()
+	entry := &cacheEntry{
+		Status:                    rec.status,
+		Header:                    copyHeader(rec.header),
+		Body:                      rec.body.Bytes(),
+		StoredAt:                  now,
+		FreshUntil:                now + ttl,
+		StaleWhileRevalidateUntil: now + ttl + respCC.seconds("stale-while-revalidate", m.staleWhileRevalidate),
+		StaleIfErrorUntil:         now + ttl + respCC.seconds("stale-if-error", m.staleIfError),
+	}
+	entry.Header.Del(constant.CacheStatusHeader)
+	if len(vary) == 0 {
+		m.set(key, entry)
+		return
+	}
+	m.set(varyKey(key, vary, req), entry)
+	index := *entry
+	index.Header, index.Body, index.Vary = nil, nil, vary
+	m.set(key, &index)
+}
+
+// 缓存秒数：按路径覆盖的规则优先，其次s-maxage、max-age、Expires，均未声明时使用默认值
+func (m *moduleCache) ttl(req *http.Request, header http.Header, cc cacheControl) (int64, bool) {
+	path, _ := clientURI(req)
+	for _, rule := range m.ttlRules {
+		if rule.re.MatchString(path) {
+			return rule.ttl, true
+		}
+	}
+	if v, ok := cc["s-maxage"]; ok {
+		ttl, err := strconv.ParseInt(v, 10, 64)
+		return ttl, err == nil
+	}
+	if v, ok := cc["max-age"]; ok {
+		ttl, err := strconv.ParseInt(v, 10, 64)
+		return ttl, err == nil
+	}
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0, false
+		}
+		date := time.Now()
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		return int64(expires.Sub(date) / time.Second), true
+	}
+	return m.defaultTTL, true
+}
+
+// 输出缓存内容
+func writeCacheEntry(w http.ResponseWriter, entry *cacheEntry, status string) {
+	header := w.Header()
+	for k, v := range entry.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	header.Set("Age", strconv.FormatInt(time.Now().Unix()-entry.StoredAt, 10))
+	header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	header.Set(constant.CacheStatusHeader, status)
+	w.WriteHeader(entry.Status)
+	w.Write(entry.Body)
+}
+
+// Cache-Control指令
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, item := range splitList(strings.Join(header["Cache-Control"], ",")) {
+		kv := strings.SplitN(item, "=", 2)
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		if len(kv) == 2 {
+			cc[name] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		} else {
+			cc[name] = ""
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// 指令声明的秒数，未声明时使用默认值
+func (cc cacheControl) seconds(name string, defaultValue int64) int64 {
+	if v, ok := cc[name]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// 缓存上游响应，超过maxBodySize后改为直接输出给客户端且不再缓存
+// w为nil时为后台刷新，超出大小直接丢弃
+type cacheRecorder struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	limit       int64
+	passthrough bool
+}
+
+func newCacheRecorder(w http.ResponseWriter, limit int64) *cacheRecorder {
+	return &cacheRecorder{w: w, header: http.Header{}, limit: limit}
+}
+
+func (r *cacheRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *cacheRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *cacheRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.passthrough {
+		if r.w == nil {
+			return len(p), nil
+		}
+		return r.w.Write(p)
+	}
+	if int64(r.body.Len()+len(p)) <= r.limit {
+		return r.body.Write(p)
+	}
+	r.passthrough = true
+	if r.w == nil {
+		return len(p), nil
+	}
+	r.writeHeader(cacheStatusBypass)
+	if _, err := r.w.Write(r.body.Bytes()); err != nil {
+		return 0, err
+	}
+	r.body.Reset()
+	return r.w.Write(p)
+}
+
+func (r *cacheRecorder) writeHeader(status string) {
+	header := r.w.Header()
+	for k, v := range r.header {
+		header[k] = v
+	}
+	header.Set(constant.CacheStatusHeader, status)
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.w.WriteHeader(r.status)
+}
+
+// 输出缓存的上游响应
+func (r *cacheRecorder) flush(status string) {
+	r.writeHeader(status)
+	r.w.Write(r.body.Bytes())
+}
+
+// 保留请求上下文中的值但不随客户端请求结束而取消，用于后台刷新
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func copyHeader(header http.Header) http.Header {
+	copied := make(http.Header, len(header))
+	for k, v := range header {
+		copied[k] = append([]string(nil), v...)
+	}
+	return copied
+}
+
+func maxInt64(values ...int64) int64 {
+	max := values[0]
+	for _, v := range values[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/model/entity"
+	"gatekeeper/model/running"
+)
+
+// ConfigStorage 模块及租户配置存储
+// mysql、sqlite存储在DB中并记录历史，file模式直接读写module.json/app.json
+type ConfigStorage interface {
+	// Load 读取全量配置
+	Load() (*ConfigSnapshot, error)
+	// Apply 原子地执行变更，plan根据存储中最新的配置计算变更，返回实际执行的变更
+	Apply(plan func(current *ConfigSnapshot) ([]*ConfigChange, error), author, action string) ([]*ConfigChange, error)
+}
+
+// Storage 全局配置存储
+var Storage ConfigStorage
+
+// InitStorage 按存储类型初始化配置存储，mysql、sqlite均自动建表并补全新增字段
+func InitStorage() error {
+	if !config.DBEnabled() {
+		Storage = &fileStorage{dir: *config.Conf}
+		return nil
+	}
+	if err := MigrateDB(config.DB); err != nil {
+		return errors.Wrap(err, "MigrateDB")
+	}
+	Storage = &dbStorage{db: config.DB}
+	return nil
+}
+
+// MigrateDB 按实体结构创建或补全数据表
+func MigrateDB(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&entity.GatewayModuleBase{},
+		&entity.GatewayMatchRule{},
+		&entity.GatewayLoadBalance{},
+		&entity.GatewayAccessControl{},
+		&entity.GatewayUpstreamGroup{},
+		&entity.GatewayCache{},
+		&entity.GatewayAPP{},
+		&entity.GatewayConfigHistory{},
+		&entity.GatewayAuditLog{},
+		&entity.GatewayAlertHistory{},
+		&entity.GatewayAdminUser{},
+	).Error
+}
+
+// DB存储
+type dbStorage struct {
+	db *gorm.DB
+}
+
+func (s *dbStorage) Load() (*ConfigSnapshot, error) {
+	return LoadDBConfig(s.db)
+}
+
+func (s *dbStorage) Apply(plan func(current *ConfigSnapshot) ([]*ConfigChange, error), author, action string) ([]*ConfigChange, error) {
+	tx := s.db.Begin()
+	current, err := LoadDBConfig(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "LoadDBConfig")
+	}
+	changes, err := plan(current)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := ApplyConfig(tx, changes, author, action); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	return changes, nil
+}
+
+// 文件存储
+// 历史由配置文件所在的版本库管理，不单独记录
+type fileStorage struct {
+	sync.Mutex
+	dir string
+}
+
+func (s *fileStorage) Load() (*ConfigSnapshot, error) {
+	modules := &running.Modules{}
+	if err := readConfigFile(s.dir+"module.json", modules); err != nil {
+		return nil, err
+	}
+	apps := &running.Apps{}
+	if err := readConfigFile(s.dir+"app.json", apps); err != nil {
+		return nil, err
+	}
+	snapshot := &ConfigSnapshot{Module: modules.Module, Apps: apps.Apps}
+	if snapshot.Module == nil {
+		snapshot.Module = map[string]*running.GatewayModule{}
+	}
+	if snapshot.Apps == nil {
+		snapshot.Apps = map[string]*entity.GatewayAPP{}
+	}
+	return snapshot, nil
+}
+
+func (s *fileStorage) Apply(plan func(current *ConfigSnapshot) ([]*ConfigChange, error), author, action string) ([]*ConfigChange, error) {
+	s.Lock()
+	defer s.Unlock()
+	current, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := plan(current)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		if err := applySnapshotChange(current, change); err != nil {
+			return nil, err
+		}
+	}
+	modules := &running.Modules{Module: current.Module}
+	apps := &running.Apps{Apps: current.Apps}
+	if err := ValidateConfig(modules, apps).Err(); err != nil {
+		return nil, err
+	}
+	if err := writeConfigFile(s.dir+"module.json", modules); err != nil {
+		return nil, errors.Wrap(err, "write module.json")
+	}
+	if err := writeConfigFile(s.dir+"app.json", apps); err != nil {
+		return nil, errors.Wrap(err, "write app.json")
+	}
+	return changes, nil
+}
+
+// applySnapshotChange 在快照上执行单个变更，租户以app_id标识
+func applySnapshotChange(snapshot *ConfigSnapshot, change *ConfigChange) error {
+	switch change.Type {
+	case ConfigTypeModule:
+		delete(snapshot.Module, change.Name)
+		if change.Action == ConfigActionDelete {
+			return nil
+		}
+		module, ok := change.After.(*running.GatewayModule)
+		if !ok {
+			return errors.New("invalid module change: " + change.Name)
+		}
+		snapshot.Module[change.Name] = module
+	case ConfigTypeApp:
+		for name, app := range snapshot.Apps {
+			if app.AppID == change.Name {
+				delete(snapshot.Apps, name)
+			}
+		}
+		if change.Action == ConfigActionDelete {
+			return nil
+		}
+		app, ok := change.After.(*entity.GatewayAPP)
+		if !ok {
+			return errors.New("invalid app change: " + change.Name)
+		}
+		snapshot.Apps[app.Name] = app
+	default:
+		return errors.New("unknown config type: " + change.Type)
+	}
+	return nil
+}
+
+// readConfigFile 读取json配置文件，文件不存在时保持为空
+func readConfigFile(confPath string, v interface{}) error {
+	bts, err := ioutil.ReadFile(confPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bts, v)
+}
+
+// writeConfigFile 写入json配置文件，原文件备份为*_bak.json
+func writeConfigFile(confPath string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "\t"); err != nil {
+		return err
+	}
+	bakPath := strings.Replace(confPath, ".json", "_bak.json", -1)
+	os.Remove(bakPath)
+	if err := os.Rename(confPath, bakPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(confPath, out.Bytes(), 0644)
+}
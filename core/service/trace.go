@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gatekeeper/config"
+	"gatekeeper/core/resource"
+	"gatekeeper/util"
+)
+
+// W3C trace context header
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// Trace 一次网关请求的链路
+// 请求携带合法traceparent时沿用其trace_id、采样标记及tracestate，否则新建链路
+type Trace struct {
+	TraceID    string
+	parentID   string
+	traceState string
+	sampled    bool
+	recording  bool
+	root       *Span
+	mu         sync.Mutex
+	spans      []*resource.Span
+}
+
+// Span 链路中的一个阶段，未开启导出时只生成span_id
+type Span struct {
+	trace *Trace
+	data  *resource.Span
+}
+
+type traceKey struct{}
+
+// StartTrace 按请求header开始链路并创建网关的根span，返回附带链路的请求
+func StartTrace(req *http.Request) (*Trace, *http.Request) {
+	t := &Trace{}
+	if traceID, parentID, sampled, ok := parseTraceparent(req.Header.Get(TraceparentHeader)); ok {
+		t.TraceID = traceID
+		t.parentID = parentID
+		t.sampled = sampled
+		t.traceState = req.Header.Get(TracestateHeader)
+	} else {
+		t.TraceID = randomHex(16)
+		t.sampled = sampleTrace()
+	}
+	t.recording = t.sampled && resource.Spans != nil
+	t.root = t.newSpan("gateway", resource.SpanKindServer, t.parentID)
+	t.root.SetAttribute("http.method", req.Method)
+	t.root.SetAttribute("http.target", req.RequestURI)
+	t.root.SetAttribute("net.peer.ip", util.RemoteIP(req))
+	ctx := context.WithValue(req.Context(), traceKey{}, t)
+	return t, req.WithContext(util.WithTraceID(ctx, t.TraceID))
+}
+
+// TraceFromContext 请求context中的链路，不存在时返回nil
+func TraceFromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// 请求未携带traceparent时按配置采样
+func sampleTrace() bool {
+	conf := config.BaseConf.Trace
+	if conf == nil || conf.SampleRate <= 0 || conf.SampleRate >= 1 {
+		return true
+	}
+	return mathrand.Float64() < conf.SampleRate
+}
+
+// 解析traceparent：版本-trace_id-parent_id-标记
+// 未知版本按00版本解析前四段，trace_id与parent_id不能全为0
+func parseTraceparent(value string) (traceID, parentID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return "", "", false, false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return "", "", false, false
+	}
+	if len(traceID) != 32 || !isLowerHex(traceID) || strings.Trim(traceID, "0") == "" {
+		return "", "", false, false
+	}
+	if len(parentID) != 16 || !isLowerHex(parentID) || strings.Trim(parentID, "0") == "" {
+		return "", "", false, false
+	}
+	if len(flags) != 2 || !isLowerHex(flags) {
+		return "", "", false, false
+	}
+	flag, _ := strconv.ParseUint(flags, 16, 8)
+	return traceID, parentID, flag&1 == 1, true
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Repeat("0", 2*n-1) + "1"
+	}
+	return hex.EncodeToString(b)
+}
+
+func (t *Trace) newSpan(name string, kind int, parentID string) *Span {
+	return &Span{trace: t, data: &resource.Span{
+		TraceID:      t.TraceID,
+		SpanID:       randomHex(8),
+		ParentSpanID: parentID,
+		TraceState:   t.traceState,
+		Name:         name,
+		Kind:         kind,
+		Start:        time.Now(),
+		Attributes:   map[string]interface{}{},
+	}}
+}
+
+// StartSpan 开始网关根span下的一个阶段
+func (t *Trace) StartSpan(name string, kind int) *Span {
+	if t == nil {
+		return nil
+	}
+	return t.newSpan(name, kind, t.root.data.SpanID)
+}
+
+// Root 网关根span
+func (t *Trace) Root() *Span {
+	if t == nil {
+		return nil
+	}
+	return t.root
+}
+
+// Traceparent 以指定span为父节点的traceparent
+func (t *Trace) Traceparent(span *Span) string {
+	flags := 0
+	if t.sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", t.TraceID, span.data.SpanID, flags)
+}
+
+// Inject 将链路写入转发请求的header，span为转发请求的父span
+func (t *Trace) Inject(header http.Header, span *Span) {
+	if t == nil || span == nil {
+		return
+	}
+	header.Set(TraceparentHeader, t.Traceparent(span))
+	if t.traceState != "" {
+		header.Set(TracestateHeader, t.traceState)
+	} else {
+		header.Del(TracestateHeader)
+	}
+}
+
+// Finish 结束根span并导出本次请求的全部span
+func (t *Trace) Finish(status int) {
+	if t == nil {
+		return
+	}
+	t.root.SetAttribute("http.status_code", status)
+	if status >= http.StatusInternalServerError {
+		t.root.data.Status = resource.SpanStatusError
+	}
+	t.root.End(nil)
+	if !t.recording {
+		return
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	resource.Spans.Export(spans...)
+}
+
+// SpanID span id
+func (s *Span) SpanID() string {
+	if s == nil {
+		return ""
+	}
+	return s.data.SpanID
+}
+
+// SetAttribute 设置span属性，值支持string、int、int64、bool
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.data.Attributes[key] = value
+}
+
+// End 结束span，err不为空时标记为错误
+func (s *Span) End(err error) {
+	if s == nil || !s.data.End.IsZero() {
+		return
+	}
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Status = resource.SpanStatusError
+		s.data.StatusMessage = err.Error()
+	}
+	if !s.trace.recording {
+		return
+	}
+	s.trace.mu.Lock()
+	s.trace.spans = append(s.trace.spans, s.data)
+	s.trace.mu.Unlock()
+}
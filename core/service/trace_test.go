@@ -0,0 +1,49 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name    string
+		value   string
+		sampled bool
+		ok      bool
+	}{
+		{name: "sampled", value: "00-" + traceID + "-" + parentID + "-01", sampled: true, ok: true},
+		{name: "not sampled", value: "00-" + traceID + "-" + parentID + "-00", ok: true},
+		{name: "other flags", value: "00-" + traceID + "-" + parentID + "-03", sampled: true, ok: true},
+		{name: "surrounding spaces", value: " 00-" + traceID + "-" + parentID + "-01 ", sampled: true, ok: true},
+		{name: "future version with extra fields", value: "cc-" + traceID + "-" + parentID + "-01-extra", sampled: true, ok: true},
+		{name: "empty", value: ""},
+		{name: "too few fields", value: "00-" + traceID + "-" + parentID},
+		{name: "version 00 with extra fields", value: "00-" + traceID + "-" + parentID + "-01-extra"},
+		{name: "invalid version ff", value: "ff-" + traceID + "-" + parentID + "-01"},
+		{name: "upper case", value: "00-" + strings.ToUpper(traceID) + "-" + parentID + "-01"},
+		{name: "short trace id", value: "00-" + traceID[1:] + "-" + parentID + "-01"},
+		{name: "zero trace id", value: "00-" + strings.Repeat("0", 32) + "-" + parentID + "-01"},
+		{name: "zero parent id", value: "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01"},
+		{name: "non hex parent id", value: "00-" + traceID + "-" + "00f067aa0ba902bz" + "-01"},
+		{name: "invalid flags", value: "00-" + traceID + "-" + parentID + "-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTraceID, gotParentID, sampled, ok := parseTraceparent(tt.value)
+			if ok != tt.ok || sampled != tt.sampled {
+				t.Fatalf("sampled %v ok %v, want %v %v", sampled, ok, tt.sampled, tt.ok)
+			}
+			if ok && (gotTraceID != traceID || gotParentID != parentID) {
+				t.Errorf("got %s %s, want %s %s", gotTraceID, gotParentID, traceID, parentID)
+			}
+			if !ok && (gotTraceID != "" || gotParentID != "") {
+				t.Errorf("invalid value returned ids %s %s", gotTraceID, gotParentID)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/model/entity"
+	"gatekeeper/model/running"
+	"gatekeeper/util"
+)
+
+// DefaultUpstreamGroup 默认分组，即模块load_balance中配置的节点，承接未分配给其他分组的流量
+const DefaultUpstreamGroup = "default"
+
+// 分组强制规则类型
+const (
+	groupRuleHeader = "header"  //header:X-Canary=1，省略值时只要求header存在
+	groupRuleCookie = "cookie"  //cookie:canary=1，省略值时只要求cookie存在
+	groupRuleAppID  = "app_id"  //app_id:app1,app2
+	groupRuleIPHash = "ip_hash" //ip_hash:0-9，客户端ip哈希取模100后落在区间内
+	groupRuleMethod = "method"  //method:GET,POST
+	groupRuleHost   = "host"    //host:api.example.com，忽略端口及大小写
+)
+
+// 分组强制规则
+type groupRule struct {
+	kind     string
+	name     string
+	values   []string
+	min, max uint32
+}
+
+// 运行时分组
+type upstreamGroup struct {
+	name    string
+	percent int64
+	rules   []*groupRule
+}
+
+// UpstreamGroupKey 分组运行时数据的key，默认分组沿用模块名
+func UpstreamGroupKey(moduleName, group string) string {
+	if group == "" || group == DefaultUpstreamGroup {
+		return moduleName
+	}
+	return moduleName + ":" + group
+}
+
+// 拆分分组运行时key，默认分组的group为空
+func splitUpstreamGroupKey(key string) (moduleName, group string) {
+	if i := strings.Index(key, ":"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return key, ""
+}
+
+// 解析分组强制规则，多条规则用分号分隔，命中任意一条即进入分组
+func parseGroupRules(s string) ([]*groupRule, error) {
+	rules := []*groupRule{}
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+			return nil, errors.Errorf("invalid rule %s", item)
+		}
+		rule := &groupRule{kind: kv[0]}
+		value := strings.TrimSpace(kv[1])
+		switch rule.kind {
+		case groupRuleHeader, groupRuleCookie:
+			nv := strings.SplitN(value, "=", 2)
+			rule.name = nv[0]
+			if len(nv) == 2 {
+				rule.values = splitList(nv[1])
+			}
+		case groupRuleAppID, groupRuleMethod, groupRuleHost:
+			rule.values = splitList(value)
+		case groupRuleIPHash:
+			bounds := strings.SplitN(value, "-", 2)
+			if len(bounds) != 2 {
+				return nil, errors.Errorf("invalid ip_hash range %s", value)
+			}
+			min, err1 := strconv.ParseUint(bounds[0], 10, 32)
+			max, err2 := strconv.ParseUint(bounds[1], 10, 32)
+			if err1 != nil || err2 != nil || min > max || max > 99 {
+				return nil, errors.Errorf("invalid ip_hash range %s, must be within 0-99", value)
+			}
+			rule.min, rule.max = uint32(min), uint32(max)
+		default:
+			return nil, errors.Errorf("unknown rule type %s", rule.kind)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// 请求是否命中规则
+func (r *groupRule) match(req *http.Request) bool {
+	switch r.kind {
+	case groupRuleHeader:
+		value := req.Header.Get(r.name)
+		return value != "" && (len(r.values) == 0 || util.InStringList(value, r.values))
+	case groupRuleCookie:
+		cookie, err := req.Cookie(r.name)
+		return err == nil && (len(r.values) == 0 || util.InStringList(cookie.Value, r.values))
+	case groupRuleAppID:
+		return util.InStringList(req.Header.Get("app_id"), r.values)
+	case groupRuleIPHash:
+		hash := crc32.ChecksumIEEE([]byte(util.RemoteIP(req))) % 100
+		return hash >= r.min && hash <= r.max
+	case groupRuleMethod:
+		for _, method := range r.values {
+			if strings.EqualFold(method, req.Method) {
+				return true
+			}
+		}
+	case groupRuleHost:
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		for _, value := range r.values {
+			if strings.EqualFold(value, host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 编译模块的分组配置
+func newUpstreamGroups(module *running.GatewayModule) []*upstreamGroup {
+	groups := []*upstreamGroup{}
+	for _, group := range module.UpstreamGroups {
+		rules, err := parseGroupRules(group.ForceRule)
+		if err != nil {
+			rules = []*groupRule{}
+		}
+		groups = append(groups, &upstreamGroup{name: group.Name, percent: group.Percent, rules: rules})
+	}
+	return groups
+}
+
+// 选择请求所属分组：先匹配强制规则，再按百分比随机分配，剩余流量进入默认分组
+func selectUpstreamGroup(groups []*upstreamGroup, req *http.Request) string {
+	for _, group := range groups {
+		for _, rule := range group.rules {
+			if rule.match(req) {
+				return group.name
+			}
+		}
+	}
+	if len(groups) == 0 {
+		return DefaultUpstreamGroup
+	}
+	n := rand.Int63n(100)
+	for _, group := range groups {
+		if n < group.percent {
+			return group.name
+		}
+		n -= group.percent
+	}
+	return DefaultUpstreamGroup
+}
+
+// 分组的负载配置，探活及代理参数沿用模块配置，节点来自分组
+func groupLoadBalance(module *running.GatewayModule, group *entity.GatewayUpstreamGroup) *entity.GatewayLoadBalance {
+	lb := *module.LoadBalance
+	lb.DiscoveryType = group.DiscoveryType
+	lb.DiscoveryTarget = group.DiscoveryTarget
+	lb.IPList = group.IPList
+	lb.WeightList = group.WeightList
+	return &lb
+}
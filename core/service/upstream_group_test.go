@@ -0,0 +1,201 @@
+package service
+
+import (
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseGroupRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []groupRule
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: " ; ",
+			want:  []groupRule{},
+		},
+		{
+			name:  "all kinds",
+			input: "header:X-Canary=1,2; cookie:beta; app_id:a,b; ip_hash:0-9; method:GET; host:api.example.com",
+			want: []groupRule{
+				{kind: groupRuleHeader, name: "X-Canary", values: []string{"1", "2"}},
+				{kind: groupRuleCookie, name: "beta"},
+				{kind: groupRuleAppID, values: []string{"a", "b"}},
+				{kind: groupRuleIPHash, min: 0, max: 9},
+				{kind: groupRuleMethod, values: []string{"GET"}},
+				{kind: groupRuleHost, values: []string{"api.example.com"}},
+			},
+		},
+		{
+			name:    "missing value",
+			input:   "header:",
+			wantErr: true,
+		},
+		{
+			name:    "unknown kind",
+			input:   "region:cn",
+			wantErr: true,
+		},
+		{
+			name:    "ip_hash without range",
+			input:   "ip_hash:5",
+			wantErr: true,
+		},
+		{
+			name:    "ip_hash out of range",
+			input:   "ip_hash:50-100",
+			wantErr: true,
+		},
+		{
+			name:    "ip_hash reversed",
+			input:   "ip_hash:9-0",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseGroupRules(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(rules) != len(tt.want) {
+				t.Fatalf("got %d rules, want %d", len(rules), len(tt.want))
+			}
+			for i, rule := range rules {
+				want := tt.want[i]
+				if rule.kind != want.kind || rule.name != want.name || rule.min != want.min || rule.max != want.max ||
+					strings.Join(rule.values, ",") != strings.Join(want.values, ",") {
+					t.Errorf("rule %d = %+v, want %+v", i, rule, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGroupRuleMatch(t *testing.T) {
+	//httptest请求的客户端ip为192.0.2.1
+	hash := int(crc32.ChecksumIEEE([]byte("192.0.2.1")) % 100)
+	tests := []struct {
+		name   string
+		rule   string
+		method string
+		host   string
+		header map[string]string
+		want   bool
+	}{
+		{name: "header value", rule: "header:X-Canary=1", header: map[string]string{"X-Canary": "1"}, want: true},
+		{name: "header other value", rule: "header:X-Canary=1", header: map[string]string{"X-Canary": "2"}},
+		{name: "header exists", rule: "header:X-Canary", header: map[string]string{"X-Canary": "any"}, want: true},
+		{name: "header missing", rule: "header:X-Canary"},
+		{name: "cookie value", rule: "cookie:beta=on", header: map[string]string{"Cookie": "beta=on"}, want: true},
+		{name: "cookie missing", rule: "cookie:beta"},
+		{name: "app_id", rule: "app_id:a,b", header: map[string]string{"app_id": "b"}, want: true},
+		{name: "app_id other", rule: "app_id:a,b", header: map[string]string{"app_id": "c"}},
+		{name: "ip_hash in range", rule: "ip_hash:" + strconv.Itoa(hash) + "-" + strconv.Itoa(hash), want: true},
+		{name: "ip_hash out of range", rule: "ip_hash:" + strconv.Itoa((hash+1)%100) + "-" + strconv.Itoa((hash+1)%100)},
+		{name: "method ignores case", rule: "method:post", method: http.MethodPost, want: true},
+		{name: "method other", rule: "method:POST"},
+		{name: "host ignores port and case", rule: "host:API.example.com", host: "api.example.com:8080", want: true},
+		{name: "host other", rule: "host:api.example.com", host: "www.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseGroupRules(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/", nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			if got := rules[0].match(req); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectUpstreamGroup(t *testing.T) {
+	canary := &upstreamGroup{name: "canary", percent: 0}
+	canary.rules, _ = parseGroupRules("header:X-Canary=1")
+	tests := []struct {
+		name   string
+		groups []*upstreamGroup
+		header map[string]string
+		want   string
+	}{
+		{name: "no groups", want: DefaultUpstreamGroup},
+		{name: "force rule", groups: []*upstreamGroup{canary}, header: map[string]string{"X-Canary": "1"}, want: "canary"},
+		{name: "force rule not matched", groups: []*upstreamGroup{canary}, want: DefaultUpstreamGroup},
+		{name: "full percent", groups: []*upstreamGroup{canary, {name: "blue", percent: 100}}, want: "blue"},
+		{
+			name:   "force rule before percent",
+			groups: []*upstreamGroup{{name: "blue", percent: 100}, canary},
+			header: map[string]string{"X-Canary": "1"},
+			want:   "canary",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			if got := selectUpstreamGroup(tt.groups, req); got != tt.want {
+				t.Errorf("group = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectUpstreamGroupPercent(t *testing.T) {
+	groups := []*upstreamGroup{{name: "a", percent: 20}, {name: "b", percent: 30}}
+	counts := map[string]int{}
+	const total = 20000
+	for i := 0; i < total; i++ {
+		counts[selectUpstreamGroup(groups, httptest.NewRequest(http.MethodGet, "/", nil))]++
+	}
+	for name, percent := range map[string]int{"a": 20, "b": 30, DefaultUpstreamGroup: 50} {
+		got := counts[name] * 100 / total
+		if got < percent-3 || got > percent+3 {
+			t.Errorf("group %s got %d%%, want about %d%%", name, got, percent)
+		}
+	}
+}
+
+func TestUpstreamGroupKey(t *testing.T) {
+	tests := []struct {
+		module, group, key string
+	}{
+		{"svc", "", "svc"},
+		{"svc", DefaultUpstreamGroup, "svc"},
+		{"svc", "canary", "svc:canary"},
+	}
+	for _, tt := range tests {
+		key := UpstreamGroupKey(tt.module, tt.group)
+		if key != tt.key {
+			t.Errorf("UpstreamGroupKey(%s, %s) = %s, want %s", tt.module, tt.group, key, tt.key)
+		}
+		module, group := splitUpstreamGroupKey(key)
+		if module != tt.module || (group != tt.group && tt.group != DefaultUpstreamGroup) {
+			t.Errorf("splitUpstreamGroupKey(%s) = %s, %s", key, module, group)
+		}
+	}
+}
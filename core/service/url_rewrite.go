@@ -0,0 +1,226 @@
+package service
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/model/running"
+)
+
+// url重写动作
+const (
+	rewriteActionPath     = "rewrite"  //rewrite 正则 替换值，替换值含?时同时改写query
+	rewriteActionQuery    = "query"    //query 正则 替换值，对query做正则替换
+	rewriteActionRedirect = "redirect" //redirect 正则 目标地址 [301|302|307|308]，不经过上游直接返回跳转
+)
+
+// 规则命中后的流程控制，缺省时继续匹配下一条规则
+const (
+	rewriteFlagLast  = "last"  //停止本轮匹配，以改写后的地址从第一条规则重新匹配
+	rewriteFlagBreak = "break" //停止匹配后续规则
+)
+
+// last最多重新匹配的轮数，避免规则互相改写形成死循环
+const maxRewriteCycles = 10
+
+// URLRewriteRule url重写规则
+type URLRewriteRule struct {
+	Action  string
+	Regexp  *regexp.Regexp
+	Replace string
+	Flag    string
+	Code    int
+	conds   []*groupRule
+}
+
+// URLRedirect 命中跳转规则时返回给客户端的跳转
+type URLRedirect struct {
+	Code     int
+	Location string
+}
+
+// URLRewriteLines 将重写配置拆分为规则行
+// 每行一条规则，兼容旧版以逗号分隔的 "正则 替换值"
+func URLRewriteLines(urlRewrite string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.Replace(urlRewrite, "\r", "\n", -1), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if isRewriteAction(strings.Fields(line)[0]) {
+			lines = append(lines, line)
+			continue
+		}
+		for _, item := range strings.Split(line, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				lines = append(lines, item)
+			}
+		}
+	}
+	return lines
+}
+
+// ParseURLRewrite 解析url重写配置
+// 规则格式为 "[动作] 正则 替换值 [last|break|跳转状态码] [if 条件;条件]"
+// 省略动作时为rewrite，条件语法同分组强制规则，需全部满足
+func ParseURLRewrite(urlRewrite string) ([]*URLRewriteRule, error) {
+	rules := []*URLRewriteRule{}
+	for _, line := range URLRewriteLines(urlRewrite) {
+		rule, err := parseURLRewriteRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func isRewriteAction(s string) bool {
+	return s == rewriteActionPath || s == rewriteActionQuery || s == rewriteActionRedirect
+}
+
+func parseURLRewriteRule(line string) (*URLRewriteRule, error) {
+	fields := strings.Fields(line)
+	rule := &URLRewriteRule{Action: rewriteActionPath}
+	if isRewriteAction(fields[0]) {
+		rule.Action = fields[0]
+		fields = fields[1:]
+	}
+	for i, field := range fields {
+		if field == "if" {
+			conds, err := parseGroupRules(strings.Join(fields[i+1:], " "))
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid rewrite condition in "+line)
+			}
+			if len(conds) == 0 {
+				return nil, errors.New("empty rewrite condition in " + line)
+			}
+			rule.conds = conds
+			fields = fields[:i]
+			break
+		}
+	}
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, errors.New("invalid rewrite rule: " + line)
+	}
+	re, err := regexp.Compile(fields[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid rewrite regexp "+fields[0])
+	}
+	rule.Regexp = re
+	rule.Replace = fields[1]
+	if rule.Action == rewriteActionRedirect {
+		rule.Code = http.StatusFound
+		if len(fields) == 3 {
+			code, err := strconv.Atoi(fields[2])
+			if err != nil || (code != http.StatusMovedPermanently && code != http.StatusFound &&
+				code != http.StatusTemporaryRedirect && code != http.StatusPermanentRedirect) {
+				return nil, errors.New("redirect code must be 301, 302, 307 or 308 in " + line)
+			}
+			rule.Code = code
+		}
+		return rule, nil
+	}
+	if len(fields) == 3 {
+		if fields[2] != rewriteFlagLast && fields[2] != rewriteFlagBreak {
+			return nil, errors.New("rewrite flag must be last or break in " + line)
+		}
+		rule.Flag = fields[2]
+	}
+	return rule, nil
+}
+
+// 编译模块的重写规则，解析失败时不做重写
+func newURLRewriteRules(module *running.GatewayModule) []*URLRewriteRule {
+	if module.MatchRule == nil {
+		return []*URLRewriteRule{}
+	}
+	rules, err := ParseURLRewrite(module.MatchRule.URLRewrite)
+	if err != nil {
+		config.SysLog.Warn("[url rewrite invalid] [module:%s] [err:%s]", module.Base.Name, err.Error())
+		return []*URLRewriteRule{}
+	}
+	return rules
+}
+
+// 请求是否命中规则：query动作匹配query，其余匹配path
+func (r *URLRewriteRule) match(req *http.Request) bool {
+	subject := req.URL.Path
+	if r.Action == rewriteActionQuery {
+		subject = req.URL.RawQuery
+	}
+	if !r.Regexp.MatchString(subject) {
+		return false
+	}
+	for _, cond := range r.conds {
+		if !cond.match(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// 改写path，替换值中?之后的部分作为新query，原query追加在后
+// 替换值以?结尾时丢弃原query
+func (r *URLRewriteRule) rewritePath(req *http.Request) {
+	target := r.Regexp.ReplaceAllString(req.URL.Path, r.Replace)
+	if i := strings.Index(target, "?"); i >= 0 {
+		query := target[i+1:]
+		if query != "" && req.URL.RawQuery != "" {
+			query += "&" + req.URL.RawQuery
+		}
+		req.URL.RawQuery = query
+		target = target[:i]
+	}
+	req.URL.Path = target
+	req.URL.RawPath = ""
+}
+
+// 跳转地址，目标地址不含?时保留原query
+func (r *URLRewriteRule) location(req *http.Request) string {
+	location := r.Regexp.ReplaceAllString(req.URL.Path, r.Replace)
+	if strings.HasSuffix(location, "?") {
+		return strings.TrimSuffix(location, "?")
+	}
+	if !strings.Contains(location, "?") && req.URL.RawQuery != "" {
+		location += "?" + req.URL.RawQuery
+	}
+	return location
+}
+
+// 按顺序执行重写规则，命中跳转规则时返回跳转信息
+func rewriteRequest(rules []*URLRewriteRule, req *http.Request) (*URLRedirect, error) {
+	for cycle := 0; cycle < maxRewriteCycles; cycle++ {
+		restart := false
+		for _, rule := range rules {
+			if !rule.match(req) {
+				continue
+			}
+			switch rule.Action {
+			case rewriteActionRedirect:
+				return &URLRedirect{Code: rule.Code, Location: rule.location(req)}, nil
+			case rewriteActionQuery:
+				req.URL.RawQuery = rule.Regexp.ReplaceAllString(req.URL.RawQuery, rule.Replace)
+			default:
+				rule.rewritePath(req)
+			}
+			if rule.Flag == rewriteFlagBreak {
+				return nil, nil
+			}
+			if rule.Flag == rewriteFlagLast {
+				restart = true
+				break
+			}
+		}
+		if !restart {
+			return nil, nil
+		}
+	}
+	return nil, errors.New("url rewrite cycle exceeded")
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseURLRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []URLRewriteRule //仅比较动作、替换值、标记及状态码
+		conds   []int
+		wantErr bool
+	}{
+		{
+			name:  "legacy comma separated",
+			input: "^/a /b,^/c /d",
+			want: []URLRewriteRule{
+				{Action: rewriteActionPath, Replace: "/b"},
+				{Action: rewriteActionPath, Replace: "/d"},
+			},
+		},
+		{
+			name:  "actions and flags",
+			input: "# comment\nrewrite ^/a /b last\nquery ^id= uid= break\nredirect ^/old /new 301",
+			want: []URLRewriteRule{
+				{Action: rewriteActionPath, Replace: "/b", Flag: rewriteFlagLast},
+				{Action: rewriteActionQuery, Replace: "uid=", Flag: rewriteFlagBreak},
+				{Action: rewriteActionRedirect, Replace: "/new", Code: http.StatusMovedPermanently},
+			},
+		},
+		{
+			name:  "redirect default code",
+			input: "redirect ^/old /new",
+			want:  []URLRewriteRule{{Action: rewriteActionRedirect, Replace: "/new", Code: http.StatusFound}},
+		},
+		{
+			name:  "conditions",
+			input: "^/a /b break if header:X-Canary=1;method:GET",
+			want:  []URLRewriteRule{{Action: rewriteActionPath, Replace: "/b", Flag: rewriteFlagBreak}},
+			conds: []int{2},
+		},
+		{
+			name:    "invalid regexp",
+			input:   "^/(a /b",
+			wantErr: true,
+		},
+		{
+			name:    "missing replace",
+			input:   "rewrite ^/a",
+			wantErr: true,
+		},
+		{
+			name:    "unknown flag",
+			input:   "^/a /b stop",
+			wantErr: true,
+		},
+		{
+			name:    "invalid redirect code",
+			input:   "redirect ^/a /b 200",
+			wantErr: true,
+		},
+		{
+			name:    "empty condition",
+			input:   "^/a /b if",
+			wantErr: true,
+		},
+		{
+			name:    "invalid condition",
+			input:   "^/a /b if region:cn",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseURLRewrite(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(rules) != len(tt.want) {
+				t.Fatalf("got %d rules, want %d", len(rules), len(tt.want))
+			}
+			for i, rule := range rules {
+				want := tt.want[i]
+				if rule.Action != want.Action || rule.Replace != want.Replace || rule.Flag != want.Flag || rule.Code != want.Code {
+					t.Errorf("rule %d = %+v, want %+v", i, rule, want)
+				}
+				if i < len(tt.conds) && len(rule.conds) != tt.conds[i] {
+					t.Errorf("rule %d has %d conditions, want %d", i, len(rule.conds), tt.conds[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRewriteRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    string
+		url      string
+		header   map[string]string
+		wantURI  string
+		redirect *URLRedirect
+		wantErr  bool
+	}{
+		{
+			name:    "no match",
+			rules:   "^/a /b",
+			url:     "/c?x=1",
+			wantURI: "/c?x=1",
+		},
+		{
+			name:    "rules chain without flag",
+			rules:   "^/a /b\n^/b /c",
+			url:     "/a",
+			wantURI: "/c",
+		},
+		{
+			name:    "break stops later rules",
+			rules:   "^/a /b break\n^/b /c",
+			url:     "/a",
+			wantURI: "/b",
+		},
+		{
+			name:    "last restarts from first rule",
+			rules:   "^/v2/(.*) /v3/$1\n^/v1/(.*) /v2/$1 last",
+			url:     "/v1/user",
+			wantURI: "/v3/user",
+		},
+		{
+			name:    "rewrite with query appends original query",
+			rules:   "^/item/(\\d+) /item?id=$1",
+			url:     "/item/7?from=app",
+			wantURI: "/item?id=7&from=app",
+		},
+		{
+			name:    "trailing question mark drops query",
+			rules:   "^/a /b?",
+			url:     "/a?x=1",
+			wantURI: "/b",
+		},
+		{
+			name:    "query rewrite",
+			rules:   "query (^|&)uid= ${1}user_id=",
+			url:     "/a?uid=1&x=2",
+			wantURI: "/a?user_id=1&x=2",
+		},
+		{
+			name:     "redirect keeps query",
+			rules:    "redirect ^/old/(.*) /new/$1 308",
+			url:      "/old/x?y=1",
+			redirect: &URLRedirect{Code: http.StatusPermanentRedirect, Location: "/new/x?y=1"},
+		},
+		{
+			name:    "condition not met",
+			rules:   "^/a /b if header:X-Canary=1",
+			url:     "/a",
+			wantURI: "/a",
+		},
+		{
+			name:    "condition met",
+			rules:   "^/a /b if header:X-Canary=1",
+			url:     "/a",
+			header:  map[string]string{"X-Canary": "1"},
+			wantURI: "/b",
+		},
+		{
+			name:    "last cycle",
+			rules:   "^/a /b last\n^/b /a last",
+			url:     "/a",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseURLRewrite(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			redirect, err := rewriteRequest(rules, req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.redirect != nil {
+				if redirect == nil || *redirect != *tt.redirect {
+					t.Fatalf("redirect = %+v, want %+v", redirect, tt.redirect)
+				}
+				return
+			}
+			if redirect != nil {
+				t.Fatalf("unexpected redirect %+v", redirect)
+			}
+			if got := req.URL.RequestURI(); got != tt.wantURI {
+				t.Errorf("uri = %s, want %s", got, tt.wantURI)
+			}
+		})
+	}
+}
@@ -0,0 +1,514 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gatekeeper/config"
+	"gatekeeper/core/discovery"
+	"gatekeeper/model/entity"
+	"gatekeeper/model/running"
+)
+
+var (
+	moduleNameRegexp = regexp.MustCompile("^[0-9a-zA-Z_-]+$")
+	appIDRegexp      = regexp.MustCompile("^[0-9a-zA-Z_-]+$")
+)
+
+// ValidateItem 单条校验信息
+type ValidateItem struct {
+	Target string `json:"target"` //module:xxx 或 app:xxx
+	Field  string `json:"field"`
+	Msg    string `json:"msg"`
+}
+
+func (i *ValidateItem) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Target, i.Field, i.Msg)
+}
+
+// ValidateResult 配置校验结果
+// Errors会阻止配置生效，Warnings仅做提示
+type ValidateResult struct {
+	Valid    bool            `json:"valid"`
+	Errors   []*ValidateItem `json:"errors"`
+	Warnings []*ValidateItem `json:"warnings"`
+}
+
+// Err 将校验错误合并为一个error，校验通过时返回nil
+func (r *ValidateResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	msgs := []string{}
+	for _, item := range r.Errors {
+		msgs = append(msgs, item.String())
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+func (r *ValidateResult) addError(target, field, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, &ValidateItem{Target: target, Field: field, Msg: fmt.Sprintf(format, args...)})
+	r.Valid = false
+}
+
+func (r *ValidateResult) addWarning(target, field, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, &ValidateItem{Target: target, Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+// ValidateConfig 校验模块及租户配置
+// modules或apps为nil时跳过对应部分及两者之间的引用校验
+func ValidateConfig(modules *running.Modules, apps *running.Apps) *ValidateResult {
+	result := &ValidateResult{Valid: true, Errors: []*ValidateItem{}, Warnings: []*ValidateItem{}}
+	if modules != nil {
+		for name, module := range modules.Module {
+			validateModule(result, name, module)
+		}
+		validateMatchRules(result, modules)
+	}
+	if apps != nil {
+		appIDs := map[string]string{}
+		for name, app := range apps.Apps {
+			validateApp(result, name, app)
+			if app == nil {
+				continue
+			}
+			if other, ok := appIDs[app.AppID]; ok {
+				result.addError("app:"+name, "app_id", "duplicate app_id %s with app %s", app.AppID, other)
+			}
+			appIDs[app.AppID] = name
+		}
+	}
+	if modules != nil && apps != nil {
+		validateAppReference(result, modules, apps)
+	}
+	return result
+}
+
+// 校验单个模块
+func validateModule(result *ValidateResult, name string, module *running.GatewayModule) {
+	target := "module:" + name
+	if module == nil || module.Base == nil {
+		result.addError(target, "base", "is empty")
+		return
+	}
+	if module.LoadBalance == nil {
+		result.addError(target, "load_balance", "is empty")
+		return
+	}
+	if module.Base.Name != name {
+		result.addError(target, "base.name", "%s not match config key", module.Base.Name)
+	}
+	if !moduleNameRegexp.MatchString(module.Base.Name) {
+		result.addError(target, "base.name", "invalid format %s", module.Base.Name)
+	}
+	if module.Base.LoadType != "http" && module.Base.LoadType != "tcp" {
+		result.addError(target, "base.load_type", "must be http or tcp, got %s", module.Base.LoadType)
+	}
+	if pages, err := ParseErrorPages(module.Base.ErrorPages); err != nil {
+		result.addError(target, "base.error_pages", "%s", err.Error())
+	} else {
+		for _, page := range pages {
+			if err := checkErrorPageJSON(page); err != nil {
+				result.addError(target, "base.error_pages", "%s", err.Error())
+			}
+		}
+	}
+	if module.MatchRule != nil {
+		if _, err := ParseURLRewrite(module.MatchRule.URLRewrite); err != nil {
+			result.addError(target, "match_rule.url_rewrite", "%s", err.Error())
+		}
+	}
+	validateLoadBalance(result, target, module.LoadBalance)
+	if module.AccessControl != nil {
+		validateAccessControl(result, target, module.AccessControl)
+	}
+	validateUpstreamGroups(result, target, module)
+	validateMirror(result, target, module)
+	if module.Cache != nil {
+		validateCache(result, target, module)
+	}
+}
+
+// 校验负载配置：服务发现、ip格式、权重数量、超时时间
+// 使用服务发现时ip_list可为空
+func validateLoadBalance(result *ValidateResult, target string, lb *entity.GatewayLoadBalance) {
+	static := discovery.IsStatic(lb.DiscoveryType)
+	seen := validateNodes(result, target, "load_balance", lb)
+	for _, addr := range splitList(lb.ForbidList) {
+		if err := validateAddr(addr); err != nil {
+			result.addError(target, "load_balance.forbid_list", "%s", err.Error())
+		} else if !seen[addr] && static {
+			result.addWarning(target, "load_balance.forbid_list", "%s not in ip_list", addr)
+		}
+	}
+	for _, addr := range splitList(lb.DrainList) {
+		if err := validateAddr(addr); err != nil {
+			result.addError(target, "load_balance.drain_list", "%s", err.Error())
+		} else if !seen[addr] && static {
+			result.addWarning(target, "load_balance.drain_list", "%s not in ip_list", addr)
+		}
+	}
+
+	if lb.CheckInterval < 100 {
+		result.addError(target, "load_balance.check_interval", "min 100 ms, got %d", lb.CheckInterval)
+	}
+	if lb.CheckTimeout < 0 {
+		result.addError(target, "load_balance.check_timeout", "must not be negative")
+	}
+	if lb.ProxyConnectTimeout < 1 {
+		result.addError(target, "load_balance.proxy_connect_timeout", "min 1 ms, got %d", lb.ProxyConnectTimeout)
+	}
+	if lb.ProxyHeaderTimeout < 0 {
+		result.addError(target, "load_balance.proxy_header_timeout", "must not be negative")
+	}
+	if lb.ProxyBodyTimeout < 0 {
+		result.addError(target, "load_balance.proxy_body_timeout", "must not be negative")
+	}
+	if lb.IdleConnTimeout < 0 {
+		result.addError(target, "load_balance.idle_conn_timeout", "must not be negative")
+	}
+	if lb.MaxIdleConn < 0 {
+		result.addError(target, "load_balance.max_idle_conn", "must not be negative")
+	}
+	if lb.MaxBodySize < 0 {
+		result.addError(target, "load_balance.max_body_size", "must not be negative")
+	}
+	if lb.SlowStart < 0 {
+		result.addError(target, "load_balance.slow_start", "must not be negative")
+	}
+	if lb.MaxConnsPerHost < 0 {
+		result.addError(target, "load_balance.max_conns_per_host", "must not be negative")
+	}
+	if lb.MaxConcurrency < 0 {
+		result.addError(target, "load_balance.max_concurrency", "must not be negative")
+	}
+	if lb.MaxConcurrencyPerNode < 0 {
+		result.addError(target, "load_balance.max_concurrency_per_node", "must not be negative")
+	}
+	if lb.QueueSize < 0 {
+		result.addError(target, "load_balance.queue_size", "must not be negative")
+	} else if lb.QueueSize > 0 && lb.MaxConcurrency == 0 {
+		result.addWarning(target, "load_balance.queue_size", "ignored without max_concurrency")
+	}
+	if lb.QueueTimeout < 0 {
+		result.addError(target, "load_balance.queue_timeout", "must not be negative")
+	}
+
+	switch lb.HostMode {
+	case "", HostModeFixed:
+	case HostModePreserve, HostModeUpstream:
+		if lb.FixedHost != "" {
+			result.addWarning(target, "load_balance.fixed_host", "ignored in host_mode %s", lb.HostMode)
+		}
+	default:
+		result.addError(target, "load_balance.host_mode", "must be fixed, preserve or upstream, got %s", lb.HostMode)
+	}
+	if _, err := parseHeaderRules(lb.RequestHeaderRules); err != nil {
+		result.addError(target, "load_balance.request_header_rules", "%s", err.Error())
+	}
+	if _, err := parseHeaderRules(lb.ResponseHeaderRules); err != nil {
+		result.addError(target, "load_balance.response_header_rules", "%s", err.Error())
+	}
+}
+
+// 校验节点配置：服务发现、ip格式及权重数量，返回配置的ip
+func validateNodes(result *ValidateResult, target, field string, lb *entity.GatewayLoadBalance) map[string]bool {
+	static := discovery.IsStatic(lb.DiscoveryType)
+	if !static {
+		if _, err := discovery.New(lb); err != nil {
+			result.addError(target, field+".discovery", "%s", err.Error())
+		}
+	}
+
+	ipList := splitList(lb.IPList)
+	if len(ipList) == 0 && static {
+		result.addError(target, field+".ip_list", "is empty")
+	}
+	seen := map[string]bool{}
+	for _, addr := range ipList {
+		if err := validateAddr(addr); err != nil {
+			result.addError(target, field+".ip_list", "%s", err.Error())
+		}
+		if seen[addr] {
+			result.addError(target, field+".ip_list", "duplicate addr %s", addr)
+		}
+		seen[addr] = true
+	}
+
+	// 权重为空时全部使用默认权重，否则须与ip一一对应
+	weightList := splitList(lb.WeightList)
+	if len(weightList) > 0 && len(weightList) != len(ipList) {
+		result.addError(target, field+".weight_list", "%d weights for %d ips", len(weightList), len(ipList))
+	}
+	for _, weight := range weightList {
+		w, err := strconv.ParseInt(weight, 10, 64)
+		if err != nil || w < 0 {
+			result.addError(target, field+".weight_list", "invalid weight %s", weight)
+		}
+	}
+	return seen
+}
+
+// 校验上游分组：名称唯一、流量百分比合计不超过100、强制规则格式及分组节点
+func validateUpstreamGroups(result *ValidateResult, target string, module *running.GatewayModule) {
+	if len(module.UpstreamGroups) > 0 && module.Base.LoadType != "http" {
+		result.addError(target, "upstream_groups", "only supported by http module")
+	}
+	names := map[string]bool{}
+	var total int64
+	for _, group := range module.UpstreamGroups {
+		if group == nil {
+			result.addError(target, "upstream_groups", "contains empty group")
+			continue
+		}
+		field := "upstream_groups." + group.Name
+		if !moduleNameRegexp.MatchString(group.Name) {
+			result.addError(target, field, "invalid group name %s", group.Name)
+		}
+		if group.Name == DefaultUpstreamGroup {
+			result.addError(target, field, "%s is reserved for load_balance", DefaultUpstreamGroup)
+		}
+		if names[group.Name] {
+			result.addError(target, field, "duplicate group name")
+		}
+		names[group.Name] = true
+		if group.Percent < 0 || group.Percent > 100 {
+			result.addError(target, field+".percent", "must be within 0-100, got %d", group.Percent)
+		}
+		total += group.Percent
+		if _, err := parseGroupRules(group.ForceRule); err != nil {
+			result.addError(target, field+".force_rule", "%s", err.Error())
+		}
+		validateNodes(result, target, field, groupLoadBalance(module, group))
+	}
+	if total > 100 {
+		result.addError(target, "upstream_groups", "total percent %d exceeds 100", total)
+	}
+}
+
+// 校验流量镜像：镜像分组需为模块的上游分组
+func validateMirror(result *ValidateResult, target string, module *running.GatewayModule) {
+	lb := module.LoadBalance
+	if lb.MirrorPercent < 0 || lb.MirrorPercent > 100 {
+		result.addError(target, "load_balance.mirror_percent", "must be within 0-100, got %d", lb.MirrorPercent)
+	}
+	if lb.MirrorMaxConcurrency < 0 {
+		result.addError(target, "load_balance.mirror_max_concurrency", "must not be negative")
+	}
+	if lb.MirrorGroup == "" {
+		return
+	}
+	if module.Base.LoadType != "http" {
+		result.addError(target, "load_balance.mirror_group", "only supported by http module")
+	}
+	for _, group := range module.UpstreamGroups {
+		if group == nil || group.Name != lb.MirrorGroup {
+			continue
+		}
+		if group.Percent > 0 || group.ForceRule != "" {
+			result.addWarning(target, "load_balance.mirror_group", "group %s also receives primary traffic", group.Name)
+		}
+		return
+	}
+	result.addError(target, "load_balance.mirror_group", "group %s not found in upstream_groups", lb.MirrorGroup)
+}
+
+// 校验响应缓存配置
+func validateCache(result *ValidateResult, target string, module *running.GatewayModule) {
+	cache := module.Cache
+	if cache.Open != 0 && cache.Open != 1 {
+		result.addError(target, "cache.open", "must be 0 or 1, got %d", cache.Open)
+	}
+	if cache.Open == 1 && module.Base.LoadType != "http" {
+		result.addError(target, "cache.open", "only supported by http module")
+	}
+	switch cache.Backend {
+	case "", "memory":
+	case "redis":
+		if !config.RedisEnabled() {
+			result.addWarning(target, "cache.backend", "redis not configured, fallback to memory")
+		}
+	default:
+		result.addError(target, "cache.backend", "must be memory or redis, got %s", cache.Backend)
+	}
+	if cache.DefaultTTL < 0 || cache.StaleWhileRevalidate < 0 || cache.StaleIfError < 0 || cache.MaxBodySize < 0 {
+		result.addError(target, "cache", "ttl, stale time and max_body_size must not be negative")
+	}
+	if _, appID, _, err := parseCacheKeyParts(cache.KeyParts); err != nil {
+		result.addError(target, "cache.key_parts", "%s", err.Error())
+	} else if cache.Open == 1 && strings.TrimSpace(cache.KeyParts) != "" && !appID && accessControlOpen(module) {
+		result.addWarning(target, "cache.key_parts", "access control is open but app_id is not in key, responses are shared between apps")
+	}
+	if _, err := parseCacheTTLRules(cache.TTLRules); err != nil {
+		result.addError(target, "cache.ttl_rules", "%s", err.Error())
+	}
+}
+
+// 校验访问控制配置的名单格式
+func validateAccessControl(result *ValidateResult, target string, ac *entity.GatewayAccessControl) {
+	for _, ip := range splitList(ac.BlackList) {
+		if net.ParseIP(ip) == nil {
+			result.addError(target, "access_control.black_list", "invalid ip %s", ip)
+		}
+	}
+	for _, ip := range splitList(ac.WhiteList) {
+		if net.ParseIP(ip) == nil {
+			result.addError(target, "access_control.white_list", "invalid ip %s", ip)
+		}
+	}
+	if strings.Contains(ac.WhiteHostName, ",,") {
+		result.addError(target, "access_control.white_host_name", "contains empty item")
+	}
+	if ac.ClientFlowLimit < 0 {
+		result.addError(target, "access_control.client_flow_limit", "must not be negative")
+	}
+	if ac.Open != 0 && ac.Open != 1 {
+		result.addError(target, "access_control.open", "must be 0 or 1")
+	}
+}
+
+// 校验模块间的访问前缀，完全相同为错误，前缀包含为警告
+func validateMatchRules(result *ValidateResult, modules *running.Modules) {
+	rules := map[string]string{}
+	for name, module := range modules.Module {
+		if module == nil || module.MatchRule == nil || module.MatchRule.Rule == "" {
+			continue
+		}
+		for _, rule := range splitList(module.MatchRule.Rule) {
+			if !strings.HasPrefix(rule, "/") {
+				result.addError("module:"+name, "match_rule.rule", "%s must start with /", rule)
+			}
+			if other, ok := rules[rule]; ok && other != name {
+				result.addError("module:"+name, "match_rule.rule", "duplicate rule %s with module %s", rule, other)
+				continue
+			}
+			rules[rule] = name
+		}
+	}
+	for rule, name := range rules {
+		for otherRule, otherName := range rules {
+			if name != otherName && rule != otherRule && strings.HasPrefix(rule, otherRule) {
+				result.addWarning("module:"+name, "match_rule.rule", "rule %s overlaps rule %s of module %s", rule, otherRule, otherName)
+			}
+		}
+	}
+}
+
+// 校验单个租户
+func validateApp(result *ValidateResult, name string, app *entity.GatewayAPP) {
+	target := "app:" + name
+	if app == nil {
+		result.addError(target, "app", "is empty")
+		return
+	}
+	if app.Name == "" {
+		result.addError(target, "name", "is empty")
+	}
+	if app.Secret == "" {
+		result.addError(target, "secret", "is empty")
+	}
+	if !appIDRegexp.MatchString(app.AppID) {
+		result.addError(target, "app_id", "invalid format %s", app.AppID)
+	}
+	if app.Method != "" && app.Method != "any" && app.Method != "get" && app.Method != "post" {
+		result.addError(target, "method", "must be any, get or post, got %s", app.Method)
+	}
+	if app.QPS < 0 {
+		result.addError(target, "qps", "must not be negative")
+	}
+	if app.TotalQueryDaily < 0 {
+		result.addError(target, "total_query_daily", "must not be negative")
+	}
+	if app.TotalQueryHourly < 0 {
+		result.addError(target, "total_query_hourly", "must not be negative")
+	}
+	if app.TotalQueryMonthly < 0 {
+		result.addError(target, "total_query_monthly", "must not be negative")
+	}
+	if _, err := ParseModuleQuota(app.ModuleQuota); err != nil {
+		result.addError(target, "module_quota", "%s", err.Error())
+	}
+	if app.Timeout < 0 {
+		result.addError(target, "timeout", "must not be negative")
+	}
+	for _, ip := range splitList(app.WhiteIps) {
+		if net.ParseIP(ip) == nil {
+			result.addWarning(target, "white_ips", "%s is not a complete ip", ip)
+		}
+	}
+	for _, path := range splitList(app.OpenAPI) {
+		if !strings.HasPrefix(path, "/") {
+			result.addError(target, "open_api", "%s must start with /", path)
+		}
+	}
+}
+
+// 校验租户开放接口是否存在对应的模块
+func validateAppReference(result *ValidateResult, modules *running.Modules, apps *running.Apps) {
+	rules := []string{}
+	moduleNames := map[string]bool{}
+	for _, module := range modules.Module {
+		if module != nil && module.Base != nil {
+			moduleNames[module.Base.Name] = true
+		}
+		if module != nil && module.MatchRule != nil {
+			rules = append(rules, splitList(module.MatchRule.Rule)...)
+		}
+	}
+	for name, app := range apps.Apps {
+		if app == nil {
+			continue
+		}
+		quotas, _ := ParseModuleQuota(app.ModuleQuota)
+		for module := range quotas {
+			if !moduleNames[module] {
+				result.addWarning("app:"+name, "module_quota", "module %s not found", module)
+			}
+		}
+		for _, path := range splitList(app.OpenAPI) {
+			matched := false
+			for _, rule := range rules {
+				if strings.HasPrefix(path, rule) || strings.HasPrefix(rule, path) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				result.addWarning("app:"+name, "open_api", "%s not served by any module", path)
+			}
+		}
+	}
+}
+
+// 校验ip:port格式
+func validateAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Errorf("invalid addr %s", addr)
+	}
+	if host == "" {
+		return errors.Errorf("empty host in addr %s", addr)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return errors.Errorf("invalid port in addr %s", addr)
+	}
+	return nil
+}
+
+// 按逗号拆分列表，忽略空白项
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
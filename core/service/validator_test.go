@@ -0,0 +1,215 @@
+package service
+
+import (
+	"testing"
+
+	"gatekeeper/model/entity"
+	"gatekeeper/model/running"
+)
+
+func testModule(name, rule string) *running.GatewayModule {
+	return &running.GatewayModule{
+		Base:          &entity.GatewayModuleBase{Name: name, LoadType: "http"},
+		MatchRule:     &entity.GatewayMatchRule{Type: "url_prefix", Rule: rule},
+		AccessControl: &entity.GatewayAccessControl{},
+		LoadBalance: &entity.GatewayLoadBalance{
+			IPList:              "127.0.0.1:8001,127.0.0.1:8002",
+			CheckInterval:       1000,
+			ProxyConnectTimeout: 1000,
+		},
+	}
+}
+
+func testApp(appID string) *entity.GatewayAPP {
+	return &entity.GatewayAPP{Name: appID, AppID: appID, Secret: "secret"}
+}
+
+// hasItem 是否包含指定的校验项，want格式为 "target field"
+func hasItem(items []*ValidateItem, want string) bool {
+	for _, item := range items {
+		if item.Target+" "+item.Field == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		modules  map[string]*running.GatewayModule
+		apps     map[string]*entity.GatewayAPP
+		errors   []string //target field
+		warnings []string
+	}{
+		{
+			name:    "valid",
+			modules: map[string]*running.GatewayModule{"svc": testModule("svc", "/svc")},
+			apps:    map[string]*entity.GatewayAPP{"app": testApp("app")},
+		},
+		{
+			name:    "empty base",
+			modules: map[string]*running.GatewayModule{"svc": {}},
+			errors:  []string{"module:svc base"},
+		},
+		{
+			name:    "name not match key",
+			modules: map[string]*running.GatewayModule{"svc": testModule("other", "/svc")},
+			errors:  []string{"module:svc base.name"},
+		},
+		{
+			name: "invalid ip and weight count",
+			modules: map[string]*running.GatewayModule{"svc": func() *running.GatewayModule {
+				m := testModule("svc", "/svc")
+				m.LoadBalance.IPList = "127.0.0.1,127.0.0.1:8002"
+				m.LoadBalance.WeightList = "50"
+				return m
+			}()},
+			errors: []string{"module:svc load_balance.ip_list", "module:svc load_balance.weight_list"},
+		},
+		{
+			name: "empty weight list uses default weight",
+			modules: map[string]*running.GatewayModule{"svc": func() *running.GatewayModule {
+				m := testModule("svc", "/svc")
+				m.LoadBalance.WeightList = ""
+				return m
+			}()},
+		},
+		{
+			name: "forbid addr not in ip_list",
+			modules: map[string]*running.GatewayModule{"svc": func() *running.GatewayModule {
+				m := testModule("svc", "/svc")
+				m.LoadBalance.ForbidList = "127.0.0.1:9000"
+				return m
+			}()},
+			warnings: []string{"module:svc load_balance.forbid_list"},
+		},
+		{
+			name: "check interval too small",
+			modules: map[string]*running.GatewayModule{"svc": func() *running.GatewayModule {
+				m := testModule("svc", "/svc")
+				m.LoadBalance.CheckInterval = 10
+				return m
+			}()},
+			errors: []string{"module:svc load_balance.check_interval"},
+		},
+		{
+			name: "overlapping match rule",
+			modules: map[string]*running.GatewayModule{
+				"a": testModule("a", "/svc"),
+				"b": testModule("b", "/svc/v2"),
+			},
+			warnings: []string{"module:b match_rule.rule"},
+		},
+		{
+			name: "group percent exceeds 100",
+			modules: map[string]*running.GatewayModule{"svc": func() *running.GatewayModule {
+				m := testModule("svc", "/svc")
+				m.UpstreamGroups = []*entity.GatewayUpstreamGroup{
+					{Name: "a", Percent: 60, IPList: "127.0.0.1:9001"},
+					{Name: "b", Percent: 60, IPList: "127.0.0.1:9002"},
+				}
+				return m
+			}()},
+			errors: []string{"module:svc upstream_groups"},
+		},
+		{
+			name: "invalid app",
+			apps: map[string]*entity.GatewayAPP{"app": {Name: "app", AppID: "bad id", Method: "put"}},
+			errors: []string{
+				"app:app secret",
+				"app:app app_id",
+				"app:app method",
+			},
+		},
+		{
+			name:    "open api not served",
+			modules: map[string]*running.GatewayModule{"svc": testModule("svc", "/svc")},
+			apps: map[string]*entity.GatewayAPP{"app": func() *entity.GatewayAPP {
+				app := testApp("app")
+				app.OpenAPI = "/other"
+				return app
+			}()},
+			warnings: []string{"app:app open_api"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var modules *running.Modules
+			if tt.modules != nil {
+				modules = &running.Modules{Module: tt.modules}
+			}
+			var apps *running.Apps
+			if tt.apps != nil {
+				apps = &running.Apps{Apps: tt.apps}
+			}
+			result := ValidateConfig(modules, apps)
+			if result.Valid != (len(tt.errors) == 0) {
+				t.Fatalf("valid = %v, errors: %v", result.Valid, result.Err())
+			}
+			if len(result.Errors) < len(tt.errors) {
+				t.Errorf("got %d errors, want at least %d: %v", len(result.Errors), len(tt.errors), result.Err())
+			}
+			for _, item := range tt.errors {
+				if !hasItem(result.Errors, item) {
+					t.Errorf("missing error %s, got %v", item, result.Err())
+				}
+			}
+			for _, item := range tt.warnings {
+				if !hasItem(result.Warnings, item) {
+					t.Errorf("missing warning %s, got %v", item, result.Warnings)
+				}
+			}
+		})
+	}
+}
+
+// 重复项按map遍历顺序报告在其中一项上
+func TestValidateConfigDuplicate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modules map[string]*running.GatewayModule
+		apps    map[string]*entity.GatewayAPP
+		errors  []string //任一项存在即可
+	}{
+		{
+			name: "match rule",
+			modules: map[string]*running.GatewayModule{
+				"a": testModule("a", "/svc"),
+				"b": testModule("b", "/svc"),
+			},
+			errors: []string{"module:a match_rule.rule", "module:b match_rule.rule"},
+		},
+		{
+			name: "app_id",
+			apps: map[string]*entity.GatewayAPP{
+				"a": {Name: "a", AppID: "same", Secret: "secret"},
+				"b": {Name: "b", AppID: "same", Secret: "secret"},
+			},
+			errors: []string{"app:a app_id", "app:b app_id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var modules *running.Modules
+			if tt.modules != nil {
+				modules = &running.Modules{Module: tt.modules}
+			}
+			var apps *running.Apps
+			if tt.apps != nil {
+				apps = &running.Apps{Apps: tt.apps}
+			}
+			result := ValidateConfig(modules, apps)
+			if len(result.Errors) != 1 {
+				t.Fatalf("got %d errors, want 1: %v", len(result.Errors), result.Err())
+			}
+			found := false
+			for _, item := range tt.errors {
+				found = found || hasItem(result.Errors, item)
+			}
+			if !found {
+				t.Errorf("got %v, want one of %v", result.Err(), tt.errors)
+			}
+		})
+	}
+}
@@ -2,17 +2,35 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"gatekeeper/cli"
 	"gatekeeper/config"
+	"gatekeeper/constant"
 	"gatekeeper/core/resource"
 	"gatekeeper/core/service"
 	"gatekeeper/server"
 )
 
 func main() {
+	// 子命令：apply 声明式配置导入，export 配置导出
+	commands := map[string]func([]string) error{
+		"apply":  cli.Apply,
+		"export": cli.Export,
+	}
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	config.Conf = flag.String("config", "./etc/", "input config file like ./conf/dev/")
 	flag.Parse()
 
@@ -29,15 +47,41 @@ func main() {
 		panic(err)
 	}
 
+	// 初始化配置存储
+	if err := service.InitStorage(); err != nil {
+		panic(err)
+	}
+
+	// 初始化访问日志格式
+	if err := service.InitAccessLog(); err != nil {
+		panic(err)
+	}
+
 	// 初始化resource
+	resource.Counters = resource.NewCounterStore()
+	resource.TimeSeries = resource.NewTimeSeriesStore()
 	resource.FlowCounters = resource.NewFlowCounterManager()
 	resource.Limiters = resource.NewLimiterManager()
+	resource.GroupStats = resource.NewGroupStatManager()
+	resource.ActiveRequests = resource.NewActiveRequestManager()
+	resource.UpstreamStats = resource.NewUpstreamStatManager()
+	resource.Events = resource.NewEventBus()
+	resource.MemoryCaches = resource.NewMemoryCacheStore(constant.CacheMemoryMaxBytes)
+	if resource.Spans, err = resource.NewSpanExporter(config.BaseConf.Trace); err != nil {
+		panic(err)
+	}
 
 	// 运行时配置初始化
 	service.SysConfMgr = service.NewSysConfigManage()
 	service.SysConfMgr.InitConfig()
 	service.SysConfMgr.MonitorConfig()
 
+	// 启动告警检查
+	if service.Alerts, err = service.NewAlertManager(config.BaseConf.Alert); err != nil {
+		panic(err)
+	}
+	service.Alerts.Start()
+
 	// 启动http服务器
 	server.HTTPServerRun()
 	quit := make(chan os.Signal)
@@ -47,5 +91,6 @@ func main() {
 	// 资源销毁
 	config.Destroy()
 	server.HTTPServerStop()
+	resource.Spans.Close()
 	signal.Stop(quit)
 }
@@ -0,0 +1,69 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type GatewayAdminUser struct {
+	ID        int64     `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
+	UserName  string    `json:"user_name" toml:"user_name" validate:"required" gorm:"size:255" orm:"column(user_name);size(255)" description:"用户名"`
+	Password  string    `json:"-" toml:"-" gorm:"size:255" orm:"column(password);size(255)" description:"bcrypt密码"`
+	Role      string    `json:"role" toml:"role" gorm:"size:50" orm:"column(role);size(50)" description:"角色 viewer/operator/admin"`
+	Modules   string    `json:"modules" toml:"modules" gorm:"type:text" orm:"column(modules);type(text)" description:"可管理的模块，逗号分隔，为空不限制"`
+	CreatedAt time.Time `json:"created_at" toml:"-" orm:"column(created_at);auto_now_add;type(datetime)" description:"创建时间"`
+	UpdatedAt time.Time `json:"updated_at" toml:"-" orm:"column(updated_at);auto_now;type(datetime)" description:"更新时间"`
+}
+
+func (e *GatewayAdminUser) TableName() string {
+	return "gateway_admin_user"
+}
+
+// 按用户名输出所有记录
+func (e *GatewayAdminUser) GetAll(db *gorm.DB) ([]*GatewayAdminUser, error) {
+	var users []*GatewayAdminUser
+	err := db.Order("user_name").Find(&users).Error
+	return users, err
+}
+
+// 根据用户名查找对应记录
+func (e *GatewayAdminUser) FindByName(db *gorm.DB, userName string) (*GatewayAdminUser, error) {
+	var user GatewayAdminUser
+	err := db.Where("user_name = ?", userName).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return &user, nil
+	}
+	return &user, err
+}
+
+// 根据主键id查找对应记录
+func (e *GatewayAdminUser) FindByID(db *gorm.DB, id int64) (*GatewayAdminUser, error) {
+	var user GatewayAdminUser
+	err := db.Where("id = ?", id).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return &user, nil
+	}
+	return &user, err
+}
+
+// 统计用户数
+func (e *GatewayAdminUser) Count(db *gorm.DB) (int64, error) {
+	var count int64
+	err := db.Model(&GatewayAdminUser{}).Count(&count).Error
+	return count, err
+}
+
+// 保存一条记录
+func (e *GatewayAdminUser) Save(db *gorm.DB) error {
+	return db.Save(e).Error
+}
+
+// 通过主键ID删除一条记录
+func (e *GatewayAdminUser) Del(db *gorm.DB) error {
+	return db.Where("id = ?", e.ID).Delete(e).Error
+}
+
+func (e *GatewayAdminUser) GetPk() int64 {
+	return e.ID
+}
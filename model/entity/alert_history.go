@@ -0,0 +1,90 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type GatewayAlertHistory struct {
+	ID           int64      `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
+	Fingerprint  string     `json:"fingerprint" toml:"fingerprint" gorm:"size:255" orm:"column(fingerprint);size(255)" description:"告警标识 规则名|目标"`
+	Rule         string     `json:"rule" toml:"rule" gorm:"size:100" orm:"column(rule);size(100)" description:"规则名称"`
+	Type         string     `json:"type" toml:"type" gorm:"size:50" orm:"column(type);size(50)" description:"规则类型"`
+	Severity     string     `json:"severity" toml:"severity" gorm:"size:20" orm:"column(severity);size(20)" description:"级别 critical/warning/info"`
+	Target       string     `json:"target" toml:"target" gorm:"size:255" orm:"column(target);size(255)" description:"告警目标"`
+	Status       string     `json:"status" toml:"status" gorm:"size:20" orm:"column(status);size(20)" description:"状态 firing/resolved"`
+	Summary      string     `json:"summary" toml:"summary" gorm:"size:1000" orm:"column(summary);size(1000)" description:"告警内容"`
+	Value        float64    `json:"value" toml:"value" orm:"column(value)" description:"触发时的值"`
+	Threshold    float64    `json:"threshold" toml:"threshold" orm:"column(threshold)" description:"阈值"`
+	Instance     string     `json:"instance" toml:"instance" gorm:"size:255" orm:"column(instance);size(255)" description:"产生告警的网关节点"`
+	NotifyResult string     `json:"notify_result" toml:"notify_result" gorm:"size:1000" orm:"column(notify_result);size(1000)" description:"最近一次通知结果"`
+	StartedAt    time.Time  `json:"started_at" toml:"started_at" orm:"column(started_at);type(datetime)" description:"触发时间"`
+	ResolvedAt   *time.Time `json:"resolved_at" toml:"resolved_at" orm:"column(resolved_at);type(datetime);null" description:"恢复时间"`
+	CreatedAt    time.Time  `json:"created_at" toml:"created_at" orm:"column(created_at);auto_now_add;type(datetime)" description:"创建时间"`
+}
+
+// 告警历史查询条件，空值不过滤
+type GatewayAlertHistoryFilter struct {
+	Rule      string
+	Severity  string
+	Status    string
+	Target    string
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+func (e *GatewayAlertHistory) TableName() string {
+	return "gateway_alert_history"
+}
+
+// 按条件分页查询，按触发时间倒序
+func (e *GatewayAlertHistory) GetList(db *gorm.DB, filter *GatewayAlertHistoryFilter, offset, limit int) ([]*GatewayAlertHistory, error) {
+	var list []*GatewayAlertHistory
+	query := db.Model(&GatewayAlertHistory{})
+	if filter.Rule != "" {
+		query = query.Where("rule = ?", filter.Rule)
+	}
+	if filter.Severity != "" {
+		query = query.Where("severity = ?", filter.Severity)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if !filter.StartTime.IsZero() {
+		query = query.Where("started_at >= ?", filter.StartTime)
+	}
+	if !filter.EndTime.IsZero() {
+		query = query.Where("started_at < ?", filter.EndTime)
+	}
+	query = query.Order("id desc").Offset(offset)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&list).Error
+	return list, err
+}
+
+func (e *GatewayAlertHistory) Save(db *gorm.DB) error {
+	return db.Save(e).Error
+}
+
+// 告警恢复，只更新状态及恢复时间
+func (e *GatewayAlertHistory) Resolve(db *gorm.DB, id int64, resolvedAt time.Time) error {
+	return db.Model(&GatewayAlertHistory{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":      "resolved",
+		"resolved_at": resolvedAt,
+	}).Error
+}
+
+// 更新通知结果
+func (e *GatewayAlertHistory) UpdateNotifyResult(db *gorm.DB, id int64, result string) error {
+	return db.Model(&GatewayAlertHistory{}).Where("id = ?", id).Update("notify_result", result).Error
+}
+
+func (e *GatewayAlertHistory) GetPk() int64 {
+	return e.ID
+}
@@ -5,18 +5,21 @@ import (
 )
 
 type GatewayAPP struct {
-	ID              int64  `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
-	AppID           string `json:"app_id" toml:"app_id" validate:"required"  orm:"column(app_id);" description:"租户id"`
-	Name            string `json:"name" toml:"name" validate:"required"  orm:"column(name);" description:"租户名称"`
-	Secret          string `json:"secret" toml:"secret" validate:"required"  orm:"column(secret);" description:"密钥"`
-	Method          string `json:"method" toml:"method" validate:""  orm:"column(method);" description:"请求方法"`
-	Timeout         int64  `json:"timeout" toml:"timeout" orm:"column(timeout);" description:"超时时间"`
-	OpenAPI         string `json:"open_api" toml:"open_api" orm:"column(open_api);" description:"接口列表，支持前缀匹配"`
-	WhiteIps        string `json:"white_ips" toml:"white_ips" orm:"column(white_ips);" description:"ip白名单，支持前缀匹配"`
-	CityIDs         string `json:"city_ids" toml:"city_ids" orm:"column(city_ids);" description:"city_id数据权限"`
-	TotalQueryDaily int64  `json:"total_query_daily" toml:"total_query_daily" orm:"column(total_query_daily);" description:"日请求量"`
-	QPS             int64  `json:"qps" toml:"qps" orm:"column(qps);" description:"qps"`
-	GroupID         int64  `json:"group_id" toml:"group_id" orm:"column(group_id);" description:"数据关联id"`
+	ID                int64  `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
+	AppID             string `json:"app_id" toml:"app_id" validate:"required"  gorm:"size:255" orm:"column(app_id);" description:"租户id"`
+	Name              string `json:"name" toml:"name" validate:"required"  gorm:"size:255" orm:"column(name);" description:"租户名称"`
+	Secret            string `json:"secret" toml:"secret" validate:"required"  gorm:"size:255" orm:"column(secret);" description:"密钥"`
+	Method            string `json:"method" toml:"method" validate:""  gorm:"size:255" orm:"column(method);" description:"请求方法"`
+	Timeout           int64  `json:"timeout" toml:"timeout" orm:"column(timeout);" description:"超时时间"`
+	OpenAPI           string `json:"open_api" toml:"open_api" gorm:"type:text" orm:"column(open_api);" description:"接口列表，支持前缀匹配"`
+	WhiteIps          string `json:"white_ips" toml:"white_ips" gorm:"type:text" orm:"column(white_ips);" description:"ip白名单，支持前缀匹配"`
+	CityIDs           string `json:"city_ids" toml:"city_ids" gorm:"type:text" orm:"column(city_ids);" description:"city_id数据权限"`
+	TotalQueryDaily   int64  `json:"total_query_daily" toml:"total_query_daily" orm:"column(total_query_daily);" description:"日请求量"`
+	TotalQueryHourly  int64  `json:"total_query_hourly" toml:"total_query_hourly" orm:"column(total_query_hourly);" description:"小时请求量，0为不限制"`
+	TotalQueryMonthly int64  `json:"total_query_monthly" toml:"total_query_monthly" orm:"column(total_query_monthly);" description:"月请求量，0为不限制"`
+	ModuleQuota       string `json:"module_quota" toml:"module_quota" gorm:"type:text" orm:"column(module_quota);size(2000)" description:"按模块的请求配额，每行一条"`
+	QPS               int64  `json:"qps" toml:"qps" orm:"column(qps);" description:"qps"`
+	GroupID           int64  `json:"group_id" toml:"group_id" orm:"column(group_id);" description:"数据关联id"`
 }
 
 func (e *GatewayAPP) TableName() string {
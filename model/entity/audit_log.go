@@ -0,0 +1,77 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type GatewayAuditLog struct {
+	ID         int64     `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
+	Actor      string    `json:"actor" toml:"actor" gorm:"size:255" orm:"column(actor);size(255)" description:"操作人"`
+	SourceIP   string    `json:"source_ip" toml:"source_ip" gorm:"size:64" orm:"column(source_ip);size(64)" description:"来源ip"`
+	Action     string    `json:"action" toml:"action" gorm:"size:50" orm:"column(action);size(50)" description:"操作"`
+	TargetType string    `json:"target_type" toml:"target_type" gorm:"size:50" orm:"column(target_type);size(50)" description:"目标类型 module/app/config"`
+	TargetName string    `json:"target_name" toml:"target_name" gorm:"size:255" orm:"column(target_name);size(255)" description:"目标名称"`
+	Before     string    `json:"before" toml:"before" gorm:"type:longtext" orm:"column(before);type(text)" description:"操作前配置"`
+	After      string    `json:"after" toml:"after" gorm:"type:longtext" orm:"column(after);type(text)" description:"操作后配置"`
+	Result     string    `json:"result" toml:"result" gorm:"size:50" orm:"column(result);size(50)" description:"结果 success/failure/denied"`
+	Message    string    `json:"message" toml:"message" gorm:"size:1000" orm:"column(message);size(1000)" description:"失败原因"`
+	CreatedAt  time.Time `json:"created_at" toml:"created_at" orm:"column(created_at);auto_now_add;type(datetime)" description:"创建时间"`
+}
+
+// 审计日志查询条件，空值不过滤
+type GatewayAuditLogFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetName string
+	Result     string
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+func (e *GatewayAuditLog) TableName() string {
+	return "gateway_audit_log"
+}
+
+// 按条件分页查询，limit小于等于0时不分页
+func (e *GatewayAuditLog) GetList(db *gorm.DB, filter *GatewayAuditLogFilter, offset, limit int) ([]*GatewayAuditLog, error) {
+	var logs []*GatewayAuditLog
+	query := db.Model(&GatewayAuditLog{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetName != "" {
+		query = query.Where("target_name = ?", filter.TargetName)
+	}
+	if filter.Result != "" {
+		query = query.Where("result = ?", filter.Result)
+	}
+	if !filter.StartTime.IsZero() {
+		query = query.Where("created_at >= ?", filter.StartTime)
+	}
+	if !filter.EndTime.IsZero() {
+		query = query.Where("created_at < ?", filter.EndTime)
+	}
+	query = query.Order("id desc").Offset(offset)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&logs).Error
+	return logs, err
+}
+
+func (e *GatewayAuditLog) Save(db *gorm.DB) error {
+	return db.Save(e).Error
+}
+
+func (e *GatewayAuditLog) GetPk() int64 {
+	return e.ID
+}
@@ -5,12 +5,14 @@ import (
 )
 
 type GatewayModuleBase struct {
-	ID           int64  `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
-	LoadType     string `json:"load_type" toml:"load_type" validate:"" orm:"column(load_type);size(255)" description:"负载类型 http/tcp"`
-	Name         string `json:"name" toml:"name" validate:"required" orm:"column(name);size(255)" description:"模块名"`
-	ServiceName  string `json:"service_name" toml:"service_name" validate:"" orm:"column(service_name);size(255)" description:"服务名称"`
-	PassAuthType int8   `json:"pass_auth_type" toml:"pass_auth_type" validate:"" orm:"column(pass_auth_type)" description:"认证传参类型"`
-	FrontendAddr string `json:"frontend_addr" toml:"frontend_addr" validate:"" orm:"column(frontend_addr);size(255)" description:"前端绑定ip地址"`
+	ID            int64  `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
+	LoadType      string `json:"load_type" toml:"load_type" validate:"" gorm:"size:255" orm:"column(load_type);size(255)" description:"负载类型 http/tcp"`
+	Name          string `json:"name" toml:"name" validate:"required" gorm:"size:255" orm:"column(name);size(255)" description:"模块名"`
+	ServiceName   string `json:"service_name" toml:"service_name" validate:"" gorm:"size:255" orm:"column(service_name);size(255)" description:"服务名称"`
+	PassAuthType  int8   `json:"pass_auth_type" toml:"pass_auth_type" validate:"" orm:"column(pass_auth_type)" description:"认证传参类型"`
+	FrontendAddr  string `json:"frontend_addr" toml:"frontend_addr" validate:"" gorm:"size:255" orm:"column(frontend_addr);size(255)" description:"前端绑定ip地址"`
+	AccessLogFile string `json:"access_log_file" toml:"access_log_file" validate:"" gorm:"size:255" orm:"column(access_log_file);size(255)" description:"模块独立访问日志文件，为空时写入全局访问日志"`
+	ErrorPages    string `json:"error_pages" toml:"error_pages" validate:"" gorm:"type:text" orm:"column(error_pages);size(5000)" description:"自定义错误页，每行一条：匹配 格式 模板"`
 }
 
 func (e *GatewayModuleBase) TableName() string {
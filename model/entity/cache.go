@@ -0,0 +1,57 @@
+package entity
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// GatewayCache 模块响应缓存配置
+type GatewayCache struct {
+	ID                   int64  `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
+	ModuleID             int64  `json:"module_id" toml:"-" orm:"column(module_id)" description:"模块id"`
+	Open                 int64  `json:"open" toml:"open" orm:"column(open)" description:"是否开启缓存"`
+	Backend              string `json:"backend" toml:"backend" gorm:"size:20" orm:"column(backend);size(20)" description:"缓存存储 memory/redis"`
+	DefaultTTL           int64  `json:"default_ttl" toml:"default_ttl" orm:"column(default_ttl)" description:"上游未声明有效期时的缓存秒数，0为不缓存"`
+	TTLRules             string `json:"ttl_rules" toml:"ttl_rules" gorm:"type:text" orm:"column(ttl_rules);size(2000)" description:"按路径覆盖缓存秒数，每行 正则 秒数"`
+	KeyParts             string `json:"key_parts" toml:"key_parts" gorm:"size:1000" orm:"column(key_parts);size(1000)" description:"缓存key组成 path,query,app_id,header:Name"`
+	StaleWhileRevalidate int64  `json:"stale_while_revalidate" toml:"stale_while_revalidate" orm:"column(stale_while_revalidate)" description:"过期后返回旧数据并后台刷新的秒数"`
+	StaleIfError         int64  `json:"stale_if_error" toml:"stale_if_error" orm:"column(stale_if_error)" description:"上游异常时返回旧数据的秒数"`
+	MaxBodySize          int64  `json:"max_body_size" toml:"max_body_size" orm:"column(max_body_size)" description:"可缓存的最大响应字节数"`
+}
+
+func (e *GatewayCache) TableName() string {
+	return "gateway_cache"
+}
+
+func (e *GatewayCache) GetAll(db *gorm.DB) ([]*GatewayCache, error) {
+	var caches []*GatewayCache
+	err := db.Model(&GatewayCache{}).
+		Find(&caches).Error
+	return caches, err
+}
+
+// 根据module获取对应记录，未配置时返回nil
+func (e *GatewayCache) GetByModule(db *gorm.DB, moduleID int64) (*GatewayCache, error) {
+	var caches []*GatewayCache
+	err := db.Model(&GatewayCache{}).
+		Where(&GatewayCache{ModuleID: moduleID}).
+		Find(&caches).Error
+	if len(caches) == 0 {
+		return nil, err
+	}
+	return caches[0], err
+}
+
+func (e *GatewayCache) Save(db *gorm.DB) error {
+	return db.Save(e).Error
+}
+
+func (e *GatewayCache) Del(db *gorm.DB) error {
+	if err := db.Where("module_id = ?", e.ModuleID).Delete(e).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (e *GatewayCache) GetPk() int64 {
+	return e.ID
+}
@@ -0,0 +1,78 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type GatewayConfigHistory struct {
+	ID         int64     `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
+	Type       string    `json:"type" toml:"type" gorm:"size:50" orm:"column(type);size(50)" description:"配置类型 module/app"`
+	Name       string    `json:"name" toml:"name" gorm:"size:255" orm:"column(name);size(255)" description:"模块名或租户id"`
+	Action     string    `json:"action" toml:"action" gorm:"size:50" orm:"column(action);size(50)" description:"变更动作"`
+	Author     string    `json:"author" toml:"author" gorm:"size:255" orm:"column(author);size(255)" description:"操作人"`
+	Content    string    `json:"content" toml:"content" gorm:"type:longtext" orm:"column(content);type(text)" description:"变更后的配置，删除时为空"`
+	Snapshot   string    `json:"snapshot" toml:"snapshot" gorm:"type:longtext" orm:"column(snapshot);type(text)" description:"变更后的全量配置，同批次变更仅首条记录保存"`
+	SnapshotID int64     `json:"snapshot_id" toml:"snapshot_id" orm:"column(snapshot_id)" description:"全量配置所在的版本id，为0时取本条记录的快照"`
+	CreatedAt  time.Time `json:"created_at" toml:"created_at" orm:"column(created_at);auto_now_add;type(datetime)" description:"创建时间"`
+}
+
+func (e *GatewayConfigHistory) TableName() string {
+	return "gateway_config_history"
+}
+
+// 按条件分页查询，type或name为空时不过滤
+func (e *GatewayConfigHistory) GetList(db *gorm.DB, historyType, name string, offset, limit int) ([]*GatewayConfigHistory, error) {
+	var histories []*GatewayConfigHistory
+	query := db.Model(&GatewayConfigHistory{}).Select("id, type, name, action, author, created_at")
+	if historyType != "" {
+		query = query.Where("type = ?", historyType)
+	}
+	if name != "" {
+		query = query.Where("name = ?", name)
+	}
+	err := query.Order("id desc").Offset(offset).Limit(limit).Find(&histories).Error
+	return histories, err
+}
+
+// 根据主键id查找对应记录
+func (e *GatewayConfigHistory) FindByID(db *gorm.DB, id int64) (*GatewayConfigHistory, error) {
+	var history GatewayConfigHistory
+	err := db.Where("id = ?", id).First(&history).Error
+	if err == gorm.ErrRecordNotFound {
+		return &history, nil
+	}
+	return &history, err
+}
+
+// 查找同一配置项在给定版本之前的最近一个版本
+func (e *GatewayConfigHistory) FindPrevious(db *gorm.DB) (*GatewayConfigHistory, error) {
+	var history GatewayConfigHistory
+	err := db.Where("type = ? AND name = ? AND id < ?", e.Type, e.Name, e.ID).
+		Order("id desc").First(&history).Error
+	if err == gorm.ErrRecordNotFound {
+		return &history, nil
+	}
+	return &history, err
+}
+
+// 获取版本对应的全量配置，同批次变更的快照保存在SnapshotID指向的记录中
+func (e *GatewayConfigHistory) FullSnapshot(db *gorm.DB) (string, error) {
+	if e.SnapshotID == 0 {
+		return e.Snapshot, nil
+	}
+	history, err := e.FindByID(db, e.SnapshotID)
+	if err != nil {
+		return "", err
+	}
+	return history.Snapshot, nil
+}
+
+func (e *GatewayConfigHistory) Save(db *gorm.DB) error {
+	return db.Save(e).Error
+}
+
+func (e *GatewayConfigHistory) GetPk() int64 {
+	return e.ID
+}
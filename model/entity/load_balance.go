@@ -7,20 +7,40 @@ import (
 type GatewayLoadBalance struct {
 	ID            int64  `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
 	ModuleID      int64  `json:"module_id" toml:"-" orm:"column(module_id)"`
-	CheckMethod   string `json:"check_method" validate:"required" toml:"check_method" orm:"column(check_method);size(200)" description:"检查方法"`
-	CheckURL      string `json:"check_url" validate:"" toml:"check_url" orm:"column(check_url);size(500)" description:"检测url"`
+	CheckMethod   string `json:"check_method" validate:"required" toml:"check_method" gorm:"size:200" orm:"column(check_method);size(200)" description:"检查方法"`
+	CheckURL      string `json:"check_url" validate:"" toml:"check_url" gorm:"size:500" orm:"column(check_url);size(500)" description:"检测url"`
 	CheckTimeout  int    `json:"check_timeout" validate:"required,min=100" toml:"check_timeout" orm:"column(check_timeout);size(500)" description:"检测超时时间"`
 	CheckInterval int    `json:"check_interval" validate:"required,min=100" toml:"check_interval" orm:"column(check_interval);size(500)" description:"检测url"`
 
-	Type                string `json:"type" validate:"required" toml:"type" orm:"column(type);size(100)" description:"轮询方式"`
-	IPList              string `json:"ip_list" validate:"required" toml:"ip_list" orm:"column(ip_list);size(500)" description:"ip列表"`
-	WeightList          string `json:"weight_list" validate:"" toml:"weight_list" orm:"column(weight_list);size(500)" description:"ip列表"`
-	ForbidList          string `json:"forbid_list" validate:"" toml:"forbid_list" orm:"column(forbid_list);size(1000)" description:"禁用 ip列表"`
+	Type                string `json:"type" validate:"required" toml:"type" gorm:"size:100" orm:"column(type);size(100)" description:"轮询方式"`
+	DiscoveryType       string `json:"discovery_type" validate:"" toml:"discovery_type" gorm:"size:20" orm:"column(discovery_type);size(20)" description:"服务发现类型，为空或static时使用ip_list"`
+	DiscoveryTarget     string `json:"discovery_target" validate:"" toml:"discovery_target" gorm:"size:500" orm:"column(discovery_target);size(500)" description:"服务发现目标：dns域名、节点文件、注册中心url或redis服务名"`
+	IPList              string `json:"ip_list" validate:"" toml:"ip_list" gorm:"size:500" orm:"column(ip_list);size(500)" description:"ip列表"`
+	WeightList          string `json:"weight_list" validate:"" toml:"weight_list" gorm:"size:500" orm:"column(weight_list);size(500)" description:"ip列表"`
+	ForbidList          string `json:"forbid_list" validate:"" toml:"forbid_list" gorm:"size:1000" orm:"column(forbid_list);size(1000)" description:"禁用 ip列表"`
+	DrainList           string `json:"drain_list" validate:"" toml:"drain_list" gorm:"size:1000" orm:"column(drain_list);size(1000)" description:"排空中的ip列表，不再接收新请求"`
+	SlowStart           int    `json:"slow_start" validate:"" toml:"slow_start" orm:"column(slow_start)" description:"单位ms，节点恢复后权重从低到满的爬升时间，0为不启用"`
 	ProxyConnectTimeout int    `json:"proxy_connect_timeout" validate:"required,min=1" toml:"proxy_connect_timeout" orm:"column(proxy_connect_timeout)" description:"单位ms，连接后端超时时间"`
 	ProxyHeaderTimeout  int    `json:"proxy_header_timeout" validate:"" toml:"proxy_header_timeout" orm:"column(proxy_header_timeout)" description:"单位ms，后端服务器数据回传时间"`
 	ProxyBodyTimeout    int    `json:"proxy_body_timeout" validate:"" toml:"proxy_body_timeout" orm:"column(proxy_body_timeout)" description:"单位ms，后端服务器响应时间"`
 	MaxIdleConn         int    `json:"max_idle_conn" validate:"" toml:"max_idle_conn" orm:"column(max_idle_conn)"`
 	IdleConnTimeout     int    `json:"idle_conn_timeout" validate:"" toml:"idle_conn_timeout" orm:"column(idle_conn_timeout)" description:"keep-alived超时时间，新增"`
+	MaxConnsPerHost     int    `json:"max_conns_per_host" validate:"" toml:"max_conns_per_host" orm:"column(max_conns_per_host)" description:"每个节点最大连接数，0为不限制"`
+
+	MaxConcurrency        int `json:"max_concurrency" validate:"" toml:"max_concurrency" orm:"column(max_concurrency)" description:"模块最大并发请求数，0为不限制"`
+	MaxConcurrencyPerNode int `json:"max_concurrency_per_node" validate:"" toml:"max_concurrency_per_node" orm:"column(max_concurrency_per_node)" description:"每个节点最大并发请求数，0为不限制"`
+	QueueSize             int `json:"queue_size" validate:"" toml:"queue_size" orm:"column(queue_size)" description:"模块并发已满时最多排队的请求数，0为不排队"`
+	QueueTimeout          int `json:"queue_timeout" validate:"" toml:"queue_timeout" orm:"column(queue_timeout)" description:"单位ms，排队超时时间，0为默认1000"`
+
+	MirrorGroup          string `json:"mirror_group" validate:"" toml:"mirror_group" gorm:"size:128" orm:"column(mirror_group);size(128)" description:"流量镜像目标分组，为空时不镜像"`
+	MirrorPercent        int64  `json:"mirror_percent" validate:"" toml:"mirror_percent" orm:"column(mirror_percent)" description:"镜像请求百分比，0-100"`
+	MirrorMaxConcurrency int    `json:"mirror_max_concurrency" validate:"" toml:"mirror_max_concurrency" orm:"column(mirror_max_concurrency)" description:"镜像最大并发数，超出时丢弃镜像请求"`
+
+	HostMode            string `json:"host_mode" validate:"" toml:"host_mode" gorm:"size:20" orm:"column(host_mode);size(20)" description:"转发Host：fixed、preserve、upstream，为空时同fixed"`
+	FixedHost           string `json:"fixed_host" validate:"" toml:"fixed_host" gorm:"size:255" orm:"column(fixed_host);size(255)" description:"fixed模式下的Host，为空时使用全局req_host"`
+	RequestHeaderRules  string `json:"request_header_rules" validate:"" toml:"request_header_rules" gorm:"type:text" orm:"column(request_header_rules);size(2000)" description:"请求header规则，每行一条"`
+	ResponseHeaderRules string `json:"response_header_rules" validate:"" toml:"response_header_rules" gorm:"type:text" orm:"column(response_header_rules);size(2000)" description:"响应header规则，每行一条"`
+	MaxBodySize         int64  `json:"max_body_size" validate:"" toml:"max_body_size" orm:"column(max_body_size)" description:"请求体最大字节数，0为不限制"`
 }
 
 func (o *GatewayLoadBalance) TableName() string {
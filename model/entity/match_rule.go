@@ -7,10 +7,10 @@ import (
 type GatewayMatchRule struct {
 	ID         int64  `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
 	ModuleID   int64  `json:"module_id" toml:"-" orm:"column(module_id)" description:"模块id"`
-	Type       string `json:"type" toml:"type" validate:"required" orm:"column(type)" description:"匹配类型"`
-	Rule       string `json:"rule" toml:"rule" validate:"required" orm:"column(rule);size(1000)" description:"规则"`
-	RuleExt    string `json:"rule_ext" validate:"required" toml:"rule_ext" orm:"column(rule_ext);size(1000)" description:"拓展规则"`
-	URLRewrite string `json:"url_rewrite" validate:"required" toml:"url_rewrite" orm:"column(rule_ext);size(1000)" description:"url重写"`
+	Type       string `json:"type" toml:"type" validate:"required" gorm:"size:255" orm:"column(type)" description:"匹配类型"`
+	Rule       string `json:"rule" toml:"rule" validate:"required" gorm:"size:1000" orm:"column(rule);size(1000)" description:"规则"`
+	RuleExt    string `json:"rule_ext" validate:"required" toml:"rule_ext" gorm:"size:1000" orm:"column(rule_ext);size(1000)" description:"拓展规则"`
+	URLRewrite string `json:"url_rewrite" validate:"required" toml:"url_rewrite" gorm:"size:1000" orm:"column(rule_ext);size(1000)" description:"url重写"`
 }
 
 func (o *GatewayMatchRule) TableName() string {
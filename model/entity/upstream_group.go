@@ -0,0 +1,55 @@
+package entity
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// GatewayUpstreamGroup 模块下的上游分组，用于灰度发布及按比例分流
+// 未命中任何分组的流量转发到模块默认的load_balance节点
+type GatewayUpstreamGroup struct {
+	ID              int64  `json:"id" toml:"-" orm:"column(id);auto" description:"自增主键"`
+	ModuleID        int64  `json:"module_id" toml:"-" orm:"column(module_id)"`
+	Name            string `json:"name" validate:"required" toml:"name" gorm:"size:128" orm:"column(name);size(128)" description:"分组名称"`
+	Percent         int64  `json:"percent" validate:"" toml:"percent" orm:"column(percent)" description:"流量百分比，0-100"`
+	ForceRule       string `json:"force_rule" validate:"" toml:"force_rule" gorm:"size:1000" orm:"column(force_rule);size(1000)" description:"强制进入分组的规则，多条用分号分隔"`
+	DiscoveryType   string `json:"discovery_type" validate:"" toml:"discovery_type" gorm:"size:20" orm:"column(discovery_type);size(20)" description:"服务发现类型，为空或static时使用ip_list"`
+	DiscoveryTarget string `json:"discovery_target" validate:"" toml:"discovery_target" gorm:"size:500" orm:"column(discovery_target);size(500)" description:"服务发现目标"`
+	IPList          string `json:"ip_list" validate:"" toml:"ip_list" gorm:"size:500" orm:"column(ip_list);size(500)" description:"ip列表"`
+	WeightList      string `json:"weight_list" validate:"" toml:"weight_list" gorm:"size:500" orm:"column(weight_list);size(500)" description:"权重列表"`
+}
+
+func (o *GatewayUpstreamGroup) TableName() string {
+	return "gateway_upstream_group"
+}
+
+func (o *GatewayUpstreamGroup) GetAll(db *gorm.DB) ([]*GatewayUpstreamGroup, error) {
+	var groups []*GatewayUpstreamGroup
+	err := db.Model(&GatewayUpstreamGroup{}).
+		Order("id asc").
+		Find(&groups).Error
+	return groups, err
+}
+
+func (o *GatewayUpstreamGroup) GetByModule(db *gorm.DB, moduleID int64) ([]*GatewayUpstreamGroup, error) {
+	var groups []*GatewayUpstreamGroup
+	err := db.Model(&GatewayUpstreamGroup{}).
+		Where(&GatewayUpstreamGroup{ModuleID: moduleID}).
+		Order("id asc").
+		Find(&groups).Error
+	return groups, err
+}
+
+func (o *GatewayUpstreamGroup) Save(db *gorm.DB) error {
+	return db.Save(o).Error
+}
+
+func (o *GatewayUpstreamGroup) Del(db *gorm.DB) error {
+	if err := db.Where("module_id = ?", o.ModuleID).Delete(o).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (o *GatewayUpstreamGroup) GetPk() int64 {
+	return o.ID
+}
@@ -3,8 +3,10 @@ package running
 import "gatekeeper/model/entity"
 
 type GatewayModule struct {
-	Base          *entity.GatewayModuleBase    `json:"base" validate:"required" toml:"base"`
-	MatchRule     *entity.GatewayMatchRule     `json:"match_rule" validate:"required"  toml:"match_rule"`
-	LoadBalance   *entity.GatewayLoadBalance   `json:"load_balance" validate:"required" toml:"load_balance"`
-	AccessControl *entity.GatewayAccessControl `json:"access_control" toml:"access_control"`
+	Base           *entity.GatewayModuleBase      `json:"base" validate:"required" toml:"base"`
+	MatchRule      *entity.GatewayMatchRule       `json:"match_rule" validate:"required"  toml:"match_rule"`
+	LoadBalance    *entity.GatewayLoadBalance     `json:"load_balance" validate:"required" toml:"load_balance"`
+	AccessControl  *entity.GatewayAccessControl   `json:"access_control" toml:"access_control"`
+	UpstreamGroups []*entity.GatewayUpstreamGroup `json:"upstream_groups,omitempty" toml:"upstream_groups"`
+	Cache          *entity.GatewayCache           `json:"cache,omitempty" toml:"cache"`
 }
@@ -50,10 +50,13 @@ func initRouter() *gin.Engine {
 	router := gin.New()
 	router.Use(middleware.Recovery())
 
-	admin := router.Group("/admin")
-	admin.Use(middleware.RequestTraceLog())
-	{
-		controller.AdminRegister(admin)
+	// file存储模式下无用户及历史数据，不提供管理后台
+	if config.DBEnabled() {
+		admin := router.Group("/admin")
+		admin.Use(middleware.RequestTraceLog(), middleware.AdminBodyLimit())
+		{
+			controller.AdminRegister(admin)
+		}
 	}
 
 	router.Static("/assets", "./tmpl/green/assets")
@@ -64,11 +67,14 @@ func initRouter() *gin.Engine {
 	csr := router.Group("/")
 	csr.Use(middleware.ClusterAuth())
 	csr.GET("/reload", gateway.Reload)
+	csr.GET("/cache_purge", gateway.CachePurge)
 
 	gw := router.Group(config.BaseConf.Http.RoutePrefix)
 	gw.Use(
 		middleware.RequestTraceLog(),
+		middleware.Tracing(),
 		middleware.MatchRule(),
+		middleware.BodyLimit(),
 		middleware.AccessControl(),
 		middleware.HTTPLimit(),
 		middleware.LoadBalance())
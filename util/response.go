@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/e421083458/golang_common/lib"
 	"github.com/gin-gonic/gin"
@@ -28,6 +29,19 @@ type Response struct {
 	TraceID   interface{}  `json:"trace_id"`
 }
 
+type traceIDKey struct{}
+
+//WithTraceID 在请求context中记录trace_id，HTTPError/HTTPSuccess据此输出
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+//TraceID 请求context中的trace_id，不存在时为空
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
 //ResponseError 错误输出
 func ResponseError(c *gin.Context, code ResponseCode, err error) {
 	trace, ok := c.Get("trace")
@@ -73,7 +87,7 @@ func ResponseSuccess(c *gin.Context, data interface{}) {
 //HTTPError 错误输出
 func HTTPError(errcode ResponseCode, message string, w http.ResponseWriter, r *http.Request) {
 	var resp *Response
-	resp = &Response{ErrorCode: errcode, ErrorMsg: message, Data: "", TraceID: 1}
+	resp = &Response{ErrorCode: errcode, ErrorMsg: message, Data: "", TraceID: TraceID(r.Context())}
 	w.Header().Set("Content-Type", "application/json")
 	response, jerr := json.Marshal(resp)
 	if jerr != nil {
@@ -85,7 +99,7 @@ func HTTPError(errcode ResponseCode, message string, w http.ResponseWriter, r *h
 //HTTPSuccess 正确输出
 func HTTPSuccess(message string, w http.ResponseWriter, r *http.Request) {
 	var resp *Response
-	resp = &Response{ErrorCode: 0, ErrorMsg: "", Data: message, TraceID: 1}
+	resp = &Response{ErrorCode: 0, ErrorMsg: "", Data: message, TraceID: TraceID(r.Context())}
 	w.WriteHeader(200)
 	w.Header().Set("Content-Type", "application/json")
 	response, jerr := json.Marshal(resp)